@@ -0,0 +1,59 @@
+// cognito-sync reports the drift between the roles in mongo and the role groups of the user pool, and repairs it with
+// -repair. It exits with 1 when a report finds drift or a repair leaves some of it.
+//
+//	go run ./cmd/cognito-sync           # report
+//	go run ./cmd/cognito-sync -repair   # report and repair
+package main
+
+import (
+	"context"
+	"control-panel-bk/config"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/pkg/panelAdmins"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	repair := flag.Bool("repair", false, "create, describe and delete the groups and move the members to match mongo")
+	timeout := flag.Duration("timeout", 10*time.Minute, "the time the reconciliation is given")
+	flag.Parse()
+
+	// The environment can come from the process as well, as it does in the containers
+	if err := godotenv.Load(); err != nil {
+		log.Print("cognito-sync: no .env file was loaded: ", err)
+	}
+
+	if err := config.LoadAwsConfiguration(); err != nil {
+		log.Fatalln(err)
+	}
+
+	client, err := aws.ConnectMongoDB()
+	if err != nil {
+		log.Fatalln("cognito-sync: unable to connect to mongo: ", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	defer client.Disconnect(context.Background())
+
+	drift, err := panelAdmins.ReconcileRoleGroups(ctx, client.Database("flowCx"), *repair)
+	if err != nil {
+		log.Fatalln("cognito-sync: ", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(drift); err != nil {
+		log.Fatalln(err)
+	}
+
+	if (*repair && !drift.Repaired) || (!*repair && drift.Drifted()) {
+		os.Exit(1)
+	}
+}
@@ -54,6 +54,14 @@ func (p *PayStack) PlanUrl() string {
 	return s
 }
 
+func (p *PayStack) SubscriptionUrl() string {
+	if p == nil {
+		panic("didn't initialized paystack")
+	}
+
+	return fmt.Sprintf("https://%s:%d/subscription", p.Host, p.Port)
+}
+
 func LoadAwsConfiguration() error {
 	cfg, err := config.LoadDefaultConfig(
 		context.TODO(),
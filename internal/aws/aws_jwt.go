@@ -1,10 +1,31 @@
 package aws
 
 import (
+	"context"
 	"control-panel-bk/config"
+	"control-panel-bk/util"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"sync"
 	"time"
 )
 
+const (
+	AccessTokenUse = "access"
+	IdTokenUse     = "id"
+)
+
+// jwksRefreshInterval is the least amount of time between two JWKS downloads triggered by an unknown key id
+const jwksRefreshInterval = time.Minute
+
 type CognitoToken struct {
 	IdToken      string
 	AccessToken  string // An hour live span
@@ -13,9 +34,271 @@ type CognitoToken struct {
 	ExpiresIn    time.Time
 }
 
-func (c *CognitoToken) DecodeIdToken() {}
+// CognitoClaims are the claims carried by a user pool access or id token
+type CognitoClaims struct {
+	Username        string   `json:"username,omitempty"`
+	CognitoUsername string   `json:"cognito:username,omitempty"`
+	Email           string   `json:"email,omitempty"`
+	Groups          []string `json:"cognito:groups,omitempty"`
+	Role            string   `json:"custom:role,omitempty"`
+	ClientId        string   `json:"client_id,omitempty"`
+	TokenUse        string   `json:"token_use"`
+	jwt.RegisteredClaims
+
+	// The raw token the claims were parsed from
+	Token string `json:"-"`
+}
+
+// Actor is the identity recorded against the work done by the caller
+func (c *CognitoClaims) Actor() string {
+	switch {
+	case c.Username != "":
+		return c.Username
+	case c.CognitoUsername != "":
+		return c.CognitoUsername
+	case c.Email != "":
+		return c.Email
+	default:
+		return c.Subject
+	}
+}
+
+type claimsContextKey struct{}
+
+// ContextWithClaims returns a copy of the context carrying the verified claims of the caller
+func ContextWithClaims(ctx context.Context, claims *CognitoClaims) context.Context {
+	return context.WithValue(ctx, claimsContextKey{}, claims)
+}
+
+// ClaimsFromContext returns the verified claims of the caller, if any
+func ClaimsFromContext(ctx context.Context) (*CognitoClaims, bool) {
+	claims, ok := ctx.Value(claimsContextKey{}).(*CognitoClaims)
+	return claims, ok && claims != nil
+}
+
+// ActorFromContext returns the identity of the authenticated caller, used to stamp created_by and updated_by
+func ActorFromContext(ctx context.Context) (string, error) {
+	claims, ok := ClaimsFromContext(ctx)
+	if !ok || claims.Actor() == "" {
+		return "", errors.New("the caller could not be identified")
+	}
+
+	return claims.Actor(), nil
+}
+
+// RequireActor returns the identity of the authenticated caller, or answers 401 when there is none. The audit fields
+// are always taken from the caller it returns, never from the request body.
+func RequireActor(w http.ResponseWriter, r *http.Request) (string, bool) {
+	actor, err := ActorFromContext(r.Context())
+	if err != nil {
+		util.ErrorException(w, err, http.StatusUnauthorized)
+		return "", false
+	}
+
+	return actor, true
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// JWTVerifier verifies user pool tokens locally against the pool's JWKS
+type JWTVerifier struct {
+	Issuer     string
+	ClientId   string
+	JwksUrl    string
+	CacheTTL   time.Duration
+	HTTPClient *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+var (
+	defaultVerifier     *JWTVerifier
+	defaultVerifierOnce sync.Once
+)
+
+func NewJWTVerifier(region, userPoolId, clientId string) *JWTVerifier {
+	issuer := fmt.Sprintf("https://cognito-idp.%s.amazonaws.com/%s", region, userPoolId)
+
+	return &JWTVerifier{
+		Issuer:     issuer,
+		ClientId:   clientId,
+		JwksUrl:    fmt.Sprintf("%s/.well-known/jwks.json", issuer),
+		CacheTTL:   12 * time.Hour,
+		HTTPClient: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// DefaultJWTVerifier returns the verifier of the user pool the server is configured with
+func DefaultJWTVerifier() *JWTVerifier {
+	defaultVerifierOnce.Do(func() {
+		defaultVerifier = NewJWTVerifier(os.Getenv("AWS_REGION"), os.Getenv("AWS_USER_POOL_ID"), os.Getenv("AWS_CLIENT_ID"))
+	})
+
+	return defaultVerifier
+}
+
+// Verify checks the token's RS256 signature, issuer, expiry, token use and client, and returns its claims
+func (v *JWTVerifier) Verify(ctx context.Context, token string, tokenUse string) (*CognitoClaims, error) {
+	claims := &CognitoClaims{}
+
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, ok := t.Header["kid"].(string)
+		if !ok || kid == "" {
+			return nil, errors.New("token has no key id")
+		}
+
+		return v.key(ctx, kid)
+	}, jwt.WithValidMethods([]string{"RS256"}), jwt.WithIssuer(v.Issuer), jwt.WithExpirationRequired())
+
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.TokenUse != tokenUse {
+		return nil, fmt.Errorf("expected an %s token but got %q", tokenUse, claims.TokenUse)
+	}
+
+	switch tokenUse {
+	case AccessTokenUse:
+		if claims.ClientId != v.ClientId {
+			return nil, errors.New("token was not issued for this client")
+		}
+	case IdTokenUse:
+		if !slices.Contains(claims.Audience, v.ClientId) {
+			return nil, errors.New("token was not issued for this client")
+		}
+	}
+
+	claims.Token = token
+	return claims, nil
+}
+
+// key returns the public key for the key id, refreshing the cached JWKS when it is stale or the key id is unknown
+func (v *JWTVerifier) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	v.mu.RLock()
+	key, found := v.keys[kid]
+	fetchedAt := v.fetchedAt
+	v.mu.RUnlock()
 
-func (c *CognitoToken) VerifyIdToken() {}
+	stale := time.Since(fetchedAt) > v.CacheTTL
+	if found && !stale {
+		return key, nil
+	}
+
+	if !found && !stale && time.Since(fetchedAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+
+	if err := v.refresh(ctx); err != nil {
+		if found {
+			return key, nil
+		}
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+
+	if key, found = v.keys[kid]; !found {
+		return nil, fmt.Errorf("unknown signing key %s", kid)
+	}
+
+	return key, nil
+}
+
+func (v *JWTVerifier) refresh(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.JwksUrl, nil)
+	if err != nil {
+		return err
+	}
+
+	client := v.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to fetch the jwks: %w", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to fetch the jwks: %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("unable to decode the jwks: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+
+		key, err := k.publicKey()
+		if err != nil {
+			return err
+		}
+
+		keys[k.Kid] = key
+	}
+
+	v.mu.Lock()
+	v.keys = keys
+	v.fetchedAt = time.Now()
+	v.mu.Unlock()
+
+	return nil
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, fmt.Errorf("invalid modulus for key %s: %w", k.Kid, err)
+	}
+
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exponent for key %s: %w", k.Kid, err)
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+// DecodeIdToken reads the claims of the id token without verifying it
+func (c *CognitoToken) DecodeIdToken() (*CognitoClaims, error) {
+	claims := &CognitoClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(c.IdToken, claims); err != nil {
+		return nil, err
+	}
+
+	claims.Token = c.IdToken
+	return claims, nil
+}
+
+// VerifyIdToken verifies the id token against the user pool's JWKS
+func (c *CognitoToken) VerifyIdToken(ctx context.Context, v *JWTVerifier) (*CognitoClaims, error) {
+	return v.Verify(ctx, c.IdToken, IdTokenUse)
+}
 
 func (c *CognitoToken) RefreshingSessionToken(clientId string) error {
 	tokens, err := AuthViaRefreshToken(config.AwsConfig, clientId, c.RefreshToken)
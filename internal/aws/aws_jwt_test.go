@@ -0,0 +1,220 @@
+package aws
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKid      = "test-key"
+	testClientId = "test-client"
+)
+
+type jwksStub struct {
+	server  *httptest.Server
+	key     *rsa.PrivateKey
+	fetches atomic.Int32
+}
+
+func newJwksStub(t *testing.T) *jwksStub {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	stub := &jwksStub{key: key}
+	stub.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		stub.fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []jsonWebKey{{
+				Kid: testKid,
+				Kty: "RSA",
+				Alg: "RS256",
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	t.Cleanup(stub.server.Close)
+
+	return stub
+}
+
+func (s *jwksStub) verifier() *JWTVerifier {
+	return &JWTVerifier{
+		Issuer:     "https://cognito-idp.us-east-1.amazonaws.com/us-east-1_test",
+		ClientId:   testClientId,
+		JwksUrl:    s.server.URL,
+		CacheTTL:   time.Hour,
+		HTTPClient: s.server.Client(),
+	}
+}
+
+func (s *jwksStub) sign(t *testing.T, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+
+	signed, err := token.SignedString(s.key)
+	require.NoError(t, err)
+
+	return signed
+}
+
+func accessClaims(issuer string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":            "6f1c2a4e-0000-4000-8000-000000000001",
+		"username":       "jane@flowcx.io",
+		"cognito:groups": []string{"admins"},
+		"custom:role":    "67db3402d08dedc2e44081bb",
+		"client_id":      testClientId,
+		"token_use":      AccessTokenUse,
+		"iss":            issuer,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+}
+
+func TestJWTVerifier_Verify(t *testing.T) {
+	stub := newJwksStub(t)
+	v := stub.verifier()
+
+	claims, err := v.Verify(context.Background(), stub.sign(t, testKid, accessClaims(v.Issuer)), AccessTokenUse)
+	require.NoError(t, err)
+
+	assert.Equal(t, "6f1c2a4e-0000-4000-8000-000000000001", claims.Subject)
+	assert.Equal(t, "jane@flowcx.io", claims.Username)
+	assert.Equal(t, []string{"admins"}, claims.Groups)
+	assert.Equal(t, "67db3402d08dedc2e44081bb", claims.Role)
+	assert.Equal(t, "jane@flowcx.io", claims.Actor())
+	assert.NotEmpty(t, claims.Token)
+
+	// A second verification is served from the cached key set
+	_, err = v.Verify(context.Background(), stub.sign(t, testKid, accessClaims(v.Issuer)), AccessTokenUse)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), stub.fetches.Load())
+}
+
+func TestJWTVerifier_Rejects(t *testing.T) {
+	stub := newJwksStub(t)
+	v := stub.verifier()
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name  string
+		token func() string
+	}{
+		{"expired", func() string {
+			c := accessClaims(v.Issuer)
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+			return stub.sign(t, testKid, c)
+		}},
+		{"missing expiry", func() string {
+			c := accessClaims(v.Issuer)
+			delete(c, "exp")
+			return stub.sign(t, testKid, c)
+		}},
+		{"wrong issuer", func() string {
+			return stub.sign(t, testKid, accessClaims("https://cognito-idp.us-east-1.amazonaws.com/us-east-1_other"))
+		}},
+		{"wrong client", func() string {
+			c := accessClaims(v.Issuer)
+			c["client_id"] = "another-client"
+			return stub.sign(t, testKid, c)
+		}},
+		{"id token used as access token", func() string {
+			c := accessClaims(v.Issuer)
+			c["token_use"] = IdTokenUse
+			return stub.sign(t, testKid, c)
+		}},
+		{"unknown key id", func() string {
+			return stub.sign(t, "rotated-away", accessClaims(v.Issuer))
+		}},
+		{"signed by another key", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodRS256, accessClaims(v.Issuer))
+			token.Header["kid"] = testKid
+			signed, _ := token.SignedString(otherKey)
+			return signed
+		}},
+		{"hmac signed", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, accessClaims(v.Issuer))
+			token.Header["kid"] = testKid
+			signed, _ := token.SignedString([]byte("secret"))
+			return signed
+		}},
+		{"malformed", func() string { return "not-a-jwt" }},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			claims, err := v.Verify(context.Background(), tc.token(), AccessTokenUse)
+			assert.Error(t, err)
+			assert.Nil(t, claims)
+		})
+	}
+}
+
+func TestCognitoToken_IdToken(t *testing.T) {
+	stub := newJwksStub(t)
+	v := stub.verifier()
+
+	c := accessClaims(v.Issuer)
+	delete(c, "client_id")
+	delete(c, "username")
+	c["token_use"] = IdTokenUse
+	c["aud"] = testClientId
+	c["cognito:username"] = "jane"
+	c["email"] = "jane@flowcx.io"
+
+	token := CognitoToken{IdToken: stub.sign(t, testKid, c)}
+
+	decoded, err := token.DecodeIdToken()
+	require.NoError(t, err)
+	assert.Equal(t, "jane", decoded.Actor())
+
+	verified, err := token.VerifyIdToken(context.Background(), v)
+	require.NoError(t, err)
+	assert.Equal(t, "jane@flowcx.io", verified.Email)
+
+	c["aud"] = "another-client"
+	token.IdToken = stub.sign(t, testKid, c)
+	_, err = token.VerifyIdToken(context.Background(), v)
+	assert.Error(t, err)
+}
+
+func TestClaimsContext(t *testing.T) {
+	_, ok := ClaimsFromContext(context.Background())
+	assert.False(t, ok)
+
+	ctx := ContextWithClaims(context.Background(), &CognitoClaims{Username: "jane"})
+	claims, ok := ClaimsFromContext(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, "jane", claims.Actor())
+}
+
+func TestRequireActor(t *testing.T) {
+	rr := httptest.NewRecorder()
+	_, ok := RequireActor(rr, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, rr.Code)
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	actor, ok := RequireActor(rr, req.WithContext(ContextWithClaims(req.Context(), &CognitoClaims{Username: "jane"})))
+	assert.True(t, ok)
+	assert.Equal(t, "jane", actor)
+	assert.Equal(t, http.StatusOK, rr.Code, "nothing is written for a known caller")
+}
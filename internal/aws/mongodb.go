@@ -43,6 +43,7 @@ func connect() (*mongo.Client, error) {
 		ObjectIDAsHexString: true, // Allows the ObjectID to be marshalled as a string
 		NilSliceAsEmpty:     true,
 		UseLocalTimeZone:    false,
+		DefaultDocumentM:    true, // Free-form documents (e.g. the audit snapshots) decode as maps rather than key/value pairs
 	}
 
 	client, err := mongo.Connect(options.Client().SetBSONOptions(bsonOpts).ApplyURI(os.Getenv("AWS_MONGO_DB_URL")))
@@ -71,6 +72,27 @@ func connect() (*mongo.Client, error) {
 				{
 					Keys: bson.D{{"updated_at", -1}},
 				},
+				{
+					Keys: bson.D{{"is_deleted_status", 1}, {"deleted_at", 1}},
+				},
+			},
+		},
+		{
+			cn: "permission_catalog",
+			indexes: []mongo.IndexModel{
+				{
+					Keys:    bson.D{{"name", 1}},
+					Options: options.Index().SetUnique(true),
+				},
+			},
+		},
+		{
+			cn: "role_versions",
+			indexes: []mongo.IndexModel{
+				{
+					Keys:    bson.D{{"role_id", 1}, {"version", -1}},
+					Options: options.Index().SetUnique(true),
+				},
 			},
 		},
 		{
@@ -88,6 +110,9 @@ func connect() (*mongo.Client, error) {
 				{
 					Keys: bson.D{{"updated_at", -1}},
 				},
+				{
+					Keys: bson.D{{"is_deleted_status", 1}, {"deleted_at", 1}},
+				},
 			},
 		},
 		{
@@ -100,6 +125,105 @@ func connect() (*mongo.Client, error) {
 				{
 					Keys: bson.D{{"first_name", "text"}, {"last_name", "text"}, {"email", "text"}, {"full_name", "text"}},
 				},
+				{
+					// Every request resolves its caller by up_id. The users without one are left out, the filter
+					// rather than sparse as a sparse index still holds the users stored with a null up_id.
+					Keys:    bson.D{{"up_id", 1}},
+					Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"up_id": bson.M{"$type": "string"}}),
+				},
+				{
+					Keys: bson.D{{"role_id", 1}},
+				},
+			},
+		},
+		{
+			cn: "audit_events",
+			indexes: []mongo.IndexModel{
+				{
+					Keys: bson.D{{"created_at", -1}, {"_id", -1}},
+				},
+				{
+					Keys: bson.D{{"entity_type", 1}, {"entity_id", 1}, {"created_at", -1}},
+				},
+				{
+					Keys: bson.D{{"actor", 1}, {"created_at", -1}},
+				},
+				{
+					Keys: bson.D{{"action", 1}, {"created_at", -1}},
+				},
+			},
+		},
+		{
+			cn: "tiers",
+			indexes: []mongo.IndexModel{
+				{
+					Keys: bson.D{{"amount", 1}, {"interval", 1}, {"currency", 1}},
+				},
+				{
+					Keys: bson.D{{"group_id", 1}, {"currency", 1}},
+				},
+				{
+					Keys: bson.D{{"created_at", -1}},
+				},
+				{
+					Keys:    bson.D{{"drift", 1}},
+					Options: options.Index().SetSparse(true),
+				},
+			},
+		},
+		{
+			cn: "paystack_events",
+			indexes: []mongo.IndexModel{
+				{
+					Keys: bson.D{{"event", 1}, {"received_at", -1}},
+				},
+			},
+		},
+		{
+			cn: "subscriptions",
+			indexes: []mongo.IndexModel{
+				{
+					Keys:    bson.D{{"subscription_code", 1}},
+					Options: options.Index().SetUnique(true),
+				},
+				{
+					Keys: bson.D{{"plan_code", 1}, {"customer_code", 1}},
+				},
+			},
+		},
+		{
+			cn: "tenants",
+			indexes: []mongo.IndexModel{
+				{
+					Keys:    bson.D{{"name", 1}},
+					Options: options.Index().SetUnique(true).SetCollation(&options.Collation{Locale: "en", Strength: 2}),
+				},
+				{
+					Keys: bson.D{{"status", 1}, {"created_at", -1}},
+				},
+				{
+					Keys: bson.D{{"account_manager", 1}},
+				},
+				{
+					Keys: bson.D{{"plan_code", 1}},
+				},
+				{
+					Keys: bson.D{{"is_deleted_status", 1}, {"deleted_at", 1}},
+				},
+			},
+		},
+		{
+			cn: "onboardings",
+			indexes: []mongo.IndexModel{
+				{
+					Keys: bson.D{{"status", 1}, {"updated_at", -1}},
+				},
+				{
+					Keys: bson.D{{"tenant_id", 1}},
+				},
+				{
+					Keys: bson.D{{"steps.assignee", 1}},
+				},
 			},
 		},
 	}
@@ -3,6 +3,7 @@ package aws
 import (
 	"context"
 	"control-panel-bk/util"
+	"errors"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider"
 	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
@@ -20,16 +21,24 @@ type Group struct {
 
 type PoolUser struct {
 	Username string `json:"username"`
+	Email    string `json:"email,omitempty"`
 }
 
 func getClient(cfg *aws.Config) *cognitoidentityprovider.Client {
 	return cognitoidentityprovider.NewFromConfig(*cfg)
 }
 
+// IsNotFound tells whether the user pool refused the call because the group or the user does not exist
+func IsNotFound(err error) bool {
+	var notFound *types.ResourceNotFoundException
+	var userNotFound *types.UserNotFoundException
+	return errors.As(err, &notFound) || errors.As(err, &userNotFound)
+}
+
 func CreateUserPoolGroup(cfg *aws.Config, group Group) (*cognitoidentityprovider.CreateGroupOutput, error) {
 	client := getClient(cfg)
 	input := cognitoidentityprovider.CreateGroupInput{
-		UserPoolId:  aws.String(os.Getenv("us-east-1_kNKCRvql2")),
+		UserPoolId:  aws.String(os.Getenv("AWS_USER_POOL_ID")),
 		Description: aws.String(group.Description),
 		GroupName:   aws.String(group.Name),
 	}
@@ -46,7 +55,7 @@ func AddUsersToUserPoolGroup(cfg *aws.Config, groupName string, username string)
 	client := getClient(cfg)
 
 	input := cognitoidentityprovider.AdminAddUserToGroupInput{
-		UserPoolId: aws.String(os.Getenv("us-east-1_kNKCRvql2")),
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
 		GroupName:  aws.String(groupName),
 		Username:   aws.String(username),
 	}
@@ -60,6 +69,118 @@ func AddUsersToUserPoolGroup(cfg *aws.Config, groupName string, username string)
 	return output, nil
 }
 
+// UpdateUserPoolGroup writes the description of the group, a group cannot be renamed
+func UpdateUserPoolGroup(cfg *aws.Config, group Group) error {
+	client := getClient(cfg)
+
+	input := cognitoidentityprovider.UpdateGroupInput{
+		UserPoolId:  aws.String(os.Getenv("AWS_USER_POOL_ID")),
+		GroupName:   aws.String(group.Name),
+		Description: aws.String(group.Description),
+	}
+
+	_, err := client.UpdateGroup(context.TODO(), &input)
+	return err
+}
+
+// DeleteUserPoolGroup removes the group, its members are left in the pool without it
+func DeleteUserPoolGroup(cfg *aws.Config, groupName string) error {
+	client := getClient(cfg)
+
+	input := cognitoidentityprovider.DeleteGroupInput{
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
+		GroupName:  aws.String(groupName),
+	}
+
+	_, err := client.DeleteGroup(context.TODO(), &input)
+	return err
+}
+
+func RemoveUserFromUserPoolGroup(cfg *aws.Config, groupName string, username string) error {
+	client := getClient(cfg)
+
+	input := cognitoidentityprovider.AdminRemoveUserFromGroupInput{
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
+		GroupName:  aws.String(groupName),
+		Username:   aws.String(username),
+	}
+
+	_, err := client.AdminRemoveUserFromGroup(context.TODO(), &input)
+	return err
+}
+
+// ListUserPoolGroups lists every group of the pool
+func ListUserPoolGroups(cfg *aws.Config) ([]Group, error) {
+	client := getClient(cfg)
+
+	groups := []Group{}
+	paginator := cognitoidentityprovider.NewListGroupsPaginator(client, &cognitoidentityprovider.ListGroupsInput{
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range page.Groups {
+			groups = append(groups, Group{Name: aws.ToString(g.GroupName), Description: aws.ToString(g.Description)})
+		}
+	}
+
+	return groups, nil
+}
+
+// ListUserPoolGroupsForUser lists the names of the groups the user is a member of
+func ListUserPoolGroupsForUser(cfg *aws.Config, username string) ([]string, error) {
+	client := getClient(cfg)
+
+	groups := []string{}
+	paginator := cognitoidentityprovider.NewAdminListGroupsForUserPaginator(client, &cognitoidentityprovider.AdminListGroupsForUserInput{
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
+		Username:   aws.String(username),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, g := range page.Groups {
+			groups = append(groups, aws.ToString(g.GroupName))
+		}
+	}
+
+	return groups, nil
+}
+
+// ListUsersInUserPoolGroup lists the members of the group along with their email, the username of a panel user is
+// their email unless the pool signs users in by an email alias
+func ListUsersInUserPoolGroup(cfg *aws.Config, groupName string) ([]PoolUser, error) {
+	client := getClient(cfg)
+
+	users := []PoolUser{}
+	paginator := cognitoidentityprovider.NewListUsersInGroupPaginator(client, &cognitoidentityprovider.ListUsersInGroupInput{
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
+		GroupName:  aws.String(groupName),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.TODO())
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range page.Users {
+			user := PoolUser{Username: aws.ToString(u.Username)}
+			for _, attr := range u.Attributes {
+				if aws.ToString(attr.Name) == "email" {
+					user.Email = aws.ToString(attr.Value)
+				}
+			}
+			users = append(users, user)
+		}
+	}
+
+	return users, nil
+}
+
 func CreateNewUser(cfg *aws.Config, username string, roleId string, tp util.Password) (*string, error) {
 	client := getClient(cfg)
 
@@ -94,6 +215,53 @@ func CreateNewUser(cfg *aws.Config, username string, roleId string, tp util.Pass
 	return &userSub, nil
 }
 
+// CreateTenantAdmin creates the admin of a customer tenant in the tenants' user pool, apart from the panel users
+func CreateTenantAdmin(cfg *aws.Config, email string, tenantId string, tp util.Password) (*string, error) {
+	client := getClient(cfg)
+
+	input := cognitoidentityprovider.AdminCreateUserInput{
+		Username:   aws.String(email),
+		UserPoolId: aws.String(os.Getenv("AWS_TENANT_USER_POOL_ID")),
+		DesiredDeliveryMediums: []types.DeliveryMediumType{
+			types.DeliveryMediumTypeEmail,
+		},
+		UserAttributes: []types.AttributeType{
+			{Name: aws.String("email"), Value: aws.String(email)},
+			{Name: aws.String("custom:tenant_id"), Value: aws.String(tenantId)},
+			{Name: aws.String("custom:role"), Value: aws.String("admin")},
+		},
+		TemporaryPassword: aws.String(tp.GetPassword()),
+	}
+
+	output, err := client.AdminCreateUser(context.TODO(), &input)
+	if err != nil {
+		return nil, err
+	}
+
+	var userSub string
+	for _, attr := range output.User.Attributes {
+		if *attr.Name == "sub" {
+			userSub = *attr.Value
+			break
+		}
+	}
+
+	return &userSub, nil
+}
+
+// DeleteTenantAdmin removes the admin of a customer tenant from the tenants' user pool
+func DeleteTenantAdmin(cfg *aws.Config, email string) error {
+	client := getClient(cfg)
+
+	input := cognitoidentityprovider.AdminDeleteUserInput{
+		Username:   aws.String(email),
+		UserPoolId: aws.String(os.Getenv("AWS_TENANT_USER_POOL_ID")),
+	}
+
+	_, err := client.AdminDeleteUser(context.TODO(), &input)
+	return err
+}
+
 func DeleteUser(cfg *aws.Config, username string) (*cognitoidentityprovider.AdminDeleteUserOutput, error) {
 	client := getClient(cfg)
 
@@ -142,6 +310,36 @@ func ActivateUser(cfg *aws.Config, username string) (*cognitoidentityprovider.Ad
 	return output, nil
 }
 
+// UpdateUserRole points the custom:role attribute of the panel user at the role
+func UpdateUserRole(cfg *aws.Config, username string, roleId string) error {
+	client := getClient(cfg)
+
+	input := cognitoidentityprovider.AdminUpdateUserAttributesInput{
+		Username:   aws.String(username),
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
+		UserAttributes: []types.AttributeType{
+			{Name: aws.String("custom:role"), Value: aws.String(roleId)},
+		},
+	}
+
+	_, err := client.AdminUpdateUserAttributes(context.TODO(), &input)
+	return err
+}
+
+// SignOutUser revokes the refresh tokens of the panel user, the access tokens already issued stay valid until they
+// expire unless the caller checks the sessions_revoked_at of the user
+func SignOutUser(cfg *aws.Config, username string) error {
+	client := getClient(cfg)
+
+	input := cognitoidentityprovider.AdminUserGlobalSignOutInput{
+		Username:   aws.String(username),
+		UserPoolId: aws.String(os.Getenv("AWS_USER_POOL_ID")),
+	}
+
+	_, err := client.AdminUserGlobalSignOut(context.TODO(), &input)
+	return err
+}
+
 func AuthViaRefreshToken(cfg *aws.Config, clientId, refreshToken string) (*cognitoidentityprovider.InitiateAuthOutput, error) {
 	client := getClient(cfg)
 
@@ -58,7 +58,7 @@ func TestCreateUserPoolGroup(t *testing.T) {
 	}
 
 	cfg := aws.Config{}
-	os.Setenv("us-east-1_kNKCRvql2", "test-user-pool-id")
+	os.Setenv("AWS_USER_POOL_ID", "test-user-pool-id")
 	group := Group{Name: "Admins", Description: "Admin group"}
 
 	output, err := CreateUserPoolGroup(&cfg, group)
@@ -77,7 +77,7 @@ func TestAddUsersToUserPoolGroup(t *testing.T) {
 	}
 
 	cfg := aws.Config{}
-	os.Setenv("us-east-1_kNKCRvql2", "test-user-pool-id")
+	os.Setenv("AWS_USER_POOL_ID", "test-user-pool-id")
 	output, err := AddUsersToUserPoolGroup(&cfg, "Admins", "testuser")
 
 	assert.NoError(t, err)
@@ -2,18 +2,150 @@ package internal
 
 import (
 	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/pkg/panelAdmins"
 	"control-panel-bk/util"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"log"
 	"net/http"
+	"os"
 	"strings"
+	"time"
 )
 
+// verifyAccessToken verifies the bearer token presented to AuthMiddleware
+var verifyAccessToken = func(ctx context.Context, token string) (*aws.CognitoClaims, error) {
+	return aws.DefaultJWTVerifier().Verify(ctx, token, aws.AccessTokenUse)
+}
+
+// callerRoleId resolves the role id of the authenticated caller from the role group of the "cognito:groups" claim,
+// then the "custom:role" claim, falling back to the role_id of the caller's user record when the token carries
+// neither (as with access tokens issued before the roles were mirrored as groups)
+var callerRoleId = func(r *http.Request, db *mongo.Database) (string, error) {
+	claims, ok := aws.ClaimsFromContext(r.Context())
+	if !ok {
+		return "", errors.New("missing access token claims")
+	}
+
+	if roleId, ok := panelAdmins.RoleOfGroups(claims.Groups); ok {
+		return roleId, nil
+	}
+
+	if claims.Role != "" {
+		return claims.Role, nil
+	}
+
+	var user panelAdmins.User
+	if err := db.Collection("users").FindOne(r.Context(), bson.M{"up_id": claims.Subject}).Decode(&user); err != nil {
+		return "", fmt.Errorf("no user record matches the caller: %w", err)
+	}
+
+	if user.RoleId == "" {
+		return "", errors.New("no role is assigned to the user")
+	}
+
+	return user.RoleId, nil
+}
+
+// callerSessionsRevokedAt reads the sessions_revoked_at of the caller's user record, nil when the sessions were never
+// revoked or no user record matches the caller
+var callerSessionsRevokedAt = func(r *http.Request, db *mongo.Database) (*time.Time, error) {
+	claims, ok := aws.ClaimsFromContext(r.Context())
+	if !ok {
+		return nil, errors.New("missing access token claims")
+	}
+
+	var user panelAdmins.User
+	opt := options.FindOne().SetProjection(bson.M{"sessions_revoked_at": 1})
+	if err := db.Collection("users").FindOne(r.Context(), bson.M{"up_id": claims.Subject}, opt).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return user.SessionsRevokedAt, nil
+}
+
+// sessionRevoked tells whether the token was issued before the sessions of the user were revoked. The issued at of a
+// token is in whole seconds, a token of the second of the revocation is refused too.
+func sessionRevoked(claims *aws.CognitoClaims, revokedAt *time.Time) bool {
+	if revokedAt == nil {
+		return false
+	}
+	if claims == nil || claims.IssuedAt == nil {
+		return true
+	}
+	return !claims.IssuedAt.Time.After(*revokedAt)
+}
+
+// checkSession answers 401 when the caller's sessions were revoked after the token was issued, the claims of the
+// caller are in the context of the request
+func checkSession(w http.ResponseWriter, r *http.Request, db *mongo.Database) bool {
+	revokedAt, err := callerSessionsRevokedAt(r, db)
+	if err != nil {
+		util.ErrorException(w, fmt.Errorf("unable to check the caller's session: %w", err), http.StatusUnauthorized)
+		return false
+	}
+
+	if claims, _ := aws.ClaimsFromContext(r.Context()); sessionRevoked(claims, revokedAt) {
+		util.ErrorException(w, errors.New("the session was revoked, sign in again"), http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+// fetchCallerRole loads the role document the caller's role id points at
+var fetchCallerRole = func(ctx context.Context, db *mongo.Database, roleId string) (*panelAdmins.Role, error) {
+	role, err, _ := panelAdmins.FetchRoleById(roleId, ctx, db)
+	return role, err
+}
+
+// resolveCallerGrants gathers the grants the caller's role holds along with the ones it inherits from its parents
+var resolveCallerGrants = func(ctx context.Context, db *mongo.Database, role *panelAdmins.Role) (panelAdmins.Grants, error) {
+	effective, err := panelAdmins.ResolveGrants(ctx, db, role)
+	if err != nil {
+		return nil, err
+	}
+	return effective.Grants, nil
+}
+
+// redactingLogFormatter logs the requests as middleware.Logger does, with the "token" query param the websocket
+// handshake carries the access token in left out
+type redactingLogFormatter struct {
+	middleware.LogFormatter
+}
+
+func (f redactingLogFormatter) NewLogEntry(r *http.Request) middleware.LogEntry {
+	query := r.URL.Query()
+	if !query.Has("token") {
+		return f.LogFormatter.NewLogEntry(r)
+	}
+
+	query.Set("token", "REDACTED")
+	redacted := *r.URL
+	redacted.RawQuery = query.Encode()
+
+	logged := r.WithContext(r.Context())
+	logged.RequestURI = redacted.RequestURI()
+	return f.LogFormatter.NewLogEntry(logged)
+}
+
+// requestLogger is middleware.Logger with the access token of the websocket handshake redacted
+var requestLogger = middleware.RequestLogger(redactingLogFormatter{
+	&middleware.DefaultLogFormatter{Logger: log.New(os.Stdout, "", log.LstdFlags)},
+})
+
 func appMiddleware(m *chi.Mux) {
-	m.Use(middleware.Logger)
+	m.Use(requestLogger)
 	m.Use(middleware.Recoverer)
 	m.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"https://*", "http://*"}, // Wild card
@@ -61,12 +193,62 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), "access_token", token)
+		claims, err := verifyAccessToken(r.Context(), *token)
+		if err != nil {
+			util.ErrorException(w, fmt.Errorf("invalid access token: %w", err), http.StatusUnauthorized)
+			return
+		}
+
+		ctx := aws.ContextWithClaims(r.Context(), claims)
 		newReq := r.WithContext(ctx)
 		next.ServeHTTP(w, newReq)
 	}
 }
 
+// PolicyMiddleware rejects the request with a 403 unless the caller's role grants the action on the resource, by its
+// own grants or the ones it inherits, and with a 401 when the caller's sessions were revoked after the token was
+// issued. It must run after AuthMiddleware.
+func PolicyMiddleware(db *mongo.Database, resource panelAdmins.Resource, action panelAdmins.Action, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !checkSession(w, r, db) {
+			return
+		}
+
+		roleId, err := callerRoleId(r, db)
+		if err != nil {
+			util.ErrorException(w, fmt.Errorf("unable to resolve the caller's role: %w", err), http.StatusUnauthorized)
+			return
+		}
+
+		role, err := fetchCallerRole(r.Context(), db, roleId)
+		if err != nil || role == nil {
+			forbidden(w, resource, action)
+			return
+		}
+
+		if role.ArchiveStatus || role.IsDeletedStatus {
+			forbidden(w, resource, action)
+			return
+		}
+
+		grants, err := resolveCallerGrants(r.Context(), db, role)
+		if err != nil {
+			util.ErrorException(w, fmt.Errorf("unable to resolve the caller's grants: %w", err), http.StatusInternalServerError)
+			return
+		}
+		if !grants.Allows(resource, action) {
+			forbidden(w, resource, action)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}
+}
+
+func forbidden(w http.ResponseWriter, resource panelAdmins.Resource, action panelAdmins.Action) {
+	util.ErrorException(w, fmt.Errorf("access denied: the %s grant is required", panelAdmins.NewGrant(resource, action)), http.StatusForbidden)
+}
+
 func AppAuthorizationMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
 
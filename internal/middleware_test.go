@@ -1,11 +1,24 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/pkg/panelAdmins"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
 func TestAppMiddleware(t *testing.T) {
@@ -37,3 +50,197 @@ func TestAppMiddleware(t *testing.T) {
 		t.Errorf("Expected status OK, got %v", resp.Status)
 	}
 }
+
+func TestRedactingLogFormatter(t *testing.T) {
+	var logged bytes.Buffer
+	formatter := redactingLogFormatter{&middleware.DefaultLogFormatter{Logger: log.New(&logged, "", 0), NoColor: true}}
+
+	handler := middleware.RequestLogger(formatter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret-token", r.URL.Query().Get("token"), "the handler still reads the token")
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/ws?token=secret-token&room=a", nil))
+
+	assert.NotContains(t, logged.String(), "secret-token")
+	assert.Contains(t, logged.String(), "/ws?room=a&token=REDACTED")
+}
+
+func TestPolicyMiddleware(t *testing.T) {
+	originalRoleId, originalFetch, originalRevokedAt, originalResolve := callerRoleId, fetchCallerRole, callerSessionsRevokedAt, resolveCallerGrants
+	defer func() {
+		callerRoleId, fetchCallerRole, callerSessionsRevokedAt, resolveCallerGrants = originalRoleId, originalFetch, originalRevokedAt, originalResolve
+	}()
+
+	roles := map[string]*panelAdmins.Role{
+		"reader": {
+			ID:     "reader",
+			Grants: panelAdmins.Grants{"role:read"},
+		},
+		"writer": {
+			ID:     "writer",
+			Grants: panelAdmins.Grants{"role:*"},
+		},
+		"auditor": {
+			ID:     "auditor",
+			Grants: panelAdmins.Grants{"*:read"},
+		},
+		"inheritor": {
+			ID:      "inheritor",
+			Grants:  panelAdmins.Grants{"team:read"},
+			Parents: []string{"writer"},
+		},
+		"archived": {
+			ID:            "archived",
+			Grants:        panelAdmins.Grants{"role:*"},
+			ArchiveStatus: true,
+		},
+	}
+
+	fetchCallerRole = func(ctx context.Context, db *mongo.Database, roleId string) (*panelAdmins.Role, error) {
+		if role, ok := roles[roleId]; ok {
+			return role, nil
+		}
+		return nil, errors.New("record regarding this role was not found")
+	}
+	resolveCallerGrants = func(ctx context.Context, db *mongo.Database, role *panelAdmins.Role) (panelAdmins.Grants, error) {
+		grants := role.Grants
+		for _, parent := range role.Parents {
+			grants = append(grants, roles[parent].Grants...)
+		}
+		return grants, nil
+	}
+
+	issuedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	revokedAt, revokedBefore := issuedAt.Add(30*time.Second), issuedAt.Add(-time.Hour)
+
+	testCases := []struct {
+		name       string
+		roleId     string
+		roleErr    error
+		revokedAt  *time.Time
+		resource   panelAdmins.Resource
+		action     panelAdmins.Action
+		wantStatus int
+	}{
+		{"read granted", "reader", nil, nil, panelAdmins.RoleResource, panelAdmins.ReadAction, http.StatusOK},
+		{"write denied", "reader", nil, nil, panelAdmins.RoleResource, panelAdmins.UpdateAction, http.StatusForbidden},
+		{"write granted", "writer", nil, nil, panelAdmins.RoleResource, panelAdmins.UpdateAction, http.StatusOK},
+		{"other resource denied", "writer", nil, nil, panelAdmins.BillingResource, panelAdmins.ReadAction, http.StatusForbidden},
+		{"wildcard resource granted", "auditor", nil, nil, panelAdmins.BillingResource, panelAdmins.ReadAction, http.StatusOK},
+		{"wildcard resource other action denied", "auditor", nil, nil, panelAdmins.TenantResource, panelAdmins.ArchiveAction, http.StatusForbidden},
+		{"inherited grant granted", "inheritor", nil, nil, panelAdmins.RoleResource, panelAdmins.UpdateAction, http.StatusOK},
+		{"grant neither held nor inherited denied", "inheritor", nil, nil, panelAdmins.TeamResource, panelAdmins.UpdateAction, http.StatusForbidden},
+		{"archived role denied", "archived", nil, nil, panelAdmins.RoleResource, panelAdmins.ReadAction, http.StatusForbidden},
+		{"unknown role denied", "ghost", nil, nil, panelAdmins.RoleResource, panelAdmins.ReadAction, http.StatusForbidden},
+		{"unresolved caller", "", errors.New("no role is assigned to the user"), nil, panelAdmins.RoleResource, panelAdmins.ReadAction, http.StatusUnauthorized},
+		{"revoked session", "writer", nil, &revokedAt, panelAdmins.RoleResource, panelAdmins.ReadAction, http.StatusUnauthorized},
+		{"session revoked before the token", "writer", nil, &revokedBefore, panelAdmins.RoleResource, panelAdmins.ReadAction, http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			callerRoleId = func(r *http.Request, db *mongo.Database) (string, error) {
+				return tc.roleId, tc.roleErr
+			}
+			callerSessionsRevokedAt = func(r *http.Request, db *mongo.Database) (*time.Time, error) {
+				return tc.revokedAt, nil
+			}
+
+			handler := PolicyMiddleware(nil, tc.resource, tc.action, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			rec := httptest.NewRecorder()
+			claims := &aws.CognitoClaims{Username: "jane@flowcx.io", RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(issuedAt)}}
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/roles/all", nil)
+			handler.ServeHTTP(rec, req.WithContext(aws.ContextWithClaims(req.Context(), claims)))
+
+			assert.Equal(t, tc.wantStatus, rec.Code)
+
+			if tc.wantStatus == http.StatusForbidden {
+				var body map[string]string
+				assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, fmt.Sprintf("access denied: the %s grant is required", panelAdmins.NewGrant(tc.resource, tc.action)), body["error"])
+			}
+		})
+	}
+}
+
+func TestCallerRoleId(t *testing.T) {
+	withClaims := func(claims *aws.CognitoClaims) *http.Request {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/roles/all", nil)
+		return req.WithContext(aws.ContextWithClaims(req.Context(), claims))
+	}
+
+	roleId, err := callerRoleId(withClaims(&aws.CognitoClaims{Groups: []string{"admins", panelAdmins.RoleGroupName("r1")}, Role: "r2"}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "r1", roleId, "the role group of the token is authoritative")
+
+	roleId, err = callerRoleId(withClaims(&aws.CognitoClaims{Groups: []string{"admins"}, Role: "r2"}), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "r2", roleId, "custom:role is read without a role group")
+}
+
+func TestSessionRevoked(t *testing.T) {
+	issuedAt := time.Date(2025, 3, 19, 15, 0, 0, 0, time.UTC)
+	claims := &aws.CognitoClaims{RegisteredClaims: jwt.RegisteredClaims{IssuedAt: jwt.NewNumericDate(issuedAt)}}
+
+	at := func(d time.Duration) *time.Time {
+		t := issuedAt.Add(d)
+		return &t
+	}
+
+	assert.False(t, sessionRevoked(claims, nil), "sessions never revoked")
+	assert.False(t, sessionRevoked(claims, at(-time.Second)), "a token issued after the revocation")
+	assert.True(t, sessionRevoked(claims, at(time.Minute)), "a token issued before the revocation")
+	assert.True(t, sessionRevoked(claims, at(300*time.Millisecond)), "a token of the second of the revocation")
+	assert.True(t, sessionRevoked(&aws.CognitoClaims{}, at(0)), "a token without an issued at")
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	originalVerify := verifyAccessToken
+	defer func() { verifyAccessToken = originalVerify }()
+
+	verifyAccessToken = func(ctx context.Context, token string) (*aws.CognitoClaims, error) {
+		if token != "valid-token" {
+			return nil, errors.New("token signature is invalid")
+		}
+		return &aws.CognitoClaims{Username: "jane@flowcx.io", TokenUse: aws.AccessTokenUse, Token: token}, nil
+	}
+
+	handler := AuthMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := aws.ClaimsFromContext(r.Context())
+		if !ok {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(claims.Actor()))
+	})
+
+	testCases := []struct {
+		name       string
+		header     string
+		wantStatus int
+		wantBody   string
+	}{
+		{"missing header", "", http.StatusUnauthorized, "missing Authorization header"},
+		{"wrong scheme", "Basic abc", http.StatusUnauthorized, "invalid Authorization header format"},
+		{"invalid token", "Bearer forged-token", http.StatusUnauthorized, "invalid access token"},
+		{"valid token", "Bearer valid-token", http.StatusOK, "jane@flowcx.io"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/roles/all", nil)
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.wantBody)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package notify
+
+import (
+	"context"
+	"control-panel-bk/util"
+	"github.com/gorilla/websocket"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// sendBuffer is the number of events queued for a socket before it is considered too slow and dropped
+	sendBuffer = 32
+
+	// maxMessageSize is the largest message a client may send, clients are not expected to send more than pongs
+	maxMessageSize = 1024
+)
+
+// Client is a single socket of a connected user
+type Client struct {
+	Id     string
+	UserId string
+	RoleId string
+
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// deliver queues the message without blocking the publisher, a client whose queue is full is disconnected
+func (c *Client) deliver(msg []byte) {
+	select {
+	case <-c.done:
+	case c.send <- msg:
+	default:
+		log.Printf("notify: dropping the slow socket of user %s", c.UserId)
+		c.Close()
+	}
+}
+
+// deliverLast queues the message followed by the end of the socket, which is closed once the message is written
+func (c *Client) deliverLast(msg []byte) {
+	c.deliver(msg)
+	c.deliver(nil)
+}
+
+// Close detaches the client from the hub and closes its socket, it is safe to call more than once
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.hub.remove(c)
+		c.conn.Close()
+
+		if c.hub.Presence != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), c.hub.WriteWait)
+			defer cancel()
+
+			if err := c.hub.Presence.Leave(ctx, c.UserId, c.Id); err != nil {
+				log.Printf("notify: unable to remove the presence of user %s: %s", c.UserId, err.Error())
+			}
+		}
+	})
+}
+
+// touch records the socket in the presence registry, it is repeated on every heartbeat to keep the entry alive
+func (c *Client) touch() {
+	if c.hub.Presence == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.hub.WriteWait)
+	defer cancel()
+
+	if err := c.hub.Presence.Touch(ctx, c.UserId, c.RoleId, c.Id); err != nil {
+		log.Printf("notify: unable to record the presence of user %s: %s", c.UserId, err.Error())
+	}
+}
+
+func newConnectionId() string {
+	id, err := util.GenerateUuid()
+	if err != nil {
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	return id.String()
+}
+
+// Serve pumps the socket until the peer goes away or stops answering the pings
+func (c *Client) Serve() {
+	go c.writePump()
+	c.readPump()
+}
+
+func (c *Client) readPump() {
+	defer c.Close()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.hub.PongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(c.hub.PongWait))
+	})
+
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Printf("notify: socket of user %s closed: %s", c.UserId, err.Error())
+			}
+			return
+		}
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(c.hub.PingInterval)
+
+	defer func() {
+		ticker.Stop()
+		c.Close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(c.hub.WriteWait))
+			return
+		case msg := <-c.send:
+			if msg == nil {
+				c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(c.hub.WriteWait))
+				return
+			}
+
+			c.conn.SetWriteDeadline(time.Now().Add(c.hub.WriteWait))
+			if err := c.conn.WriteMessage(websocket.TextMessage, msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.hub.WriteWait)); err != nil {
+				return
+			}
+			c.touch()
+		}
+	}
+}
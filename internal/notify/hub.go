@@ -0,0 +1,319 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"log"
+	"sync"
+	"time"
+)
+
+type EventType string
+
+const (
+	RoleUpdated    EventType = "role.updated"
+	RoleArchived   EventType = "role.archived"
+	RoleUnArchived EventType = "role.unarchived"
+	RoleBinned     EventType = "role.binned"
+	RoleRestored   EventType = "role.restored"
+
+	TeamMemberAdded   EventType = "team.member_added"
+	TeamMemberRemoved EventType = "team.member_removed"
+	TeamLeadChanged   EventType = "team.lead_changed"
+	TeamArchived      EventType = "team.archived"
+	TeamUnArchived    EventType = "team.unarchived"
+
+	UserDeactivated EventType = "user.deactivated"
+	UserActivated   EventType = "user.activated"
+	UserRoleChanged EventType = "user.role_changed"
+
+	OnboardingStepAssigned EventType = "onboarding.step_assigned"
+)
+
+type TargetKind string
+
+const (
+	UserTarget TargetKind = "user"
+	RoleTarget TargetKind = "role"
+	TeamTarget TargetKind = "team"
+)
+
+// Target is the audience of an event: a single user, every user holding a role, or every member of a team
+type Target struct {
+	Kind TargetKind `json:"kind"`
+	Id   string     `json:"id"`
+}
+
+func ToUser(userId string) Target { return Target{Kind: UserTarget, Id: userId} }
+func ToRole(roleId string) Target { return Target{Kind: RoleTarget, Id: roleId} }
+func ToTeam(teamId string) Target { return Target{Kind: TeamTarget, Id: teamId} }
+
+// Event is the message written to the sockets of the target
+type Event struct {
+	Type   EventType   `json:"type"`
+	Target Target      `json:"target"`
+	Data   interface{} `json:"data,omitempty"`
+	SentAt time.Time   `json:"sent_at"`
+}
+
+// TeamResolver returns the user ids of the members (lead included) of a team
+type TeamResolver func(ctx context.Context, teamId string) ([]string, error)
+
+// Hub tracks the open sockets of every connected user and fans events out to them
+type Hub struct {
+	// ResolveTeam expands a team target into its members, it must be set before events are pushed to teams
+	ResolveTeam TeamResolver
+
+	// Presence records the open sockets in a registry shared by every instance, it is optional
+	Presence PresenceRegistry
+
+	PingInterval time.Duration
+	PongWait     time.Duration
+	WriteWait    time.Duration
+
+	mu     sync.RWMutex
+	users  map[string]map[*Client]struct{}
+	roles  map[string]map[*Client]struct{}
+	broker Broker
+}
+
+// Default is the hub the server's sockets are registered with
+var Default = NewHub()
+
+func NewHub() *Hub {
+	return &Hub{
+		PingInterval: 50 * time.Second,
+		PongWait:     60 * time.Second,
+		WriteWait:    10 * time.Second,
+		users:        make(map[string]map[*Client]struct{}),
+		roles:        make(map[string]map[*Client]struct{}),
+	}
+}
+
+func (h *Hub) add(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.users[c.UserId] == nil {
+		h.users[c.UserId] = make(map[*Client]struct{})
+	}
+	h.users[c.UserId][c] = struct{}{}
+
+	if c.RoleId != "" {
+		if h.roles[c.RoleId] == nil {
+			h.roles[c.RoleId] = make(map[*Client]struct{})
+		}
+		h.roles[c.RoleId][c] = struct{}{}
+	}
+}
+
+func (h *Hub) remove(c *Client) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if clients, ok := h.users[c.UserId]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.users, c.UserId)
+		}
+	}
+
+	if clients, ok := h.roles[c.RoleId]; ok {
+		delete(clients, c)
+		if len(clients) == 0 {
+			delete(h.roles, c.RoleId)
+		}
+	}
+}
+
+// Connections is the number of sockets the user currently has open on the hub
+func (h *Hub) Connections(userId string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return len(h.users[userId])
+}
+
+// envelope is an event together with its resolved audience, as it travels between the instances of the server
+type envelope struct {
+	Event Event    `json:"event"`
+	Users []string `json:"users,omitempty"`
+	Roles []string `json:"roles,omitempty"`
+
+	// Disconnect closes the sockets of the audience once the event is written to them
+	Disconnect bool `json:"disconnect,omitempty"`
+}
+
+// Publish pushes the event to every socket of the target. With a broker the event reaches the sockets held by every
+// instance of the server, this one included, otherwise only the sockets held by this instance.
+func (h *Hub) Publish(ctx context.Context, target Target, eventType EventType, data interface{}) error {
+	env := envelope{Event: Event{Type: eventType, Target: target, Data: data, SentAt: time.Now().UTC()}}
+
+	switch target.Kind {
+	case UserTarget:
+		env.Users = []string{target.Id}
+	case RoleTarget:
+		env.Roles = []string{target.Id}
+	case TeamTarget:
+		if h.ResolveTeam == nil {
+			return errors.New("the hub cannot resolve team members")
+		}
+
+		// Teams are resolved once by the publisher rather than by every instance
+		members, err := h.ResolveTeam(ctx, target.Id)
+		if err != nil {
+			return fmt.Errorf("unable to resolve the members of team %s: %w", target.Id, err)
+		}
+		env.Users = members
+	default:
+		return fmt.Errorf("unknown target kind %q", target.Kind)
+	}
+
+	return h.send(ctx, env)
+}
+
+// Disconnect pushes the event to the sockets of the user as the last one they get and closes them, on every instance
+// with a broker. It is used once the user can no longer hold a socket: they were deactivated or their sessions were
+// revoked, the socket they open again is checked like any handshake.
+func (h *Hub) Disconnect(ctx context.Context, userId string, eventType EventType, data interface{}) error {
+	env := envelope{
+		Event:      Event{Type: eventType, Target: ToUser(userId), Data: data, SentAt: time.Now().UTC()},
+		Users:      []string{userId},
+		Disconnect: true,
+	}
+
+	return h.send(ctx, env)
+}
+
+// send dispatches the envelope to the local sockets, or through the broker when there is one
+func (h *Hub) send(ctx context.Context, env envelope) error {
+	h.mu.RLock()
+	broker := h.broker
+	h.mu.RUnlock()
+
+	if broker == nil {
+		return h.dispatch(env)
+	}
+
+	msg, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+
+	if err := broker.Publish(ctx, msg); err != nil {
+		// The sockets of this instance are still served while the broker is unreachable
+		if dispatchErr := h.dispatch(env); dispatchErr != nil {
+			return dispatchErr
+		}
+		return fmt.Errorf("unable to fan the event out to the other instances: %w", err)
+	}
+
+	return nil
+}
+
+// UseBroker fans the events published on any instance out to the sockets of this one, until the context is done
+func (h *Hub) UseBroker(ctx context.Context, broker Broker) error {
+	err := broker.Subscribe(ctx, func(msg []byte) {
+		var env envelope
+		if err := json.Unmarshal(msg, &env); err != nil {
+			log.Printf("notify: dropping a malformed event: %s", err.Error())
+			return
+		}
+
+		if err := h.dispatch(env); err != nil {
+			log.Printf("notify: unable to dispatch %s: %s", env.Event.Type, err.Error())
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.broker = broker
+	h.mu.Unlock()
+
+	return nil
+}
+
+// dispatch writes the event to the local sockets of its audience
+func (h *Hub) dispatch(env envelope) error {
+	msg, err := json.Marshal(env.Event)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range h.audience(env.Users, env.Roles) {
+		if env.Disconnect {
+			c.deliverLast(msg)
+		} else {
+			c.deliver(msg)
+		}
+	}
+
+	return nil
+}
+
+func (h *Hub) audience(userIds []string, roleIds []string) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var clients []*Client
+	seen := make(map[*Client]bool)
+
+	collect := func(set map[*Client]struct{}) {
+		for c := range set {
+			if !seen[c] {
+				seen[c] = true
+				clients = append(clients, c)
+			}
+		}
+	}
+
+	for _, id := range roleIds {
+		collect(h.roles[id])
+	}
+
+	for _, id := range userIds {
+		collect(h.users[id])
+	}
+
+	return clients
+}
+
+// Publish pushes the event through the Default hub, logging rather than returning a failure as the change the
+// event describes has already been made
+func Publish(ctx context.Context, target Target, eventType EventType, data interface{}) {
+	if err := Default.Publish(ctx, target, eventType, data); err != nil {
+		log.Printf("notify: unable to publish %s to %s %s: %s", eventType, target.Kind, target.Id, err.Error())
+	}
+}
+
+// Disconnect pushes the event to the user and closes their sockets through the Default hub, logging rather than
+// returning a failure as Publish does
+func Disconnect(ctx context.Context, userId string, eventType EventType, data interface{}) {
+	if err := Default.Disconnect(ctx, userId, eventType, data); err != nil {
+		log.Printf("notify: unable to disconnect user %s on %s: %s", userId, eventType, err.Error())
+	}
+}
+
+// Register attaches the socket of the user to the hub, Serve must then be called on the client to pump it
+func (h *Hub) Register(conn *websocket.Conn, userId string, roleId string) *Client {
+	c := &Client{
+		Id:     newConnectionId(),
+		UserId: userId,
+		RoleId: roleId,
+		hub:    h,
+		conn:   conn,
+		send:   make(chan []byte, sendBuffer),
+		done:   make(chan struct{}),
+	}
+
+	h.add(c)
+	c.touch()
+
+	return c
+}
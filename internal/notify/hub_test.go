@@ -0,0 +1,200 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// serveHub registers every socket on the hub under the user and role given in the query params
+func serveHub(t *testing.T, hub *Hub) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		hub.Register(conn, r.URL.Query().Get("user"), r.URL.Query().Get("role")).Serve()
+	}))
+
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func dial(t *testing.T, srv *httptest.Server, hub *Hub, user, role string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "?user=" + user + "&role=" + role
+	before := hub.Connections(user)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	// Registration happens on the server side of the handshake
+	require.Eventually(t, func() bool { return hub.Connections(user) == before+1 }, time.Second, 5*time.Millisecond)
+
+	return conn
+}
+
+func readEvent(t *testing.T, conn *websocket.Conn) (Event, error) {
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+	var event Event
+	_, msg, err := conn.ReadMessage()
+	if err != nil {
+		return event, err
+	}
+
+	require.NoError(t, json.Unmarshal(msg, &event))
+	return event, nil
+}
+
+func TestHub_PublishToUser(t *testing.T) {
+	hub := NewHub()
+	srv := serveHub(t, hub)
+
+	laptop := dial(t, srv, hub, "u1", "r1")
+	phone := dial(t, srv, hub, "u1", "r1")
+	other := dial(t, srv, hub, "u2", "r1")
+
+	err := hub.Publish(context.Background(), ToUser("u1"), UserDeactivated, map[string]string{"id": "u1"})
+	require.NoError(t, err)
+
+	for _, conn := range []*websocket.Conn{laptop, phone} {
+		event, err := readEvent(t, conn)
+		require.NoError(t, err)
+		assert.Equal(t, UserDeactivated, event.Type)
+		assert.Equal(t, ToUser("u1"), event.Target)
+	}
+
+	_, err = readEvent(t, other)
+	assert.Error(t, err, "the event is only pushed to the target user")
+}
+
+func TestHub_Disconnect(t *testing.T) {
+	hub := NewHub()
+	srv := serveHub(t, hub)
+
+	laptop := dial(t, srv, hub, "u1", "r1")
+	phone := dial(t, srv, hub, "u1", "r1")
+	other := dial(t, srv, hub, "u2", "r1")
+
+	require.NoError(t, hub.Disconnect(context.Background(), "u1", UserDeactivated, nil))
+
+	for _, conn := range []*websocket.Conn{laptop, phone} {
+		event, err := readEvent(t, conn)
+		require.NoError(t, err)
+		assert.Equal(t, UserDeactivated, event.Type, "the event is written before the socket is closed")
+
+		_, err = readEvent(t, conn)
+		assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "the socket is closed")
+	}
+	assert.Eventually(t, func() bool { return hub.Connections("u1") == 0 }, time.Second, 5*time.Millisecond)
+
+	require.NoError(t, hub.Publish(context.Background(), ToUser("u2"), UserActivated, nil))
+	event, err := readEvent(t, other)
+	require.NoError(t, err)
+	assert.Equal(t, UserActivated, event.Type, "the sockets of the other users are kept")
+}
+
+func TestHub_PublishToRoleAndTeam(t *testing.T) {
+	hub := NewHub()
+	hub.ResolveTeam = func(ctx context.Context, teamId string) ([]string, error) {
+		return []string{"u2", "u3"}, nil
+	}
+	srv := serveHub(t, hub)
+
+	u1 := dial(t, srv, hub, "u1", "admin")
+	u2 := dial(t, srv, hub, "u2", "admin")
+	u3 := dial(t, srv, hub, "u3", "support")
+
+	require.NoError(t, hub.Publish(context.Background(), ToRole("admin"), RoleUpdated, nil))
+
+	for _, conn := range []*websocket.Conn{u1, u2} {
+		event, err := readEvent(t, conn)
+		require.NoError(t, err)
+		assert.Equal(t, RoleUpdated, event.Type)
+	}
+
+	require.NoError(t, hub.Publish(context.Background(), ToTeam("t1"), TeamLeadChanged, nil))
+
+	// u3 does not hold the role, hence the first event it gets is the team's
+	for _, conn := range []*websocket.Conn{u2, u3} {
+		event, err := readEvent(t, conn)
+		require.NoError(t, err)
+		assert.Equal(t, TeamLeadChanged, event.Type)
+	}
+
+	// A failed read breaks the socket, hence the absence of events is asserted last
+	_, err := readEvent(t, u1)
+	assert.Error(t, err, "u1 is not a member of the team")
+}
+
+func TestHub_PublishToTeamWithoutResolver(t *testing.T) {
+	hub := NewHub()
+	assert.Error(t, hub.Publish(context.Background(), ToTeam("t1"), TeamArchived, nil))
+	assert.Error(t, hub.Publish(context.Background(), Target{Kind: "tenant", Id: "x"}, TeamArchived, nil))
+}
+
+func TestHub_UnregistersClosedSockets(t *testing.T) {
+	hub := NewHub()
+	srv := serveHub(t, hub)
+
+	conn := dial(t, srv, hub, "u1", "r1")
+	require.Equal(t, 1, hub.Connections("u1"))
+
+	conn.Close()
+
+	assert.Eventually(t, func() bool { return hub.Connections("u1") == 0 }, time.Second, 5*time.Millisecond)
+	assert.NoError(t, hub.Publish(context.Background(), ToUser("u1"), UserActivated, nil))
+}
+
+func TestHub_Keepalive(t *testing.T) {
+	hub := NewHub()
+	hub.PingInterval = 20 * time.Millisecond
+	hub.PongWait = 60 * time.Millisecond
+	srv := serveHub(t, hub)
+
+	conn := dial(t, srv, hub, "u1", "r1")
+
+	var pings atomic.Int32
+	conn.SetPingHandler(func(data string) error {
+		pings.Add(1)
+		return conn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+
+	// Control frames are only handled while the client reads, the pongs keep the socket open past the PongWait
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+
+	assert.GreaterOrEqual(t, pings.Load(), int32(3))
+	assert.Equal(t, 1, hub.Connections("u1"))
+}
+
+func TestHub_DropsUnresponsivePeers(t *testing.T) {
+	hub := NewHub()
+	hub.PingInterval = 20 * time.Millisecond
+	hub.PongWait = 60 * time.Millisecond
+	srv := serveHub(t, hub)
+
+	// The client never reads, hence never answers the pings
+	dial(t, srv, hub, "u1", "r1")
+
+	assert.Eventually(t, func() bool { return hub.Connections("u1") == 0 }, time.Second, 10*time.Millisecond)
+}
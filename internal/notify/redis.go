@@ -0,0 +1,123 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-redis/redis/v8"
+	"log"
+	"strconv"
+	"time"
+)
+
+// EventsChannel is the Redis channel the events are fanned out on
+const EventsChannel = "notify:events"
+
+// presencePrefix prefixes the key of the sorted set holding the open sockets of a user
+const presencePrefix = "websocket_presence"
+
+// Broker carries the events published on one instance of the server to every instance
+type Broker interface {
+	Publish(ctx context.Context, msg []byte) error
+
+	// Subscribe hands every message to the handler until the context is done, it returns once the subscription is live
+	Subscribe(ctx context.Context, handler func(msg []byte)) error
+}
+
+// PresenceRegistry records which users have a socket open, on whichever instance holds it
+type PresenceRegistry interface {
+	Touch(ctx context.Context, userId string, roleId string, connId string) error
+	Leave(ctx context.Context, userId string, connId string) error
+	Connections(ctx context.Context, userId string) (int64, error)
+}
+
+type RedisBroker struct {
+	client  *redis.Client
+	channel string
+}
+
+func NewRedisBroker(client *redis.Client, channel string) *RedisBroker {
+	return &RedisBroker{client: client, channel: channel}
+}
+
+func (b *RedisBroker) Publish(ctx context.Context, msg []byte) error {
+	return b.client.Publish(ctx, b.channel, msg).Err()
+}
+
+func (b *RedisBroker) Subscribe(ctx context.Context, handler func(msg []byte)) error {
+	sub := b.client.Subscribe(ctx, b.channel)
+
+	// Waits for the confirmation, so that no event published after Subscribe returns is missed
+	if _, err := sub.Receive(ctx); err != nil {
+		sub.Close()
+		return fmt.Errorf("unable to subscribe to %s: %w", b.channel, err)
+	}
+
+	go func() {
+		defer sub.Close()
+
+		// The channel is re-subscribed by the client whenever the connection to Redis drops
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					log.Printf("notify: the subscription to %s was closed", b.channel)
+					return
+				}
+				handler([]byte(msg.Payload))
+			}
+		}
+	}()
+
+	return nil
+}
+
+// RedisPresence keeps the open sockets of a user in a sorted set scored by the time each entry expires. Every
+// heartbeat pushes the expiry of the socket forward, hence the sockets of an instance that crashed expire on their own.
+type RedisPresence struct {
+	client *redis.Client
+	ttl    time.Duration
+}
+
+func NewRedisPresence(client *redis.Client, ttl time.Duration) *RedisPresence {
+	return &RedisPresence{client: client, ttl: ttl}
+}
+
+func presenceKey(userId string) string {
+	return fmt.Sprintf("%s:%s", presencePrefix, userId)
+}
+
+func (p *RedisPresence) Touch(ctx context.Context, userId string, roleId string, connId string) error {
+	key := presenceKey(userId)
+	expiresAt := time.Now().Add(p.ttl)
+
+	_, err := p.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(expiresAt.UnixMilli()), Member: connId})
+		pipe.HSet(ctx, key+":role", "role", roleId)
+
+		// The whole set goes away once its last socket stops beating
+		pipe.PExpire(ctx, key, p.ttl)
+		pipe.PExpire(ctx, key+":role", p.ttl)
+		return nil
+	})
+
+	return err
+}
+
+func (p *RedisPresence) Leave(ctx context.Context, userId string, connId string) error {
+	return p.client.ZRem(ctx, presenceKey(userId), connId).Err()
+}
+
+// Connections is the number of live sockets the user has open across every instance
+func (p *RedisPresence) Connections(ctx context.Context, userId string) (int64, error) {
+	key := presenceKey(userId)
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+
+	if err := p.client.ZRemRangeByScore(ctx, key, "-inf", now).Err(); err != nil {
+		return 0, err
+	}
+
+	return p.client.ZCard(ctx, key).Result()
+}
@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func newRedis(t *testing.T) *redis.Client {
+	mr := miniredis.RunT(t)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestRedisBroker_FansOutAcrossInstances(t *testing.T) {
+	rdb := newRedis(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Two instances of the server, each holding its own sockets
+	podA, podB := NewHub(), NewHub()
+	podA.ResolveTeam = func(ctx context.Context, teamId string) ([]string, error) {
+		return []string{"u2"}, nil
+	}
+
+	require.NoError(t, podA.UseBroker(ctx, NewRedisBroker(rdb, EventsChannel)))
+	require.NoError(t, podB.UseBroker(ctx, NewRedisBroker(rdb, EventsChannel)))
+
+	srvA, srvB := serveHub(t, podA), serveHub(t, podB)
+
+	onA := dial(t, srvA, podA, "u1", "admin")
+	onB := dial(t, srvB, podB, "u2", "admin")
+
+	// Published on pod A, the user connected to pod B gets it
+	require.NoError(t, podA.Publish(ctx, ToUser("u2"), TeamMemberAdded, nil))
+
+	event, err := readEvent(t, onB)
+	require.NoError(t, err)
+	assert.Equal(t, TeamMemberAdded, event.Type)
+
+	// Role targets reach the holders on every pod, each socket gets the event once
+	require.NoError(t, podB.Publish(ctx, ToRole("admin"), RoleUpdated, nil))
+
+	for _, conn := range []*websocket.Conn{onA, onB} {
+		event, err := readEvent(t, conn)
+		require.NoError(t, err)
+		assert.Equal(t, RoleUpdated, event.Type)
+	}
+
+	// Teams are resolved by the publishing pod
+	require.NoError(t, podA.Publish(ctx, ToTeam("t1"), TeamLeadChanged, nil))
+
+	event, err = readEvent(t, onB)
+	require.NoError(t, err)
+	assert.Equal(t, TeamLeadChanged, event.Type)
+
+	_, err = readEvent(t, onA)
+	assert.Error(t, err, "u1 is not a member of the team")
+}
+
+func TestRedisBroker_DeliversLocallyWhenRedisIsDown(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1})
+	defer rdb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewHub()
+	require.NoError(t, hub.UseBroker(ctx, NewRedisBroker(rdb, EventsChannel)))
+
+	conn := dial(t, serveHub(t, hub), hub, "u1", "r1")
+
+	mr.Close()
+
+	assert.Error(t, hub.Publish(ctx, ToUser("u1"), UserDeactivated, nil))
+
+	event, err := readEvent(t, conn)
+	require.NoError(t, err)
+	assert.Equal(t, UserDeactivated, event.Type)
+}
+
+func TestRedisPresence(t *testing.T) {
+	rdb := newRedis(t)
+	ctx := context.Background()
+
+	presence := NewRedisPresence(rdb, 100*time.Millisecond)
+
+	require.NoError(t, presence.Touch(ctx, "u1", "r1", "laptop"))
+	require.NoError(t, presence.Touch(ctx, "u1", "r1", "phone"))
+
+	count, err := presence.Connections(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+
+	require.NoError(t, presence.Leave(ctx, "u1", "phone"))
+
+	count, err = presence.Connections(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// The pod holding the laptop socket crashes, hence the entry is never refreshed nor removed
+	time.Sleep(150 * time.Millisecond)
+
+	count, err = presence.Connections(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestHub_RecordsPresence(t *testing.T) {
+	rdb := newRedis(t)
+	ctx := context.Background()
+
+	hub := NewHub()
+	hub.PingInterval = 20 * time.Millisecond
+	hub.Presence = NewRedisPresence(rdb, 50*time.Millisecond)
+
+	conn := dial(t, serveHub(t, hub), hub, "u1", "r1")
+
+	// Reading answers the pings, and every ping refreshes the entry past its TTL
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	time.Sleep(120 * time.Millisecond)
+
+	count, err := hub.Presence.Connections(ctx, "u1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	conn.Close()
+
+	assert.Eventually(t, func() bool {
+		count, err := hub.Presence.Connections(ctx, "u1")
+		return err == nil && count == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -2,11 +2,16 @@ package internal
 
 import (
 	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
 	"control-panel-bk/pkg"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/pkg/onboarding"
 	"control-panel-bk/pkg/panelAdmins"
+	"control-panel-bk/pkg/tenants"
 	"control-panel-bk/pkg/tiers"
 	"github.com/go-chi/chi/v5"
 	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
 )
 
 func getDB(client *mongo.Client) *mongo.Database {
@@ -21,13 +26,19 @@ func Routes() *chi.Mux {
 	appMiddleware(mux)
 
 	db := getDB(aws.MongoDBClient)
+	notify.Default.ResolveTeam = teamMembers(db)
+
+	// policy authenticates the caller and checks their role grants the action on the resource
+	policy := func(resource panelAdmins.Resource, action panelAdmins.Action, next http.HandlerFunc) http.HandlerFunc {
+		return AuthMiddleware(PolicyMiddleware(db, resource, action, next))
+	}
 
 	// Routes
 	mux.Route("/api", func(r chi.Router) {
 		r.Route("/v1", func(r chi.Router) {
 			// Auth Sub Routes
 			r.Route("/auth", func(authRouter chi.Router) {
-				authRouter.Post("/create", panelAdmins.CreateUser(aws.MongoDBClient))
+				authRouter.Post("/create", policy(panelAdmins.UserResource, panelAdmins.CreateAction, panelAdmins.CreateUser(aws.MongoDBClient)))
 				authRouter.Get("/refresh-token", pkg.RefreshTokenAuth)
 				authRouter.Post("/login", pkg.LoginHandler)
 				authRouter.Get("/logout", AuthMiddleware(pkg.LogoutHandler))
@@ -38,57 +49,132 @@ func Routes() *chi.Mux {
 
 			// The Tier Sub Routes
 			r.Route("/tier", func(tierRouter chi.Router) {
-				tierRouter.Get("/all", AuthMiddleware(tiers.HandleFetchTiers))
-				tierRouter.Get("/{id}", AuthMiddleware(tiers.HandleFetchTier))
+				tierRouter.Get("/all", policy(panelAdmins.BillingResource, panelAdmins.ReadAction, tiers.HandleFetchTiers(db))) // ?live=true reads from Paystack, ?drift=true lists the drifted plans
+				tierRouter.Get("/{id}", policy(panelAdmins.BillingResource, panelAdmins.ReadAction, tiers.HandleFetchTier(db)))
 
 				tierRouter.Group(func(tierRouterGroup chi.Router) {
-					tierRouterGroup.Post("/", AuthMiddleware(tiers.HandleTierCreation))
-					tierRouterGroup.Put("/{id}", AuthMiddleware(tiers.HandleUpdateTier))
+					tierRouterGroup.Post("/", policy(panelAdmins.BillingResource, panelAdmins.CreateAction, tiers.HandleTierCreation(db)))
+					tierRouterGroup.Put("/{id}", policy(panelAdmins.BillingResource, panelAdmins.UpdateAction, tiers.HandleUpdateTier(db)))
+					tierRouterGroup.Post("/reconcile", policy(panelAdmins.BillingResource, panelAdmins.UpdateAction, tiers.HandleReconcileTiers(db)))
+				})
+			})
+
+			// The Subscription Sub Routes
+			r.Route("/subscriptions", func(subRouter chi.Router) {
+				subRouter.Get("/", policy(panelAdmins.BillingResource, panelAdmins.ReadAction, tiers.HandleFetchSubscriptions)) // takes the query params page, perPage, customer and plan
+				subRouter.Get("/{code}", policy(panelAdmins.BillingResource, panelAdmins.ReadAction, tiers.HandleFetchSubscription))
+				subRouter.Get("/{code}/manage-link", policy(panelAdmins.BillingResource, panelAdmins.ReadAction, tiers.HandleManageLink))
+
+				subRouter.Group(func(subRouterGroup chi.Router) {
+					subRouterGroup.Post("/", policy(panelAdmins.BillingResource, panelAdmins.CreateAction, tiers.HandleCreateSubscription(db)))
+					subRouterGroup.Patch("/{code}/enable", policy(panelAdmins.BillingResource, panelAdmins.UpdateAction, tiers.HandleEnableSubscription(db)))
+					subRouterGroup.Patch("/{code}/disable", policy(panelAdmins.BillingResource, panelAdmins.UpdateAction, tiers.HandleDisableSubscription(db)))
+					subRouterGroup.Post("/{code}/manage-link/email", policy(panelAdmins.BillingResource, panelAdmins.UpdateAction, tiers.HandleSendManageLink))
 				})
 			})
 
 			// The Panel-Admins Sub Routes
 			// Role sub-router
 			r.Route("/roles", func(roleRouter chi.Router) {
-				roleRouter.Post("/", AuthMiddleware(panelAdmins.HandleCreateRole(db)))
-				roleRouter.Get("/all", AuthMiddleware(panelAdmins.HandleFetchRoles(db)))
-				roleRouter.Get("/{id}", AuthMiddleware(panelAdmins.HandleFetchRoleById(db)))
-				roleRouter.Get("/name", AuthMiddleware(panelAdmins.HandleFetchRoleByName(db))) // takes the query params page and limit
-
-				roleRouter.Patch("/update", AuthMiddleware(panelAdmins.HandleGeneralUpdate(db)))
-				roleRouter.Patch("/archive", AuthMiddleware(panelAdmins.HandleArchiveRole(db)))
-				roleRouter.Patch("/unarchive", AuthMiddleware(panelAdmins.HandleUnArchiveRole(db)))
-				roleRouter.Patch("/bin", AuthMiddleware(panelAdmins.HandlePushRoleToBin(db)))
-				roleRouter.Patch("/restore", AuthMiddleware(panelAdmins.HandleRestoreRoleFromBin(db)))
-
-				roleRouter.Delete("/delete", panelAdmins.HandleHardDeleteOfRole(db))
+				roleRouter.Post("/", policy(panelAdmins.RoleResource, panelAdmins.CreateAction, panelAdmins.HandleCreateRole(db)))
+				roleRouter.Get("/all", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleFetchRoles(db)))
+				roleRouter.Get("/bin", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleFetchBinnedRoles(db)))
+				roleRouter.Get("/bin/purge-preview", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandlePreviewRoleBinPurge(db)))
+				roleRouter.Get("/export", policy(panelAdmins.RoleResource, panelAdmins.ExportAction, panelAdmins.HandleExportRoles(db))) // takes the query params format, view, filter[field][op] and sort
+				roleRouter.Get("/{id}", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleFetchRoleById(db)))
+				roleRouter.Get("/{id}/versions", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleFetchRoleVersions(db)))     // takes the query params cursor, page and limit
+				roleRouter.Get("/{id}/versions/diff", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleDiffRoleVersions(db))) // takes the query params from and to
+				roleRouter.Get("/{id}/effective-grants", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleFetchEffectiveGrants(db)))
+				roleRouter.Get("/name", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleFetchRoleByName(db))) // takes the query params name, view, cursor, page and limit
+
+				roleRouter.Patch("/update", policy(panelAdmins.RoleResource, panelAdmins.UpdateAction, panelAdmins.HandleGeneralUpdate(db)))
+				roleRouter.Patch("/archive", policy(panelAdmins.RoleResource, panelAdmins.ArchiveAction, panelAdmins.HandleArchiveRole(db)))
+				roleRouter.Patch("/unarchive", policy(panelAdmins.RoleResource, panelAdmins.ArchiveAction, panelAdmins.HandleUnArchiveRole(db)))
+				roleRouter.Patch("/bin", policy(panelAdmins.RoleResource, panelAdmins.DeleteAction, panelAdmins.HandlePushRoleToBin(db)))
+				roleRouter.Patch("/restore", policy(panelAdmins.RoleResource, panelAdmins.RestoreAction, panelAdmins.HandleRestoreRoleFromBin(db)))
+				roleRouter.Post("/{id}/revert/{version}", policy(panelAdmins.RoleResource, panelAdmins.UpdateAction, panelAdmins.HandleRevertRole(db)))
+
+				roleRouter.Delete("/delete", policy(panelAdmins.RoleResource, panelAdmins.DeleteAction, panelAdmins.HandleHardDeleteOfRole(db)))
 			})
 
+			// The resources and actions the roles can be granted, read by the role editors
+			r.Get("/permissions/catalog", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, panelAdmins.HandleFetchPermissionCatalog(db)))
+
 			// Team sub-router
 			r.Route("/teams", func(teamRouter chi.Router) {
-				teamRouter.Post("/create", AuthMiddleware(panelAdmins.HandleCreateTeam(db)))
+				teamRouter.Post("/create", policy(panelAdmins.TeamResource, panelAdmins.CreateAction, panelAdmins.HandleCreateTeam(db)))
+
+				teamRouter.Patch("/archive", policy(panelAdmins.TeamResource, panelAdmins.ArchiveAction, panelAdmins.HandleArchiveTeam(db)))
+				teamRouter.Patch("/unarchive", policy(panelAdmins.TeamResource, panelAdmins.ArchiveAction, panelAdmins.HandleUnArchiveTeam(db)))
+				teamRouter.Patch("/add-members", policy(panelAdmins.TeamResource, panelAdmins.UpdateAction, panelAdmins.HandleAddNewMembers(db)))
+				teamRouter.Patch("/remove-members", policy(panelAdmins.TeamResource, panelAdmins.UpdateAction, panelAdmins.HandleRemoveNewMembers(db)))
+				teamRouter.Patch("/change-lead", policy(panelAdmins.TeamResource, panelAdmins.UpdateAction, panelAdmins.HandleChangeTeamLead(db)))
+				teamRouter.Patch("/bin", policy(panelAdmins.TeamResource, panelAdmins.DeleteAction, panelAdmins.PushTeamToBin(db)))
+				teamRouter.Patch("/restore", policy(panelAdmins.TeamResource, panelAdmins.RestoreAction, panelAdmins.RestoreTeamFromBin(db)))
+
+				teamRouter.Delete("/delete", policy(panelAdmins.TeamResource, panelAdmins.DeleteAction, panelAdmins.HardDeleteTeam(db)))
+
+				teamRouter.Get("/{id}", policy(panelAdmins.TeamResource, panelAdmins.ReadAction, panelAdmins.GetTeam(db)))
+				teamRouter.Get("/all", policy(panelAdmins.TeamResource, panelAdmins.ReadAction, panelAdmins.GetTeams(db)))
+				teamRouter.Get("/bin", policy(panelAdmins.TeamResource, panelAdmins.ReadAction, panelAdmins.GetBinnedTeams(db)))
+				teamRouter.Get("/bin/purge-preview", policy(panelAdmins.TeamResource, panelAdmins.ReadAction, panelAdmins.HandlePreviewTeamBinPurge(db)))
+				teamRouter.Get("/export", policy(panelAdmins.TeamResource, panelAdmins.ExportAction, panelAdmins.HandleExportTeams(db))) // takes the query params format, view, filter[field][op] and sort
+			})
+
+			// User sub-router
+			// Panel users, deactivating one is the archive action
+			r.Route("/users", func(userRouter chi.Router) {
+				userRouter.Get("/", policy(panelAdmins.UserResource, panelAdmins.ReadAction, panelAdmins.GetUsers(db)))
+				userRouter.Get("/export", policy(panelAdmins.UserResource, panelAdmins.ExportAction, panelAdmins.HandleExportUsers(db))) // takes the query params format, view, filter[field][op] and sort
+				userRouter.Get("/{user}", policy(panelAdmins.UserResource, panelAdmins.ReadAction, panelAdmins.GetUser(db)))
 
-				teamRouter.Patch("/archive", AuthMiddleware(panelAdmins.HandleArchiveTeam(db)))
-				teamRouter.Patch("/unarchive", AuthMiddleware(panelAdmins.HandleUnArchiveTeam(db)))
-				teamRouter.Patch("/add-members", AuthMiddleware(panelAdmins.HandleAddNewMembers(db)))
-				teamRouter.Patch("/remove-members", AuthMiddleware(panelAdmins.HandleRemoveNewMembers(db)))
-				teamRouter.Patch("/change-lead", AuthMiddleware(panelAdmins.HandleChangeTeamLead(db)))
-				teamRouter.Patch("/bin", AuthMiddleware(panelAdmins.PushTeamToBin(db)))
-				teamRouter.Patch("/restore", AuthMiddleware(panelAdmins.RestoreTeamFromBin(db)))
+				userRouter.Post("/import", policy(panelAdmins.UserResource, panelAdmins.CreateAction, panelAdmins.HandleImportUsers(aws.MongoDBClient))) // takes the query params format and dry_run
 
-				teamRouter.Delete("/delete", AuthMiddleware(panelAdmins.HardDeleteTeam(db)))
+				userRouter.Patch("/de-active", policy(panelAdmins.UserResource, panelAdmins.ArchiveAction, panelAdmins.DeActiveUser(db)))
+				userRouter.Patch("/reactive", policy(panelAdmins.UserResource, panelAdmins.ArchiveAction, panelAdmins.ActiveUser(db)))
 
-				teamRouter.Get("/{id}", AuthMiddleware(panelAdmins.GetTeam(db)))
-				teamRouter.Get("/all", AuthMiddleware(panelAdmins.GetTeams(db)))
+				// Giving a user a role hands out its grants, hence the role grants guard it rather than the user ones
+				userRouter.Patch("/{user}/role", policy(panelAdmins.RoleResource, panelAdmins.UpdateAction, panelAdmins.HandleChangeUserRole(db)))
 			})
 
-			// User sub-router
-			r.Route("/users", func(userRouter chi.Router) {
-				userRouter.Get("/", AuthMiddleware(panelAdmins.GetUsers(db)))
-				userRouter.Get("/{user}", AuthMiddleware(panelAdmins.GetUser(db)))
+			// Tenant (customer organization) sub-router, with the archive and bin lifecycle of the roles and teams
+			r.Route("/tenants", func(tenantRouter chi.Router) {
+				tenantRouter.Post("/", policy(panelAdmins.TenantResource, panelAdmins.CreateAction, tenants.HandleCreateTenant(db)))
+				tenantRouter.Get("/all", policy(panelAdmins.TenantResource, panelAdmins.ReadAction, tenants.HandleFetchTenants(db))) // takes the query params view, cursor, page, limit and status
+				tenantRouter.Get("/bin/purge-preview", policy(panelAdmins.TenantResource, panelAdmins.ReadAction, panelAdmins.HandlePreviewTenantBinPurge(db)))
+				tenantRouter.Get("/{id}", policy(panelAdmins.TenantResource, panelAdmins.ReadAction, tenants.HandleFetchTenantById(db)))
+
+				tenantRouter.Patch("/update", policy(panelAdmins.TenantResource, panelAdmins.UpdateAction, tenants.HandleUpdateTenant(db)))
+				tenantRouter.Patch("/archive", policy(panelAdmins.TenantResource, panelAdmins.ArchiveAction, tenants.HandleArchiveTenant(db)))
+				tenantRouter.Patch("/unarchive", policy(panelAdmins.TenantResource, panelAdmins.ArchiveAction, tenants.HandleUnArchiveTenant(db)))
+				tenantRouter.Patch("/bin", policy(panelAdmins.TenantResource, panelAdmins.DeleteAction, tenants.HandlePushTenantToBin(db)))
+				tenantRouter.Patch("/restore", policy(panelAdmins.TenantResource, panelAdmins.RestoreAction, tenants.HandleRestoreTenantFromBin(db)))
+
+				tenantRouter.Delete("/delete", policy(panelAdmins.TenantResource, panelAdmins.DeleteAction, tenants.HandleHardDeleteTenant(db)))
+			})
+
+			// Onboarding sub-router, the pipeline a new tenant goes through step by step
+			r.Route("/onboarding", func(onboardingRouter chi.Router) {
+				onboardingRouter.Post("/", policy(panelAdmins.OnboardingResource, panelAdmins.CreateAction, onboarding.HandleStartOnboarding(db)))
+				onboardingRouter.Get("/all", policy(panelAdmins.OnboardingResource, panelAdmins.ReadAction, onboarding.HandleFetchOnboardings(db)))            // takes the query params page, limit and status
+				onboardingRouter.Get("/stalled", policy(panelAdmins.OnboardingResource, panelAdmins.ReadAction, onboarding.HandleFetchStalledOnboardings(db))) // takes the query params page, limit and older_than
+				onboardingRouter.Get("/{id}", policy(panelAdmins.OnboardingResource, panelAdmins.ReadAction, onboarding.HandleFetchOnboarding(db)))
+
+				onboardingRouter.Patch("/{id}/steps/{step}/advance", policy(panelAdmins.OnboardingResource, panelAdmins.UpdateAction, onboarding.HandleAdvanceStep(db)))
+				onboardingRouter.Patch("/{id}/steps/{step}/rollback", policy(panelAdmins.OnboardingResource, panelAdmins.UpdateAction, onboarding.HandleRollbackStep(db)))
+				onboardingRouter.Patch("/{id}/steps/{step}/assignee", policy(panelAdmins.OnboardingResource, panelAdmins.UpdateAction, onboarding.HandleAssignStep(db)))
+			})
+
+			// Live notifications of the panel users
+			r.Get("/ws", WsHandler(db, notify.Default))
+
+			// Audit log of every control-panel mutation, readable by whoever may read the roles (the access control)
+			r.Get("/audit", policy(panelAdmins.RoleResource, panelAdmins.ReadAction, audit.HandleFetchEvents(db)))
+			r.Get("/audit/export", policy(panelAdmins.RoleResource, panelAdmins.ExportAction, audit.HandleExportEvents(db))) // takes the query param format and the filter of /audit
 
-				userRouter.Patch("/de-active", AuthMiddleware(panelAdmins.DeActiveUser(db)))
-				userRouter.Patch("/reactive", AuthMiddleware(panelAdmins.ActiveUser(db)))
+			// Webhooks of the third parties, authenticated by their signature rather than a panel session
+			r.Route("/webhooks", func(webhookRouter chi.Router) {
+				webhookRouter.Post("/paystack", tiers.HandlePaystackWebhook(db))
 			})
 
 		})
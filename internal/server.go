@@ -4,6 +4,9 @@ import (
 	"context"
 	"control-panel-bk/config"
 	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/panelAdmins"
+	"control-panel-bk/pkg/tiers"
 	"errors"
 	"fmt"
 	"log"
@@ -14,6 +17,22 @@ import (
 	"time"
 )
 
+// tierReconcileInterval reads TIER_RECONCILE_INTERVAL (a duration such as "10m"), it defaults to 15 minutes
+func tierReconcileInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("TIER_RECONCILE_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return 15 * time.Minute
+}
+
+// binPurgeInterval reads BIN_PURGE_INTERVAL (a duration such as "30m"), it defaults to an hour
+func binPurgeInterval() time.Duration {
+	if interval, err := time.ParseDuration(os.Getenv("BIN_PURGE_INTERVAL")); err == nil && interval > 0 {
+		return interval
+	}
+	return time.Hour
+}
+
 func ControlPanelServer() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
@@ -29,7 +48,32 @@ func ControlPanelServer() {
 		log.Print("Error MongoDB: ", err)
 	}
 
-	go RedisConnection()
+	RedisConnection()
+
+	// Events published on any instance reach the sockets held by every instance
+	if err := notify.Default.UseBroker(ctx, notify.NewRedisBroker(RedisClient, notify.EventsChannel)); err != nil {
+		log.Print("Error Redis: ", err)
+	} else {
+		notify.Default.Presence = notify.NewRedisPresence(RedisClient, 2*notify.Default.PongWait)
+	}
+
+	// Keeps the tiers mirror in sync with the plans of Paystack
+	if aws.MongoDBClient != nil {
+		// The roles stored with the permission matrix are converted to grants of the permission catalog
+		if err := panelAdmins.SeedPermissionCatalog(ctx, getDB(aws.MongoDBClient)); err != nil {
+			log.Print("Error permission catalog: ", err)
+		}
+		if migrated, err := panelAdmins.MigratePermissions(ctx, getDB(aws.MongoDBClient)); err != nil {
+			log.Print("Error permission migration: ", err)
+		} else if migrated > 0 {
+			log.Printf("Migrated the permission of %d roles and role versions to grants", migrated)
+		}
+
+		tiers.StartReconciler(ctx, getDB(aws.MongoDBClient), tierReconcileInterval())
+
+		// Hard-deletes the roles and teams kept in the bin for longer than BIN_RETENTION_DAYS
+		panelAdmins.StartBinPurger(ctx, getDB(aws.MongoDBClient), panelAdmins.BinRetention(), binPurgeInterval())
+	}
 
 	server := &http.Server{
 		Handler: Routes(),
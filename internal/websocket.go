@@ -2,78 +2,102 @@ package internal
 
 import (
 	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/util"
+	"errors"
 	"fmt"
 	"github.com/gorilla/websocket"
-	"log"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 	"net/http"
 )
 
-const (
-	wsClient = "websocket_client"
-)
-
 var upgrade = websocket.Upgrader{
 	CheckOrigin: func(r *http.Request) bool {
 		return true
 	},
 }
 
-// User connection tracking (alternative to Redis for debugging)
-var connections = make(map[string]*websocket.Conn)
-
-func WsHandler(w http.ResponseWriter, r *http.Request) {
-	// Validate AWS TOKEN
-	token := r.URL.Query().Get("token")
-
-	if token == "" {
-		log.Println("No token was sent")
-		return
-	}
+// wsUser is the panel user a socket is opened for
+type wsUser struct {
+	ID       string `json:"_id"`
+	RoleId   string `json:"role_id"`
+	IsActive bool   `json:"is_active"`
+}
 
-	// TODO Validating Token against AWS Cognito
-	header := w.Header()
-	conn, e := upgrade.Upgrade(w, r, header)
-	if e != nil {
-		return
+// wsCaller resolves the panel user the verified token belongs to
+var wsCaller = func(ctx context.Context, db *mongo.Database, claims *aws.CognitoClaims) (*wsUser, error) {
+	var user wsUser
+	if err := db.Collection("users").FindOne(ctx, bson.M{"up_id": claims.Subject}).Decode(&user); err != nil {
+		return nil, fmt.Errorf("no user record matches the caller: %w", err)
 	}
 
-	defer conn.Close()
+	return &user, nil
+}
 
-	c := r.URL.Query().Get("role")
-	userID := c
+// teamMembers resolves a team target of the hub into the ids of the team's lead and members
+func teamMembers(db *mongo.Database) notify.TeamResolver {
+	return func(ctx context.Context, teamId string) ([]string, error) {
+		objId, err := util.GetPrimitiveID(teamId)
+		if err != nil {
+			return nil, err
+		}
 
-	// Store in local memory for debugging
-	connections[userID] = conn
+		var team struct {
+			TeamLead   string   `json:"team_lead"`
+			TeamMember []string `json:"team_member"`
+		}
 
-	// Keep the connection alive
-	for {
-		_, msg, err := conn.ReadMessage()
-		if err != nil {
-			removeWsConnection(userID)
-			delete(connections, userID)
-			break
+		if err := db.Collection("teams").FindOne(ctx, bson.M{"_id": objId}).Decode(&team); err != nil {
+			return nil, err
 		}
 
-		log.Printf("Received from %s: %s\n", userID, msg)
+		return append(team.TeamMember, team.TeamLead), nil
 	}
 }
 
-func storeWsConnection(userId, role string) error {
-	userId = fmt.Sprintf("%s:%s", wsClient, userId)
-	if err := RedisClient.HSet(context.TODO(), userId, map[string]interface{}{
-		"role": role,
-	}).Err(); err != nil {
-		return err
-	}
+// WsHandler upgrades the request of an authenticated panel user to a socket registered on the hub. Browsers cannot
+// set headers on a websocket handshake, hence the access token is sent in the "token" query param, requestLogger
+// redacts it.
+func WsHandler(db *mongo.Database, hub *notify.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" {
+			util.ErrorException(w, errors.New("missing access token"), http.StatusUnauthorized)
+			return
+		}
 
-	return nil
-}
+		claims, err := verifyAccessToken(r.Context(), token)
+		if err != nil {
+			util.ErrorException(w, fmt.Errorf("invalid access token: %w", err), http.StatusUnauthorized)
+			return
+		}
 
-func removeWsConnection(userId string) error {
-	userId = fmt.Sprintf("%s:%s", wsClient, userId)
-	if err := RedisClient.HDel(context.TODO(), userId).Err(); err != nil {
-		return err
-	}
+		// A socket outlives the request, it is refused like any request once the sessions of the user are revoked
+		r = r.WithContext(aws.ContextWithClaims(r.Context(), claims))
+		if !checkSession(w, r, db) {
+			return
+		}
+
+		user, err := wsCaller(r.Context(), db, claims)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusUnauthorized)
+			return
+		}
 
-	return nil
+		// The sockets of a deactivated user are closed, the user cannot open new ones
+		if !user.IsActive {
+			util.ErrorException(w, errors.New("the user is deactivated"), http.StatusForbidden)
+			return
+		}
+
+		conn, err := upgrade.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		// Blocks until the socket is closed
+		hub.Register(conn, user.ID, user.RoleId).Serve()
+	}
 }
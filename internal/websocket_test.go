@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"encoding/json"
+	"errors"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWsHandler(t *testing.T) {
+	originalVerify, originalCaller, originalRevokedAt := verifyAccessToken, wsCaller, callerSessionsRevokedAt
+	defer func() {
+		verifyAccessToken, wsCaller, callerSessionsRevokedAt = originalVerify, originalCaller, originalRevokedAt
+	}()
+
+	issuedAt := time.Now().Add(-time.Hour)
+	verifyAccessToken = func(ctx context.Context, token string) (*aws.CognitoClaims, error) {
+		switch token {
+		case "valid-token":
+			return &aws.CognitoClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "cognito-sub", IssuedAt: jwt.NewNumericDate(issuedAt)}, TokenUse: aws.AccessTokenUse}, nil
+		case "inactive-token":
+			return &aws.CognitoClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "inactive-sub", IssuedAt: jwt.NewNumericDate(issuedAt)}, TokenUse: aws.AccessTokenUse}, nil
+		case "revoked-token":
+			return &aws.CognitoClaims{RegisteredClaims: jwt.RegisteredClaims{Subject: "revoked-sub", IssuedAt: jwt.NewNumericDate(issuedAt)}, TokenUse: aws.AccessTokenUse}, nil
+		}
+		return nil, errors.New("token signature is invalid")
+	}
+
+	callerSessionsRevokedAt = func(r *http.Request, db *mongo.Database) (*time.Time, error) {
+		if claims, _ := aws.ClaimsFromContext(r.Context()); claims.Subject == "revoked-sub" {
+			revokedAt := issuedAt.Add(time.Minute)
+			return &revokedAt, nil
+		}
+		return nil, nil
+	}
+
+	wsCaller = func(ctx context.Context, db *mongo.Database, claims *aws.CognitoClaims) (*wsUser, error) {
+		switch claims.Subject {
+		case "cognito-sub":
+			return &wsUser{ID: "user-1", RoleId: "role-1", IsActive: true}, nil
+		case "inactive-sub":
+			return &wsUser{ID: "user-2", RoleId: "role-1"}, nil
+		}
+		return nil, errors.New("no user record matches the caller")
+	}
+
+	hub := notify.NewHub()
+	srv := httptest.NewServer(WsHandler(nil, hub))
+	defer srv.Close()
+
+	wsUrl := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	t.Run("missing token", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsUrl, nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsUrl+"?token=forged-token", nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsUrl+"?token=revoked-token", nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("deactivated user", func(t *testing.T) {
+		_, resp, err := websocket.DefaultDialer.Dial(wsUrl+"?token=inactive-token", nil)
+		assert.Error(t, err)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		conn, _, err := websocket.DefaultDialer.Dial(wsUrl+"?token=valid-token", nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		require.Eventually(t, func() bool { return hub.Connections("user-1") == 1 }, time.Second, 5*time.Millisecond)
+
+		require.NoError(t, hub.Publish(context.Background(), notify.ToRole("role-1"), notify.RoleUpdated, nil))
+
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		require.NoError(t, err)
+
+		var event notify.Event
+		require.NoError(t, json.Unmarshal(msg, &event))
+		assert.Equal(t, notify.RoleUpdated, event.Type)
+	})
+}
@@ -0,0 +1,305 @@
+package audit
+
+import (
+	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/util"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5/middleware"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Collection is the append-only collection every control-panel mutation is recorded in
+const Collection = "audit_events"
+
+const MAX_LIMIT = 100
+
+type EntityType string
+
+const (
+	RoleEntity EntityType = "role"
+	TeamEntity EntityType = "team"
+	UserEntity EntityType = "user"
+	TierEntity EntityType = "tier"
+
+	TenantEntity     EntityType = "tenant"
+	OnboardingEntity EntityType = "onboarding"
+
+	SubscriptionEntity EntityType = "subscription"
+)
+
+type Action string
+
+const (
+	RoleCreated    Action = "role.created"
+	RoleUpdated    Action = "role.updated"
+	RoleArchived   Action = "role.archived"
+	RoleUnArchived Action = "role.unarchived"
+	RoleBinned     Action = "role.binned"
+	RoleRestored   Action = "role.restored"
+	RoleDeleted    Action = "role.deleted"
+	RolePurged     Action = "role.purged"
+	RoleReassigned Action = "role.users_reassigned"
+	RoleReverted   Action = "role.reverted"
+
+	TeamCreated        Action = "team.created"
+	TeamArchived       Action = "team.archived"
+	TeamUnArchived     Action = "team.unarchived"
+	TeamMembersAdded   Action = "team.members_added"
+	TeamMembersRemoved Action = "team.members_removed"
+	TeamLeadChanged    Action = "team.lead_changed"
+	TeamBinned         Action = "team.binned"
+	TeamRestored       Action = "team.restored"
+	TeamDeleted        Action = "team.deleted"
+	TeamPurged         Action = "team.purged"
+
+	UserCreated     Action = "user.created"
+	UserDeactivated Action = "user.deactivated"
+	UserActivated   Action = "user.activated"
+	UserRoleChanged Action = "user.role_changed"
+
+	TierCreated Action = "tier.created"
+	TierUpdated Action = "tier.updated"
+
+	TenantCreated    Action = "tenant.created"
+	TenantUpdated    Action = "tenant.updated"
+	TenantArchived   Action = "tenant.archived"
+	TenantUnArchived Action = "tenant.unarchived"
+	TenantBinned     Action = "tenant.binned"
+	TenantRestored   Action = "tenant.restored"
+	TenantDeleted    Action = "tenant.deleted"
+	TenantPurged     Action = "tenant.purged"
+
+	OnboardingStarted        Action = "onboarding.started"
+	OnboardingStepCompleted  Action = "onboarding.step_completed"
+	OnboardingStepFailed     Action = "onboarding.step_failed"
+	OnboardingStepRolledBack Action = "onboarding.step_rolled_back"
+	OnboardingStepAssigned   Action = "onboarding.step_assigned"
+
+	SubscriptionCreated  Action = "subscription.created"
+	SubscriptionEnabled  Action = "subscription.enabled"
+	SubscriptionDisabled Action = "subscription.disabled"
+)
+
+type Event struct {
+	ID         string      `json:"_id,omitempty"`
+	Actor      string      `json:"actor"`
+	Action     Action      `json:"action"`
+	EntityType EntityType  `json:"entity_type"`
+	EntityId   string      `json:"entity_id"`
+	Before     interface{} `json:"before,omitempty"`
+	After      interface{} `json:"after,omitempty"`
+	RequestId  string      `json:"request_id,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+type Filter struct {
+	Actor      string
+	Action     Action
+	EntityType EntityType
+	EntityId   string
+	RequestId  string
+	From       time.Time
+	To         time.Time
+}
+
+// Record appends the event to the audit log, taking the actor and request id from the request context.
+// The mutation it describes has already happened, hence a failure is logged rather than returned to the caller
+func Record(ctx context.Context, db *mongo.Database, e Event) {
+	if e.Actor == "" {
+		if actor, err := aws.ActorFromContext(ctx); err == nil {
+			e.Actor = actor
+		}
+	}
+
+	if e.RequestId == "" {
+		e.RequestId = middleware.GetReqID(ctx)
+	}
+
+	e.ID = ""
+	e.CreatedAt = time.Now().UTC()
+
+	if _, err := db.Collection(Collection).InsertOne(ctx, e); err != nil {
+		log.Printf("audit: unable to record %s of %s %s: %s", e.Action, e.EntityType, e.EntityId, err.Error())
+	}
+}
+
+// Snapshot returns the stored document with the id, used as the "before" state of a mutation
+func Snapshot(ctx context.Context, db *mongo.Database, collection string, id string) interface{} {
+	objId, err := util.GetPrimitiveID(id)
+	if err != nil {
+		return nil
+	}
+
+	var doc bson.M
+	if err := db.Collection(collection).FindOne(ctx, bson.M{"_id": objId}).Decode(&doc); err != nil {
+		return nil
+	}
+
+	return doc
+}
+
+func (f Filter) query() bson.M {
+	query := bson.M{}
+
+	if f.Actor != "" {
+		query["actor"] = f.Actor
+	}
+
+	if f.Action != "" {
+		query["action"] = f.Action
+	}
+
+	if f.EntityType != "" {
+		query["entity_type"] = f.EntityType
+	}
+
+	if f.EntityId != "" {
+		query["entity_id"] = f.EntityId
+	}
+
+	if f.RequestId != "" {
+		query["request_id"] = f.RequestId
+	}
+
+	createdAt := bson.M{}
+	if !f.From.IsZero() {
+		createdAt["$gte"] = f.From.UTC()
+	}
+
+	if !f.To.IsZero() {
+		createdAt["$lte"] = f.To.UTC()
+	}
+
+	if len(createdAt) > 0 {
+		query["created_at"] = createdAt
+	}
+
+	return query
+}
+
+// FetchEvents lists the events matching the filter a page at a time, newest first
+func FetchEvents(f Filter, req util.PageRequest, ctx context.Context, db *mongo.Database) (*util.Page[Event], error, int) {
+	page, err := util.Paginate[Event](ctx, db.Collection(Collection), f.query(), req)
+	if err != nil {
+		return nil, err, util.PageErrorStatus(err)
+	}
+
+	return page, nil, http.StatusOK
+}
+
+// exportColumns are the columns of the audit export, the before and after snapshots are written as JSON
+var exportColumns = []string{"id", "created_at", "actor", "action", "entity_type", "entity_id", "request_id", "before", "after"}
+
+func exportRow(e Event) ([]string, error) {
+	row := []string{e.ID, util.ExportTime(e.CreatedAt), e.Actor, string(e.Action), string(e.EntityType), e.EntityId, e.RequestId, "", ""}
+
+	for i, snapshot := range []interface{}{e.Before, e.After} {
+		if snapshot == nil {
+			continue
+		}
+		b, err := json.Marshal(snapshot)
+		if err != nil {
+			return nil, fmt.Errorf("unable to write the snapshot of the event %s: %w", e.ID, err)
+		}
+		row[7+i] = string(b)
+	}
+
+	return row, nil
+}
+
+// parseFilter reads the filter from the query params actor, action, entity_type, entity_id, request_id and from, to
+// (RFC 3339)
+func parseFilter(r *http.Request) (Filter, error) {
+	query := r.URL.Query()
+
+	f := Filter{
+		Actor:      query.Get("actor"),
+		Action:     Action(query.Get("action")),
+		EntityType: EntityType(query.Get("entity_type")),
+		EntityId:   query.Get("entity_id"),
+		RequestId:  query.Get("request_id"),
+	}
+
+	for param, target := range map[string]*time.Time{"from": &f.From, "to": &f.To} {
+		if value := query.Get(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+			}
+			*target = t
+		}
+	}
+
+	return f, nil
+}
+
+// Handlers
+
+// HandleFetchEvents takes the query params of the filter along with cursor, page and limit
+func HandleFetchEvents(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f, err := parseFilter(r)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		req, err := util.ParsePageRequest(r, MAX_LIMIT)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		result, err, code := FetchEvents(f, req, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		respBytes, respErr := util.GetBytesResponse(code, result)
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(respBytes)
+	}
+}
+
+// HandleExportEvents streams the events matching the filter of /audit newest first, the query param format is csv,
+// xlsx or jsonl and the page params are ignored
+func HandleExportEvents(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := util.ParseExportFormat(r)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		f, err := parseFilter(r)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		opt := options.Find().SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}})
+		cursor, err := db.Collection(Collection).Find(r.Context(), f.query(), opt)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := util.StreamExport(r.Context(), w, cursor, format, "audit", exportColumns, exportRow); err != nil {
+			log.Printf("audit: the export was cut short: %s", err.Error())
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package audit
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/audit?actor=jane&action=role.archived&entity_type=role&entity_id=abc&from=2025-03-01T00:00:00Z&page=3&limit=20", nil)
+
+	f, err := parseFilter(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", f.Actor)
+	assert.Equal(t, RoleArchived, f.Action)
+	assert.Equal(t, RoleEntity, f.EntityType)
+	assert.Equal(t, "abc", f.EntityId)
+	assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), f.From)
+	assert.True(t, f.To.IsZero())
+}
+
+func TestParseFilter_Invalid(t *testing.T) {
+	for _, q := range []string{"from=yesterday", "to=2025-13-01"} {
+		req := httptest.NewRequest(http.MethodGet, "/audit?"+q, nil)
+
+		_, err := parseFilter(req)
+		assert.Error(t, err, q)
+	}
+}
+
+func TestHandleFetchEvents_InvalidQuery(t *testing.T) {
+	for _, q := range []string{"from=yesterday", "page=0", "page=x", "limit=-1", "cursor=x", "page=2&cursor=x"} {
+		rr := httptest.NewRecorder()
+		HandleFetchEvents(nil).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/audit?"+q, nil))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, q)
+	}
+}
+
+func TestFilter_Query(t *testing.T) {
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	assert.Equal(t, bson.M{}, Filter{}.query())
+
+	assert.Equal(t, bson.M{
+		"actor":       "jane",
+		"entity_type": TeamEntity,
+		"created_at":  bson.M{"$gte": from, "$lte": to},
+	}, Filter{Actor: "jane", EntityType: TeamEntity, From: from, To: to}.query())
+}
+
+func TestExportRow(t *testing.T) {
+	e := Event{
+		ID:         "e1",
+		Actor:      "jane",
+		Action:     RoleArchived,
+		EntityType: RoleEntity,
+		EntityId:   "abc",
+		Before:     bson.M{"archive_status": false},
+		CreatedAt:  time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	row, err := exportRow(e)
+
+	assert.NoError(t, err)
+	assert.Len(t, row, len(exportColumns))
+	assert.Equal(t, []string{"e1", "2025-03-01T00:00:00Z", "jane", "role.archived", "role", "abc", "", `{"archive_status":false}`, ""}, row)
+}
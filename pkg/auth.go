@@ -5,6 +5,7 @@ import (
 	"control-panel-bk/internal/aws"
 	"control-panel-bk/util"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"os"
 	"time"
@@ -121,9 +122,13 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	token := r.Context().Value("access_token").(string)
+	claims, ok := aws.ClaimsFromContext(r.Context())
+	if !ok {
+		util.ErrorException(w, errors.New("missing access token"), http.StatusUnauthorized)
+		return
+	}
 
-	if err := aws.LogOutUser(config.AwsConfig, token); err != nil {
+	if err := aws.LogOutUser(config.AwsConfig, claims.Token); err != nil {
 		util.ErrorException(w, err, http.StatusNotImplemented)
 		return
 	}
@@ -136,7 +141,11 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 func ChangePasswordHandle(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 
-	token := r.Context().Value("access_token").(string)
+	claims, ok := aws.ClaimsFromContext(r.Context())
+	if !ok {
+		util.ErrorException(w, errors.New("missing access token"), http.StatusUnauthorized)
+		return
+	}
 
 	var body ChangePassword
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
@@ -144,7 +153,7 @@ func ChangePasswordHandle(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	output, e := aws.ChangeUserPassword(config.AwsConfig, token, body.NewPassword, body.OldPassword)
+	output, e := aws.ChangeUserPassword(config.AwsConfig, claims.Token, body.NewPassword, body.OldPassword)
 	if e != nil {
 		util.ErrorException(w, e, http.StatusNotImplemented)
 		return
@@ -0,0 +1,232 @@
+package onboarding
+
+import (
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"io"
+	"net/http"
+	"time"
+)
+
+func writeResponse(w http.ResponseWriter, code int, data interface{}) {
+	respBytes, err := util.GetBytesResponse(code, data)
+	if err != nil {
+		util.ErrorException(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(respBytes)
+}
+
+func HandleStartOnboarding(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var body StartRequest
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil && err != io.EOF {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		result, err, code := StartOnboarding(body, actor, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.OnboardingStarted,
+			EntityType: audit.OnboardingEntity,
+			EntityId:   result.ID,
+			After:      result,
+		})
+		for _, step := range result.Steps {
+			if step.Assignee != "" {
+				notify.Publish(r.Context(), notify.ToTeam(step.Assignee), notify.OnboardingStepAssigned, result)
+			}
+		}
+
+		writeResponse(w, code, result)
+	}
+}
+
+// HandleFetchOnboardings takes the query params cursor, page, limit and status
+func HandleFetchOnboardings(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := util.ParsePageRequest(r, MAX_LIMIT)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		result, err, code := FetchOnboardings(req, Status(r.URL.Query().Get("status")), r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		writeResponse(w, code, result)
+	}
+}
+
+// HandleFetchStalledOnboardings takes the query params cursor, page, limit and older_than (a duration such as "48h"), which
+// defaults to ONBOARDING_STALL_AFTER
+func HandleFetchStalledOnboardings(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, err := util.ParsePageRequest(r, MAX_LIMIT)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		olderThan := StallThreshold()
+		if value := r.URL.Query().Get("older_than"); value != "" {
+			if olderThan, err = time.ParseDuration(value); err != nil || olderThan < 0 {
+				util.ErrorException(w, fmt.Errorf("invalid older_than %q, a duration such as 48h is expected", value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		result, err, code := FetchStalledOnboardings(olderThan, req, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		writeResponse(w, code, result)
+	}
+}
+
+func HandleFetchOnboarding(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err, code := FetchOnboarding(chi.URLParam(r, "id"), r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		writeResponse(w, code, result)
+	}
+}
+
+// HandleAdvanceStep runs the step with the input of the body, a failed step answers 422 and is kept for a retry
+func HandleAdvanceStep(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		input, err := io.ReadAll(r.Body)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		id, step := chi.URLParam(r, "id"), StepName(chi.URLParam(r, "step"))
+
+		result, err, code := AdvanceStep(id, step, input, actor, r.Context(), db)
+		if result != nil {
+			action := audit.OnboardingStepCompleted
+			if err != nil {
+				action = audit.OnboardingStepFailed
+			}
+
+			audit.Record(r.Context(), db, audit.Event{
+				Action:     action,
+				EntityType: audit.OnboardingEntity,
+				EntityId:   result.ID,
+				After:      result,
+			})
+		}
+
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		writeResponse(w, code, result)
+	}
+}
+
+func HandleRollbackStep(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		id, step := chi.URLParam(r, "id"), StepName(chi.URLParam(r, "step"))
+		before := audit.Snapshot(r.Context(), db, Collection, id)
+
+		result, err, code := RollbackStep(id, step, actor, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.OnboardingStepRolledBack,
+			EntityType: audit.OnboardingEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+
+		writeResponse(w, code, result)
+	}
+}
+
+func HandleAssignStep(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var body struct {
+			TeamId string `json:"team_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		id, step := chi.URLParam(r, "id"), StepName(chi.URLParam(r, "step"))
+		before := audit.Snapshot(r.Context(), db, Collection, id)
+
+		result, err, code := AssignStep(id, step, body.TeamId, actor, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.OnboardingStepAssigned,
+			EntityType: audit.OnboardingEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+		notify.Publish(r.Context(), notify.ToTeam(body.TeamId), notify.OnboardingStepAssigned, result)
+
+		writeResponse(w, code, result)
+	}
+}
@@ -0,0 +1,514 @@
+package onboarding
+
+import (
+	"context"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"time"
+)
+
+const Collection = "onboardings"
+
+const MAX_LIMIT = 50
+
+// ErrConflict is returned when the onboarding changed since it was read, the caller should reload it and retry
+var ErrConflict = errors.New("the onboarding was changed by someone else, reload it and try again")
+
+type StepName string
+
+const (
+	StepCompanyDetails    StepName = "company_details"
+	StepChooseTier        StepName = "choose_tier"
+	StepIssueSubscription StepName = "issue_subscription"
+	StepProvisionAdmin    StepName = "provision_admin"
+	StepAssignTeam        StepName = "assign_team"
+)
+
+// Pipeline is the order the steps of an onboarding are completed in
+var Pipeline = []StepName{StepCompanyDetails, StepChooseTier, StepIssueSubscription, StepProvisionAdmin, StepAssignTeam}
+
+type StepStatus string
+
+const (
+	StepPending   StepStatus = "pending"
+	StepRunning   StepStatus = "running"
+	StepCompleted StepStatus = "completed"
+	StepFailed    StepStatus = "failed"
+)
+
+// stepClaimTimeout is how long a run holds its step. A run that did not record its result by then, the server went
+// down with it, no longer keeps the step from being advanced.
+const stepClaimTimeout = 15 * time.Minute
+
+type Status string
+
+const (
+	StatusInProgress Status = "in_progress"
+	StatusCompleted  Status = "completed"
+)
+
+// Step is the persisted state of a step. The input is what the step was last run with and the output what it
+// produced, the later steps and the rollback read it. A failed step keeps the output of its failed runs, the next run
+// resumes from it.
+type Step struct {
+	Name         StepName               `json:"name"`
+	Status       StepStatus             `json:"status"`
+	Assignee     string                 `json:"assignee,omitempty"` // the id of the team in charge of the step
+	Input        map[string]interface{} `json:"input,omitempty"`
+	Output       map[string]interface{} `json:"output,omitempty"`
+	Error        string                 `json:"error,omitempty"`
+	Attempts     int                    `json:"attempts"`
+	StartedAt    *time.Time             `json:"started_at,omitempty"` // when the latest run claimed the step
+	CompletedAt  *time.Time             `json:"completed_at,omitempty"`
+	CompletedBy  string                 `json:"completed_by,omitempty"`
+	RolledBackAt *time.Time             `json:"rolled_back_at,omitempty"`
+}
+
+// Onboarding is the progress of a new customer tenant through the Pipeline
+type Onboarding struct {
+	ID          string    `json:"_id,omitempty"`
+	TenantId    string    `json:"tenant_id,omitempty"`
+	Status      Status    `json:"status"`
+	CurrentStep StepName  `json:"current_step,omitempty"`
+	Steps       []Step    `json:"steps"`
+	Version     int       `json:"version"`
+	CreatedBy   string    `json:"created_by"`
+	UpdatedBy   string    `json:"updated_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type StartRequest struct {
+	// Assignees maps a step to the id of the team in charge of it
+	Assignees map[StepName]string `json:"assignees,omitempty"`
+}
+
+// StallThreshold reads ONBOARDING_STALL_AFTER (a duration such as "48h"), an onboarding with no progress for that long
+// is stalled. It defaults to 72 hours.
+func StallThreshold() time.Duration {
+	if threshold, err := time.ParseDuration(os.Getenv("ONBOARDING_STALL_AFTER")); err == nil && threshold > 0 {
+		return threshold
+	}
+	return 72 * time.Hour
+}
+
+func (o *Onboarding) stepIndex(name StepName) int {
+	return slices.IndexFunc(o.Steps, func(s Step) bool { return s.Name == name })
+}
+
+// Step returns the state of the named step
+func (o *Onboarding) Step(name StepName) (*Step, error) {
+	i := o.stepIndex(name)
+	if i < 0 {
+		return nil, fmt.Errorf("unknown onboarding step %q", name)
+	}
+	return &o.Steps[i], nil
+}
+
+// output reads a value a completed step produced
+func (o *Onboarding) output(name StepName, key string) string {
+	step, err := o.Step(name)
+	if err != nil || step.Status != StepCompleted {
+		return ""
+	}
+
+	value, _ := step.Output[key].(string)
+	return value
+}
+
+// kept is the value of the output the failed runs of the step kept, the step's next run resumes from it
+func (o *Onboarding) kept(name StepName, key string) string {
+	step, err := o.Step(name)
+	if err != nil {
+		return ""
+	}
+
+	value, _ := step.Output[key].(string)
+	return value
+}
+
+// keptChanges tells whether the failed runs of the step kept some of the changes they made
+func (s Step) keptChanges() bool {
+	for _, value := range s.Output {
+		if value != nil && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAdvance only lets the current step run, earlier steps are resumed in order
+func (o *Onboarding) checkAdvance(name StepName) (int, error, int) {
+	i := o.stepIndex(name)
+	if i < 0 {
+		return 0, fmt.Errorf("unknown onboarding step %q", name), http.StatusBadRequest
+	}
+
+	if o.Status == StatusCompleted {
+		return 0, errors.New("the onboarding is already completed"), http.StatusConflict
+	}
+
+	if o.CurrentStep != name {
+		return 0, fmt.Errorf("the onboarding is at the %s step, %s cannot be advanced", o.CurrentStep, name), http.StatusConflict
+	}
+
+	if step := o.Steps[i]; step.Status == StepRunning && step.StartedAt != nil && time.Since(*step.StartedAt) < stepClaimTimeout {
+		return 0, fmt.Errorf("the %s step is already being run", name), http.StatusConflict
+	}
+
+	return i, nil, http.StatusOK
+}
+
+// checkRollback only lets the latest completed step be rolled back, so a step never outlives the ones it depends on.
+// The next step must not be running nor have failed with a side effect kept, such as a subscription issued, it would
+// outlive the step too.
+func (o *Onboarding) checkRollback(name StepName) (int, error, int) {
+	i := o.stepIndex(name)
+	if i < 0 {
+		return 0, fmt.Errorf("unknown onboarding step %q", name), http.StatusBadRequest
+	}
+
+	if o.Steps[i].Status != StepCompleted {
+		return 0, fmt.Errorf("the %s step is not completed", name), http.StatusConflict
+	}
+
+	if i+1 < len(o.Steps) {
+		switch next := o.Steps[i+1]; {
+		case next.Status == StepCompleted:
+			return 0, fmt.Errorf("the %s step has to be rolled back first", next.Name), http.StatusConflict
+		case next.Status == StepRunning:
+			return 0, fmt.Errorf("the %s step is being run", next.Name), http.StatusConflict
+		case next.Status == StepFailed && next.keptChanges():
+			return 0, fmt.Errorf("the %s step failed after some of its changes were made, advance it again first", next.Name), http.StatusConflict
+		}
+	}
+
+	return i, nil, http.StatusOK
+}
+
+// claim marks the step as running with the input, the time of the claim identifies the run holding it
+func (o *Onboarding) claim(i int, input map[string]interface{}, now time.Time) time.Time {
+	// The stored dates are to the millisecond, the claim is compared with the stored one
+	now = now.Truncate(time.Millisecond)
+
+	step := &o.Steps[i]
+	step.Status, step.Input, step.StartedAt = StepRunning, input, &now
+	step.Attempts++
+
+	return now
+}
+
+// complete records the output of the step and moves the onboarding to the next step
+func (o *Onboarding) complete(i int, output map[string]interface{}, actor string, now time.Time) {
+	step := &o.Steps[i]
+	step.Status, step.Output, step.Error = StepCompleted, output, ""
+	step.CompletedAt, step.CompletedBy = &now, actor
+
+	if i+1 < len(o.Steps) {
+		o.CurrentStep = o.Steps[i+1].Name
+	} else {
+		o.CurrentStep, o.Status = "", StatusCompleted
+	}
+}
+
+// fail keeps the step as the current one with its error and adds the output the run produced before it failed, such
+// as the code of a subscription issued, advancing it again resumes the onboarding from there
+func (o *Onboarding) fail(i int, err error, output map[string]interface{}) {
+	step := &o.Steps[i]
+	step.Status, step.Error = StepFailed, err.Error()
+
+	if len(output) > 0 {
+		merged := maps.Clone(step.Output)
+		if merged == nil {
+			merged = map[string]interface{}{}
+		}
+		maps.Copy(merged, output)
+		step.Output = merged
+	}
+}
+
+// rollBack makes the step pending again and the current one, a failed next step is pending again too
+func (o *Onboarding) rollBack(i int, now time.Time) {
+	step := &o.Steps[i]
+	step.Status, step.Output, step.Error = StepPending, nil, ""
+	step.CompletedAt, step.CompletedBy, step.RolledBackAt = nil, "", &now
+
+	if i+1 < len(o.Steps) && o.Steps[i+1].Status == StepFailed {
+		next := &o.Steps[i+1]
+		next.Status, next.Output, next.Error = StepPending, nil, ""
+	}
+
+	o.CurrentStep, o.Status = step.Name, StatusInProgress
+}
+
+// loadOnboarding and saveOnboarding persist the onboardings, the save only applies to the version that was loaded
+var loadOnboarding = func(ctx context.Context, db *mongo.Database, id string) (*Onboarding, error, int) {
+	objId, err := util.GetPrimitiveID(id)
+	if err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+
+	var o Onboarding
+	if err := db.Collection(Collection).FindOne(ctx, bson.M{"_id": objId}).Decode(&o); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no onboarding with the id %s was found", id), http.StatusNotFound
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	return &o, nil, http.StatusOK
+}
+
+var saveOnboarding = func(ctx context.Context, db *mongo.Database, o *Onboarding) error {
+	objId, err := util.GetPrimitiveID(o.ID)
+	if err != nil {
+		return err
+	}
+
+	loaded := o.Version
+	o.Version, o.UpdatedAt = loaded+1, time.Now().UTC()
+
+	update := bson.M{"$set": bson.M{
+		"tenant_id":    o.TenantId,
+		"status":       o.Status,
+		"current_step": o.CurrentStep,
+		"steps":        o.Steps,
+		"version":      o.Version,
+		"updated_by":   o.UpdatedBy,
+		"updated_at":   o.UpdatedAt,
+	}}
+
+	result, err := db.Collection(Collection).UpdateOne(ctx, bson.M{"_id": objId, "version": loaded}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return ErrConflict
+	}
+
+	return nil
+}
+
+func saveStatus(err error) int {
+	if errors.Is(err, ErrConflict) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// StartOnboarding creates an onboarding at its first step, the assignees are checked against the teams
+func StartOnboarding(req StartRequest, actor string, ctx context.Context, db *mongo.Database) (*Onboarding, error, int) {
+	now := time.Now().UTC()
+	o := Onboarding{
+		Status:      StatusInProgress,
+		CurrentStep: Pipeline[0],
+		Steps:       make([]Step, 0, len(Pipeline)),
+		CreatedBy:   actor,
+		UpdatedBy:   actor,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	for _, name := range Pipeline {
+		o.Steps = append(o.Steps, Step{Name: name, Status: StepPending})
+	}
+
+	for name, teamId := range req.Assignees {
+		step, err := o.Step(name)
+		if err != nil {
+			return nil, err, http.StatusBadRequest
+		}
+
+		if err, code := checkTeam(ctx, db, teamId); err != nil {
+			return nil, err, code
+		}
+		step.Assignee = teamId
+	}
+
+	doc, err := db.Collection(Collection).InsertOne(ctx, o)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	o.ID = doc.InsertedID.(bson.ObjectID).Hex()
+	return &o, nil, http.StatusCreated
+}
+
+// AdvanceStep validates the input of the current step, claims the step and runs it. The claim is saved before the run
+// so of two advances at once only one runs the side effects of the step, the other gets a 409. A step that fails is
+// kept as the current step with its error and partial output, the onboarding resumes from it once it is advanced again.
+func AdvanceStep(id string, name StepName, input json.RawMessage, actor string, ctx context.Context, db *mongo.Database) (*Onboarding, error, int) {
+	o, err, code := loadOnboarding(ctx, db, id)
+	if err != nil {
+		return nil, err, code
+	}
+
+	i, err, code := o.checkAdvance(name)
+	if err != nil {
+		return nil, err, code
+	}
+
+	runner := steps[name]
+
+	parsed, fields, err := runner.parse(input)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s input: %w", name, err), http.StatusBadRequest
+	}
+
+	o.UpdatedBy = actor
+	claimed := o.claim(i, fields, time.Now().UTC())
+
+	if err := saveOnboarding(ctx, db, o); err != nil {
+		return nil, err, saveStatus(err)
+	}
+
+	output, runErr := runner.run(ctx, db, o, parsed, actor)
+
+	tenantId := o.TenantId
+	o, err = recordRun(ctx, db, o, i, claimed, func(o *Onboarding) {
+		o.UpdatedBy, o.TenantId = actor, tenantId
+		if runErr != nil {
+			o.fail(i, runErr, output)
+		} else {
+			o.complete(i, output, actor, time.Now().UTC())
+		}
+	})
+	if err != nil {
+		return nil, err, saveStatus(err)
+	}
+
+	if runErr != nil {
+		return o, fmt.Errorf("the %s step failed: %w", name, runErr), http.StatusUnprocessableEntity
+	}
+
+	return o, nil, http.StatusOK
+}
+
+// recordRun saves the result of the run holding the claim on the step. A write that leaves the step alone, such as an
+// assignment, may have been saved during the run, the result is then recorded on the onboarding as it is now.
+func recordRun(ctx context.Context, db *mongo.Database, o *Onboarding, i int, claimed time.Time, record func(o *Onboarding)) (*Onboarding, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		record(o)
+
+		err := saveOnboarding(ctx, db, o)
+		if !errors.Is(err, ErrConflict) {
+			return o, err
+		}
+
+		current, err, _ := loadOnboarding(ctx, db, o.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		step := current.Steps[i]
+		if step.Status != StepRunning || step.StartedAt == nil || !step.StartedAt.Equal(claimed) {
+			return nil, fmt.Errorf("%w, the %s step was claimed by another run", ErrConflict, step.Name)
+		}
+		if current.CurrentStep != step.Name {
+			return nil, fmt.Errorf("%w, the onboarding was moved to the %s step while %s ran", ErrConflict, current.CurrentStep, step.Name)
+		}
+		o = current
+	}
+
+	return nil, ErrConflict
+}
+
+// RollbackStep undoes the latest completed step and makes it the current step again
+func RollbackStep(id string, name StepName, actor string, ctx context.Context, db *mongo.Database) (*Onboarding, error, int) {
+	o, err, code := loadOnboarding(ctx, db, id)
+	if err != nil {
+		return nil, err, code
+	}
+
+	i, err, code := o.checkRollback(name)
+	if err != nil {
+		return nil, err, code
+	}
+
+	if err := steps[name].rollback(ctx, db, o); err != nil {
+		return nil, fmt.Errorf("unable to roll back the %s step: %w", name, err), http.StatusBadGateway
+	}
+
+	o.UpdatedBy = actor
+	o.rollBack(i, time.Now().UTC())
+
+	if err := saveOnboarding(ctx, db, o); err != nil {
+		return nil, err, saveStatus(err)
+	}
+
+	return o, nil, http.StatusOK
+}
+
+// AssignStep puts a team in charge of the step
+func AssignStep(id string, name StepName, teamId string, actor string, ctx context.Context, db *mongo.Database) (*Onboarding, error, int) {
+	o, err, code := loadOnboarding(ctx, db, id)
+	if err != nil {
+		return nil, err, code
+	}
+
+	step, err := o.Step(name)
+	if err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+
+	if err, code := checkTeam(ctx, db, teamId); err != nil {
+		return nil, err, code
+	}
+
+	step.Assignee = teamId
+	o.UpdatedBy = actor
+
+	if err := saveOnboarding(ctx, db, o); err != nil {
+		return nil, err, saveStatus(err)
+	}
+
+	return o, nil, http.StatusOK
+}
+
+func FetchOnboarding(id string, ctx context.Context, db *mongo.Database) (*Onboarding, error, int) {
+	return loadOnboarding(ctx, db, id)
+}
+
+// FetchOnboardings lists the onboardings, newest first, optionally of a status
+func FetchOnboardings(req util.PageRequest, status Status, ctx context.Context, db *mongo.Database) (*util.Page[Onboarding], error, int) {
+	filter := bson.M{}
+	if status != "" {
+		filter["status"] = status
+	}
+
+	return findOnboardings(ctx, db, filter, req)
+}
+
+// stalledFilter matches the onboardings in progress with no progress since the cutoff, or whose current step failed
+func stalledFilter(cutoff time.Time) bson.M {
+	return bson.M{
+		"status": StatusInProgress,
+		"$or": bson.A{
+			bson.M{"updated_at": bson.M{"$lt": cutoff}},
+			bson.M{"steps": bson.M{"$elemMatch": bson.M{"status": StepFailed}}},
+		},
+	}
+}
+
+// FetchStalledOnboardings lists the stalled onboardings, newest first
+func FetchStalledOnboardings(olderThan time.Duration, req util.PageRequest, ctx context.Context, db *mongo.Database) (*util.Page[Onboarding], error, int) {
+	return findOnboardings(ctx, db, stalledFilter(time.Now().UTC().Add(-olderThan)), req)
+}
+
+func findOnboardings(ctx context.Context, db *mongo.Database, filter bson.M, req util.PageRequest) (*util.Page[Onboarding], error, int) {
+	page, err := util.Paginate[Onboarding](ctx, db.Collection(Collection), filter, req)
+	if err != nil {
+		return nil, err, util.PageErrorStatus(err)
+	}
+
+	return page, nil, http.StatusOK
+}
@@ -0,0 +1,420 @@
+package onboarding
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newOnboarding() *Onboarding {
+	o := &Onboarding{ID: bson.NewObjectID().Hex(), Status: StatusInProgress, CurrentStep: Pipeline[0]}
+	for _, name := range Pipeline {
+		o.Steps = append(o.Steps, Step{Name: name, Status: StepPending})
+	}
+	return o
+}
+
+// withStore keeps the onboarding in memory rather than MongoDB, saving it bumps its version
+func withStore(t *testing.T, o *Onboarding) {
+	originalLoad, originalSave := loadOnboarding, saveOnboarding
+	t.Cleanup(func() { loadOnboarding, saveOnboarding = originalLoad, originalSave })
+
+	loadOnboarding = func(ctx context.Context, db *mongo.Database, id string) (*Onboarding, error, int) {
+		loaded := *o
+		loaded.Steps = append([]Step(nil), o.Steps...)
+		return &loaded, nil, http.StatusOK
+	}
+
+	saveOnboarding = func(ctx context.Context, db *mongo.Database, saved *Onboarding) error {
+		if saved.Version != o.Version {
+			return ErrConflict
+		}
+		saved.Version++
+		*o = *saved
+		o.Steps = append([]Step(nil), saved.Steps...)
+		return nil
+	}
+}
+
+// withRunner replaces the run and rollback of a step
+func withRunner(t *testing.T, name StepName, run func(o *Onboarding) (map[string]interface{}, error), rollback func(o *Onboarding) error) {
+	original := steps[name]
+	t.Cleanup(func() { steps[name] = original })
+
+	steps[name] = stepRunner{
+		input: original.input,
+		run: func(ctx context.Context, db *mongo.Database, o *Onboarding, input stepInput, actor string) (map[string]interface{}, error) {
+			return run(o)
+		},
+		rollback: func(ctx context.Context, db *mongo.Database, o *Onboarding) error {
+			return rollback(o)
+		},
+	}
+}
+
+func TestAdvanceStep(t *testing.T) {
+	o := newOnboarding()
+	withStore(t, o)
+
+	attempts := 0
+	withRunner(t, StepCompanyDetails, func(o *Onboarding) (map[string]interface{}, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("tenant store unavailable")
+		}
+		o.TenantId = "tenant-1"
+		return map[string]interface{}{"tenant_id": "tenant-1"}, nil
+	}, nil)
+
+	input := json.RawMessage(`{"name": "Acme", "contact_emails": ["Ops@Acme.com"], "account_manager": "jane"}`)
+
+	// A failed step is kept as the current step with its error
+	result, err, code := AdvanceStep(o.ID, StepCompanyDetails, input, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the company_details step failed: tenant store unavailable")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, StepFailed, result.Steps[0].Status)
+	assert.Equal(t, StepCompanyDetails, o.CurrentStep)
+	assert.Equal(t, "tenant store unavailable", o.Steps[0].Error)
+
+	// And the onboarding resumes from it
+	result, err, code = AdvanceStep(o.ID, StepCompanyDetails, input, "jane", context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StepCompleted, result.Steps[0].Status)
+	assert.Empty(t, result.Steps[0].Error)
+	assert.Equal(t, 2, result.Steps[0].Attempts)
+	assert.Equal(t, "jane", result.Steps[0].CompletedBy)
+	assert.Equal(t, []interface{}{"ops@acme.com"}, result.Steps[0].Input["contact_emails"], "the validated input is kept")
+	assert.Equal(t, "tenant-1", o.TenantId)
+	assert.Equal(t, StepChooseTier, o.CurrentStep)
+	assert.Equal(t, 4, o.Version, "each advance saves the claim of the step and its result")
+
+	// Only the current step can be advanced
+	_, err, code = AdvanceStep(o.ID, StepAssignTeam, json.RawMessage(`{"team_id": "67de0141ee7ad487b8861b73"}`), "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the onboarding is at the choose_tier step, assign_team cannot be advanced")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// An invalid input does not run the step
+	_, err, code = AdvanceStep(o.ID, StepChooseTier, json.RawMessage(`{"plan_code": ""}`), "jane", context.Background(), nil)
+	assert.EqualError(t, err, "invalid choose_tier input: a plan code is required")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, 0, o.Steps[1].Attempts)
+}
+
+func TestAdvanceStep_CompletesTheOnboarding(t *testing.T) {
+	o := newOnboarding()
+	for i := range o.Steps[:len(o.Steps)-1] {
+		o.complete(i, nil, "jane", time.Now())
+	}
+	withStore(t, o)
+	withRunner(t, StepAssignTeam, func(o *Onboarding) (map[string]interface{}, error) {
+		return map[string]interface{}{"team_id": "67de0141ee7ad487b8861b73"}, nil
+	}, nil)
+
+	result, err, _ := AdvanceStep(o.ID, StepAssignTeam, json.RawMessage(`{"team_id": "67de0141ee7ad487b8861b73"}`), "jane", context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, StatusCompleted, result.Status)
+	assert.Empty(t, result.CurrentStep)
+
+	_, err, code := AdvanceStep(o.ID, StepAssignTeam, nil, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the onboarding is already completed")
+	assert.Equal(t, http.StatusConflict, code)
+}
+
+func TestAdvanceStep_Claimed(t *testing.T) {
+	o := newOnboarding()
+	started := time.Now().UTC()
+	o.Steps[0].Status, o.Steps[0].StartedAt = StepRunning, &started
+	withStore(t, o)
+
+	runs := 0
+	withRunner(t, StepCompanyDetails, func(o *Onboarding) (map[string]interface{}, error) {
+		runs++
+		return nil, nil
+	}, nil)
+
+	input := json.RawMessage(`{"name": "Acme", "contact_emails": ["ops@acme.com"], "account_manager": "jane"}`)
+
+	// A step another run holds is not run twice
+	_, err, code := AdvanceStep(o.ID, StepCompanyDetails, input, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the company_details step is already being run")
+	assert.Equal(t, http.StatusConflict, code)
+	assert.Zero(t, runs)
+
+	// Unless the run never recorded its result
+	expired := started.Add(-stepClaimTimeout)
+	o.Steps[0].StartedAt = &expired
+	result, err, _ := AdvanceStep(o.ID, StepCompanyDetails, input, "jane", context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, 1, runs)
+	assert.Equal(t, StepCompleted, result.Steps[0].Status)
+}
+
+func TestAdvanceStep_KeepsPartialOutput(t *testing.T) {
+	o := newOnboarding()
+	o.CurrentStep = StepIssueSubscription
+	o.complete(0, nil, "jane", time.Now())
+	o.complete(1, nil, "jane", time.Now())
+	withStore(t, o)
+
+	var kept []string
+	withRunner(t, StepIssueSubscription, func(o *Onboarding) (map[string]interface{}, error) {
+		kept = append(kept, o.kept(StepIssueSubscription, "subscription_code"))
+		if len(kept) == 1 {
+			return map[string]interface{}{"subscription_code": "SUB_1"}, errors.New("tenant store unavailable")
+		}
+		return map[string]interface{}{"subscription_code": kept[1]}, nil
+	}, nil)
+
+	_, err, _ := AdvanceStep(o.ID, StepIssueSubscription, nil, "jane", context.Background(), nil)
+	require.Error(t, err)
+	assert.Equal(t, StepFailed, o.Steps[2].Status)
+	assert.Equal(t, "SUB_1", o.Steps[2].Output["subscription_code"], "the subscription issued is kept with the error")
+
+	result, err, _ := AdvanceStep(o.ID, StepIssueSubscription, nil, "jane", context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"", "SUB_1"}, kept, "the next run resumes from the subscription issued")
+	assert.Equal(t, "SUB_1", result.output(StepIssueSubscription, "subscription_code"))
+}
+
+func TestAdvanceStep_SavedDuringRun(t *testing.T) {
+	input := json.RawMessage(`{"name": "Acme", "contact_emails": ["ops@acme.com"], "account_manager": "jane"}`)
+
+	t.Run("an assignment is kept", func(t *testing.T) {
+		o := newOnboarding()
+		withStore(t, o)
+		withRunner(t, StepCompanyDetails, func(loaded *Onboarding) (map[string]interface{}, error) {
+			o.Steps[0].Assignee = "team-1"
+			o.Version++
+			loaded.TenantId = "tenant-1"
+			return map[string]interface{}{"tenant_id": "tenant-1"}, nil
+		}, nil)
+
+		result, err, _ := AdvanceStep(o.ID, StepCompanyDetails, input, "jane", context.Background(), nil)
+		require.NoError(t, err)
+		assert.Equal(t, StepCompleted, result.Steps[0].Status)
+		assert.Equal(t, "team-1", o.Steps[0].Assignee)
+		assert.Equal(t, "tenant-1", o.TenantId)
+	})
+
+	t.Run("a step the onboarding moved away from is not recorded", func(t *testing.T) {
+		o := newOnboarding()
+		o.complete(0, nil, "jane", time.Now())
+		withStore(t, o)
+		withRunner(t, StepChooseTier, func(loaded *Onboarding) (map[string]interface{}, error) {
+			o.CurrentStep = StepCompanyDetails
+			o.Version++
+			return map[string]interface{}{"plan_code": "PLN_1"}, nil
+		}, nil)
+
+		_, err, code := AdvanceStep(o.ID, StepChooseTier, json.RawMessage(`{"plan_code": "PLN_1"}`), "jane", context.Background(), nil)
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.Equal(t, http.StatusConflict, code)
+		assert.Equal(t, StepCompanyDetails, o.CurrentStep)
+	})
+
+	t.Run("a step claimed by another run is not recorded", func(t *testing.T) {
+		o := newOnboarding()
+		withStore(t, o)
+		withRunner(t, StepCompanyDetails, func(loaded *Onboarding) (map[string]interface{}, error) {
+			other := loaded.Steps[0].StartedAt.Add(time.Second)
+			o.Steps[0].StartedAt = &other
+			o.Version++
+			return nil, nil
+		}, nil)
+
+		_, err, code := AdvanceStep(o.ID, StepCompanyDetails, input, "jane", context.Background(), nil)
+		assert.ErrorIs(t, err, ErrConflict)
+		assert.Equal(t, http.StatusConflict, code)
+		assert.Equal(t, StepRunning, o.Steps[0].Status)
+	})
+}
+
+func TestRollbackStep(t *testing.T) {
+	o := newOnboarding()
+	o.complete(0, map[string]interface{}{"tenant_id": "tenant-1"}, "jane", time.Now())
+	o.complete(1, map[string]interface{}{"plan_code": "PLN_1"}, "jane", time.Now())
+	withStore(t, o)
+
+	var rolledBack []StepName
+	for _, name := range []StepName{StepCompanyDetails, StepChooseTier} {
+		withRunner(t, name, nil, func(o *Onboarding) error {
+			rolledBack = append(rolledBack, name)
+			return nil
+		})
+	}
+
+	// The later steps are rolled back first
+	_, err, code := RollbackStep(o.ID, StepCompanyDetails, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the choose_tier step has to be rolled back first")
+	assert.Equal(t, http.StatusConflict, code)
+
+	_, err, code = RollbackStep(o.ID, StepIssueSubscription, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the issue_subscription step is not completed")
+	assert.Equal(t, http.StatusConflict, code)
+
+	result, err, _ := RollbackStep(o.ID, StepChooseTier, "jane", context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, StepPending, result.Steps[1].Status)
+	assert.Nil(t, result.Steps[1].Output)
+	assert.NotNil(t, result.Steps[1].RolledBackAt)
+	assert.Equal(t, StepChooseTier, result.CurrentStep)
+
+	_, err, _ = RollbackStep(o.ID, StepCompanyDetails, "jane", context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, StepCompanyDetails, o.CurrentStep)
+	assert.Equal(t, []StepName{StepChooseTier, StepCompanyDetails}, rolledBack)
+}
+
+func TestRollbackStep_NextStepStarted(t *testing.T) {
+	o := newOnboarding()
+	o.complete(0, nil, "jane", time.Now())
+	withStore(t, o)
+	withRunner(t, StepCompanyDetails, nil, func(o *Onboarding) error { return nil })
+
+	started := time.Now().UTC()
+	o.Steps[1].Status, o.Steps[1].StartedAt = StepRunning, &started
+	_, err, code := RollbackStep(o.ID, StepCompanyDetails, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the choose_tier step is being run")
+	assert.Equal(t, http.StatusConflict, code)
+
+	o.Steps[1].Status, o.Steps[1].Output = StepFailed, map[string]interface{}{"plan_code": "PLN_1"}
+	_, err, code = RollbackStep(o.ID, StepCompanyDetails, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "the choose_tier step failed after some of its changes were made, advance it again first")
+	assert.Equal(t, http.StatusConflict, code)
+
+	// A failed step that kept nothing is pending again along with the step rolled back
+	o.Steps[1].Output, o.Steps[1].Error = map[string]interface{}{"email": ""}, "plan not found"
+	result, err, _ := RollbackStep(o.ID, StepCompanyDetails, "jane", context.Background(), nil)
+	require.NoError(t, err)
+	assert.Equal(t, StepPending, result.Steps[1].Status)
+	assert.Empty(t, result.Steps[1].Error)
+	assert.Nil(t, result.Steps[1].Output)
+}
+
+func TestRollbackStep_FailedCompensation(t *testing.T) {
+	o := newOnboarding()
+	o.complete(0, nil, "jane", time.Now())
+	withStore(t, o)
+	withRunner(t, StepCompanyDetails, nil, func(o *Onboarding) error { return errors.New("tenant is locked") })
+
+	_, err, code := RollbackStep(o.ID, StepCompanyDetails, "jane", context.Background(), nil)
+	assert.EqualError(t, err, "unable to roll back the company_details step: tenant is locked")
+	assert.Equal(t, http.StatusBadGateway, code)
+	assert.Equal(t, StepCompleted, o.Steps[0].Status, "the step stays completed")
+}
+
+func TestSaveConflict(t *testing.T) {
+	o := newOnboarding()
+	withStore(t, o)
+	withRunner(t, StepCompanyDetails, func(o *Onboarding) (map[string]interface{}, error) {
+		return nil, nil
+	}, nil)
+
+	// Someone else saved the onboarding in the meantime
+	load := loadOnboarding
+	loadOnboarding = func(ctx context.Context, db *mongo.Database, id string) (*Onboarding, error, int) {
+		loaded, err, code := load(ctx, db, id)
+		o.Version++
+		return loaded, err, code
+	}
+
+	_, err, code := AdvanceStep(o.ID, StepCompanyDetails, json.RawMessage(`{"name": "Acme", "contact_emails": ["ops@acme.com"], "account_manager": "jane"}`), "jane", context.Background(), nil)
+	assert.ErrorIs(t, err, ErrConflict)
+	assert.Equal(t, http.StatusConflict, code)
+}
+
+func TestStepInputs(t *testing.T) {
+	tests := []struct {
+		step    StepName
+		input   string
+		message string
+	}{
+		{StepCompanyDetails, `{"name": "Acme", "account_manager": "jane"}`, "at least one contact email is required"},
+		{StepCompanyDetails, `{"name": "Acme", "contact_emails": ["ops@acme.com"], "account_manager": "jane", "plan": "x"}`, `json: unknown field "plan"`},
+		{StepChooseTier, ``, "a plan code is required"},
+		{StepIssueSubscription, `{"start_date": "tomorrow"}`, `the start date "tomorrow" is not an ISO 8601 date`},
+		{StepProvisionAdmin, `{"email": "admin", "first_name": "Ada", "last_name": "Obi"}`, `invalid admin email "admin"`},
+		{StepProvisionAdmin, `{"email": "admin@acme.com", "first_name": "Ada"}`, "the admin's first and last names are required"},
+		{StepAssignTeam, `{"team_id": "support"}`, `invalid team id "support"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.step), func(t *testing.T) {
+			_, _, err := steps[tt.step].parse(json.RawMessage(tt.input))
+			assert.EqualError(t, err, tt.message)
+		})
+	}
+
+	input, fields, err := steps[StepProvisionAdmin].parse(json.RawMessage(`{"email": "Ada <Ada@Acme.com>", "first_name": "Ada", "last_name": "Obi"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "ada@acme.com", input.(*ProvisionAdminInput).Email)
+	assert.Equal(t, "ada@acme.com", fields["email"])
+
+	_, _, err = steps[StepIssueSubscription].parse(nil)
+	assert.NoError(t, err, "the subscription has defaults for all its input")
+}
+
+func TestPipelineHasRunners(t *testing.T) {
+	for _, name := range Pipeline {
+		runner, ok := steps[name]
+		require.True(t, ok, name)
+		assert.NotNil(t, runner.run, name)
+		assert.NotNil(t, runner.rollback, name)
+	}
+}
+
+func TestStalledFilter(t *testing.T) {
+	cutoff := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, bson.M{
+		"status": StatusInProgress,
+		"$or": bson.A{
+			bson.M{"updated_at": bson.M{"$lt": cutoff}},
+			bson.M{"steps": bson.M{"$elemMatch": bson.M{"status": StepFailed}}},
+		},
+	}, stalledFilter(cutoff))
+}
+
+func TestStallThreshold(t *testing.T) {
+	t.Setenv("ONBOARDING_STALL_AFTER", "")
+	assert.Equal(t, 72*time.Hour, StallThreshold())
+
+	t.Setenv("ONBOARDING_STALL_AFTER", "36h")
+	assert.Equal(t, 36*time.Hour, StallThreshold())
+}
+
+func TestHandlers(t *testing.T) {
+	t.Run("mutations require a caller", func(t *testing.T) {
+		handlers := map[string]http.HandlerFunc{
+			"HandleStartOnboarding": HandleStartOnboarding(nil),
+			"HandleAdvanceStep":     HandleAdvanceStep(nil),
+			"HandleRollbackStep":    HandleRollbackStep(nil),
+			"HandleAssignStep":      HandleAssignStep(nil),
+		}
+
+		for name, handler := range handlers {
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPatch, "/", bytes.NewBufferString(`{}`)))
+
+			assert.Equal(t, http.StatusUnauthorized, rr.Code, name)
+		}
+	})
+
+	t.Run("stalled rejects an invalid older_than", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		HandleFetchStalledOnboardings(nil).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/stalled?older_than=3days", nil))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code)
+		assert.Contains(t, rr.Body.String(), `invalid older_than \"3days\"`)
+	})
+}
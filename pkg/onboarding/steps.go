@@ -0,0 +1,401 @@
+package onboarding
+
+import (
+	"bytes"
+	"context"
+	"control-panel-bk/config"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/pkg/panelAdmins"
+	"control-panel-bk/pkg/tenants"
+	"control-panel-bk/pkg/tiers"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"maps"
+	"net/http"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// stepInput is the input a step is advanced with, it is validated before the step runs
+type stepInput interface {
+	validate() error
+}
+
+type CompanyDetailsInput struct {
+	Name           string          `json:"name"`
+	Profile        tenants.Profile `json:"profile"`
+	ContactEmails  []string        `json:"contact_emails"`
+	AccountManager string          `json:"account_manager"`
+}
+
+type ChooseTierInput struct {
+	PlanCode string `json:"plan_code"`
+}
+
+type IssueSubscriptionInput struct {
+	Customer      string `json:"customer,omitempty"` // the customer's email or code, the tenant's first contact by default
+	Authorization string `json:"authorization,omitempty"`
+	StartDate     string `json:"start_date,omitempty"`
+}
+
+type ProvisionAdminInput struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+type AssignTeamInput struct {
+	TeamId string `json:"team_id"`
+}
+
+func (in *CompanyDetailsInput) tenant() tenants.CTenant {
+	return tenants.CTenant{Name: in.Name, Profile: in.Profile, ContactEmails: in.ContactEmails, AccountManager: in.AccountManager, Status: tenants.StatusTrial}
+}
+
+func (in *CompanyDetailsInput) validate() error {
+	ct := in.tenant()
+	if err := ct.Validate(); err != nil {
+		return err
+	}
+
+	in.Name, in.ContactEmails = strings.TrimSpace(in.Name), ct.ContactEmails
+	return nil
+}
+
+func (in *ChooseTierInput) validate() error {
+	if strings.TrimSpace(in.PlanCode) == "" {
+		return errors.New("a plan code is required")
+	}
+	return nil
+}
+
+func (in *IssueSubscriptionInput) validate() error {
+	if in.StartDate == "" {
+		return nil
+	}
+
+	if _, err := time.Parse(time.RFC3339, in.StartDate); err != nil {
+		return fmt.Errorf("the start date %q is not an ISO 8601 date", in.StartDate)
+	}
+	return nil
+}
+
+func (in *ProvisionAdminInput) validate() error {
+	address, err := mail.ParseAddress(strings.TrimSpace(in.Email))
+	if err != nil {
+		return fmt.Errorf("invalid admin email %q", in.Email)
+	}
+	in.Email = strings.ToLower(address.Address)
+
+	if strings.TrimSpace(in.FirstName) == "" || strings.TrimSpace(in.LastName) == "" {
+		return errors.New("the admin's first and last names are required")
+	}
+	return nil
+}
+
+func (in *AssignTeamInput) validate() error {
+	if _, err := util.GetPrimitiveID(in.TeamId); err != nil {
+		return fmt.Errorf("invalid team id %q", in.TeamId)
+	}
+	return nil
+}
+
+// stepRunner runs a step of the Pipeline and undoes it. The run gets the validated input and returns the output the
+// later steps and the rollback read. A run that fails after a side effect returns what it produced with the error, it
+// is kept on the step so the next run does not repeat it.
+type stepRunner struct {
+	input    func() stepInput
+	run      func(ctx context.Context, db *mongo.Database, o *Onboarding, input stepInput, actor string) (map[string]interface{}, error)
+	rollback func(ctx context.Context, db *mongo.Database, o *Onboarding) error
+}
+
+// parse decodes and validates the input of the step, the fields are what the step keeps of it
+func (sr stepRunner) parse(raw json.RawMessage) (stepInput, map[string]interface{}, error) {
+	input := sr.input()
+
+	if len(bytes.TrimSpace(raw)) > 0 {
+		decoder := json.NewDecoder(bytes.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(input); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := input.validate(); err != nil {
+		return nil, nil, err
+	}
+
+	encoded, err := json.Marshal(input)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, nil, err
+	}
+
+	return input, fields, nil
+}
+
+var steps = map[StepName]stepRunner{
+	StepCompanyDetails: {
+		input:    func() stepInput { return &CompanyDetailsInput{} },
+		run:      runCompanyDetails,
+		rollback: rollbackCompanyDetails,
+	},
+	StepChooseTier: {
+		input:    func() stepInput { return &ChooseTierInput{} },
+		run:      runChooseTier,
+		rollback: rollbackChooseTier,
+	},
+	StepIssueSubscription: {
+		input:    func() stepInput { return &IssueSubscriptionInput{} },
+		run:      runIssueSubscription,
+		rollback: rollbackIssueSubscription,
+	},
+	StepProvisionAdmin: {
+		input:    func() stepInput { return &ProvisionAdminInput{} },
+		run:      runProvisionAdmin,
+		rollback: rollbackProvisionAdmin,
+	},
+	StepAssignTeam: {
+		input:    func() stepInput { return &AssignTeamInput{} },
+		run:      runAssignTeam,
+		rollback: rollbackAssignTeam,
+	},
+}
+
+// checkTeam verifies the team exists and is neither archived nor in the bin
+func checkTeam(ctx context.Context, db *mongo.Database, teamId string) (error, int) {
+	objId, err := util.GetPrimitiveID(teamId)
+	if err != nil {
+		return fmt.Errorf("invalid team id %q", teamId), http.StatusBadRequest
+	}
+
+	var team panelAdmins.Team
+	if err := db.Collection("teams").FindOne(ctx, bson.M{"_id": objId}).Decode(&team); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return fmt.Errorf("no team with the id %s was found", teamId), http.StatusBadRequest
+		}
+		return err, http.StatusInternalServerError
+	}
+
+	if team.ArchiveStatus || team.DeletedStatus {
+		return fmt.Errorf("the team %s is archived or in the bin", team.Name), http.StatusBadRequest
+	}
+
+	return nil, http.StatusOK
+}
+
+// updateTenant applies the changes of a step to the tenant of the onboarding
+func updateTenant(ctx context.Context, db *mongo.Database, o *Onboarding, update bson.M) error {
+	objId, err := util.GetPrimitiveID(o.TenantId)
+	if err != nil {
+		return errors.New("the onboarding has no tenant, the company details come first")
+	}
+
+	set, _ := update["$set"].(bson.M)
+	if set == nil {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set["updated_at"], set["updated_by"] = time.Now().UTC(), o.UpdatedBy
+
+	result, err := db.Collection(tenants.Collection).UpdateOne(ctx, bson.M{"_id": objId}, update)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return fmt.Errorf("the tenant %s no longer exists", o.TenantId)
+	}
+
+	return nil
+}
+
+// runCompanyDetails creates the tenant on trial, or updates it when an earlier attempt already created it
+func runCompanyDetails(ctx context.Context, db *mongo.Database, o *Onboarding, input stepInput, actor string) (map[string]interface{}, error) {
+	in := input.(*CompanyDetailsInput)
+
+	if o.TenantId == "" {
+		ct := in.tenant()
+		ct.CreatedBy, ct.UpdatedBy = actor, actor
+
+		created, err, _ := tenants.CreateTenant(ct, ctx, db)
+		if err != nil {
+			return nil, err
+		}
+		o.TenantId = created.InsertedID.(bson.ObjectID).Hex()
+	} else {
+		if err := updateTenant(ctx, db, o, bson.M{"$set": bson.M{
+			"name":            in.Name,
+			"profile":         in.Profile,
+			"contact_emails":  in.ContactEmails,
+			"account_manager": in.AccountManager,
+		}}); err != nil {
+			return nil, err
+		}
+	}
+
+	return map[string]interface{}{"tenant_id": o.TenantId, "contact_email": in.ContactEmails[0]}, nil
+}
+
+// rollbackCompanyDetails deletes the tenant, the later steps are rolled back already so nothing refers to it
+func rollbackCompanyDetails(ctx context.Context, db *mongo.Database, o *Onboarding) error {
+	tenant := tenants.Tenant{ID: o.TenantId}
+	if _, err, code := tenant.HardDeleteTenant(ctx, db); err != nil && code != http.StatusNotFound {
+		return err
+	}
+
+	o.TenantId = ""
+	return nil
+}
+
+func runChooseTier(ctx context.Context, db *mongo.Database, o *Onboarding, input stepInput, actor string) (map[string]interface{}, error) {
+	in := input.(*ChooseTierInput)
+
+	plan, err, _ := tiers.GetMirroredTier(in.PlanCode, ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.Data.Drift == tiers.DriftMissing {
+		return nil, fmt.Errorf("the plan %s no longer exists on Paystack", in.PlanCode)
+	}
+
+	if err := updateTenant(ctx, db, o, bson.M{"$set": bson.M{"plan_code": in.PlanCode}}); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"plan_code": in.PlanCode, "currency": plan.Data.Currency, "interval": plan.Data.Interval}, nil
+}
+
+func rollbackChooseTier(ctx context.Context, db *mongo.Database, o *Onboarding) error {
+	return updateTenant(ctx, db, o, bson.M{"$set": bson.M{"plan_code": ""}})
+}
+
+// runIssueSubscription subscribes the tenant to the chosen plan on Paystack, the tenant is then active
+func runIssueSubscription(ctx context.Context, db *mongo.Database, o *Onboarding, input stepInput, actor string) (map[string]interface{}, error) {
+	in := input.(*IssueSubscriptionInput)
+
+	customer := in.Customer
+	if customer == "" {
+		customer = o.output(StepCompanyDetails, "contact_email")
+	}
+
+	// A subscription issued by a run that could not activate the tenant is kept, it is not issued twice. One issued
+	// on another plan, the tier was chosen again since, is disabled first.
+	plan := o.output(StepChooseTier, "plan_code")
+	output := map[string]interface{}{
+		"customer":          o.kept(StepIssueSubscription, "customer"),
+		"plan_code":         o.kept(StepIssueSubscription, "plan_code"),
+		"subscription_code": o.kept(StepIssueSubscription, "subscription_code"),
+		"email_token":       o.kept(StepIssueSubscription, "email_token"),
+	}
+	var disabled map[string]interface{}
+	if output["subscription_code"] != "" && output["plan_code"] != plan {
+		if _, err, _ := tiers.DisableSubscription(tiers.SubscriptionStateRequest{
+			Code:  output["subscription_code"].(string),
+			Token: output["email_token"].(string),
+		}, ctx); err != nil {
+			return nil, fmt.Errorf("unable to disable the subscription %s of the plan chosen before: %w", output["subscription_code"], err)
+		}
+		disabled = map[string]interface{}{"customer": "", "plan_code": "", "subscription_code": "", "email_token": ""}
+		output = maps.Clone(disabled)
+	}
+
+	if output["subscription_code"] == "" {
+		created, err, _ := tiers.CreateSubscription(tiers.CreateSubscriptionRequest{
+			Customer:      customer,
+			Plan:          plan,
+			Authorization: in.Authorization,
+			StartDate:     in.StartDate,
+		}, ctx)
+		if err != nil {
+			// The subscription disabled is no longer kept
+			return disabled, err
+		}
+
+		output["customer"], output["plan_code"] = customer, plan
+		output["subscription_code"], output["email_token"] = created.Data.SubscriptionCode, created.Data.EmailToken
+	}
+
+	if err := updateTenant(ctx, db, o, bson.M{"$set": bson.M{"status": tenants.StatusActive}}); err != nil {
+		return output, fmt.Errorf("subscription %s was issued but the tenant could not be activated: %w", output["subscription_code"], err)
+	}
+
+	return output, nil
+}
+
+func rollbackIssueSubscription(ctx context.Context, db *mongo.Database, o *Onboarding) error {
+	if _, err, _ := tiers.DisableSubscription(tiers.SubscriptionStateRequest{
+		Code:  o.output(StepIssueSubscription, "subscription_code"),
+		Token: o.output(StepIssueSubscription, "email_token"),
+	}, ctx); err != nil {
+		return err
+	}
+
+	return updateTenant(ctx, db, o, bson.M{"$set": bson.M{"status": tenants.StatusTrial}})
+}
+
+// runProvisionAdmin creates the tenant's admin in the tenants' user pool, they are emailed a temporary password. The
+// admin a failed run created is kept when the email is the same and replaced otherwise.
+func runProvisionAdmin(ctx context.Context, db *mongo.Database, o *Onboarding, input stepInput, actor string) (map[string]interface{}, error) {
+	in := input.(*ProvisionAdminInput)
+
+	email, upId := o.kept(StepProvisionAdmin, "email"), o.kept(StepProvisionAdmin, "up_id")
+	if upId != "" && email != in.Email {
+		if err := aws.DeleteTenantAdmin(config.AwsConfig, email); err != nil {
+			return nil, err
+		}
+		upId = ""
+	}
+
+	if upId == "" {
+		userId, err := aws.CreateTenantAdmin(config.AwsConfig, in.Email, o.TenantId, util.DefaultPassword)
+		if err != nil {
+			// The admin of the earlier email is gone, the step no longer keeps it
+			return map[string]interface{}{"email": "", "up_id": ""}, err
+		}
+		upId = *userId
+	}
+
+	output := map[string]interface{}{"email": in.Email, "up_id": upId}
+	if err := updateTenant(ctx, db, o, bson.M{"$set": bson.M{"admin_email": in.Email}}); err != nil {
+		return output, err
+	}
+
+	return output, nil
+}
+
+func rollbackProvisionAdmin(ctx context.Context, db *mongo.Database, o *Onboarding) error {
+	if err := aws.DeleteTenantAdmin(config.AwsConfig, o.output(StepProvisionAdmin, "email")); err != nil {
+		return err
+	}
+
+	return updateTenant(ctx, db, o, bson.M{"$set": bson.M{"admin_email": ""}})
+}
+
+func runAssignTeam(ctx context.Context, db *mongo.Database, o *Onboarding, input stepInput, actor string) (map[string]interface{}, error) {
+	in := input.(*AssignTeamInput)
+
+	if err, _ := checkTeam(ctx, db, in.TeamId); err != nil {
+		return nil, err
+	}
+
+	if err := updateTenant(ctx, db, o, bson.M{"$set": bson.M{"account_team": in.TeamId}}); err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{"team_id": in.TeamId}, nil
+}
+
+func rollbackAssignTeam(ctx context.Context, db *mongo.Database, o *Onboarding) error {
+	return updateTenant(ctx, db, o, bson.M{"$set": bson.M{"account_team": ""}})
+}
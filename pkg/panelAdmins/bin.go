@@ -0,0 +1,275 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/pkg/tenants"
+	"control-panel-bk/util"
+	"errors"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// BinPurgeActor is the actor of the audit entries of the purges, which no user triggers
+const BinPurgeActor = "system:bin-purger"
+
+// BinRetention reads BIN_RETENTION_DAYS, the days a record stays in the bin before it is purged, it defaults to 30 days
+func BinRetention() time.Duration {
+	if days, err := strconv.Atoi(os.Getenv("BIN_RETENTION_DAYS")); err == nil && days > 0 {
+		return time.Duration(days) * 24 * time.Hour
+	}
+	return 30 * 24 * time.Hour
+}
+
+// PurgedRecord is a record removed from the bin, or the one a dry run would remove
+type PurgedRecord struct {
+	ID        string    `json:"_id"`
+	Name      string    `json:"name"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// PurgeReport sums up a purge of the bin of a collection
+type PurgeReport struct {
+	Collection string         `json:"collection"`
+	DryRun     bool           `json:"dry_run"`
+	Cutoff     time.Time      `json:"cutoff"`
+	Purged     []PurgedRecord `json:"purged"`
+	Failed     []string       `json:"failed,omitempty"`
+}
+
+// bin is the recycle bin of a collection, remove is the hard delete of the manual delete route and tells whether the
+// record with the id matched the filter
+type bin struct {
+	collection string
+	entity     audit.EntityType
+	action     audit.Action
+	remove     func(ctx context.Context, db *mongo.Database, id string, filter bson.M) (bool, error)
+}
+
+var roleBin = bin{
+	collection: "roles",
+	entity:     audit.RoleEntity,
+	action:     audit.RolePurged,
+	remove: func(ctx context.Context, db *mongo.Database, id string, filter bson.M) (bool, error) {
+		// A role some users still hold stays in the bin, as it does on the delete route
+		if err, _ := checkRoleUnused(ctx, db, id); err != nil {
+			return false, err
+		}
+
+		deleted, err := deleteRole(ctx, db, filter)
+		if deleted > 0 {
+			if err := dropRoleGroup(id); err != nil {
+				log.Printf("bin: unable to remove the group of the role %s from the user pool, run cognito-sync: %s", id, err.Error())
+			}
+		}
+		return deleted > 0, err
+	},
+}
+
+var teamBin = bin{
+	collection: "teams",
+	entity:     audit.TeamEntity,
+	action:     audit.TeamPurged,
+	remove: func(ctx context.Context, db *mongo.Database, id string, filter bson.M) (bool, error) {
+		var t Team
+		if err := deleteTeam(ctx, db, filter, &t); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	},
+}
+
+var tenantBin = bin{
+	collection: tenants.Collection,
+	entity:     audit.TenantEntity,
+	action:     audit.TenantPurged,
+	// A tenant an onboarding still refers to stays in the bin, as it does on the delete route
+	remove: tenants.DeleteTenant,
+}
+
+// expiredFilter matches the records binned before the cutoff. The records binned before deleted_at was kept fall back
+// to updated_at, which is the time they were binned unless they were edited in the bin.
+func expiredFilter(cutoff time.Time) bson.M {
+	return bson.M{
+		"is_deleted_status": true,
+		"$or": bson.A{
+			bson.M{"deleted_at": bson.M{"$lte": cutoff}},
+			bson.M{"deleted_at": bson.M{"$exists": false}, "updated_at": bson.M{"$lte": cutoff}},
+		},
+	}
+}
+
+// purge hard-deletes the records of the bin older than the cutoff, or only lists them on a dry run. A record that
+// fails to delete is reported and left for the next pass.
+func (b bin) purge(ctx context.Context, db *mongo.Database, cutoff time.Time, dryRun bool) (*PurgeReport, error) {
+	report := &PurgeReport{Collection: b.collection, DryRun: dryRun, Cutoff: cutoff, Purged: []PurgedRecord{}}
+
+	opt := options.Find().SetSort(bson.D{{Key: "deleted_at", Value: 1}, {Key: "updated_at", Value: 1}})
+	cursor, err := db.Collection(b.collection).Find(ctx, expiredFilter(cutoff), opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var before bson.M
+		var record struct {
+			ID        string     `json:"_id"`
+			Name      string     `json:"name"`
+			DeletedAt *time.Time `json:"deleted_at"`
+			UpdatedAt time.Time  `json:"updated_at"`
+		}
+		if err := cursor.Decode(&before); err != nil {
+			return nil, err
+		}
+		if err := cursor.Decode(&record); err != nil {
+			return nil, err
+		}
+
+		purged := PurgedRecord{ID: record.ID, Name: record.Name, DeletedAt: record.UpdatedAt}
+		if record.DeletedAt != nil {
+			purged.DeletedAt = *record.DeletedAt
+		}
+
+		if dryRun {
+			report.Purged = append(report.Purged, purged)
+			continue
+		}
+
+		objId, err := util.GetPrimitiveID(record.ID)
+		if err != nil {
+			report.Failed = append(report.Failed, record.ID)
+			continue
+		}
+
+		// The expiry is checked again on the delete, a record restored since it was listed stays
+		removed, err := b.remove(ctx, db, record.ID, bson.M{"$and": bson.A{bson.M{"_id": objId}, expiredFilter(cutoff)}})
+		if err != nil {
+			log.Printf("bin: unable to purge %s %s: %s", b.entity, record.ID, err.Error())
+			report.Failed = append(report.Failed, record.ID)
+			continue
+		}
+		if !removed {
+			continue
+		}
+
+		audit.Record(ctx, db, audit.Event{
+			Actor:      BinPurgeActor,
+			Action:     b.action,
+			EntityType: b.entity,
+			EntityId:   record.ID,
+			Before:     before,
+		})
+		report.Purged = append(report.Purged, purged)
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// PurgeRoleBin purges the roles binned for longer than the retention
+func PurgeRoleBin(ctx context.Context, db *mongo.Database, retention time.Duration, dryRun bool) (*PurgeReport, error, int) {
+	report, err := roleBin.purge(ctx, db, time.Now().UTC().Add(-retention), dryRun)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+	return report, nil, http.StatusOK
+}
+
+// PurgeTeamBin purges the teams binned for longer than the retention
+func PurgeTeamBin(ctx context.Context, db *mongo.Database, retention time.Duration, dryRun bool) (*PurgeReport, error, int) {
+	report, err := teamBin.purge(ctx, db, time.Now().UTC().Add(-retention), dryRun)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+	return report, nil, http.StatusOK
+}
+
+// PurgeTenantBin purges the tenants binned for longer than the retention
+func PurgeTenantBin(ctx context.Context, db *mongo.Database, retention time.Duration, dryRun bool) (*PurgeReport, error, int) {
+	report, err := tenantBin.purge(ctx, db, time.Now().UTC().Add(-retention), dryRun)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+	return report, nil, http.StatusOK
+}
+
+// StartBinPurger purges the bins right away and then on every tick of the interval, until the context is done
+func StartBinPurger(ctx context.Context, db *mongo.Database, retention time.Duration, interval time.Duration) {
+	purge := func() {
+		for _, b := range []bin{roleBin, teamBin, tenantBin} {
+			report, err := b.purge(ctx, db, time.Now().UTC().Add(-retention), false)
+			if err != nil {
+				log.Printf("bin: purge of the %s failed: %s", b.collection, err.Error())
+				continue
+			}
+
+			if len(report.Purged)+len(report.Failed) > 0 {
+				log.Printf("bin: purged %d %s, %d failed %v", len(report.Purged), b.collection, len(report.Failed), report.Failed)
+			}
+		}
+	}
+
+	go func() {
+		purge()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				purge()
+			}
+		}
+	}()
+}
+
+func previewPurgeHandler(db *mongo.Database, purge func(context.Context, *mongo.Database, time.Duration, bool) (*PurgeReport, error, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report, err, code := purge(r.Context(), db, BinRetention(), true)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		respBytes, respErr := util.GetBytesResponse(code, report)
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		w.Write(respBytes)
+	}
+}
+
+// HandlePreviewRoleBinPurge lists the roles the next purge removes, without removing them
+func HandlePreviewRoleBinPurge(db *mongo.Database) http.HandlerFunc {
+	return previewPurgeHandler(db, PurgeRoleBin)
+}
+
+// HandlePreviewTeamBinPurge lists the teams the next purge removes, without removing them
+func HandlePreviewTeamBinPurge(db *mongo.Database) http.HandlerFunc {
+	return previewPurgeHandler(db, PurgeTeamBin)
+}
+
+// HandlePreviewTenantBinPurge lists the tenants the next purge removes, without removing them
+func HandlePreviewTenantBinPurge(db *mongo.Database) http.HandlerFunc {
+	return previewPurgeHandler(db, PurgeTenantBin)
+}
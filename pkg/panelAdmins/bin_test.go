@@ -0,0 +1,33 @@
+package panelAdmins
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"testing"
+	"time"
+)
+
+func TestBinRetention(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"":    30 * 24 * time.Hour,
+		"7":   7 * 24 * time.Hour,
+		"0":   30 * 24 * time.Hour,
+		"-2":  30 * 24 * time.Hour,
+		"one": 30 * 24 * time.Hour,
+	} {
+		t.Setenv("BIN_RETENTION_DAYS", value)
+		assert.Equal(t, expected, BinRetention(), value)
+	}
+}
+
+func TestExpiredFilter(t *testing.T) {
+	cutoff := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, bson.M{
+		"is_deleted_status": true,
+		"$or": bson.A{
+			bson.M{"deleted_at": bson.M{"$lte": cutoff}},
+			bson.M{"deleted_at": bson.M{"$exists": false}, "updated_at": bson.M{"$lte": cutoff}},
+		},
+	}, expiredFilter(cutoff), "the records binned before deleted_at was kept expire by updated_at")
+}
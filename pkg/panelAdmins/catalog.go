@@ -0,0 +1,204 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/util"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"net/http"
+	"slices"
+)
+
+// CatalogResource is a resource of the permission catalog and the actions a role can be granted on it
+type CatalogResource struct {
+	Name        Resource `json:"name"`
+	Label       string   `json:"label"`
+	Description string   `json:"description,omitempty"`
+	Actions     []Action `json:"actions"`
+	Order       int      `json:"order"`
+}
+
+// DefaultCatalog is the catalog the routes are guarded by, it is seeded into the permission_catalog collection where
+// more resources and actions can be added
+var DefaultCatalog = []CatalogResource{
+	{Name: OnboardingResource, Label: "Onboarding", Description: "The onboarding pipeline of the new tenants", Actions: []Action{ReadAction, CreateAction, UpdateAction, ExportAction}, Order: 1},
+	{Name: RoleResource, Label: "Roles", Description: "The roles and their grants, the audit log", Actions: []Action{ReadAction, CreateAction, UpdateAction, ArchiveAction, DeleteAction, RestoreAction, ExportAction}, Order: 2},
+	{Name: TeamResource, Label: "Teams", Description: "The teams, their leads and members", Actions: []Action{ReadAction, CreateAction, UpdateAction, ArchiveAction, DeleteAction, RestoreAction, ExportAction}, Order: 3},
+	{Name: UserResource, Label: "Users", Description: "The panel users, create invites a user and archive deactivates one", Actions: []Action{ReadAction, CreateAction, UpdateAction, ArchiveAction, ExportAction}, Order: 4},
+	{Name: TenantResource, Label: "Tenants", Description: "The customer organizations", Actions: []Action{ReadAction, CreateAction, UpdateAction, ArchiveAction, DeleteAction, RestoreAction, ExportAction}, Order: 5},
+	{Name: BillingResource, Label: "Billing", Description: "The tiers and the subscriptions", Actions: []Action{ReadAction, CreateAction, UpdateAction, ExportAction}, Order: 6},
+}
+
+// catalogActions are the actions of the resource in the catalog
+func catalogActions(catalog []CatalogResource, resource Resource) ([]Action, bool) {
+	for _, r := range catalog {
+		if r.Name == resource {
+			return r.Actions, true
+		}
+	}
+	return nil, false
+}
+
+// SeedPermissionCatalog adds the resources and actions of the DefaultCatalog missing from the collection, the ones
+// added to the collection are kept
+func SeedPermissionCatalog(ctx context.Context, db *mongo.Database) error {
+	for _, r := range DefaultCatalog {
+		update := bson.M{
+			"$setOnInsert": bson.M{"label": r.Label, "description": r.Description, "order": r.Order},
+			"$addToSet":    bson.M{"actions": bson.M{"$each": r.Actions}},
+		}
+		if _, err := db.Collection("permission_catalog").UpdateOne(ctx, bson.M{"name": r.Name}, update, options.UpdateOne().SetUpsert(true)); err != nil {
+			return fmt.Errorf("unable to seed the resource %s of the permission catalog: %w", r.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// FetchPermissionCatalog reads the catalog, the DefaultCatalog until the collection is seeded
+func FetchPermissionCatalog(ctx context.Context, db *mongo.Database) ([]CatalogResource, error) {
+	opt := options.Find().SetSort(bson.D{{Key: "order", Value: 1}, {Key: "name", Value: 1}})
+	cursor, err := db.Collection("permission_catalog").Find(ctx, bson.M{}, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog []CatalogResource
+	if err := cursor.All(ctx, &catalog); err != nil {
+		return nil, err
+	}
+
+	if len(catalog) == 0 {
+		return DefaultCatalog, nil
+	}
+	return catalog, nil
+}
+
+// GrantError is a grant the catalog does not know
+type GrantError struct {
+	Grant  Grant
+	Reason string
+}
+
+func (e *GrantError) Error() string {
+	return fmt.Sprintf("invalid grant %q: %s", string(e.Grant), e.Reason)
+}
+
+// checkGrants validates the grants against the catalog and returns them lower-cased and without duplicates
+func checkGrants(catalog []CatalogResource, grants Grants) (Grants, error) {
+	checked := make(Grants, 0, len(grants))
+
+	for _, grant := range grants {
+		resource, action, err := grant.Split()
+		if err != nil {
+			return nil, &GrantError{Grant: grant, Reason: "<resource>:<action> is expected"}
+		}
+
+		switch {
+		case resource == Wildcard && action != Wildcard:
+			if !slices.ContainsFunc(catalog, func(r CatalogResource) bool { return slices.Contains(r.Actions, action) }) {
+				return nil, &GrantError{Grant: grant, Reason: fmt.Sprintf("no resource has the action %q", action)}
+			}
+		case resource != Wildcard:
+			actions, found := catalogActions(catalog, resource)
+			if !found {
+				return nil, &GrantError{Grant: grant, Reason: fmt.Sprintf("unknown resource %q", resource)}
+			}
+			if action != Wildcard && !slices.Contains(actions, action) {
+				return nil, &GrantError{Grant: grant, Reason: fmt.Sprintf("the resource %q has no action %q", resource, action)}
+			}
+		}
+
+		if g := NewGrant(resource, action); !slices.Contains(checked, g) {
+			checked = append(checked, g)
+		}
+	}
+
+	return checked, nil
+}
+
+// ValidateGrants checks the grants of a role against the permission catalog
+func ValidateGrants(ctx context.Context, db *mongo.Database, grants Grants) (Grants, error) {
+	catalog, err := FetchPermissionCatalog(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	return checkGrants(catalog, grants)
+}
+
+// MigratePermissions converts the permission matrix of the roles and of their versions to grants, it can be run any
+// number of times and returns the number of documents converted
+func MigratePermissions(ctx context.Context, db *mongo.Database) (int64, error) {
+	var migrated int64
+
+	for _, collection := range []string{"roles", "role_versions"} {
+		cursor, err := db.Collection(collection).Find(ctx, bson.M{"permission": bson.M{"$exists": true}})
+		if err != nil {
+			return migrated, err
+		}
+
+		for cursor.Next(ctx) {
+			var doc struct {
+				ID         string     `json:"_id"`
+				Permission Permission `json:"permission"`
+				Grants     *Grants    `json:"grants"`
+			}
+			if err := cursor.Decode(&doc); err != nil {
+				cursor.Close(ctx)
+				return migrated, err
+			}
+
+			objId, err := util.GetPrimitiveID(doc.ID)
+			if err != nil {
+				continue
+			}
+
+			// A role edited with grants before it was migrated keeps them
+			update := bson.M{"$unset": bson.M{"permission": ""}}
+			if doc.Grants == nil {
+				update["$set"] = bson.M{"grants": doc.Permission.Grants()}
+			}
+
+			if _, err := db.Collection(collection).UpdateOne(ctx, bson.M{"_id": objId}, update); err != nil {
+				cursor.Close(ctx)
+				return migrated, fmt.Errorf("unable to migrate the permission of %s %s: %w", collection, doc.ID, err)
+			}
+			migrated++
+		}
+
+		if err := cursor.Err(); err != nil {
+			cursor.Close(ctx)
+			return migrated, err
+		}
+		cursor.Close(ctx)
+	}
+
+	return migrated, nil
+}
+
+// HandleFetchPermissionCatalog lists the resources and actions the roles can be granted, for the role editors
+func HandleFetchPermissionCatalog(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		catalog, err := FetchPermissionCatalog(r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		respBytes, respErr := util.GetBytesResponse(http.StatusOK, map[string]interface{}{
+			"resources": catalog,
+			"wildcard":  Wildcard,
+		})
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -0,0 +1,40 @@
+package panelAdmins
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckGrants(t *testing.T) {
+	checked, err := checkGrants(DefaultCatalog, Grants{"Team:Read", "team:read", " role:* ", "*:export", "*"})
+	require.NoError(t, err)
+	assert.Equal(t, Grants{"team:read", "role:*", "*:export", "*:*"}, checked, "the grants are lower-cased and deduplicated")
+
+	invalid := map[Grant]string{
+		"team":           "<resource>:<action> is expected",
+		"ledger:read":    `unknown resource "ledger"`,
+		"billing:delete": `the resource "billing" has no action "delete"`,
+		"*:approve":      `no resource has the action "approve"`,
+	}
+
+	for grant, reason := range invalid {
+		_, err := checkGrants(DefaultCatalog, Grants{"team:read", grant})
+
+		var grantErr *GrantError
+		require.True(t, errors.As(err, &grantErr), grant)
+		assert.Equal(t, grant, grantErr.Grant)
+		assert.Contains(t, err.Error(), reason)
+	}
+}
+
+func TestDefaultCatalog(t *testing.T) {
+	seen := map[Resource]bool{}
+	for _, r := range DefaultCatalog {
+		assert.False(t, seen[r.Name], "the resource %s is listed once", r.Name)
+		seen[r.Name] = true
+		assert.Contains(t, r.Actions, ReadAction, "every resource can be read")
+	}
+}
@@ -0,0 +1,328 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/util"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The columns of the exports, in the order of the cells of a row
+var (
+	userExportColumns = []string{"id", "first_name", "last_name", "email", "phone", "gender", "role_id", "role", "teams", "leads", "is_active", "archive_status", "created_by", "created_at", "updated_at"}
+	teamExportColumns = []string{"id", "name", "description", "team_lead", "team_lead_email", "members", "member_count", "archive_status", "is_deleted_status", "created_by", "created_at", "updated_at"}
+	roleExportColumns = []string{"id", "name", "description", "grants", "parents", "active_users", "version", "archive_status", "is_deleted_status", "created_by", "created_at", "updated_at"}
+)
+
+// exportedUser is a user as createUser stores it, the personal fields are at the top of the document and the phone is
+// phone_num
+type exportedUser struct {
+	ID            string    `json:"_id"`
+	FirstName     string    `json:"first_name"`
+	LastName      string    `json:"last_name"`
+	Email         string    `json:"email"`
+	Phone         string    `json:"phone_num"`
+	Gender        string    `json:"gender"`
+	RoleId        string    `json:"role_id"`
+	IsActive      bool      `json:"is_active"`
+	ArchiveStatus bool      `json:"archive_status"`
+	CreatedBy     string    `json:"created_by"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// exportHandler streams the documents of the collection matching the list query in its order, the query params are
+// format and the view, filter[field][op] and sort of the list. The prepare func compiles the filter and makes the row
+// of a document, once per export, so the names joined on the rows are loaded before the first one is written.
+func exportHandler[T any](db *mongo.Database, collection string, fields util.Fields, columns []string, prepare func(ctx context.Context, query util.ListQuery) (bson.M, func(T) ([]string, error), error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		format, err := util.ParseExportFormat(r)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		query, err := util.ParseListQuery(r, fields)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		filter, row, err := prepare(r.Context(), query)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		cursor, err := db.Collection(collection).Find(r.Context(), filter, options.Find().SetSort(util.SortOrder(query.Sort)))
+		if err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		if err := util.StreamExport(r.Context(), w, cursor, format, collection, columns, row); err != nil {
+			log.Printf("%s: the export was cut short: %s", collection, err.Error())
+		}
+	}
+}
+
+// HandleExportUsers exports the users with the names of their role and of the teams they are in and lead
+func HandleExportUsers(db *mongo.Database) http.HandlerFunc {
+	return exportHandler(db, "users", userFields, userExportColumns, func(ctx context.Context, query util.ListQuery) (bson.M, func(exportedUser) ([]string, error), error) {
+		filter, err := usersFilter(query, ctx, db)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		dir, err := loadUserExportDirectory(ctx, db)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return filter, dir.row, nil
+	})
+}
+
+// HandleExportTeams exports the teams with the name and email of the lead and the emails of the members
+func HandleExportTeams(db *mongo.Database) http.HandlerFunc {
+	return exportHandler(db, "teams", teamFields, teamExportColumns, func(ctx context.Context, query util.ListQuery) (bson.M, func(Team) ([]string, error), error) {
+		users, err := exportUserNames(ctx, db)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return query.Filter(), func(t Team) ([]string, error) {
+			return teamExportRow(t, users), nil
+		}, nil
+	})
+}
+
+// HandleExportRoles exports the roles with the names of their parents and the number of active users holding them
+func HandleExportRoles(db *mongo.Database) http.HandlerFunc {
+	return exportHandler(db, "roles", roleFields, roleExportColumns, func(ctx context.Context, query util.ListQuery) (bson.M, func(Role) ([]string, error), error) {
+		names, err := exportRoleNames(ctx, db)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		holders, err := exportRoleHolders(ctx, db)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return query.Filter(), func(rl Role) ([]string, error) {
+			return roleExportRow(rl, names, holders), nil
+		}, nil
+	})
+}
+
+// userExportDirectory is the names joined onto the exported users, the roles by id and the teams by the ids of their
+// members and leads
+type userExportDirectory struct {
+	roles map[string]string
+	teams map[string][]string
+	leads map[string][]string
+}
+
+// loadUserExportDirectory reads the names of every role and of the teams out of the bin, the roles and teams are few
+// next to the users streamed
+func loadUserExportDirectory(ctx context.Context, db *mongo.Database) (*userExportDirectory, error) {
+	roles, err := exportRoleNames(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := &userExportDirectory{roles: roles, teams: map[string][]string{}, leads: map[string][]string{}}
+
+	projection := bson.M{"name": 1, "team_lead": 1, "team_member": 1}
+	cursor, err := db.Collection("teams").Find(ctx, bson.M{"is_deleted_status": bson.M{"$ne": true}}, options.Find().SetProjection(projection).SetSort(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var t Team
+		if err := cursor.Decode(&t); err != nil {
+			return nil, err
+		}
+		dir.add(t)
+	}
+
+	return dir, cursor.Err()
+}
+
+func (d *userExportDirectory) add(t Team) {
+	if t.TeamLead != "" {
+		d.leads[t.TeamLead] = append(d.leads[t.TeamLead], t.Name)
+		d.teams[t.TeamLead] = append(d.teams[t.TeamLead], t.Name)
+	}
+	for _, member := range t.TeamMember {
+		if member != t.TeamLead {
+			d.teams[member] = append(d.teams[member], t.Name)
+		}
+	}
+}
+
+func (d *userExportDirectory) row(u exportedUser) ([]string, error) {
+	return []string{
+		u.ID,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Phone,
+		u.Gender,
+		u.RoleId,
+		d.roles[u.RoleId],
+		strings.Join(d.teams[u.ID], "; "),
+		strings.Join(d.leads[u.ID], "; "),
+		strconv.FormatBool(u.IsActive),
+		strconv.FormatBool(u.ArchiveStatus),
+		u.CreatedBy,
+		util.ExportTime(u.CreatedAt),
+		util.ExportTime(u.UpdatedAt),
+	}, nil
+}
+
+// exportUserNames reads the names and emails of every user by their id, once for the teams streamed rather than once
+// for each of them
+func exportUserNames(ctx context.Context, db *mongo.Database) (map[string]exportedUser, error) {
+	projection := bson.M{"first_name": 1, "last_name": 1, "email": 1}
+	cursor, err := db.Collection("users").Find(ctx, bson.M{}, options.Find().SetProjection(projection))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	users := map[string]exportedUser{}
+	for cursor.Next(ctx) {
+		var u exportedUser
+		if err := cursor.Decode(&u); err != nil {
+			return nil, err
+		}
+		users[u.ID] = u
+	}
+
+	return users, cursor.Err()
+}
+
+// teamExportRow names the lead and the members by their email, or by their id once the user is gone
+func teamExportRow(t Team, users map[string]exportedUser) []string {
+	email := func(id string) string {
+		if u, found := users[id]; found && u.Email != "" {
+			return u.Email
+		}
+		return id
+	}
+
+	lead := users[t.TeamLead]
+	members := make([]string, 0, len(t.TeamMember))
+	for _, member := range t.TeamMember {
+		members = append(members, email(member))
+	}
+
+	leadEmail := ""
+	if t.TeamLead != "" {
+		leadEmail = email(t.TeamLead)
+	}
+
+	return []string{
+		t.ID,
+		t.Name,
+		t.Description,
+		strings.TrimSpace(lead.FirstName + " " + lead.LastName),
+		leadEmail,
+		strings.Join(members, "; "),
+		strconv.Itoa(len(t.TeamMember)),
+		strconv.FormatBool(t.ArchiveStatus),
+		strconv.FormatBool(t.DeletedStatus),
+		t.CreatedBy,
+		util.ExportTime(t.CreatedAt),
+		util.ExportTime(t.UpdatedAt),
+	}
+}
+
+// exportRoleNames reads the name of every role by its id, the archived and binned ones included
+func exportRoleNames(ctx context.Context, db *mongo.Database) (map[string]string, error) {
+	cursor, err := db.Collection("roles").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	names := map[string]string{}
+	for cursor.Next(ctx) {
+		var rl Role
+		if err := cursor.Decode(&rl); err != nil {
+			return nil, err
+		}
+		names[rl.ID] = rl.Name
+	}
+
+	return names, cursor.Err()
+}
+
+// exportRoleHolders counts the users that are not archived by the id of their role
+func exportRoleHolders(ctx context.Context, db *mongo.Database) (map[string]int, error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"archive_status": bson.M{"$ne": true}, "role_id": bson.M{"$nin": bson.A{nil, ""}}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$role_id", "count": bson.M{"$sum": 1}}}},
+	}
+
+	cursor, err := db.Collection("users").Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		RoleId string `json:"_id"`
+		Count  int    `json:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	holders := make(map[string]int, len(counts))
+	for _, c := range counts {
+		holders[c.RoleId] = c.Count
+	}
+
+	return holders, nil
+}
+
+// roleExportRow lists the grants as they are written and the parents by name, or by id once the parent is gone
+func roleExportRow(rl Role, names map[string]string, holders map[string]int) []string {
+	grants := make([]string, len(rl.Grants))
+	for i, g := range rl.Grants {
+		grants[i] = string(g)
+	}
+
+	parents := make([]string, len(rl.Parents))
+	for i, id := range rl.Parents {
+		parents[i] = id
+		if name, found := names[id]; found {
+			parents[i] = name
+		}
+	}
+
+	return []string{
+		rl.ID,
+		rl.Name,
+		rl.Description,
+		strings.Join(grants, ", "),
+		strings.Join(parents, "; "),
+		strconv.Itoa(holders[rl.ID]),
+		strconv.Itoa(rl.Version),
+		strconv.FormatBool(rl.ArchiveStatus),
+		strconv.FormatBool(rl.IsDeletedStatus),
+		rl.CreatedBy,
+		util.ExportTime(rl.CreatedAt),
+		util.ExportTime(rl.UpdatedAt),
+	}
+}
@@ -0,0 +1,103 @@
+package panelAdmins
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// decodeStored round-trips the document through bson and decodes it with the options of the mongo client
+func decodeStored(t *testing.T, doc bson.M, v any) {
+	t.Helper()
+
+	raw, err := bson.Marshal(doc)
+	require.NoError(t, err)
+
+	dec := bson.NewDecoder(bson.NewDocumentReader(bytes.NewReader(raw)))
+	dec.UseJSONStructTags()
+	dec.ObjectIDAsHexString()
+	dec.DefaultDocumentM()
+	require.NoError(t, dec.Decode(v))
+}
+
+// storedUser is the document createUser stores for the user, with its _id
+func storedUser(id bson.ObjectID, user NewUser) bson.M {
+	upId := "up-" + user.Email
+	doc := userDocument(user, &upId)
+	doc["_id"] = id
+	return doc
+}
+
+func TestUserExportRow(t *testing.T) {
+	adaId, bobId := bson.NewObjectID(), bson.NewObjectID()
+
+	dir := &userExportDirectory{
+		roles: map[string]string{"r1": "support"},
+		teams: map[string][]string{},
+		leads: map[string][]string{},
+	}
+	dir.add(Team{Name: "Night shift", TeamLead: bobId.Hex(), TeamMember: []string{adaId.Hex(), bobId.Hex()}})
+	dir.add(Team{Name: "Tier 1", TeamLead: adaId.Hex()})
+
+	var ada exportedUser
+	decodeStored(t, storedUser(adaId, NewUser{
+		Personal: Personal{FirstName: "Ada", LastName: "Lovelace", Email: "ada@flowcx.io", Phone: "+2348012345678", Gender: "female"},
+		RoleId:   "r1",
+	}), &ada)
+
+	row, err := dir.row(ada)
+	require.NoError(t, err)
+	require.Len(t, row, len(userExportColumns))
+
+	cells := map[string]string{}
+	for i, column := range userExportColumns {
+		cells[column] = row[i]
+	}
+
+	assert.Equal(t, adaId.Hex(), cells["id"])
+	assert.Equal(t, "Ada", cells["first_name"])
+	assert.Equal(t, "Lovelace", cells["last_name"])
+	assert.Equal(t, "ada@flowcx.io", cells["email"])
+	assert.Equal(t, "+2348012345678", cells["phone"], "the phone is stored as phone_num")
+	assert.Equal(t, "female", cells["gender"])
+	assert.Equal(t, "support", cells["role"])
+	assert.Equal(t, "Night shift; Tier 1", cells["teams"], "the teams led are teams of the user too")
+	assert.Equal(t, "Tier 1", cells["leads"])
+	assert.Equal(t, "false", cells["is_active"])
+	_, err = time.Parse(time.RFC3339, cells["created_at"])
+	assert.NoError(t, err)
+}
+
+func TestTeamExportRow(t *testing.T) {
+	adaId, bobId := bson.NewObjectID(), bson.NewObjectID()
+
+	users := map[string]exportedUser{}
+	for id, user := range map[bson.ObjectID]NewUser{
+		adaId: {Personal: Personal{FirstName: "Ada", LastName: "Lovelace", Email: "ada@flowcx.io"}},
+		bobId: {Personal: Personal{FirstName: "Bob", Email: "bob@flowcx.io"}},
+	} {
+		var u exportedUser
+		decodeStored(t, storedUser(id, user), &u)
+		users[u.ID] = u
+	}
+
+	row := teamExportRow(Team{ID: "t1", Name: "Tier 1", TeamLead: adaId.Hex(), TeamMember: []string{bobId.Hex(), "u9"}}, users)
+	require.Len(t, row, len(teamExportColumns))
+	assert.Equal(t, []string{"Ada Lovelace", "ada@flowcx.io", "bob@flowcx.io; u9", "2"}, row[3:7], "a member no longer a user keeps its id")
+
+	row = teamExportRow(Team{ID: "t2", Name: "Leaderless"}, users)
+	assert.Equal(t, []string{"", "", "", "0"}, row[3:7])
+}
+
+func TestRoleExportRow(t *testing.T) {
+	names := map[string]string{"r1": "support", "r2": "billing"}
+	rl := Role{ID: "r3", Name: "lead", Grants: Grants{"team:*", "user:read"}, Parents: []string{"r1", "r9"}, Version: 4, ArchiveStatus: true}
+
+	row := roleExportRow(rl, names, map[string]int{"r3": 7})
+	require.Len(t, row, len(roleExportColumns))
+	assert.Equal(t, []string{"r3", "lead", "", "team:*, user:read", "support; r9", "7", "4", "true", "false"}, row[:9])
+}
@@ -1,10 +1,17 @@
 package panelAdmins
 
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
 type ReadWrite struct {
 	Write bool
 	Read  bool
 }
 
+// Permission is the fixed matrix the roles were stored with before the grants, it is only read to migrate them
 type Permission struct {
 	Onboarding ReadWrite
 	Role       ReadWrite
@@ -13,7 +20,116 @@ type Permission struct {
 	Billing    ReadWrite
 }
 
-func (p *Permission) UpdatePermission(pm Permission) error  {
+// Resource names an area of the control panel, a resource of the permission catalog
+type Resource string
+
+// Action is what a route does to a Resource, an action of the permission catalog
+type Action string
+
+const (
+	OnboardingResource Resource = "onboarding"
+	RoleResource       Resource = "role"
+	TeamResource       Resource = "team"
+	UserResource       Resource = "user"
+	TenantResource     Resource = "tenant"
+	BillingResource    Resource = "billing"
+)
+
+const (
+	ReadAction    Action = "read"
+	CreateAction  Action = "create"
+	UpdateAction  Action = "update"
+	ArchiveAction Action = "archive"
+	DeleteAction  Action = "delete"
+	RestoreAction Action = "restore"
+	ExportAction  Action = "export"
+)
+
+// Wildcard grants every resource or every action
+const Wildcard = "*"
+
+// readActions and writeActions are what the read and write of the Permission matrix allowed
+var (
+	readActions  = []Action{ReadAction, ExportAction}
+	writeActions = []Action{CreateAction, UpdateAction, ArchiveAction, DeleteAction, RestoreAction}
+)
+
+func (p *Permission) UpdatePermission(pm Permission) error {
 	*p = pm
 	return nil
 }
+
+// Grants converts the matrix to grants of the DefaultCatalog, a read and write pair grants every action of the
+// resource. The panel users were guarded by the team permission, it grants the same on the users.
+func (p Permission) Grants() Grants {
+	grants := Grants{}
+
+	add := func(resource Resource, rw ReadWrite) {
+		if rw.Read && rw.Write {
+			grants = append(grants, NewGrant(resource, Wildcard))
+			return
+		}
+
+		actions, _ := catalogActions(DefaultCatalog, resource)
+		for _, action := range actions {
+			if (rw.Read && slices.Contains(readActions, action)) || (rw.Write && slices.Contains(writeActions, action)) {
+				grants = append(grants, NewGrant(resource, action))
+			}
+		}
+	}
+
+	add(OnboardingResource, p.Onboarding)
+	add(RoleResource, p.Role)
+	add(TeamResource, p.Team)
+	add(UserResource, p.Team)
+	add(TenantResource, p.Tenant)
+	add(BillingResource, p.Billing)
+
+	return grants
+}
+
+// Grant allows an action on a resource, written "<resource>:<action>". Either side can be the wildcard, "team:*"
+// grants every action on the teams and "*:read" reads every resource.
+type Grant string
+
+func NewGrant(resource Resource, action Action) Grant {
+	return Grant(string(resource) + ":" + string(action))
+}
+
+// Split returns the resource and the action of the grant, a lone "*" grants everything
+func (g Grant) Split() (Resource, Action, error) {
+	value := strings.ToLower(strings.TrimSpace(string(g)))
+	if value == Wildcard {
+		return Wildcard, Wildcard, nil
+	}
+
+	resource, action, found := strings.Cut(value, ":")
+	if !found || resource == "" || action == "" {
+		return "", "", fmt.Errorf("invalid grant %q, <resource>:<action> is expected", string(g))
+	}
+
+	return Resource(resource), Action(action), nil
+}
+
+// Allows reports whether the grant covers the action on the resource
+func (g Grant) Allows(resource Resource, action Action) bool {
+	r, a, err := g.Split()
+	if err != nil {
+		return false
+	}
+
+	return (r == Wildcard || r == resource) && (a == Wildcard || a == action)
+}
+
+// Grants are the grants of a role
+type Grants []Grant
+
+// Allows reports whether any of the grants covers the action on the resource
+func (g Grants) Allows(resource Resource, action Action) bool {
+	for _, grant := range g {
+		if grant.Allows(resource, action) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,6 +1,7 @@
 package panelAdmins
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -45,3 +46,58 @@ func TestUpdatePermission(t *testing.T) {
 		}
 	}
 }
+
+func TestGrants_Allows(t *testing.T) {
+	grants := Grants{"onboarding:read", "role:*", "*:export", "Billing:Update"}
+
+	table := []struct {
+		resource Resource
+		action   Action
+		expected bool
+	}{
+		{OnboardingResource, ReadAction, true},
+		{OnboardingResource, UpdateAction, false},
+		{RoleResource, ReadAction, true},
+		{RoleResource, DeleteAction, true},
+		{TeamResource, ReadAction, false},
+		{TeamResource, ExportAction, true},
+		{TenantResource, ArchiveAction, false},
+		{BillingResource, UpdateAction, true},
+		{BillingResource, ReadAction, false},
+		{Resource("unknown"), ReadAction, false},
+	}
+
+	for _, tt := range table {
+		if got := grants.Allows(tt.resource, tt.action); got != tt.expected {
+			t.Errorf("Allows(%s, %s) = %v, expected %v", tt.resource, tt.action, got, tt.expected)
+		}
+	}
+
+	if !(Grants{"*"}).Allows(TenantResource, DeleteAction) {
+		t.Error("the lone wildcard is expected to grant everything")
+	}
+	if (Grants{"team"}).Allows(TeamResource, ReadAction) {
+		t.Error("a grant without an action is expected to grant nothing")
+	}
+}
+
+func TestPermission_Grants(t *testing.T) {
+	p := Permission{
+		Onboarding: ReadWrite{Write: false, Read: true},
+		Role:       ReadWrite{Write: true, Read: true},
+		Team:       ReadWrite{Write: true, Read: false},
+		Billing:    ReadWrite{Write: true, Read: false},
+	}
+
+	expected := Grants{
+		"onboarding:read", "onboarding:export",
+		"role:*",
+		"team:create", "team:update", "team:archive", "team:delete", "team:restore",
+		"user:create", "user:update", "user:archive",
+		"billing:create", "billing:update",
+	}
+
+	if got := p.Grants(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Grants() = %v, expected %v", got, expected)
+	}
+}
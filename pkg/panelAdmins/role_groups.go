@@ -0,0 +1,319 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/config"
+	"control-panel-bk/internal/aws"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"slices"
+	"strings"
+)
+
+// RoleGroupPrefix starts the name of the user pool group of a role, the groups without it are not the panel's
+const RoleGroupPrefix = "role_"
+
+// RoleGroupName is the name of the user pool group of the role. A group cannot be renamed, it is named after the id
+// of the role and described by the name of the role.
+func RoleGroupName(roleId string) string {
+	return RoleGroupPrefix + roleId
+}
+
+// RoleOfGroups returns the role id of the first role group of the cognito:groups claim
+func RoleOfGroups(groups []string) (string, bool) {
+	for _, group := range groups {
+		if roleId, found := strings.CutPrefix(group, RoleGroupPrefix); found && roleId != "" {
+			return roleId, true
+		}
+	}
+	return "", false
+}
+
+// userPoolGroups are the calls the roles make to the groups of the user pool
+type userPoolGroups interface {
+	CreateGroup(group aws.Group) error
+	UpdateGroup(group aws.Group) error
+	DeleteGroup(name string) error
+	AddUser(group string, username string) error
+	RemoveUser(group string, username string) error
+	Groups() ([]aws.Group, error)
+	GroupsOf(username string) ([]string, error)
+	Members(group string) ([]aws.PoolUser, error)
+}
+
+type cognitoGroups struct{}
+
+func (cognitoGroups) CreateGroup(group aws.Group) error {
+	_, err := aws.CreateUserPoolGroup(config.AwsConfig, group)
+	return err
+}
+
+func (cognitoGroups) UpdateGroup(group aws.Group) error {
+	return aws.UpdateUserPoolGroup(config.AwsConfig, group)
+}
+
+func (cognitoGroups) DeleteGroup(name string) error {
+	return aws.DeleteUserPoolGroup(config.AwsConfig, name)
+}
+
+func (cognitoGroups) AddUser(group string, username string) error {
+	_, err := aws.AddUsersToUserPoolGroup(config.AwsConfig, group, username)
+	return err
+}
+
+func (cognitoGroups) RemoveUser(group string, username string) error {
+	return aws.RemoveUserFromUserPoolGroup(config.AwsConfig, group, username)
+}
+
+func (cognitoGroups) Groups() ([]aws.Group, error) {
+	return aws.ListUserPoolGroups(config.AwsConfig)
+}
+
+func (cognitoGroups) GroupsOf(username string) ([]string, error) {
+	return aws.ListUserPoolGroupsForUser(config.AwsConfig, username)
+}
+
+func (cognitoGroups) Members(group string) ([]aws.PoolUser, error) {
+	return aws.ListUsersInUserPoolGroup(config.AwsConfig, group)
+}
+
+// roleGroups is the user pool the roles are mirrored in, a seam for the tests
+var roleGroups userPoolGroups = cognitoGroups{}
+
+// mirrorRoleGroup gives an active role a group described by its name and removes the group of an archived or binned
+// role, its users were moved off it beforehand
+func mirrorRoleGroup(role *Role) error {
+	group := aws.Group{Name: RoleGroupName(role.ID), Description: role.Name}
+
+	if role.ArchiveStatus || role.IsDeletedStatus {
+		return dropRoleGroup(role.ID)
+	}
+
+	err := roleGroups.UpdateGroup(group)
+	if aws.IsNotFound(err) {
+		return roleGroups.CreateGroup(group)
+	}
+	return err
+}
+
+// dropRoleGroup removes the group of the role, a group already gone is not an error
+func dropRoleGroup(roleId string) error {
+	if err := roleGroups.DeleteGroup(RoleGroupName(roleId)); err != nil && !aws.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// syncRoleGroup mirrors the role after it was written to mongo. The write is not undone when the user pool refuses
+// the group, the failure is logged and cognito-sync repairs the drift.
+func syncRoleGroup(role *Role) {
+	if err := mirrorRoleGroup(role); err != nil {
+		log.Printf("roles: unable to mirror the role %s in the user pool groups, run cognito-sync: %s", role.ID, err.Error())
+	}
+}
+
+// moveToRoleGroup makes the group of the role the only role group of the user, the other groups of the pool are left
+func moveToRoleGroup(username string, roleId string) error {
+	target := RoleGroupName(roleId)
+
+	groups, err := roleGroups.GroupsOf(username)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(groups, target) {
+		err := roleGroups.AddUser(target, username)
+		if aws.IsNotFound(err) {
+			// The group of the role is missing from the pool, it is created and described on the next cognito-sync
+			if err := roleGroups.CreateGroup(aws.Group{Name: target}); err != nil {
+				return err
+			}
+			err = roleGroups.AddUser(target, username)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, group := range groups {
+		if group != target && strings.HasPrefix(group, RoleGroupPrefix) {
+			if err := roleGroups.RemoveUser(group, username); err != nil && !aws.IsNotFound(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// GroupMember is a user of a role group
+type GroupMember struct {
+	Group    string `json:"group"`
+	Username string `json:"username"`
+}
+
+// GroupDrift is the difference between the roles in mongo and the groups of the user pool
+type GroupDrift struct {
+	// MissingGroups are the active roles without a group
+	MissingGroups []aws.Group `json:"missing_groups"`
+	// RenamedGroups are the groups not described by the name of their role, with the name of the role
+	RenamedGroups []aws.Group `json:"renamed_groups"`
+	// StaleGroups are the role groups of roles removed, archived or binned
+	StaleGroups []string `json:"stale_groups"`
+	// MissingMembers are the users not in the group of the role they hold
+	MissingMembers []GroupMember `json:"missing_members"`
+	// ExtraMembers are the members of a role group holding another role or unknown to mongo
+	ExtraMembers []GroupMember `json:"extra_members"`
+
+	Repaired bool     `json:"repaired"`
+	Failed   []string `json:"failed,omitempty"`
+}
+
+// Drifted tells whether mongo and the user pool disagree
+func (d *GroupDrift) Drifted() bool {
+	return len(d.MissingGroups)+len(d.RenamedGroups)+len(d.StaleGroups)+len(d.MissingMembers)+len(d.ExtraMembers) > 0
+}
+
+// ReconcileRoleGroups compares the roles and the role_id of the users with the role groups of the user pool, and
+// repairs the drift when asked to. A repair carries on past a failure, the failures are listed in the drift.
+func ReconcileRoleGroups(ctx context.Context, db *mongo.Database, repair bool) (*GroupDrift, error) {
+	var roles []Role
+	cursor, err := db.Collection("roles").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "archive_status": 1, "is_deleted_status": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+
+	var users []roleHolder
+	cursor, err = db.Collection("users").Find(ctx, bson.M{"role_id": bson.M{"$nin": bson.A{nil, ""}}}, options.Find().SetProjection(bson.M{"email": 1, "role_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	drift, err := diffRoleGroups(roles, users)
+	if err != nil {
+		return nil, err
+	}
+
+	if repair {
+		repairRoleGroups(drift)
+	}
+
+	return drift, nil
+}
+
+// diffRoleGroups lists the drift of the role groups of the pool from the roles and their users
+func diffRoleGroups(roles []Role, users []roleHolder) (*GroupDrift, error) {
+	drift := &GroupDrift{
+		MissingGroups:  []aws.Group{},
+		RenamedGroups:  []aws.Group{},
+		StaleGroups:    []string{},
+		MissingMembers: []GroupMember{},
+		ExtraMembers:   []GroupMember{},
+	}
+
+	groups, err := roleGroups.Groups()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the groups of the user pool: %w", err)
+	}
+
+	pool := map[string]aws.Group{}
+	for _, group := range groups {
+		if strings.HasPrefix(group.Name, RoleGroupPrefix) {
+			pool[group.Name] = group
+		}
+	}
+
+	holders := map[string][]string{}
+	for _, user := range users {
+		holders[user.RoleId] = append(holders[user.RoleId], strings.ToLower(user.Email))
+	}
+
+	active := map[string]bool{}
+	for _, role := range roles {
+		if role.ArchiveStatus || role.IsDeletedStatus {
+			continue
+		}
+
+		name := RoleGroupName(role.ID)
+		active[name] = true
+
+		var members []aws.PoolUser
+		if group, found := pool[name]; !found {
+			drift.MissingGroups = append(drift.MissingGroups, aws.Group{Name: name, Description: role.Name})
+		} else {
+			if group.Description != role.Name {
+				drift.RenamedGroups = append(drift.RenamedGroups, aws.Group{Name: name, Description: role.Name})
+			}
+
+			if members, err = roleGroups.Members(name); err != nil {
+				return nil, fmt.Errorf("unable to list the members of the group %s: %w", name, err)
+			}
+		}
+
+		isMember := func(email string) bool {
+			return slices.ContainsFunc(members, func(m aws.PoolUser) bool {
+				return strings.EqualFold(m.Email, email) || strings.EqualFold(m.Username, email)
+			})
+		}
+		for _, email := range holders[role.ID] {
+			if !isMember(email) {
+				drift.MissingMembers = append(drift.MissingMembers, GroupMember{Group: name, Username: email})
+			}
+		}
+		for _, member := range members {
+			email := member.Email
+			if email == "" {
+				email = member.Username
+			}
+			if !slices.Contains(holders[role.ID], strings.ToLower(email)) {
+				drift.ExtraMembers = append(drift.ExtraMembers, GroupMember{Group: name, Username: member.Username})
+			}
+		}
+	}
+
+	for name := range pool {
+		if !active[name] {
+			drift.StaleGroups = append(drift.StaleGroups, name)
+		}
+	}
+	slices.Sort(drift.StaleGroups)
+
+	return drift, nil
+}
+
+// repairRoleGroups creates and describes the groups before their members are added, and removes the members that
+// should leave before the stale groups are deleted
+func repairRoleGroups(drift *GroupDrift) {
+	fail := func(what string, err error) {
+		if err != nil {
+			drift.Failed = append(drift.Failed, fmt.Sprintf("%s: %s", what, err.Error()))
+		}
+	}
+
+	for _, group := range drift.MissingGroups {
+		fail("create the group "+group.Name, roleGroups.CreateGroup(group))
+	}
+	for _, group := range drift.RenamedGroups {
+		fail("describe the group "+group.Name, roleGroups.UpdateGroup(group))
+	}
+	for _, member := range drift.MissingMembers {
+		fail(fmt.Sprintf("add %s to the group %s", member.Username, member.Group), roleGroups.AddUser(member.Group, member.Username))
+	}
+	for _, member := range drift.ExtraMembers {
+		fail(fmt.Sprintf("remove %s from the group %s", member.Username, member.Group), roleGroups.RemoveUser(member.Group, member.Username))
+	}
+	for _, name := range drift.StaleGroups {
+		fail("delete the group "+name, roleGroups.DeleteGroup(name))
+	}
+
+	drift.Repaired = len(drift.Failed) == 0
+}
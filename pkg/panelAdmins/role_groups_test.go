@@ -0,0 +1,190 @@
+package panelAdmins
+
+import (
+	"control-panel-bk/internal/aws"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/cognitoidentityprovider/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGroups is a user pool kept in memory, its usernames are the emails
+type fakeGroups struct {
+	descriptions map[string]string
+	members      map[string][]string
+}
+
+func newFakeGroups() *fakeGroups {
+	return &fakeGroups{descriptions: map[string]string{}, members: map[string][]string{}}
+}
+
+func (f *fakeGroups) notFound(name string) error {
+	if _, found := f.descriptions[name]; !found {
+		return &types.ResourceNotFoundException{Message: &name}
+	}
+	return nil
+}
+
+func (f *fakeGroups) CreateGroup(group aws.Group) error {
+	f.descriptions[group.Name] = group.Description
+	return nil
+}
+
+func (f *fakeGroups) UpdateGroup(group aws.Group) error {
+	if err := f.notFound(group.Name); err != nil {
+		return err
+	}
+	f.descriptions[group.Name] = group.Description
+	return nil
+}
+
+func (f *fakeGroups) DeleteGroup(name string) error {
+	if err := f.notFound(name); err != nil {
+		return err
+	}
+	delete(f.descriptions, name)
+	delete(f.members, name)
+	return nil
+}
+
+func (f *fakeGroups) AddUser(group string, username string) error {
+	if err := f.notFound(group); err != nil {
+		return err
+	}
+	if !slices.Contains(f.members[group], username) {
+		f.members[group] = append(f.members[group], username)
+	}
+	return nil
+}
+
+func (f *fakeGroups) RemoveUser(group string, username string) error {
+	if err := f.notFound(group); err != nil {
+		return err
+	}
+	f.members[group] = slices.DeleteFunc(f.members[group], func(m string) bool { return m == username })
+	return nil
+}
+
+func (f *fakeGroups) Groups() ([]aws.Group, error) {
+	groups := []aws.Group{}
+	for name, description := range f.descriptions {
+		groups = append(groups, aws.Group{Name: name, Description: description})
+	}
+	return groups, nil
+}
+
+func (f *fakeGroups) GroupsOf(username string) ([]string, error) {
+	groups := []string{}
+	for name, members := range f.members {
+		if slices.Contains(members, username) {
+			groups = append(groups, name)
+		}
+	}
+	return groups, nil
+}
+
+func (f *fakeGroups) Members(group string) ([]aws.PoolUser, error) {
+	users := []aws.PoolUser{}
+	for _, m := range f.members[group] {
+		users = append(users, aws.PoolUser{Username: m, Email: m})
+	}
+	return users, nil
+}
+
+func useFakeGroups(t *testing.T) *fakeGroups {
+	fake, previous := newFakeGroups(), roleGroups
+	roleGroups = fake
+	t.Cleanup(func() { roleGroups = previous })
+	return fake
+}
+
+func TestRoleOfGroups(t *testing.T) {
+	roleId, ok := RoleOfGroups([]string{"us-east-1_admins", RoleGroupName("67db3402d08dedc2e44081bb")})
+	assert.True(t, ok)
+	assert.Equal(t, "67db3402d08dedc2e44081bb", roleId)
+
+	_, ok = RoleOfGroups([]string{"us-east-1_admins", RoleGroupPrefix})
+	assert.False(t, ok, "only the role groups name a role")
+}
+
+func TestMirrorRoleGroup(t *testing.T) {
+	pool := useFakeGroups(t)
+	role := &Role{ID: "r1", Name: "support"}
+
+	require.NoError(t, mirrorRoleGroup(role))
+	assert.Equal(t, "support", pool.descriptions["role_r1"], "an active role gets a group")
+
+	role.Name = "customer support"
+	require.NoError(t, mirrorRoleGroup(role))
+	assert.Equal(t, "customer support", pool.descriptions["role_r1"], "a rename describes the group again")
+
+	role.ArchiveStatus = true
+	require.NoError(t, mirrorRoleGroup(role))
+	assert.NotContains(t, pool.descriptions, "role_r1", "an archived role has no group")
+
+	require.NoError(t, dropRoleGroup("r1"), "a group already gone is not an error")
+}
+
+func TestMoveToRoleGroup(t *testing.T) {
+	pool := useFakeGroups(t)
+	pool.descriptions = map[string]string{"role_r1": "support", "role_r2": "billing", "admins": ""}
+	pool.members = map[string][]string{"role_r1": {"ada@flowcx.io"}, "admins": {"ada@flowcx.io"}}
+
+	require.NoError(t, moveToRoleGroup("ada@flowcx.io", "r2"))
+	assert.Equal(t, []string{"ada@flowcx.io"}, pool.members["role_r2"])
+	assert.Empty(t, pool.members["role_r1"], "the user leaves the group of the previous role")
+	assert.Equal(t, []string{"ada@flowcx.io"}, pool.members["admins"], "the groups that are not roles are left")
+
+	require.NoError(t, moveToRoleGroup("ada@flowcx.io", "r3"))
+	assert.Contains(t, pool.descriptions, "role_r3", "a missing group is created")
+	assert.Equal(t, []string{"ada@flowcx.io"}, pool.members["role_r3"])
+}
+
+func TestDropCreatedRoleGroups(t *testing.T) {
+	pool := useFakeGroups(t)
+	pool.descriptions = map[string]string{"role_r1": "", "role_r2": "", "role_r3": ""}
+
+	dropCreatedRoleGroups([]string{"r1", "r2", "gone"})
+	assert.Equal(t, map[string]string{"role_r3": ""}, pool.descriptions, "every group of a role not kept is removed")
+}
+
+func TestReconcileRoleGroups(t *testing.T) {
+	pool := useFakeGroups(t)
+	pool.descriptions = map[string]string{"role_r1": "support", "role_r2": "old name", "role_gone": "", "admins": ""}
+	pool.members = map[string][]string{"role_r1": {"ada@flowcx.io", "eve@flowcx.io"}, "role_gone": {"bob@flowcx.io"}}
+
+	roles := []Role{
+		{ID: "r1", Name: "support"},
+		{ID: "r2", Name: "billing"},
+		{ID: "r3", Name: "auditor"},
+		{ID: "r4", Name: "retired", ArchiveStatus: true},
+	}
+	users := []roleHolder{
+		{Email: "Ada@flowcx.io", RoleId: "r1"},
+		{Email: "bob@flowcx.io", RoleId: "r2"},
+		{Email: "cy@flowcx.io", RoleId: "r3"},
+	}
+
+	drift, err := diffRoleGroups(roles, users)
+	require.NoError(t, err)
+	assert.True(t, drift.Drifted())
+
+	assert.Equal(t, []aws.Group{{Name: "role_r3", Description: "auditor"}}, drift.MissingGroups)
+	assert.Equal(t, []aws.Group{{Name: "role_r2", Description: "billing"}}, drift.RenamedGroups)
+	assert.Equal(t, []string{"role_gone"}, drift.StaleGroups, "the groups that are not roles are left")
+	assert.ElementsMatch(t, []GroupMember{{Group: "role_r2", Username: "bob@flowcx.io"}, {Group: "role_r3", Username: "cy@flowcx.io"}}, drift.MissingMembers)
+	assert.Equal(t, []GroupMember{{Group: "role_r1", Username: "eve@flowcx.io"}}, drift.ExtraMembers)
+	assert.False(t, drift.Repaired, "a report changes nothing")
+	assert.Contains(t, pool.descriptions, "role_gone")
+
+	repairRoleGroups(drift)
+	assert.True(t, drift.Repaired)
+	assert.Empty(t, drift.Failed)
+
+	after, err := diffRoleGroups(roles, users)
+	require.NoError(t, err)
+	assert.False(t, after.Drifted(), "the repaired pool matches mongo")
+	assert.Contains(t, pool.descriptions, "admins")
+}
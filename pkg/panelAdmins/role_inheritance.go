@@ -0,0 +1,294 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/util"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"slices"
+)
+
+// MaxRoleDepth is the most levels of parents a role can inherit through
+const MaxRoleDepth = 8
+
+// ParentError is a parent a role cannot inherit from, an unknown or inactive role or one that closes a cycle
+type ParentError struct {
+	Parent string
+	Reason string
+}
+
+func (e *ParentError) Error() string {
+	return fmt.Sprintf("invalid parent role %q: %s", e.Parent, e.Reason)
+}
+
+// GrantSource is a role an effective grant comes from, Path is the names of the roles from the resolved one to it
+type GrantSource struct {
+	RoleId   string   `json:"role_id"`
+	RoleName string   `json:"role_name"`
+	Path     []string `json:"path"`
+}
+
+// EffectiveGrant is a grant of a role, its own or inherited, and the roles it comes from
+type EffectiveGrant struct {
+	Grant   Grant         `json:"grant"`
+	Sources []GrantSource `json:"sources"`
+}
+
+// SkippedParent is a parent whose grants are not inherited, the role was removed, archived or binned since
+type SkippedParent struct {
+	RoleId string `json:"role_id"`
+	Reason string `json:"reason"`
+}
+
+// EffectiveGrants are the grants of a role along with the ones of its parents, resolved when they are read so a change
+// to a parent reaches its children without writing them
+type EffectiveGrants struct {
+	RoleId  string           `json:"role_id"`
+	Grants  Grants           `json:"grants"`
+	Explain []EffectiveGrant `json:"explain"`
+	Skipped []SkippedParent  `json:"skipped,omitempty"`
+}
+
+func (e *EffectiveGrants) Allows(resource Resource, action Action) bool {
+	return e.Grants.Allows(resource, action)
+}
+
+func (e *EffectiveGrants) add(role *Role, path []string) {
+	for _, grant := range role.Grants {
+		source := GrantSource{RoleId: role.ID, RoleName: role.Name, Path: path}
+
+		i := slices.IndexFunc(e.Explain, func(g EffectiveGrant) bool { return g.Grant == grant })
+		if i < 0 {
+			e.Grants = append(e.Grants, grant)
+			e.Explain = append(e.Explain, EffectiveGrant{Grant: grant, Sources: []GrantSource{source}})
+			continue
+		}
+		e.Explain[i].Sources = append(e.Explain[i].Sources, source)
+	}
+}
+
+// roleLoader loads the roles of the ids, the ones not found are left out
+type roleLoader func(ids []string) (map[string]*Role, error)
+
+func rolesOf(ctx context.Context, db *mongo.Database) roleLoader {
+	return func(ids []string) (map[string]*Role, error) {
+		return fetchRolesByIds(ctx, db, ids)
+	}
+}
+
+func fetchRolesByIds(ctx context.Context, db *mongo.Database, ids []string) (map[string]*Role, error) {
+	objIds := make(bson.A, 0, len(ids))
+	for _, id := range ids {
+		if objId, err := util.GetPrimitiveID(id); err == nil {
+			objIds = append(objIds, *objId)
+		}
+	}
+
+	roles := map[string]*Role{}
+	if len(objIds) == 0 {
+		return roles, nil
+	}
+
+	cursor, err := db.Collection("roles").Find(ctx, bson.M{"_id": bson.M{"$in": objIds}})
+	if err != nil {
+		return nil, err
+	}
+
+	var found []Role
+	if err := cursor.All(ctx, &found); err != nil {
+		return nil, err
+	}
+
+	for i := range found {
+		roles[found[i].ID] = &found[i]
+	}
+	return roles, nil
+}
+
+// ResolveGrants walks the parents of the role a level at a time and gathers their grants. A role reached twice is
+// only read once, so a parent shared by two branches is attributed to the shortest path to it.
+func ResolveGrants(ctx context.Context, db *mongo.Database, role *Role) (*EffectiveGrants, error) {
+	return resolveGrants(role, rolesOf(ctx, db))
+}
+
+func resolveGrants(role *Role, load roleLoader) (*EffectiveGrants, error) {
+	effective := &EffectiveGrants{RoleId: role.ID, Grants: Grants{}, Explain: []EffectiveGrant{}}
+	effective.add(role, []string{role.Name})
+
+	type pending struct {
+		id   string
+		path []string
+	}
+
+	visited := map[string]bool{role.ID: true}
+	var level []pending
+	for _, parent := range role.Parents {
+		level = append(level, pending{id: parent, path: []string{role.Name}})
+	}
+
+	for depth := 0; len(level) > 0 && depth < MaxRoleDepth; depth++ {
+		ids := make([]string, 0, len(level))
+		for _, p := range level {
+			ids = append(ids, p.id)
+		}
+
+		roles, err := load(ids)
+		if err != nil {
+			return nil, err
+		}
+
+		var next []pending
+		for _, p := range level {
+			if visited[p.id] {
+				continue
+			}
+			visited[p.id] = true
+
+			parent, found := roles[p.id]
+			switch {
+			case !found:
+				effective.Skipped = append(effective.Skipped, SkippedParent{RoleId: p.id, Reason: "the role was not found"})
+				continue
+			case parent.ArchiveStatus || parent.IsDeletedStatus:
+				effective.Skipped = append(effective.Skipped, SkippedParent{RoleId: p.id, Reason: "the role is archived or in the bin"})
+				continue
+			}
+
+			path := append(slices.Clone(p.path), parent.Name)
+			effective.add(parent, path)
+
+			for _, grandParent := range parent.Parents {
+				next = append(next, pending{id: grandParent, path: path})
+			}
+		}
+
+		level = next
+	}
+
+	return effective, nil
+}
+
+// checkParents validates the parents of the role, they have to be active roles and none of them can inherit from the
+// role itself. The roleId is empty for a new role, which no role inherits from yet. The parents are returned without
+// duplicates.
+func checkParents(ctx context.Context, db *mongo.Database, roleId string, parents []string) ([]string, error, int) {
+	return checkParentsOf(roleId, parents, rolesOf(ctx, db))
+}
+
+func checkParentsOf(roleId string, parents []string, load roleLoader) ([]string, error, int) {
+	unique := []string{}
+	for _, parent := range parents {
+		if parent == roleId {
+			return nil, &ParentError{Parent: parent, Reason: "a role cannot inherit from itself"}, http.StatusBadRequest
+		}
+		if _, err := util.GetPrimitiveID(parent); err != nil {
+			return nil, &ParentError{Parent: parent, Reason: "not a role id"}, http.StatusBadRequest
+		}
+		if !slices.Contains(unique, parent) {
+			unique = append(unique, parent)
+		}
+	}
+
+	roles, err := load(unique)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	for _, parent := range unique {
+		role, found := roles[parent]
+		if !found {
+			return nil, &ParentError{Parent: parent, Reason: "the role was not found"}, http.StatusBadRequest
+		}
+		if role.ArchiveStatus || role.IsDeletedStatus {
+			return nil, &ParentError{Parent: parent, Reason: "the role is archived or in the bin"}, http.StatusBadRequest
+		}
+	}
+
+	// The ancestors of the parents are walked up, reaching the role means it would inherit from itself
+	level, from := unique, map[string]string{}
+	for _, parent := range unique {
+		from[parent] = parent
+	}
+
+	for depth := 1; len(level) > 0; depth++ {
+		if depth > MaxRoleDepth {
+			return nil, &ParentError{Parent: level[0], Reason: fmt.Sprintf("a role cannot inherit through more than %d levels", MaxRoleDepth)}, http.StatusBadRequest
+		}
+
+		var next []string
+		for _, id := range level {
+			role, found := roles[id]
+			if !found {
+				continue
+			}
+
+			for _, ancestor := range role.Parents {
+				if ancestor == roleId {
+					return nil, &ParentError{Parent: from[id], Reason: "the role already inherits from this one, the parents would form a cycle"}, http.StatusBadRequest
+				}
+				if _, seen := from[ancestor]; !seen {
+					from[ancestor] = from[id]
+					next = append(next, ancestor)
+				}
+			}
+		}
+
+		if len(next) > 0 {
+			ancestors, err := load(next)
+			if err != nil {
+				return nil, err, http.StatusInternalServerError
+			}
+			for id, role := range ancestors {
+				roles[id] = role
+			}
+		}
+
+		level = next
+	}
+
+	return unique, nil, http.StatusOK
+}
+
+// HandleFetchEffectiveGrants lists the grants of the role, its own and inherited, with the roles each one comes from
+func HandleFetchEffectiveGrants(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, err, code := FetchRoleById(chi.URLParam(r, "id"), r.Context(), db)
+		if err != nil {
+			if code == http.StatusOK {
+				code = http.StatusNotFound
+			}
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		effective, err := ResolveGrants(r.Context(), db, role)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		respBytes, respErr := util.GetBytesResponse(http.StatusOK, effective)
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// roleContentError is the status of an error of the grants or parents of a role
+func roleContentError(err error) int {
+	var grantErr *GrantError
+	var parentErr *ParentError
+	if errors.As(err, &grantErr) || errors.As(err, &parentErr) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,131 @@
+package panelAdmins
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// roleTree builds roles of the names, the ids are generated, and a loader reading them
+func roleTree(names ...string) (map[string]*Role, roleLoader) {
+	byName := map[string]*Role{}
+	for _, name := range names {
+		byName[name] = &Role{ID: bson.NewObjectID().Hex(), Name: name}
+	}
+
+	load := func(ids []string) (map[string]*Role, error) {
+		found := map[string]*Role{}
+		for _, role := range byName {
+			for _, id := range ids {
+				if role.ID == id {
+					found[id] = role
+				}
+			}
+		}
+		return found, nil
+	}
+
+	return byName, load
+}
+
+func TestResolveGrants(t *testing.T) {
+	roles, load := roleTree("senior support", "support", "reader", "auditor", "retired")
+
+	roles["reader"].Grants = Grants{"team:read", "user:read"}
+	roles["auditor"].Grants = Grants{"*:read"}
+	roles["support"].Grants = Grants{"tenant:update"}
+	roles["support"].Parents = []string{roles["reader"].ID}
+	roles["retired"].Grants = Grants{"*"}
+	roles["retired"].ArchiveStatus = true
+	roles["senior support"].Grants = Grants{"team:read"}
+	roles["senior support"].Parents = []string{roles["support"].ID, roles["auditor"].ID, roles["retired"].ID, bson.NewObjectID().Hex()}
+
+	effective, err := resolveGrants(roles["senior support"], load)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, Grants{"team:read", "tenant:update", "*:read", "user:read"}, effective.Grants)
+	assert.True(t, effective.Allows(BillingResource, ReadAction), "the wildcard grant of a parent applies")
+	assert.True(t, effective.Allows(UserResource, ReadAction), "the grant of a grandparent applies")
+	assert.False(t, effective.Allows(TenantResource, DeleteAction), "the grants of an archived parent do not apply")
+
+	var teamRead EffectiveGrant
+	for _, g := range effective.Explain {
+		if g.Grant == "team:read" {
+			teamRead = g
+		}
+	}
+	require.Len(t, teamRead.Sources, 2, "a grant held along two paths lists both")
+	assert.Equal(t, []string{"senior support"}, teamRead.Sources[0].Path)
+	assert.Equal(t, roles["reader"].ID, teamRead.Sources[1].RoleId)
+	assert.Equal(t, []string{"senior support", "support", "reader"}, teamRead.Sources[1].Path)
+
+	require.Len(t, effective.Skipped, 2)
+	assert.Equal(t, roles["retired"].ID, effective.Skipped[0].RoleId)
+	assert.Contains(t, effective.Skipped[1].Reason, "not found")
+}
+
+func TestResolveGrants_Cycle(t *testing.T) {
+	roles, load := roleTree("a", "b")
+	roles["a"].Grants, roles["a"].Parents = Grants{"team:read"}, []string{roles["b"].ID}
+	roles["b"].Grants, roles["b"].Parents = Grants{"role:read"}, []string{roles["a"].ID}
+
+	effective, err := resolveGrants(roles["a"], load)
+	require.NoError(t, err, "a cycle stored before it could be refused does not loop")
+	assert.ElementsMatch(t, Grants{"team:read", "role:read"}, effective.Grants)
+}
+
+func TestCheckParents(t *testing.T) {
+	roles, load := roleTree("a", "b", "c", "archived")
+	roles["b"].Parents = []string{roles["a"].ID}
+	roles["c"].Parents = []string{roles["b"].ID}
+	roles["archived"].IsDeletedStatus = true
+
+	parents, err, code := checkParentsOf(roles["a"].ID, nil, load)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, parents)
+
+	parents, err, _ = checkParentsOf("", []string{roles["c"].ID, roles["b"].ID, roles["c"].ID}, load)
+	require.NoError(t, err)
+	assert.Equal(t, []string{roles["c"].ID, roles["b"].ID}, parents, "a new role can inherit from any active role")
+
+	invalid := map[string]struct {
+		roleId  string
+		parents []string
+		reason  string
+	}{
+		"self":      {roles["a"].ID, []string{roles["a"].ID}, "cannot inherit from itself"},
+		"not an id": {roles["a"].ID, []string{"support"}, "not a role id"},
+		"unknown":   {roles["a"].ID, []string{bson.NewObjectID().Hex()}, "not found"},
+		"inactive":  {roles["a"].ID, []string{roles["archived"].ID}, "archived or in the bin"},
+		"cycle":     {roles["a"].ID, []string{roles["c"].ID}, "would form a cycle"},
+	}
+
+	for name, tc := range invalid {
+		_, err, code := checkParentsOf(tc.roleId, tc.parents, load)
+
+		var parentErr *ParentError
+		require.True(t, errors.As(err, &parentErr), name)
+		assert.Equal(t, http.StatusBadRequest, code, name)
+		assert.Contains(t, err.Error(), tc.reason, name)
+	}
+}
+
+func TestCheckParents_Depth(t *testing.T) {
+	names := []string{"root"}
+	for i := 0; i < MaxRoleDepth+1; i++ {
+		names = append(names, string(rune('a'+i)))
+	}
+	roles, load := roleTree(names...)
+	for i := 1; i < len(names); i++ {
+		roles[names[i]].Parents = []string{roles[names[i-1]].ID}
+	}
+
+	_, err, code := checkParentsOf("", []string{roles[names[len(names)-1]].ID}, load)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.ErrorContains(t, err, "more than")
+}
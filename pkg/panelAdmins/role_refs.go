@@ -0,0 +1,325 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/config"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RoleDependent is a user that holds a role
+type RoleDependent struct {
+	ID       string `json:"_id"`
+	Email    string `json:"email"`
+	FullName string `json:"full_name,omitempty"`
+}
+
+// RoleChild is a role that inherits from another
+type RoleChild struct {
+	ID   string `json:"_id"`
+	Name string `json:"name"`
+}
+
+// RoleInUseError blocks the archive, bin or delete of a role some users still hold or some roles inherit from. The
+// children would lose the grants they inherit and could no longer be updated with a parent that is gone.
+type RoleInUseError struct {
+	RoleId     string
+	Dependents []RoleDependent
+	Children   []RoleChild
+}
+
+func (e *RoleInUseError) Error() string {
+	if len(e.Children) > 0 {
+		return fmt.Sprintf("the role %s is inherited by %d role(s), remove it from their parents first", e.RoleId, len(e.Children))
+	}
+	return fmt.Sprintf("the role %s is held by %d user(s), move them to another role or pass reassign_to", e.RoleId, len(e.Dependents))
+}
+
+// updateCognitoRole points the custom:role of the user in the user pool at the role and moves the user to the group of
+// the role, a seam for the tests
+var updateCognitoRole = func(email string, roleId string) error {
+	if err := aws.UpdateUserRole(config.AwsConfig, email, roleId); err != nil {
+		return err
+	}
+	return moveToRoleGroup(email, roleId)
+}
+
+// roleDependents lists the users holding the role
+func roleDependents(ctx context.Context, db *mongo.Database, roleId string) ([]RoleDependent, error) {
+	opt := options.Find().SetProjection(bson.M{"email": 1, "full_name": 1}).SetSort(bson.M{"_id": 1})
+	cursor, err := db.Collection("users").Find(ctx, bson.M{"role_id": roleId}, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	dependents := []RoleDependent{}
+	if err := cursor.All(ctx, &dependents); err != nil {
+		return nil, err
+	}
+
+	return dependents, nil
+}
+
+// roleChildren lists the roles listing the role among their parents, the archived and binned ones included as they can
+// be brought back
+func roleChildren(ctx context.Context, db *mongo.Database, roleId string) ([]RoleChild, error) {
+	opt := options.Find().SetProjection(bson.M{"name": 1}).SetSort(bson.M{"_id": 1})
+	cursor, err := db.Collection("roles").Find(ctx, bson.M{"parents": roleId}, opt)
+	if err != nil {
+		return nil, err
+	}
+
+	children := []RoleChild{}
+	if err := cursor.All(ctx, &children); err != nil {
+		return nil, err
+	}
+
+	return children, nil
+}
+
+// checkRoleUninherited fails with a RoleInUseError and 409 while roles inherit from the role
+func checkRoleUninherited(ctx context.Context, db *mongo.Database, roleId string) (error, int) {
+	children, err := roleChildren(ctx, db, roleId)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	if len(children) > 0 {
+		return &RoleInUseError{RoleId: roleId, Children: children}, http.StatusConflict
+	}
+
+	return nil, http.StatusOK
+}
+
+// checkRoleUnused fails with a RoleInUseError and 409 while users hold the role or roles inherit from it
+func checkRoleUnused(ctx context.Context, db *mongo.Database, roleId string) (error, int) {
+	if err, code := checkRoleUninherited(ctx, db, roleId); err != nil {
+		return err, code
+	}
+
+	dependents, err := roleDependents(ctx, db, roleId)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	if len(dependents) > 0 {
+		return &RoleInUseError{RoleId: roleId, Dependents: dependents}, http.StatusConflict
+	}
+
+	return nil, http.StatusOK
+}
+
+// assignableRole loads the role users are given, it has to exist and be neither archived nor in the bin
+func assignableRole(roleId string, ctx context.Context, db *mongo.Database) (*Role, error, int) {
+	if _, err := util.GetPrimitiveID(roleId); err != nil {
+		return nil, fmt.Errorf("invalid role id %q", roleId), http.StatusBadRequest
+	}
+
+	role, err, code := FetchRoleById(roleId, ctx, db)
+	if err != nil {
+		if code == http.StatusOK {
+			code = http.StatusNotFound
+		}
+		return nil, err, code
+	}
+	if role.ArchiveStatus || role.IsDeletedStatus {
+		return nil, fmt.Errorf("the role %s is archived or in the bin", roleId), http.StatusBadRequest
+	}
+
+	return role, nil, http.StatusOK
+}
+
+// ReassignRole moves every user of the role to the target role in a transaction, then points their custom:role and
+// role group in the user pool at it. When the user pool cannot be updated the users already updated there are pointed
+// back and the move is undone, so the users never hold a role the user pool disagrees with for longer than the call.
+// As with ChangeUserRole the sessions of the users moved are revoked, their tokens carry the group of the old role.
+func ReassignRole(from string, to string, actor string, ctx context.Context, db *mongo.Database) ([]RoleDependent, error, int) {
+	if from == to {
+		return nil, errors.New("the users cannot be reassigned to the role they hold"), http.StatusBadRequest
+	}
+
+	if _, err, code := assignableRole(to, ctx, db); err != nil {
+		return nil, fmt.Errorf("the reassign_to role: %w", err), code
+	}
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session %w", err), http.StatusInternalServerError
+	}
+	defer session.EndSession(ctx)
+
+	moved, err := session.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		dependents, err := roleDependents(ctx, db, from)
+		if err != nil || len(dependents) == 0 {
+			return dependents, err
+		}
+
+		if err := setUsersRole(ctx, db, dependents, from, to, actor); err != nil {
+			return nil, err
+		}
+
+		return dependents, nil
+	})
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	dependents := moved.([]RoleDependent)
+	for i, user := range dependents {
+		if err := updateCognitoRole(user.Email, to); err != nil {
+			// The custom:role of the user may be changed already, the user is pointed back along with the ones before
+			if revertErr := revertReassign(ctx, db, dependents, i+1, from, to, actor); revertErr != nil {
+				return nil, fmt.Errorf("unable to update the role of %s in the user pool: %w, and some users could not be put back, run cognito-sync: %w", user.Email, err, revertErr), http.StatusBadGateway
+			}
+			return nil, fmt.Errorf("unable to update the role of %s in the user pool, the users were put back: %w", user.Email, err), http.StatusBadGateway
+		}
+	}
+
+	// sessions_revoked_at already refuses the access tokens, a failed sign-out leaves the refresh tokens working but
+	// the tokens they issue carry the new role
+	for _, user := range dependents {
+		if err := signOutUser(user.Email); err != nil {
+			log.Printf("roles: unable to sign %s out of the user pool: %s", user.Email, err.Error())
+		}
+	}
+
+	return dependents, nil, http.StatusOK
+}
+
+// setUsersRole moves the users from the role to the other, only the ones still holding it, and revokes their sessions
+func setUsersRole(ctx context.Context, db *mongo.Database, users []RoleDependent, from string, to string, actor string) error {
+	ids := make(bson.A, 0, len(users))
+	for _, user := range users {
+		if id, err := util.GetPrimitiveID(user.ID); err == nil {
+			ids = append(ids, *id)
+		}
+	}
+
+	now := time.Now().UTC()
+	_, err := db.Collection("users").UpdateMany(ctx, bson.M{"_id": bson.M{"$in": ids}, "role_id": from}, bson.M{
+		"$set": bson.M{"role_id": to, "sessions_revoked_at": now, "updated_at": now, "updated_by": actor},
+	})
+	return err
+}
+
+// revertReassign is the compensation of a failed reassignment, the first updated users are pointed back in the user
+// pool and all of them are moved back in mongo. The group of the role they are pointed back at is created again when
+// it is gone already. What cannot be put back is logged and returned, cognito-sync repairs the user pool.
+func revertReassign(ctx context.Context, db *mongo.Database, users []RoleDependent, updated int, from string, to string, actor string) error {
+	var errs []error
+	for _, user := range users[:updated] {
+		if err := updateCognitoRole(user.Email, from); err != nil {
+			log.Printf("roles: unable to point %s back at the role %s in the user pool: %s", user.Email, from, err.Error())
+			errs = append(errs, fmt.Errorf("%s: %w", user.Email, err))
+		}
+	}
+
+	if err := setUsersRole(ctx, db, users, to, from, actor); err != nil {
+		log.Printf("roles: unable to move the users of the role %s back from %s: %s", from, to, err.Error())
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// roleErrorException writes the error, a role in use answers 409 along with the users holding it
+func roleErrorException(w http.ResponseWriter, err error, code int) {
+	var inUse *RoleInUseError
+	if !errors.As(err, &inUse) {
+		util.ErrorException(w, err, code)
+		return
+	}
+
+	body := map[string]interface{}{"error": err.Error()}
+	if len(inUse.Children) > 0 {
+		body["children"] = inUse.Children
+	} else {
+		body["dependents"] = inUse.Dependents
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(body)
+}
+
+// roleRemoval is the body of the archive, bin and delete routes of a role, reassign_to moves the users of the role
+// to another one beforehand
+type roleRemoval struct {
+	Role
+	ReassignTo string `json:"reassign_to,omitempty"`
+}
+
+// removedRole loads the stored role an archive, bin or delete is about, the removal is checked against it rather than
+// against the flags of the body before any user is moved
+func removedRole(roleId string, ctx context.Context, db *mongo.Database) (*Role, error, int) {
+	if _, err := util.GetPrimitiveID(roleId); err != nil {
+		return nil, fmt.Errorf("invalid role id %q", roleId), http.StatusBadRequest
+	}
+
+	role, err, code := FetchRoleById(roleId, ctx, db)
+	if err != nil {
+		if code == http.StatusOK {
+			code = http.StatusNotFound
+		}
+		return nil, err, code
+	}
+
+	return role, nil, http.StatusOK
+}
+
+// removeRole moves the users of the role to reassign_to when it is given, then runs the removal. The users are put
+// back when the removal fails, such as when a user was given the role in the meantime.
+func removeRole[T any](r *http.Request, db *mongo.Database, roleId string, reassignTo string, remove func() (T, error, int)) (T, error, int) {
+	if reassignTo == "" {
+		return remove()
+	}
+
+	var none T
+	actor, actorErr := aws.ActorFromContext(r.Context())
+	if actorErr != nil {
+		return none, actorErr, http.StatusUnauthorized
+	}
+
+	// The users are not moved off a role its children keep from being removed
+	if err, code := checkRoleUninherited(r.Context(), db, roleId); err != nil {
+		return none, err, code
+	}
+
+	moved, err, code := ReassignRole(roleId, reassignTo, actor, r.Context(), db)
+	if err != nil {
+		return none, err, code
+	}
+
+	result, err, code := remove()
+	if err != nil {
+		if revertErr := revertReassign(r.Context(), db, moved, len(moved), roleId, reassignTo, actor); revertErr != nil {
+			return none, fmt.Errorf("%w, and the users moved to %s could not all be put back, run cognito-sync: %w", err, reassignTo, revertErr), code
+		}
+		return none, err, code
+	}
+
+	if len(moved) > 0 {
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleReassigned,
+			EntityType: audit.RoleEntity,
+			EntityId:   roleId,
+			After:      map[string]interface{}{"reassign_to": reassignTo, "users": moved},
+		})
+		for _, user := range moved {
+			notify.Disconnect(r.Context(), user.ID, notify.UserRoleChanged, map[string]string{"role_id": reassignTo})
+		}
+	}
+
+	return result, nil, code
+}
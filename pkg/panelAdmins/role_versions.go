@@ -0,0 +1,368 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RoleVersion is an immutable copy of the content of a role, one is stored on every write of the name, description,
+// grants or parents. RevertedFrom is the version the content was copied from when the write was a revert.
+type RoleVersion struct {
+	RoleId       string    `json:"role_id"`
+	Version      int       `json:"version"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description,omitempty"`
+	Grants       Grants    `json:"grants"`
+	Parents      []string  `json:"parents,omitempty"`
+	EditedBy     string    `json:"edited_by"`
+	EditedAt     time.Time `json:"edited_at"`
+	RevertedFrom int       `json:"reverted_from,omitempty"`
+}
+
+// RoleChange is a field that differs between two versions of a role, a grant added or removed is the field
+// grants.<resource>:<action> going from false to true or the other way, and a parent is parents.<role id>
+type RoleChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// RoleDiff is the changes from a version of a role to another
+type RoleDiff struct {
+	RoleId  string       `json:"role_id"`
+	From    int          `json:"from"`
+	To      int          `json:"to"`
+	Changes []RoleChange `json:"changes"`
+}
+
+func versionOf(rl *Role, editedBy string, editedAt time.Time, revertedFrom int) bson.M {
+	version := bson.M{
+		"role_id":     rl.ID,
+		"version":     rl.Version,
+		"name":        rl.Name,
+		"description": rl.Description,
+		"grants":      rl.Grants,
+		"parents":     rl.Parents,
+		"edited_by":   editedBy,
+		"edited_at":   editedAt,
+	}
+	if revertedFrom > 0 {
+		version["reverted_from"] = revertedFrom
+	}
+	return version
+}
+
+// versionMatch matches the role at the version, the roles written before the versions were kept count as version 0
+func versionMatch(version int) bson.A {
+	match := bson.A{bson.M{"version": version}}
+	if version == 0 {
+		match = append(match, bson.M{"version": bson.M{"$exists": false}})
+	}
+	return match
+}
+
+// updateRoleContent writes the name, description, grants and parents of the role when it is still at the expected
+// version, and stores the new version along with it in a transaction. The role written before the versions were kept
+// gets its previous content stored as version 0 on its first update, so it can be reverted to. The name is lowercased
+// as CreateRole does, and no other role can have it.
+func updateRoleContent(ctx context.Context, db *mongo.Database, content *Role, expected int, actor string, revertedFrom int) (*Role, error, int) {
+	objId, objErr := util.GetPrimitiveID(content.ID)
+	if objErr != nil {
+		return nil, objErr, http.StatusInternalServerError
+	}
+
+	content.Name = strings.ToLower(strings.TrimSpace(content.Name))
+	if content.Name == "" {
+		return nil, errors.New("the role name is required"), http.StatusBadRequest
+	}
+
+	taken, err := db.Collection("roles").CountDocuments(ctx, bson.M{"name": content.Name, "_id": bson.M{"$ne": objId}})
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+	if taken > 0 {
+		return nil, errRoleNameTaken, http.StatusConflict
+	}
+
+	session, err := db.Client().StartSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session %w", err), http.StatusInternalServerError
+	}
+	defer session.EndSession(ctx)
+
+	updated, err := session.WithTransaction(ctx, func(ctx context.Context) (interface{}, error) {
+		now := time.Now().UTC()
+
+		filter := bson.M{"_id": objId, "$or": versionMatch(expected)}
+		update := bson.M{
+			"$set": bson.M{
+				"name":        content.Name,
+				"description": content.Description,
+				"grants":      content.Grants,
+				"parents":     content.Parents,
+				"updated_by":  actor,
+				"updated_at":  now,
+			},
+			"$inc": bson.M{"version": 1},
+		}
+
+		var rl Role
+		opt := options.FindOneAndUpdate().SetReturnDocument(options.Before)
+		if err := db.Collection("roles").FindOneAndUpdate(ctx, filter, update, opt).Decode(&rl); err != nil {
+			return nil, err
+		}
+
+		versions := []interface{}{}
+		if rl.Version == 0 {
+			versions = append(versions, versionOf(&rl, rl.UpdatedBy, rl.UpdatedAt, 0))
+		}
+
+		rl.Name, rl.Description, rl.Grants, rl.Parents = content.Name, content.Description, content.Grants, content.Parents
+		rl.UpdatedBy, rl.UpdatedAt = actor, now
+		rl.Version++
+
+		versions = append(versions, versionOf(&rl, actor, now, revertedFrom))
+		if _, err := db.Collection("role_versions").InsertMany(ctx, versions); err != nil {
+			return nil, err
+		}
+
+		return &rl, nil
+	})
+	if err != nil {
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, err, http.StatusInternalServerError
+		}
+
+		current, fetchErr, _ := FetchRoleById(content.ID, ctx, db)
+		if fetchErr != nil {
+			return nil, errors.New("no role with the selected metrics were found"), http.StatusNotFound
+		}
+		return nil, fmt.Errorf("the role was updated by %s to version %d since version %d was read, reload it and try again", current.UpdatedBy, current.Version, expected), http.StatusConflict
+	}
+
+	syncRoleGroup(updated.(*Role))
+	return updated.(*Role), nil, http.StatusOK
+}
+
+// FetchRoleVersions lists the versions of the role a page at a time, the latest first
+func FetchRoleVersions(roleId string, req util.PageRequest, ctx context.Context, db *mongo.Database) (*util.Page[RoleVersion], error, int) {
+	req.Sort = []util.SortKey{{Field: "version", Desc: true}}
+
+	page, err := util.Paginate[RoleVersion](ctx, db.Collection("role_versions"), bson.M{"role_id": roleId}, req)
+	if err != nil {
+		return nil, err, util.PageErrorStatus(err)
+	}
+
+	return page, nil, http.StatusOK
+}
+
+// FetchRoleVersion loads a version of the role
+func FetchRoleVersion(roleId string, version int, ctx context.Context, db *mongo.Database) (*RoleVersion, error, int) {
+	var v RoleVersion
+	if err := db.Collection("role_versions").FindOne(ctx, bson.M{"role_id": roleId, "version": version}).Decode(&v); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("the role has no version %d", version), http.StatusNotFound
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	return &v, nil, http.StatusOK
+}
+
+// DiffRoleVersions lists the fields that changed from a version to the other
+func DiffRoleVersions(from RoleVersion, to RoleVersion) RoleDiff {
+	diff := RoleDiff{RoleId: to.RoleId, From: from.Version, To: to.Version, Changes: []RoleChange{}}
+
+	if from.Name != to.Name {
+		diff.Changes = append(diff.Changes, RoleChange{Field: "name", From: from.Name, To: to.Name})
+	}
+	if from.Description != to.Description {
+		diff.Changes = append(diff.Changes, RoleChange{Field: "description", From: from.Description, To: to.Description})
+	}
+
+	for _, grant := range from.Grants {
+		if !slices.Contains(to.Grants, grant) {
+			diff.Changes = append(diff.Changes, RoleChange{Field: "grants." + string(grant), From: true, To: false})
+		}
+	}
+	for _, grant := range to.Grants {
+		if !slices.Contains(from.Grants, grant) {
+			diff.Changes = append(diff.Changes, RoleChange{Field: "grants." + string(grant), From: false, To: true})
+		}
+	}
+
+	for _, parent := range from.Parents {
+		if !slices.Contains(to.Parents, parent) {
+			diff.Changes = append(diff.Changes, RoleChange{Field: "parents." + parent, From: true, To: false})
+		}
+	}
+	for _, parent := range to.Parents {
+		if !slices.Contains(from.Parents, parent) {
+			diff.Changes = append(diff.Changes, RoleChange{Field: "parents." + parent, From: false, To: true})
+		}
+	}
+
+	return diff
+}
+
+// RevertRole writes the content of the version back to the role as a new version, the role has to still be at the
+// expected version. The grants and parents of the version are checked again, a permission can have left the catalog
+// and a parent can have been archived or have come to inherit from the role since.
+func RevertRole(roleId string, version int, expected int, actor string, ctx context.Context, db *mongo.Database) (*Role, error, int) {
+	v, err, code := FetchRoleVersion(roleId, version, ctx, db)
+	if err != nil {
+		return nil, err, code
+	}
+
+	grants, err := ValidateGrants(ctx, db, v.Grants)
+	if err != nil {
+		return nil, err, roleContentError(err)
+	}
+
+	parents, err, code := checkParents(ctx, db, roleId, v.Parents)
+	if err != nil {
+		return nil, err, code
+	}
+
+	content := &Role{ID: roleId, Name: v.Name, Description: v.Description, Grants: grants, Parents: parents}
+	return updateRoleContent(ctx, db, content, expected, actor, version)
+}
+
+// versionParam reads a version number of the path or the query
+func versionParam(name string, value string) (int, error) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid %s %q, a version number is expected", name, value)
+	}
+	return version, nil
+}
+
+// HandleFetchRoleVersions takes the query params cursor, page and limit
+func HandleFetchRoleVersions(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, reqErr := util.ParsePageRequest(r, MAX_LIMIT)
+		if reqErr != nil {
+			util.ErrorException(w, reqErr, http.StatusBadRequest)
+			return
+		}
+
+		versions, err, code := FetchRoleVersions(chi.URLParam(r, "id"), req, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		respBytes, respErr := util.GetBytesResponse(http.StatusOK, versions)
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// HandleDiffRoleVersions takes the query params from and to, the versions to compare
+func HandleDiffRoleVersions(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		roleId := chi.URLParam(r, "id")
+
+		var versions [2]*RoleVersion
+		for i, name := range []string{"from", "to"} {
+			number, err := versionParam(name, r.URL.Query().Get(name))
+			if err != nil {
+				util.ErrorException(w, err, http.StatusBadRequest)
+				return
+			}
+
+			v, err, code := FetchRoleVersion(roleId, number, r.Context(), db)
+			if err != nil {
+				util.ErrorException(w, err, code)
+				return
+			}
+			versions[i] = v
+		}
+
+		respBytes, respErr := util.GetBytesResponse(http.StatusOK, DiffRoleVersions(*versions[0], *versions[1]))
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
+
+// HandleRevertRole takes the version to revert to in the path and {"version": n} in the body, the version of the role
+// the caller is looking at
+func HandleRevertRole(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		target, err := versionParam("version", chi.URLParam(r, "version"))
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		var body struct {
+			Version *int `json:"version"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Version == nil {
+			util.ErrorException(w, errors.New("the version of the role being reverted is required"), http.StatusBadRequest)
+			return
+		}
+
+		roleId := chi.URLParam(r, "id")
+		before := audit.Snapshot(r.Context(), db, "roles", roleId)
+
+		role, err, code := RevertRole(roleId, target, *body.Version, actor, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleReverted,
+			EntityType: audit.RoleEntity,
+			EntityId:   roleId,
+			Before:     before,
+			After:      role,
+		})
+		notify.Publish(r.Context(), notify.ToRole(roleId), notify.RoleUpdated, role)
+
+		respBytes, respErr := util.GetBytesResponse(http.StatusOK, role)
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
@@ -2,6 +2,9 @@ package panelAdmins
 
 import (
 	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/audit"
 	"control-panel-bk/util"
 	"encoding/json"
 	"errors"
@@ -12,11 +15,21 @@ import (
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 	"log"
 	"net/http"
-	"strconv"
 	"strings"
 	"time"
 )
 
+// roleFields are the fields /roles/all can be filtered and sorted by
+var roleFields = util.Fields{
+	"name":              {Type: util.StringField, Sortable: true},
+	"archive_status":    {Type: util.BoolField},
+	"is_deleted_status": {Type: util.BoolField},
+	"created_by":        {Type: util.StringField},
+	"updated_by":        {Type: util.StringField},
+	"created_at":        {Type: util.TimeField, Sortable: true},
+	"updated_at":        {Type: util.TimeField, Sortable: true},
+}
+
 type CreateRoleResponse struct {
 	Status  bool
 	Message string
@@ -27,57 +40,48 @@ type Role struct {
 	ID              string     `json:"_id"`
 	Name            string     `json:"name"`
 	Description     string     `json:"description,omitempty"`
-	Permission      Permission `json:"permission"`
+	Grants          Grants     `json:"grants"`
 	CreatedBy       string     `json:"created_by"`
 	UpdatedBy       string     `json:"updated_by"`
 	ArchiveStatus   bool       `json:"archive_status"`
 	IsDeletedStatus bool       `json:"is_deleted_status"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
 	UpdatedAt       time.Time  `json:"updated_at,omitempty"`
 	CreatedAt       time.Time  `json:"created_at,omitempty"`
+
+	// Parents are the ids of the roles whose grants the role inherits, they are resolved on every read so a change
+	// to a parent reaches the role without writing it
+	Parents []string `json:"parents,omitempty"`
+
+	// Version is bumped by every write of the content, an update has to carry the version it was made against
+	Version int `json:"version"`
 }
 
 type CRole struct {
-	Name        string     `json:"name"`
-	Description string     `json:"description,omitempty"`
-	Permission  Permission `json:"permission"`
-	CreatedBy   string     `json:"created_by"`
-	UpdatedBy   string     `json:"updated_by"`
+	Name        string   `json:"name"`
+	Description string   `json:"description,omitempty"`
+	Grants      Grants   `json:"grants"`
+	Parents     []string `json:"parents,omitempty"`
+	CreatedBy   string   `json:"created_by"`
+	UpdatedBy   string   `json:"updated_by"`
 }
 
+// GeneralizedUpdate writes the name, description, grants and parents of the role as a new version, it fails with a
+// 409 when the role is no longer at rl.Version
 func (rl *Role) GeneralizedUpdate(ctx context.Context, client *mongo.Database) (*Role, error, int) {
-	db := client
-
-	objId, objErr := util.GetPrimitiveID(rl.ID)
-	if objErr != nil {
-		return nil, objErr, http.StatusInternalServerError
-	}
-
-	filter := bson.M{
-		"_id": objId,
-	}
-
-	update := bson.M{
-		"$set": bson.M{
-			"name":        rl.Name,
-			"description": rl.Description,
-			"permission":  rl.Permission,
-			"update_by":   rl.UpdatedBy,
-			"updated_at":  time.Now().UTC(),
-		},
+	grants, err := ValidateGrants(ctx, client, rl.Grants)
+	if err != nil {
+		return nil, err, roleContentError(err)
 	}
+	rl.Grants = grants
 
-	fuOpts := options.FindOneAndUpdate().SetReturnDocument(options.After)
-	err := db.Collection("roles").FindOneAndUpdate(ctx, filter, update, fuOpts).Decode(&rl)
-
+	parents, err, code := checkParents(ctx, client, rl.ID, rl.Parents)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.New("no role with the selected metrics were found"), http.StatusOK
-		}
-
-		return nil, err, http.StatusNotFound
+		return nil, err, code
 	}
+	rl.Parents = parents
 
-	return rl, nil, http.StatusOK
+	return updateRoleContent(ctx, client, rl, rl.Version, rl.UpdatedBy, 0)
 }
 
 func (rl *Role) UnArchiveRole(ctx context.Context, client *mongo.Database) (*Role, error, int) {
@@ -112,12 +116,21 @@ func (rl *Role) UnArchiveRole(ctx context.Context, client *mongo.Database) (*Rol
 		return nil, err, http.StatusNotFound
 	}
 
+	syncRoleGroup(rl)
 	return rl, nil, http.StatusOK
 }
 
-func (rl *Role) ArchiveRole(ctx context.Context, client *mongo.Database) (*Role, error, int) {
+// checkArchive fails when the role is archived already
+func (rl *Role) checkArchive() (error, int) {
 	if rl.ArchiveStatus {
-		return nil, errors.New("role is already archived"), http.StatusInternalServerError
+		return errors.New("role is already archived"), http.StatusInternalServerError
+	}
+	return nil, http.StatusOK
+}
+
+func (rl *Role) ArchiveRole(ctx context.Context, client *mongo.Database) (*Role, error, int) {
+	if err, code := rl.checkArchive(); err != nil {
+		return nil, err, code
 	}
 
 	db := client
@@ -127,6 +140,10 @@ func (rl *Role) ArchiveRole(ctx context.Context, client *mongo.Database) (*Role,
 		return nil, objErr, http.StatusInternalServerError
 	}
 
+	if err, code := checkRoleUnused(ctx, db, rl.ID); err != nil {
+		return nil, err, code
+	}
+
 	filter := bson.D{{"_id", objId}}
 
 	update := bson.M{
@@ -147,14 +164,23 @@ func (rl *Role) ArchiveRole(ctx context.Context, client *mongo.Database) (*Role,
 		return nil, err, http.StatusInternalServerError
 	}
 
+	syncRoleGroup(rl)
 	return rl, nil, http.StatusAccepted
 }
 
+// checkBin fails when the role is in the bin already
+func (rl *Role) checkBin() (error, int) {
+	if rl.IsDeletedStatus {
+		return fmt.Errorf("role has been sent to the bin"), http.StatusOK
+	}
+	return nil, http.StatusOK
+}
+
 func (rl *Role) PushRoleToBin(ctx context.Context, client *mongo.Database) (*Role, error, int) {
 	db := client
 
-	if rl.IsDeletedStatus {
-		return nil, fmt.Errorf("role has been sent to the bin"), http.StatusOK
+	if err, code := rl.checkBin(); err != nil {
+		return nil, err, code
 	}
 
 	objId, objErr := util.GetPrimitiveID(rl.ID)
@@ -162,11 +188,17 @@ func (rl *Role) PushRoleToBin(ctx context.Context, client *mongo.Database) (*Rol
 		return nil, objErr, http.StatusInternalServerError
 	}
 
+	if err, code := checkRoleUnused(ctx, db, rl.ID); err != nil {
+		return nil, err, code
+	}
+
+	now := time.Now().UTC()
 	filter := bson.D{{"_id", objId}}
 	update := bson.M{
 		"$set": bson.M{
-			"updated_at":        time.Now().UTC(),
+			"updated_at":        now,
 			"is_deleted_status": true,
+			"deleted_at":        now,
 			"updated_by":        rl.UpdatedBy,
 		},
 	}
@@ -180,6 +212,7 @@ func (rl *Role) PushRoleToBin(ctx context.Context, client *mongo.Database) (*Rol
 		return nil, err, http.StatusNotFound
 	}
 
+	syncRoleGroup(rl)
 	return rl, nil, http.StatusOK
 }
 
@@ -202,6 +235,7 @@ func (rl *Role) RestoreRoleFromBin(ctx context.Context, client *mongo.Database)
 			"is_deleted_status": false,
 			"updated_by":        rl.UpdatedBy,
 		},
+		"$unset": bson.M{"deleted_at": ""},
 	}
 
 	opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
@@ -213,6 +247,7 @@ func (rl *Role) RestoreRoleFromBin(ctx context.Context, client *mongo.Database)
 		return nil, err, http.StatusNotFound
 	}
 
+	syncRoleGroup(rl)
 	return rl, nil, http.StatusOK
 }
 
@@ -224,7 +259,11 @@ func (rl *Role) HardDeleteRole(ctx context.Context, client *mongo.Database) (*st
 		return nil, objErr, http.StatusInternalServerError
 	}
 
-	del, err := db.Collection("roles").DeleteOne(ctx, bson.D{{"_id", objId}})
+	if err, code := checkRoleUnused(ctx, db, rl.ID); err != nil {
+		return nil, err, code
+	}
+
+	deleted, err := deleteRole(ctx, db, bson.M{"_id": objId})
 	if err != nil {
 		if errors.Is(err, mongo.ErrNoDocuments) {
 			return nil, err, http.StatusOK
@@ -233,48 +272,37 @@ func (rl *Role) HardDeleteRole(ctx context.Context, client *mongo.Database) (*st
 		return nil, err, http.StatusInternalServerError
 	}
 
-	if del.DeletedCount == 0 {
+	if deleted == 0 {
 		return nil, errors.New("unable to delete role"), http.StatusNotImplemented
 	}
 
-	return &rl.ID, nil, http.StatusOK
-}
-
-func FetchRoles(page int, limit int, ctx context.Context, client *mongo.Database) ([]Role, error, int) {
-	var roles []Role
-
-	db := client
-
-	lmt := int64(limit)
-	var skip int64
-
-	if page == 1 {
-		skip = 0
-	} else {
-		skip = int64((page - 1) * limit)
+	if err := dropRoleGroup(rl.ID); err != nil {
+		log.Printf("roles: unable to remove the group of the role %s from the user pool, run cognito-sync: %s", rl.ID, err.Error())
 	}
 
-	opt := options.Find().SetSort(bson.D{{"created_at", -1}}).SetLimit(lmt).SetSkip(skip) //.SetSort(bson.D{{"created_at", 1}})
-	docs, err := db.Collection("roles").Find(ctx, bson.M{}, opt)
+	return &rl.ID, nil, http.StatusOK
+}
 
+// deleteRole is the hard delete of the roles matching the filter, shared by the delete route and the bin purger
+func deleteRole(ctx context.Context, db *mongo.Database, filter bson.M) (int64, error) {
+	del, err := db.Collection("roles").DeleteOne(ctx, filter)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, errors.New("no role has been created"), http.StatusOK
-		}
-		return nil, err, http.StatusInternalServerError
+		return 0, err
 	}
 
-	defer docs.Close(ctx)
+	return del.DeletedCount, nil
+}
 
-	if docs.Err() != nil {
-		return nil, docs.Err(), http.StatusOK
-	}
+// FetchRoles lists the roles of the view matching the query a page at a time, newest first unless the query is sorted
+func FetchRoles(req util.PageRequest, query util.ListQuery, ctx context.Context, client *mongo.Database) (*util.Page[Role], error, int) {
+	req.Sort = query.Sort
 
-	if err := docs.All(ctx, &roles); err != nil {
-		return nil, err, http.StatusNotFound
+	page, err := util.Paginate[Role](ctx, client.Collection("roles"), query.Filter(), req)
+	if err != nil {
+		return nil, err, util.PageErrorStatus(err)
 	}
 
-	return roles, nil, http.StatusOK
+	return page, nil, http.StatusOK
 }
 
 func FetchRoleById(roleId string, ctx context.Context, client *mongo.Database) (*Role, error, int) {
@@ -329,6 +357,16 @@ func FetchRoleByName(roleName string, ctx context.Context, client *mongo.Databas
 	return roles, nil
 }
 
+var errRoleNameTaken = errors.New("a role having the same name already exists")
+
+// createRoleError is the status of an error of CreateRole
+func createRoleError(err error) int {
+	if errors.Is(err, errRoleNameTaken) {
+		return http.StatusConflict
+	}
+	return roleContentError(err)
+}
+
 func CreateRole(crl CRole, ctx context.Context, client *mongo.Database) (*CreateRoleResponse, error) {
 	result, err := FetchRoleByName(crl.Name, ctx, client)
 	if err != nil {
@@ -336,26 +374,49 @@ func CreateRole(crl CRole, ctx context.Context, client *mongo.Database) (*Create
 	}
 
 	if len(result) > 0 {
-		return nil, errors.New("a role having the same name already exists")
+		return nil, errRoleNameTaken
+	}
+
+	grants, err := ValidateGrants(ctx, client, crl.Grants)
+	if err != nil {
+		return nil, err
+	}
+
+	parents, err, _ := checkParents(ctx, client, "", crl.Parents)
+	if err != nil {
+		return nil, err
 	}
 
 	db := client
+	now := time.Now().UTC()
 	doc, err := db.Collection("roles").InsertOne(ctx, bson.D{
 		{"name", strings.ToLower(crl.Name)},
 		{"description", crl.Description},
-		{"permission", crl.Permission},
+		{"grants", grants},
+		{"parents", parents},
 		{"created_by", crl.CreatedBy},
 		{"updated_by", crl.UpdatedBy},
 		{"archive_status", false},
 		{"is_deleted_status", false},
-		{"created_at", time.Now().UTC()},
-		{"updated_at", time.Now().UTC()},
+		{"created_at", now},
+		{"updated_at", now},
+		{"version", 1},
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
+	// The first version is the content the role was created with, a role without it is removed again
+	rl := &Role{ID: doc.InsertedID.(bson.ObjectID).Hex(), Name: strings.ToLower(crl.Name), Description: crl.Description, Grants: grants, Parents: parents, Version: 1}
+	if _, err := db.Collection("role_versions").InsertOne(ctx, versionOf(rl, crl.CreatedBy, now, 0)); err != nil {
+		if _, delErr := deleteRole(ctx, db, bson.M{"_id": doc.InsertedID}); delErr != nil {
+			log.Printf("roles: unable to remove the role %s without a version: %s", rl.ID, delErr.Error())
+		}
+		return nil, fmt.Errorf("unable to store the first version of the role: %w", err)
+	}
+	syncRoleGroup(rl)
+
 	response := CreateRoleResponse{
 		Data:    doc,
 		Message: "Role has been created",
@@ -377,8 +438,21 @@ func HandleCreateRole(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.CreatedBy = actor
+		body.UpdatedBy = actor
+
 		output, outputErr := CreateRole(body, r.Context(), db)
 		if outputErr != nil {
+			if roleContentError(outputErr) == http.StatusBadRequest {
+				util.ErrorException(w, outputErr, http.StatusBadRequest)
+				return
+			}
+
 			if errors.Is(outputErr, errors.New("a role having the same name already exists")) {
 				util.ErrorException(w, outputErr, http.StatusOK)
 				return
@@ -388,6 +462,14 @@ func HandleCreateRole(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		roleId, _ := output.Data.InsertedID.(bson.ObjectID)
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleCreated,
+			EntityType: audit.RoleEntity,
+			EntityId:   roleId.Hex(),
+			After:      body,
+		})
+
 		respBytes, e := util.GetBytesResponse(http.StatusCreated, output)
 		if e != nil {
 			util.ErrorException(w, e, http.StatusInternalServerError)
@@ -404,13 +486,26 @@ func HandleCreateRole(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
+// HandleFetchRoleByName takes the query params name, view, cursor, page and limit
 func HandleFetchRoleByName(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		req, reqErr := util.ParsePageRequest(r, MAX_LIMIT)
+		if reqErr != nil {
+			util.ErrorException(w, reqErr, http.StatusBadRequest)
+			return
+		}
+
+		view, viewErr := util.ParseView(r)
+		if viewErr != nil {
+			util.ErrorException(w, viewErr, http.StatusBadRequest)
+			return
+		}
+
 		roleName := r.URL.Query().Get("name")
-		roles, err := FetchRoleByName(roleName, r.Context(), db)
+		roles, err := util.Paginate[Role](r.Context(), db.Collection("roles"), view.Within(bson.M{"name": strings.ToLower(roleName)}), req)
 
 		if err != nil {
-			util.ErrorException(w, err, http.StatusInternalServerError)
+			util.ErrorException(w, err, util.PageErrorStatus(err))
 			return
 		}
 
@@ -429,8 +524,15 @@ func HandleFetchRoleByName(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
+// HandleFetchRoleById takes the query param view, a role outside of the view is not found
 func HandleFetchRoleById(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		view, viewErr := util.ParseView(r)
+		if viewErr != nil {
+			util.ErrorException(w, viewErr, http.StatusBadRequest)
+			return
+		}
+
 		roleId := chi.URLParam(r, "id")
 		result, err, cde := FetchRoleById(roleId, r.Context(), db)
 
@@ -439,6 +541,11 @@ func HandleFetchRoleById(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		if !view.Includes(result.ArchiveStatus, result.IsDeletedStatus) {
+			util.ErrorException(w, fmt.Errorf("the role is not in the %s view", view), http.StatusNotFound)
+			return
+		}
+
 		resp, respErr := json.Marshal(&result)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -453,37 +560,35 @@ func HandleFetchRoleById(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
+// HandleFetchRoles takes the query params view, cursor, page, limit, filter[field][op] and sort
 func HandleFetchRoles(db *mongo.Database) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query()
+	return fetchRolesHandler(db, "")
+}
 
-		var limit int
-		var page int
+// HandleFetchBinnedRoles lists the roles in the bin, the ones that can still be restored
+func HandleFetchBinnedRoles(db *mongo.Database) http.HandlerFunc {
+	return fetchRolesHandler(db, util.BinView)
+}
 
-		if len(query.Get("page")) > 0 {
-			pg, pgErr := strconv.Atoi(query.Get("page"))
-			if pgErr != nil {
-				util.ErrorException(w, pgErr, http.StatusInternalServerError)
-				return
-			}
-			page = pg
-		} else {
-			page = 1
+// fetchRolesHandler lists the roles of the view, or of the view param when it is empty
+func fetchRolesHandler(db *mongo.Database, view util.View) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, reqErr := util.ParsePageRequest(r, MAX_LIMIT)
+		if reqErr != nil {
+			util.ErrorException(w, reqErr, http.StatusBadRequest)
+			return
 		}
 
-		if len(query.Get("limit")) > 0 {
-			lmt, lmtErr := strconv.Atoi(query.Get("limit"))
-			if lmtErr != nil {
-				util.ErrorException(w, lmtErr, http.StatusInternalServerError)
-				return
-			}
-			limit = lmt
-		} else {
-			limit = MAX_LIMIT
+		query, queryErr := util.ParseListQuery(r, roleFields)
+		if queryErr != nil {
+			util.ErrorException(w, queryErr, http.StatusBadRequest)
+			return
+		}
+		if view != "" {
+			query.View = view
 		}
 
-		result, err, code := FetchRoles(page, limit, r.Context(), db)
-
+		result, err, code := FetchRoles(req, query, r.Context(), db)
 		if err != nil {
 			util.ErrorException(w, err, code)
 			return
@@ -498,7 +603,6 @@ func HandleFetchRoles(db *mongo.Database) http.HandlerFunc {
 				util.ErrorException(w, err, http.StatusInternalServerError)
 			}
 		}
-
 	}
 }
 
@@ -506,17 +610,34 @@ func HandleHardDeleteOfRole(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		var role Role
+		var body roleRemoval
 
-		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			util.ErrorException(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		if id, err, code := role.HardDeleteRole(r.Context(), db); err != nil {
+		role, err, code := removedRole(body.ID, r.Context(), db)
+		if err != nil {
 			util.ErrorException(w, err, code)
 			return
+		}
+
+		before := audit.Snapshot(r.Context(), db, "roles", role.ID)
+
+		if id, err, code := removeRole(r, db, role.ID, body.ReassignTo, func() (*string, error, int) {
+			return role.HardDeleteRole(r.Context(), db)
+		}); err != nil {
+			roleErrorException(w, err, code)
+			return
 		} else {
+			audit.Record(r.Context(), db, audit.Event{
+				Action:     audit.RoleDeleted,
+				EntityType: audit.RoleEntity,
+				EntityId:   role.ID,
+				Before:     before,
+			})
+
 			deleteBytes, delErr := util.GetBytesResponse(code, id)
 			if delErr != nil {
 				util.ErrorException(w, delErr, http.StatusInternalServerError)
@@ -543,12 +664,30 @@ func HandleGeneralUpdate(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.UpdatedBy = actor
+
+		before := audit.Snapshot(r.Context(), db, "roles", body.ID)
+
 		updateDoc, updateError, code := body.GeneralizedUpdate(r.Context(), db)
 		if updateError != nil {
 			util.ErrorException(w, updateError, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleUpdated,
+			EntityType: audit.RoleEntity,
+			EntityId:   updateDoc.ID,
+			Before:     before,
+			After:      updateDoc,
+		})
+		notify.Publish(r.Context(), notify.ToRole(updateDoc.ID), notify.RoleUpdated, updateDoc)
+
 		respBytes, respErr := util.GetBytesResponse(http.StatusAccepted, updateDoc)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -567,23 +706,53 @@ func HandleArchiveRole(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		var role Role
-		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		var body roleRemoval
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			util.ErrorException(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		doc, docErr, code := role.ArchiveRole(r.Context(), db)
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		role, err, code := removedRole(body.ID, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+		if err, code := role.checkArchive(); err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		role.UpdatedBy = actor
+
+		before := audit.Snapshot(r.Context(), db, "roles", role.ID)
+
+		doc, docErr, code := removeRole(r, db, role.ID, body.ReassignTo, func() (*Role, error, int) {
+			return role.ArchiveRole(r.Context(), db)
+		})
 		if docErr != nil {
 			if errors.Is(docErr, errors.New("no document was found")) {
 				util.ErrorException(w, docErr, code)
 				return
 			}
 
-			util.ErrorException(w, docErr, code)
+			roleErrorException(w, docErr, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleArchived,
+			EntityType: audit.RoleEntity,
+			EntityId:   doc.ID,
+			Before:     before,
+			After:      doc,
+		})
+		notify.Publish(r.Context(), notify.ToRole(doc.ID), notify.RoleArchived, doc)
+
 		archBytes, archErr := util.GetBytesResponse(http.StatusOK, doc)
 		if archErr != nil {
 			util.ErrorException(w, archErr, http.StatusInternalServerError)
@@ -609,6 +778,15 @@ func HandleUnArchiveRole(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		role.UpdatedBy = actor
+
+		before := audit.Snapshot(r.Context(), db, "roles", role.ID)
+
 		doc, docErr, code := role.UnArchiveRole(r.Context(), db)
 		if docErr != nil {
 			if errors.Is(docErr, errors.New("no document was found")) {
@@ -620,6 +798,15 @@ func HandleUnArchiveRole(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleUnArchived,
+			EntityType: audit.RoleEntity,
+			EntityId:   doc.ID,
+			Before:     before,
+			After:      doc,
+		})
+		notify.Publish(r.Context(), notify.ToRole(doc.ID), notify.RoleUnArchived, doc)
+
 		unArchBytes, unArchErr := util.GetBytesResponse(code, doc)
 		if unArchErr != nil {
 			util.ErrorException(w, unArchErr, http.StatusInternalServerError)
@@ -638,23 +825,53 @@ func HandlePushRoleToBin(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		var role Role
-		if err := json.NewDecoder(r.Body).Decode(&role); err != nil {
+		var body roleRemoval
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
 			util.ErrorException(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		bin, binErr, code := role.PushRoleToBin(r.Context(), db)
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		role, err, code := removedRole(body.ID, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+		if err, code := role.checkBin(); err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		role.UpdatedBy = actor
+
+		before := audit.Snapshot(r.Context(), db, "roles", role.ID)
+
+		bin, binErr, code := removeRole(r, db, role.ID, body.ReassignTo, func() (*Role, error, int) {
+			return role.PushRoleToBin(r.Context(), db)
+		})
 		if binErr != nil {
 			if errors.Is(binErr, errors.New("no document was found")) {
 				util.ErrorException(w, binErr, code)
 				return
 			}
 
-			util.ErrorException(w, binErr, code)
+			roleErrorException(w, binErr, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleBinned,
+			EntityType: audit.RoleEntity,
+			EntityId:   bin.ID,
+			Before:     before,
+			After:      bin,
+		})
+		notify.Publish(r.Context(), notify.ToRole(bin.ID), notify.RoleBinned, bin)
+
 		binByte, bbErr := util.GetBytesResponse(code, bin)
 		if bbErr != nil {
 			util.ErrorException(w, bbErr, http.StatusInternalServerError)
@@ -679,6 +896,15 @@ func HandleRestoreRoleFromBin(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		role.UpdatedBy = actor
+
+		before := audit.Snapshot(r.Context(), db, "roles", role.ID)
+
 		bin, binErr, code := role.RestoreRoleFromBin(r.Context(), db)
 		if binErr != nil {
 			if errors.Is(binErr, errors.New("no document was found")) {
@@ -690,6 +916,15 @@ func HandleRestoreRoleFromBin(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.RoleRestored,
+			EntityType: audit.RoleEntity,
+			EntityId:   bin.ID,
+			Before:     before,
+			After:      bin,
+		})
+		notify.Publish(r.Context(), notify.ToRole(bin.ID), notify.RoleRestored, bin)
+
 		binByte, bbErr := util.GetBytesResponse(code, bin)
 		if bbErr != nil {
 			util.ErrorException(w, bbErr, http.StatusInternalServerError)
@@ -3,9 +3,13 @@ package panelAdmins
 import (
 	"bytes"
 	"context"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/v2/bson"
@@ -14,6 +18,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type RoleTestSuite struct {
@@ -21,6 +26,7 @@ type RoleTestSuite struct {
 	db     *mongo.Database
 	client *mongo.Client
 	ctx    context.Context
+	groups *fakeGroups
 }
 
 func (suite *RoleTestSuite) SetupSuite() {
@@ -40,10 +46,23 @@ func (suite *RoleTestSuite) SetupTest() {
 	if err != nil {
 		suite.T().Fatal(err)
 	}
+
+	if _, err := suite.db.Collection(audit.Collection).DeleteMany(suite.ctx, bson.M{}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	if _, err := suite.db.Collection("role_versions").DeleteMany(suite.ctx, bson.M{}); err != nil {
+		suite.T().Fatal(err)
+	}
+
+	// The roles are mirrored in a user pool kept in memory
+	suite.groups = newFakeGroups()
+	roleGroups = suite.groups
 }
 
 func (suite *RoleTestSuite) TearDownSuite() {
 	// Cleanup after all tests
+	roleGroups = cognitoGroups{}
 	suite.db.Drop(suite.ctx)
 	suite.client.Disconnect(suite.ctx)
 }
@@ -56,7 +75,7 @@ func (suite *RoleTestSuite) TestCreateRole_Success() {
 	cRole := CRole{
 		Name:        "test-role",
 		Description: "Test description",
-		Permission:  Permission{}, // Add proper permission data
+		Grants:      Grants{"team:read", "role:*"},
 		CreatedBy:   "tester",
 		UpdatedBy:   "tester",
 	}
@@ -72,6 +91,7 @@ func (suite *RoleTestSuite) TestCreateRole_Success() {
 	err = suite.db.Collection("roles").FindOne(suite.ctx, bson.M{"name": "test-role"}).Decode(&role)
 	suite.NoError(err)
 	suite.Equal(cRole.Name, role.Name)
+	suite.Equal("test-role", suite.groups.descriptions[RoleGroupName(role.ID)], "the role is mirrored as a group")
 }
 
 func (suite *RoleTestSuite) TestCreateRole_Duplicate() {
@@ -124,16 +144,154 @@ func (suite *RoleTestSuite) TestFetchRoles_Pagination() {
 	}
 
 	// Fetch first page
-	roles, err, code := FetchRoles(1, 10, suite.ctx, suite.db)
+	roles, err, code := FetchRoles(util.PageRequest{Page: 1, Limit: 10}, util.ListQuery{}, suite.ctx, suite.db)
 	suite.NoError(err)
 	suite.Equal(http.StatusOK, code)
-	suite.Len(roles, 10)
+	suite.Len(roles.Items, 10)
+	suite.Equal(int64(15), roles.Total)
+	suite.True(roles.HasMore)
 
 	// Fetch second page
-	roles, err, code = FetchRoles(2, 10, suite.ctx, suite.db)
+	second, err, code := FetchRoles(util.PageRequest{Page: 2, Limit: 10}, util.ListQuery{}, suite.ctx, suite.db)
+	suite.NoError(err)
+	suite.Equal(http.StatusOK, code)
+	suite.Len(second.Items, 5)
+	suite.False(second.HasMore)
+	suite.NotEqual(roles.Items[0].ID, second.Items[0].ID)
+}
+
+func (suite *RoleTestSuite) TestFetchRoles_Cursor() {
+	for i := 0; i < 15; i++ {
+		_, err := CreateRole(CRole{Name: fmt.Sprintf("cursor-role-%d", i)}, suite.ctx, suite.db)
+		suite.NoError(err)
+	}
+
+	seen := map[string]bool{}
+	req := util.PageRequest{Limit: 6}
+	for {
+		roles, err, _ := FetchRoles(req, util.ListQuery{}, suite.ctx, suite.db)
+		suite.NoError(err)
+		suite.Equal(int64(15), roles.Total)
+
+		for _, role := range roles.Items {
+			suite.False(seen[role.ID], "a role is listed once")
+			seen[role.ID] = true
+		}
+
+		if !roles.HasMore {
+			suite.Empty(roles.NextCursor)
+			break
+		}
+		req.Cursor = roles.NextCursor
+	}
+
+	suite.Len(seen, 15)
+}
+
+func (suite *RoleTestSuite) TestFetchRoles_FilterAndSort() {
+	for i := 0; i < 9; i++ {
+		_, err := suite.db.Collection("roles").InsertOne(suite.ctx, bson.M{
+			"name":           fmt.Sprintf("sorted-role-%d", i),
+			"archive_status": i%3 == 0,
+			"created_at":     time.Now(),
+		})
+		suite.NoError(err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/roles/all?filter[archive_status]=false&sort=-name", nil)
+	query, err := util.ParseListQuery(r, roleFields)
+	suite.NoError(err)
+
+	var names []string
+	req := util.PageRequest{Limit: 4}
+	for {
+		roles, err, _ := FetchRoles(req, query, suite.ctx, suite.db)
+		suite.NoError(err)
+		suite.Equal(int64(6), roles.Total)
+
+		for _, role := range roles.Items {
+			suite.False(role.ArchiveStatus)
+			names = append(names, role.Name)
+		}
+
+		if !roles.HasMore {
+			break
+		}
+		req.Cursor = roles.NextCursor
+	}
+
+	suite.Equal([]string{"sorted-role-8", "sorted-role-7", "sorted-role-5", "sorted-role-4", "sorted-role-2", "sorted-role-1"}, names)
+}
+
+func (suite *RoleTestSuite) TestFetchRoles_Views() {
+	for _, flags := range [][2]bool{{false, false}, {false, false}, {true, false}, {false, true}, {true, true}} {
+		_, err := suite.db.Collection("roles").InsertOne(suite.ctx, bson.M{
+			"name":              bson.NewObjectID().Hex(),
+			"archive_status":    flags[0],
+			"is_deleted_status": flags[1],
+			"created_at":        time.Now(),
+		})
+		suite.NoError(err)
+	}
+
+	for view, expected := range map[util.View]int64{"": 2, util.ActiveView: 2, util.ArchivedView: 1, util.BinView: 2, util.AllView: 5} {
+		roles, err, _ := FetchRoles(util.PageRequest{Limit: 10}, util.ListQuery{View: view}, suite.ctx, suite.db)
+		suite.NoError(err)
+		suite.Equal(expected, roles.Total, view)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/roles/bin?view=active", nil)
+	rr := httptest.NewRecorder()
+	HandleFetchBinnedRoles(suite.db).ServeHTTP(rr, req)
+	suite.Equal(http.StatusOK, rr.Code)
+
+	var resp struct{ Data util.Page[Role] }
+	suite.NoError(json.Unmarshal(rr.Body.Bytes(), &resp))
+	suite.Equal(int64(2), resp.Data.Total, "the bin listing ignores the view param")
+	for _, role := range resp.Data.Items {
+		suite.True(role.IsDeletedStatus)
+	}
+}
+
+func (suite *RoleTestSuite) TestPurgeRoleBin() {
+	longAgo, recently := time.Now().UTC().Add(-40*24*time.Hour), time.Now().UTC().Add(-time.Hour)
+
+	seed := map[string]bson.M{
+		"expired":        {"is_deleted_status": true, "deleted_at": longAgo, "updated_at": longAgo},
+		"expired-legacy": {"is_deleted_status": true, "updated_at": longAgo},
+		"recent":         {"is_deleted_status": true, "deleted_at": recently, "updated_at": recently},
+		"live":           {"is_deleted_status": false, "updated_at": longAgo},
+	}
+	for name, doc := range seed {
+		doc["name"] = name
+		_, err := suite.db.Collection("roles").InsertOne(suite.ctx, doc)
+		suite.NoError(err)
+	}
+
+	preview, err, code := PurgeRoleBin(suite.ctx, suite.db, 30*24*time.Hour, true)
 	suite.NoError(err)
 	suite.Equal(http.StatusOK, code)
-	suite.Len(roles, 5)
+	suite.True(preview.DryRun)
+	suite.Require().Len(preview.Purged, 2)
+	suite.ElementsMatch([]string{"expired", "expired-legacy"}, []string{preview.Purged[0].Name, preview.Purged[1].Name})
+
+	count, _ := suite.db.Collection("roles").CountDocuments(suite.ctx, bson.M{})
+	suite.Equal(int64(4), count, "a dry run removes nothing")
+
+	report, err, _ := PurgeRoleBin(suite.ctx, suite.db, 30*24*time.Hour, false)
+	suite.NoError(err)
+	suite.Len(report.Purged, 2)
+	suite.Empty(report.Failed)
+
+	cursor, err := suite.db.Collection("roles").Find(suite.ctx, bson.M{})
+	suite.NoError(err)
+	var left []Role
+	suite.NoError(cursor.All(suite.ctx, &left))
+	suite.Require().Len(left, 2)
+	suite.ElementsMatch([]string{"recent", "live"}, []string{left[0].Name, left[1].Name})
+
+	purges, _ := suite.db.Collection(audit.Collection).CountDocuments(suite.ctx, bson.M{"action": audit.RolePurged, "actor": BinPurgeActor})
+	suite.Equal(int64(2), purges, "every purge is audited")
 }
 
 func (suite *RoleTestSuite) TestGeneralizedUpdate_Success() {
@@ -162,6 +320,119 @@ func (suite *RoleTestSuite) TestGeneralizedUpdate_Success() {
 	suite.Equal("new description", updatedRole.Description)
 }
 
+func (suite *RoleTestSuite) TestGeneralizedUpdate_Name() {
+	_, err := CreateRole(CRole{Name: "taken-name"}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	created, err := CreateRole(CRole{Name: "renamed-role"}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	roleId := created.Data.InsertedID.(bson.ObjectID).Hex()
+
+	edit := Role{ID: roleId, Name: " Taken-Name ", UpdatedBy: "ada", Version: 1}
+	_, err, code := edit.GeneralizedUpdate(suite.ctx, suite.db)
+	suite.Error(err)
+	suite.Equal(http.StatusConflict, code)
+
+	edit = Role{ID: roleId, Name: " Renamed-Role ", UpdatedBy: "ada", Version: 1}
+	updated, err, code := edit.GeneralizedUpdate(suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal("renamed-role", updated.Name, "the name is stored lowercased and the role keeps its own")
+}
+
+func (suite *RoleTestSuite) TestGeneralizedUpdate_Versions() {
+	created, err := CreateRole(CRole{Name: "versioned-role", Grants: Grants{"role:read"}}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	roleId := created.Data.InsertedID.(bson.ObjectID).Hex()
+
+	edit := Role{ID: roleId, Name: "versioned-role", Grants: Grants{"role:read", "role:update"}, UpdatedBy: "ada", Version: 1}
+	updated, err, code := edit.GeneralizedUpdate(suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(2, updated.Version)
+
+	stale := Role{ID: roleId, Name: "renamed", UpdatedBy: "bob", Version: 1}
+	_, err, code = stale.GeneralizedUpdate(suite.ctx, suite.db)
+	suite.Error(err, "an update made against an older version does not clobber the latest one")
+	suite.Equal(http.StatusConflict, code)
+
+	missing := Role{ID: bson.NewObjectID().Hex(), Name: "missing", UpdatedBy: "bob", Version: 1}
+	_, err, code = missing.GeneralizedUpdate(suite.ctx, suite.db)
+	suite.Error(err)
+	suite.Equal(http.StatusNotFound, code)
+
+	versions, err, _ := FetchRoleVersions(roleId, util.PageRequest{Limit: 10}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Require().Len(versions.Items, 2)
+	suite.Equal(2, versions.Items[0].Version)
+	suite.Equal("ada", versions.Items[0].EditedBy)
+
+	reverted, err, code := RevertRole(roleId, 1, 2, "jane", suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(3, reverted.Version)
+	suite.Equal(Grants{"role:read"}, reverted.Grants)
+
+	v3, err, _ := FetchRoleVersion(roleId, 3, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(1, v3.RevertedFrom)
+
+	_, err, code = RevertRole(roleId, 9, 3, "jane", suite.ctx, suite.db)
+	suite.Error(err)
+	suite.Equal(http.StatusNotFound, code)
+}
+
+func (suite *RoleTestSuite) TestCreateRole_InvalidGrant() {
+	_, err := CreateRole(CRole{Name: "ledger-keeper", Grants: Grants{"ledger:read"}}, suite.ctx, suite.db)
+
+	var grantErr *GrantError
+	suite.True(errors.As(err, &grantErr))
+
+	roles, _ := FetchRoleByName("ledger-keeper", suite.ctx, suite.db)
+	suite.Empty(roles)
+}
+
+func (suite *RoleTestSuite) TestMigratePermissions() {
+	objID := bson.NewObjectID()
+	_, err := suite.db.Collection("roles").InsertOne(suite.ctx, bson.M{
+		"_id":        objID,
+		"name":       "legacy-support",
+		"permission": Permission{Team: ReadWrite{Read: true, Write: true}, Billing: ReadWrite{Read: true}},
+	})
+	suite.Require().NoError(err)
+
+	migrated, err := MigratePermissions(suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(int64(1), migrated)
+
+	var doc bson.M
+	suite.Require().NoError(suite.db.Collection("roles").FindOne(suite.ctx, bson.M{"_id": objID}).Decode(&doc))
+	suite.NotContains(doc, "permission")
+
+	role, err, _ := FetchRoleById(objID.Hex(), suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(Grants{"team:*", "user:*", "billing:read", "billing:export"}, role.Grants)
+
+	migrated, err = MigratePermissions(suite.ctx, suite.db)
+	suite.NoError(err)
+	suite.Zero(migrated, "the migration can be run again")
+}
+
+func (suite *RoleTestSuite) TestGeneralizedUpdate_LegacyRoleKeepsItsContent() {
+	objID := bson.NewObjectID()
+	_, err := suite.db.Collection("roles").InsertOne(suite.ctx, bson.M{"_id": objID, "name": "legacy", "updated_by": "jos"})
+	suite.Require().NoError(err)
+
+	edit := Role{ID: objID.Hex(), Name: "legacy", Description: "edited", UpdatedBy: "ada"}
+	updated, err, _ := edit.GeneralizedUpdate(suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(1, updated.Version)
+
+	baseline, err, _ := FetchRoleVersion(objID.Hex(), 0, suite.ctx, suite.db)
+	suite.Require().NoError(err, "the content before the first versioned update is kept as version 0")
+	suite.Equal("jos", baseline.EditedBy)
+	suite.Empty(baseline.Description)
+}
+
 func (suite *RoleTestSuite) TestHandleCreateRole_HTTP() {
 	handler := HandleCreateRole(suite.db)
 
@@ -176,7 +447,7 @@ func (suite *RoleTestSuite) TestHandleCreateRole_HTTP() {
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+	handler.ServeHTTP(w, withCaller(req, "tester"))
 
 	suite.Equal(http.StatusCreated, w.Code)
 
@@ -193,6 +464,52 @@ func (suite *RoleTestSuite) TestHandleCreateRole_HTTP() {
 	suite.Equal(cRole.Name, role.Name)
 }
 
+func (suite *RoleTestSuite) TestHandleCreateRole_StampsCaller() {
+	handler := HandleCreateRole(suite.db)
+
+	// The client tries to attribute the role to someone else
+	body, _ := json.Marshal(map[string]string{
+		"name":       "stamped-role",
+		"created_by": "mallory",
+		"updated_by": "mallory",
+	})
+	req := httptest.NewRequest("POST", "/roles", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, withCaller(req, "jane@flowcx.io"))
+
+	suite.Equal(http.StatusCreated, w.Code)
+
+	var role Role
+	err := suite.db.Collection("roles").FindOne(suite.ctx, bson.M{"name": "stamped-role"}).Decode(&role)
+	suite.NoError(err)
+	suite.Equal("jane@flowcx.io", role.CreatedBy)
+	suite.Equal("jane@flowcx.io", role.UpdatedBy)
+}
+
+func (suite *RoleTestSuite) TestHandleArchiveRole_RecordsAudit() {
+	res, err := suite.db.Collection("roles").InsertOne(suite.ctx, bson.M{"name": "audited-role", "archive_status": false})
+	suite.NoError(err)
+	roleId := res.InsertedID.(bson.ObjectID).Hex()
+
+	body, _ := json.Marshal(map[string]interface{}{"_id": roleId, "name": "audited-role"})
+	req := httptest.NewRequest("PATCH", "/roles/archive", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+
+	HandleArchiveRole(suite.db).ServeHTTP(w, withCaller(req, "jane@flowcx.io"))
+	suite.Equal(http.StatusAccepted, w.Code)
+
+	var event audit.Event
+	err = suite.db.Collection(audit.Collection).FindOne(suite.ctx, bson.M{"entity_id": roleId}).Decode(&event)
+	suite.NoError(err)
+	suite.Equal(audit.RoleArchived, event.Action)
+	suite.Equal(audit.RoleEntity, event.EntityType)
+	suite.Equal("jane@flowcx.io", event.Actor)
+	suite.NotNil(event.Before)
+	suite.NotNil(event.After)
+	suite.False(event.CreatedAt.IsZero())
+}
+
 func (suite *RoleTestSuite) TestHardDeleteRole_Success() {
 	// Insert test role
 	role := Role{ID: bson.NewObjectID().Hex()}
@@ -409,7 +726,7 @@ func (suite *RoleTestSuite) TestHandleArchiveRole_HTTP() {
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+	handler.ServeHTTP(w, withCaller(req, "tester"))
 
 	suite.Equal(http.StatusAccepted, w.Code)
 
@@ -440,7 +757,7 @@ func (suite *RoleTestSuite) TestHandleRestoreFromBin_HTTP() {
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+	handler.ServeHTTP(w, withCaller(req, "tester"))
 
 	suite.Equal(http.StatusOK, w.Code)
 
@@ -465,7 +782,7 @@ func (suite *RoleTestSuite) TestHandleCreateRole_Success() {
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+	handler.ServeHTTP(w, withCaller(req, "tester"))
 
 	suite.Equal(http.StatusCreated, w.Code)
 
@@ -487,7 +804,7 @@ func (suite *RoleTestSuite) TestHandleCreateRole_Duplicate() {
 	req.Header.Set("Content-Type", "application/json")
 	w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+	handler.ServeHTTP(w, withCaller(req, "tester"))
 
 	suite.Equal(http.StatusOK, w.Code)
 
@@ -501,7 +818,7 @@ func (suite *RoleTestSuite) TestHandleFetchRoleByName_Success() {
 	// Create test roles
 	roles := []CRole{
 		{Name: "fetch-test-role"},
-		{Name: "fetch-test-role"},
+		{Name: "fetch-other-role"},
 	}
 	for _, r := range roles {
 		_, err := CreateRole(r, suite.ctx, suite.db)
@@ -516,10 +833,13 @@ func (suite *RoleTestSuite) TestHandleFetchRoleByName_Success() {
 
 	suite.Equal(http.StatusOK, w.Code)
 
-	var result []Role
+	var result struct {
+		Data util.Page[Role]
+	}
 	err := json.Unmarshal(w.Body.Bytes(), &result)
 	suite.NoError(err)
-	suite.Len(result, 2)
+	suite.Len(result.Data.Items, 1)
+	suite.Equal("fetch-test-role", result.Data.Items[0].Name)
 }
 
 func (suite *RoleTestSuite) TestHandleFetchRoleById_Success() {
@@ -561,10 +881,13 @@ func (suite *RoleTestSuite) TestHandleFetchRoles_Pagination() {
 
 	suite.Equal(http.StatusOK, w.Code)
 
-	var result []Role
+	var result struct {
+		Data util.Page[Role]
+	}
 	err := json.Unmarshal(w.Body.Bytes(), &result)
 	suite.NoError(err)
-	suite.Len(result, 5)
+	suite.Len(result.Data.Items, 5)
+	suite.Equal(int64(15), result.Data.Total)
 }
 
 func (suite *RoleTestSuite) TestHandleHardDeleteOfRole_Success() {
@@ -587,6 +910,243 @@ func (suite *RoleTestSuite) TestHandleHardDeleteOfRole_Success() {
 	suite.Error(err)
 }
 
+// seedRoleHolders creates a role held by users and the role they can be moved to
+func (suite *RoleTestSuite) seedRoleHolders(emails ...string) (string, string) {
+	_, err := suite.db.Collection("users").DeleteMany(suite.ctx, bson.M{})
+	suite.Require().NoError(err)
+
+	held, err := CreateRole(CRole{Name: "held-role"}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	target, err := CreateRole(CRole{Name: "target-role"}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+
+	heldId := held.Data.InsertedID.(bson.ObjectID).Hex()
+	for _, email := range emails {
+		_, err := suite.db.Collection("users").InsertOne(suite.ctx, bson.M{"email": email, "role_id": heldId})
+		suite.Require().NoError(err)
+	}
+
+	return heldId, target.Data.InsertedID.(bson.ObjectID).Hex()
+}
+
+func (suite *RoleTestSuite) TestHandleHardDeleteOfRole_InUse() {
+	heldId, _ := suite.seedRoleHolders("ada@flowcx.io", "bob@flowcx.io")
+
+	body, _ := json.Marshal(map[string]string{"_id": heldId})
+	w := httptest.NewRecorder()
+	HandleHardDeleteOfRole(suite.db).ServeHTTP(w, httptest.NewRequest("DELETE", "/roles/delete", bytes.NewReader(body)))
+
+	suite.Equal(http.StatusConflict, w.Code)
+
+	var resp struct {
+		Error      string          `json:"error"`
+		Dependents []RoleDependent `json:"dependents"`
+	}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	suite.Len(resp.Dependents, 2)
+
+	_, err, _ := FetchRoleById(heldId, suite.ctx, suite.db)
+	suite.NoError(err, "a role in use is kept")
+}
+
+func (suite *RoleTestSuite) TestHandleHardDeleteOfRole_Inherited() {
+	parent, err := CreateRole(CRole{Name: "inherited-role"}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	parentId := parent.Data.InsertedID.(bson.ObjectID).Hex()
+	_, err = CreateRole(CRole{Name: "child-role", Parents: []string{parentId}}, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+
+	body, _ := json.Marshal(map[string]string{"_id": parentId})
+	w := httptest.NewRecorder()
+	HandleHardDeleteOfRole(suite.db).ServeHTTP(w, httptest.NewRequest("DELETE", "/roles/delete", bytes.NewReader(body)))
+
+	suite.Equal(http.StatusConflict, w.Code)
+
+	var resp struct {
+		Children []RoleChild `json:"children"`
+	}
+	suite.NoError(json.Unmarshal(w.Body.Bytes(), &resp))
+	suite.Require().Len(resp.Children, 1)
+	suite.Equal("child-role", resp.Children[0].Name)
+
+	_, err, _ = FetchRoleById(parentId, suite.ctx, suite.db)
+	suite.NoError(err, "a role other roles inherit from is kept")
+}
+
+func (suite *RoleTestSuite) TestHandleHardDeleteOfRole_Reassign() {
+	heldId, targetId := suite.seedRoleHolders("ada@flowcx.io", "bob@flowcx.io")
+
+	updated := map[string]string{}
+	var signedOut []string
+	previous, previousSignOut := updateCognitoRole, signOutUser
+	updateCognitoRole = func(email string, roleId string) error {
+		updated[email] = roleId
+		return nil
+	}
+	signOutUser = func(email string) error {
+		signedOut = append(signedOut, email)
+		return nil
+	}
+	defer func() { updateCognitoRole, signOutUser = previous, previousSignOut }()
+
+	body, _ := json.Marshal(map[string]string{"_id": heldId, "reassign_to": targetId})
+	w := httptest.NewRecorder()
+	HandleHardDeleteOfRole(suite.db).ServeHTTP(w, withCaller(httptest.NewRequest("DELETE", "/roles/delete", bytes.NewReader(body)), "jane@flowcx.io"))
+
+	suite.Equal(http.StatusOK, w.Code)
+	suite.Equal(map[string]string{"ada@flowcx.io": targetId, "bob@flowcx.io": targetId}, updated)
+
+	moved, _ := suite.db.Collection("users").CountDocuments(suite.ctx, bson.M{"role_id": targetId})
+	suite.Equal(int64(2), moved)
+	revoked, _ := suite.db.Collection("users").CountDocuments(suite.ctx, bson.M{"role_id": targetId, "sessions_revoked_at": bson.M{"$exists": true}})
+	suite.Equal(int64(2), revoked, "the sessions issued before the move are refused")
+	suite.ElementsMatch([]string{"ada@flowcx.io", "bob@flowcx.io"}, signedOut)
+
+	_, err, _ := FetchRoleById(heldId, suite.ctx, suite.db)
+	suite.Error(err, "the role is deleted once its users are moved")
+}
+
+func (suite *RoleTestSuite) TestHandleArchiveRole_ReassignUndone() {
+	heldId, targetId := suite.seedRoleHolders("ada@flowcx.io", "bob@flowcx.io")
+
+	previous, previousSignOut := updateCognitoRole, signOutUser
+	signOutUser = func(email string) error { return nil }
+	updateCognitoRole = func(email string, roleId string) error {
+		if email == "bob@flowcx.io" && roleId == targetId {
+			// A user is given the role while its users are moved
+			_, err := suite.db.Collection("users").InsertOne(suite.ctx, bson.M{"email": "cy@flowcx.io", "role_id": heldId})
+			suite.Require().NoError(err)
+		}
+		return nil
+	}
+	defer func() { updateCognitoRole, signOutUser = previous, previousSignOut }()
+
+	body, _ := json.Marshal(map[string]string{"_id": heldId, "reassign_to": targetId})
+	w := httptest.NewRecorder()
+	HandleArchiveRole(suite.db).ServeHTTP(w, withCaller(httptest.NewRequest("PATCH", "/roles/archive", bytes.NewReader(body)), "jane@flowcx.io"))
+
+	suite.Equal(http.StatusConflict, w.Code)
+
+	kept, _ := suite.db.Collection("users").CountDocuments(suite.ctx, bson.M{"role_id": heldId})
+	suite.Equal(int64(3), kept, "the users moved are put back once the archive fails")
+
+	role, err, _ := FetchRoleById(heldId, suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.False(role.ArchiveStatus)
+}
+
+func (suite *RoleTestSuite) TestHandlePushRoleToBin_ChecksTheStoredRole() {
+	heldId, targetId := suite.seedRoleHolders("ada@flowcx.io")
+	objID, _ := bson.ObjectIDFromHex(heldId)
+	_, err := suite.db.Collection("roles").UpdateByID(suite.ctx, objID, bson.M{"$set": bson.M{"is_deleted_status": true}})
+	suite.Require().NoError(err)
+
+	body, _ := json.Marshal(map[string]interface{}{"_id": heldId, "is_deleted_status": false, "reassign_to": targetId})
+	w := httptest.NewRecorder()
+	HandlePushRoleToBin(suite.db).ServeHTTP(w, withCaller(httptest.NewRequest("PATCH", "/roles/bin", bytes.NewReader(body)), "jane@flowcx.io"))
+
+	suite.Contains(w.Body.String(), "role has been sent to the bin")
+
+	kept, _ := suite.db.Collection("users").CountDocuments(suite.ctx, bson.M{"role_id": heldId})
+	suite.Equal(int64(1), kept, "no user is moved off a role that cannot be binned")
+
+	w = httptest.NewRecorder()
+	body, _ = json.Marshal(map[string]string{"_id": "not-an-id", "reassign_to": targetId})
+	HandlePushRoleToBin(suite.db).ServeHTTP(w, withCaller(httptest.NewRequest("PATCH", "/roles/bin", bytes.NewReader(body)), "jane@flowcx.io"))
+	suite.Equal(http.StatusBadRequest, w.Code)
+}
+
+func (suite *RoleTestSuite) TestReassignRole_UserPoolFailure() {
+	heldId, targetId := suite.seedRoleHolders("ada@flowcx.io", "bob@flowcx.io")
+
+	var calls []string
+	previous := updateCognitoRole
+	updateCognitoRole = func(email string, roleId string) error {
+		calls = append(calls, email+"="+roleId)
+		if email == "bob@flowcx.io" && roleId == targetId {
+			return errors.New("throttled")
+		}
+		return nil
+	}
+	defer func() { updateCognitoRole = previous }()
+
+	_, err, code := ReassignRole(heldId, targetId, "jane@flowcx.io", suite.ctx, suite.db)
+	suite.Error(err)
+	suite.Equal(http.StatusBadGateway, code)
+	suite.Equal([]string{"ada@flowcx.io=" + targetId, "bob@flowcx.io=" + targetId, "ada@flowcx.io=" + heldId, "bob@flowcx.io=" + heldId}, calls,
+		"the updated users are pointed back, the one that failed included")
+
+	kept, _ := suite.db.Collection("users").CountDocuments(suite.ctx, bson.M{"role_id": heldId})
+	suite.Equal(int64(2), kept, "the move is undone")
+}
+
+func (suite *RoleTestSuite) TestChangeUserRole() {
+	heldId, targetId := suite.seedRoleHolders("ada@flowcx.io")
+
+	var doc struct {
+		ID string `json:"_id"`
+	}
+	suite.Require().NoError(suite.db.Collection("users").FindOne(suite.ctx, bson.M{"email": "ada@flowcx.io"}).Decode(&doc))
+
+	var signedOut []string
+	previousRole, previousSignOut := updateCognitoRole, signOutUser
+	updateCognitoRole = func(email string, roleId string) error { return nil }
+	signOutUser = func(email string) error {
+		signedOut = append(signedOut, email)
+		return nil
+	}
+	defer func() { updateCognitoRole, signOutUser = previousRole, previousSignOut }()
+
+	_, err, code := ChangeUserRole(doc.ID, heldId, "jane@flowcx.io", suite.ctx, suite.db)
+	suite.Error(err)
+	suite.Equal(http.StatusBadRequest, code, "the user already holds the role")
+
+	user, err, code := ChangeUserRole(doc.ID, targetId, "jane@flowcx.io", suite.ctx, suite.db)
+	suite.Require().NoError(err)
+	suite.Equal(http.StatusOK, code)
+	suite.Equal(targetId, user.RoleId)
+	suite.NotNil(user.SessionsRevokedAt, "the sessions issued before the change are refused")
+	suite.Equal([]string{"ada@flowcx.io"}, signedOut)
+
+	_, err, code = ChangeUserRole(doc.ID, bson.NewObjectID().Hex(), "jane@flowcx.io", suite.ctx, suite.db)
+	suite.Error(err)
+	suite.Equal(http.StatusNotFound, code, "the role does not exist")
+}
+
+func (suite *RoleTestSuite) TestChangeUserRole_UserPoolFailure() {
+	heldId, targetId := suite.seedRoleHolders("ada@flowcx.io")
+
+	var doc struct {
+		ID string `json:"_id"`
+	}
+	suite.Require().NoError(suite.db.Collection("users").FindOne(suite.ctx, bson.M{"email": "ada@flowcx.io"}).Decode(&doc))
+
+	var calls []string
+	previousRole, previousSignOut := updateCognitoRole, signOutUser
+	updateCognitoRole = func(email string, roleId string) error {
+		calls = append(calls, roleId)
+		if roleId == targetId {
+			return errors.New("throttled")
+		}
+		return nil
+	}
+	signOutUser = func(email string) error {
+		suite.Fail("the sessions are kept when the role is put back")
+		return nil
+	}
+	defer func() { updateCognitoRole, signOutUser = previousRole, previousSignOut }()
+
+	_, err, code := ChangeUserRole(doc.ID, targetId, "jane@flowcx.io", suite.ctx, suite.db)
+	suite.Error(err)
+	suite.Equal(http.StatusBadGateway, code)
+	suite.Equal([]string{targetId, heldId}, calls, "the user pool is pointed back at the role too")
+
+	var user User
+	suite.Require().NoError(suite.db.Collection("users").FindOne(suite.ctx, bson.M{"email": "ada@flowcx.io"}).Decode(&user))
+	suite.Equal(heldId, user.RoleId, "the role is put back")
+	suite.Nil(user.SessionsRevokedAt)
+}
+
 func (suite *RoleTestSuite) TestHandleGeneralUpdate_Success() {
 	// Create test role
 	role := CRole{Name: "update-test-role"}
@@ -598,13 +1158,14 @@ func (suite *RoleTestSuite) TestHandleGeneralUpdate_Success() {
 		ID:        created.Data.InsertedID.(bson.ObjectID).Hex(),
 		Name:      "updated-name",
 		UpdatedBy: "tester",
+		Version:   1,
 	}
 	body, _ := json.Marshal(updateData)
 
 	req := httptest.NewRequest("PUT", "/roles", bytes.NewReader(body))
 	w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+	handler.ServeHTTP(w, withCaller(req, "tester"))
 
 	suite.Equal(http.StatusAccepted, w.Code)
 
@@ -625,7 +1186,7 @@ func (suite *RoleTestSuite) TestHandlePushRoleToBin_HTTP() {
 	req := httptest.NewRequest("POST", "/roles/bin", bytes.NewReader(body))
 	w := httptest.NewRecorder()
 
-	handler.ServeHTTP(w, req)
+	handler.ServeHTTP(w, withCaller(req, "tester"))
 
 	suite.Equal(http.StatusOK, w.Code)
 
@@ -634,3 +1195,56 @@ func (suite *RoleTestSuite) TestHandlePushRoleToBin_HTTP() {
 	suite.NoError(err)
 	suite.True(inBin.IsDeletedStatus)
 }
+
+func TestHandleFetchRoles_InvalidQuery(t *testing.T) {
+	for _, query := range []string{"filter[permission]=x", "filter[name][gte]=a", "filter[created_at][lt]=yesterday", "sort=description", "view=trash"} {
+		w := httptest.NewRecorder()
+		HandleFetchRoles(nil)(w, httptest.NewRequest(http.MethodGet, "/roles/all?"+query, nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestCreateRoleError(t *testing.T) {
+	assert.Equal(t, http.StatusConflict, createRoleError(fmt.Errorf("failed to create the user's role: %w", errRoleNameTaken)))
+	assert.Equal(t, http.StatusBadRequest, createRoleError(&ParentError{}))
+	assert.Equal(t, http.StatusInternalServerError, createRoleError(errors.New("connection reset")))
+}
+
+func TestRoleErrorException(t *testing.T) {
+	w := httptest.NewRecorder()
+	roleErrorException(w, &RoleInUseError{RoleId: "r1", Dependents: []RoleDependent{{ID: "u1", Email: "ada@flowcx.io"}}}, http.StatusConflict)
+
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"the role r1 is held by 1 user(s), move them to another role or pass reassign_to","dependents":[{"_id":"u1","email":"ada@flowcx.io"}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	roleErrorException(w, &RoleInUseError{RoleId: "r1", Children: []RoleChild{{ID: "r2", Name: "support lead"}}}, http.StatusConflict)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.JSONEq(t, `{"error":"the role r1 is inherited by 1 role(s), remove it from their parents first","children":[{"_id":"r2","name":"support lead"}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	roleErrorException(w, errors.New("no document was found"), http.StatusOK)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"error":"no document was found"}`, w.Body.String())
+}
+
+func TestDiffRoleVersions(t *testing.T) {
+	from := RoleVersion{RoleId: "r1", Version: 1, Name: "support", Grants: Grants{"team:read", "user:read"}, Parents: []string{"r2"}}
+	to := RoleVersion{RoleId: "r1", Version: 3, Name: "support", Description: "first line", Grants: Grants{"team:*", "user:read", "billing:read"}, Parents: []string{"r3"}}
+
+	assert.Equal(t, RoleDiff{RoleId: "r1", From: 1, To: 3, Changes: []RoleChange{
+		{Field: "description", From: "", To: "first line"},
+		{Field: "grants.team:read", From: true, To: false},
+		{Field: "grants.team:*", From: false, To: true},
+		{Field: "grants.billing:read", From: false, To: true},
+		{Field: "parents.r2", From: true, To: false},
+		{Field: "parents.r3", From: false, To: true},
+	}}, DiffRoleVersions(from, to))
+
+	assert.Empty(t, DiffRoleVersions(to, to).Changes)
+}
+
+func TestVersionMatch(t *testing.T) {
+	assert.Equal(t, bson.A{bson.M{"version": 0}, bson.M{"version": bson.M{"$exists": false}}}, versionMatch(0), "the roles written before the versions are at version 0")
+	assert.Equal(t, bson.A{bson.M{"version": 4}}, versionMatch(4))
+}
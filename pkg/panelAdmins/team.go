@@ -2,6 +2,9 @@ package panelAdmins
 
 import (
 	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/audit"
 	"control-panel-bk/util"
 	"encoding/json"
 	"errors"
@@ -13,24 +16,36 @@ import (
 	"net/http"
 	"regexp"
 	"slices"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
 type Team struct {
-	ID            string    `json:"_id,omitempty"`
-	Name          string    `json:"name"`
-	Description   string    `json:"description,omitempty"`
-	TeamLead      string    `json:"team_lead"`
-	TeamMember    []string  `json:"team_member"`
-	UpdatedAt     time.Time `json:"updated_at,omitempty"`
-	CreatedAt     time.Time `json:"created_at,omitempty"`
-	CreatedBy     string    `json:"created_by"`
-	UpdatedBy     string    `json:"updated_by"`
-	ArchiveStatus bool      `json:"archive_status"`
-	DeletedStatus bool      `json:"is_deleted_status"`
+	ID            string     `json:"_id,omitempty"`
+	Name          string     `json:"name"`
+	Description   string     `json:"description,omitempty"`
+	TeamLead      string     `json:"team_lead"`
+	TeamMember    []string   `json:"team_member"`
+	UpdatedAt     time.Time  `json:"updated_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at,omitempty"`
+	CreatedBy     string     `json:"created_by"`
+	UpdatedBy     string     `json:"updated_by"`
+	ArchiveStatus bool       `json:"archive_status"`
+	DeletedStatus bool       `json:"is_deleted_status"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"`
+}
+
+// teamFields are the fields /teams/all can be filtered and sorted by, team_member matches the teams with the member
+var teamFields = util.Fields{
+	"name":              {Type: util.StringField, Sortable: true},
+	"team_lead":         {Type: util.StringField},
+	"team_member":       {Type: util.StringField},
+	"archive_status":    {Type: util.BoolField},
+	"is_deleted_status": {Type: util.BoolField},
+	"created_by":        {Type: util.StringField},
+	"created_at":        {Type: util.TimeField, Sortable: true},
+	"updated_at":        {Type: util.TimeField, Sortable: true},
 }
 
 type CTeam struct {
@@ -311,12 +326,28 @@ func HandleCreateTeam(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.CreatedBy = actor
+		body.UpdatedBy = actor
+
 		result, e, code := CreateTeam(body, r.Context(), db)
 		if e != nil {
 			util.ErrorException(w, e, code)
 			return
 		}
 
+		teamId, _ := result.InsertedID.(bson.ObjectID)
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamCreated,
+			EntityType: audit.TeamEntity,
+			EntityId:   teamId.Hex(),
+			After:      body,
+		})
+
 		respBytes, respErr := util.GetBytesResponse(code, result)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -331,60 +362,49 @@ func HandleCreateTeam(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
-func GetTeams(db *mongo.Database) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		query := r.URL.Query()
-
-		var limit int64
-		var skip int64
+// FetchTeams lists the teams of the view matching the query a page at a time, newest first unless the query is sorted
+func FetchTeams(req util.PageRequest, query util.ListQuery, ctx context.Context, db *mongo.Database) (*util.Page[Team], error, int) {
+	req.Sort = query.Sort
 
-		var page int
+	page, err := util.Paginate[Team](ctx, db.Collection("teams"), query.Filter(), req)
+	if err != nil {
+		return nil, err, util.PageErrorStatus(err)
+	}
 
-		var teams []Team
+	return page, nil, http.StatusOK
+}
 
-		if len(query.Get("page")) > 0 {
-			pg, pgErr := strconv.Atoi(query.Get("page"))
-			if pgErr != nil {
-				util.ErrorException(w, pgErr, http.StatusInternalServerError)
-				return
-			}
-			page = pg
-		} else {
-			page = 1
-		}
+// GetTeams takes the query params view, cursor, page, limit, filter[field][op] and sort
+func GetTeams(db *mongo.Database) http.HandlerFunc {
+	return fetchTeamsHandler(db, "")
+}
 
-		if len(query.Get("limit")) > 0 {
-			lmt, lmtErr := strconv.Atoi(query.Get("limit"))
-			if lmtErr != nil {
-				util.ErrorException(w, lmtErr, http.StatusInternalServerError)
-				return
-			}
-			limit = int64(lmt)
-		} else {
-			limit = int64(MAX_LIMIT)
-		}
+// GetBinnedTeams lists the teams in the bin, the ones that can still be restored
+func GetBinnedTeams(db *mongo.Database) http.HandlerFunc {
+	return fetchTeamsHandler(db, util.BinView)
+}
 
-		if page > 1 {
-			skip = int64(page - 1)
-		} else {
-			skip = int64(0)
+// fetchTeamsHandler lists the teams of the view, or of the view param when it is empty
+func fetchTeamsHandler(db *mongo.Database, view util.View) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, reqErr := util.ParsePageRequest(r, MAX_LIMIT)
+		if reqErr != nil {
+			util.ErrorException(w, reqErr, http.StatusBadRequest)
+			return
 		}
 
-		opt := options.Find().SetLimit(limit).SetSkip(skip).SetSort(bson.M{"created_by": -1})
-		result, err := db.Collection("teams").Find(r.Context(), bson.M{}, opt)
-
-		if err != nil {
-			if errors.Is(err, mongo.ErrNoDocuments) {
-				util.ErrorException(w, errors.New("no team record was found"), http.StatusOK)
-				return
-			}
-
-			util.ErrorException(w, err, http.StatusNotFound)
+		query, queryErr := util.ParseListQuery(r, teamFields)
+		if queryErr != nil {
+			util.ErrorException(w, queryErr, http.StatusBadRequest)
 			return
 		}
+		if view != "" {
+			query.View = view
+		}
 
-		if err = result.All(r.Context(), &teams); err != nil {
-			util.ErrorException(w, err, http.StatusNotImplemented)
+		teams, err, code := FetchTeams(req, query, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
 			return
 		}
 
@@ -395,8 +415,8 @@ func GetTeams(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
-		w.WriteHeader(http.StatusOK)
 		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
 		if _, err := w.Write(respBytes); err != nil {
 			util.ErrorException(w, err, http.StatusInternalServerError)
 			return
@@ -404,11 +424,18 @@ func GetTeams(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
+// GetTeam takes the query param view, the teams outside of the view are not found
 func GetTeam(client *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var teams []Team
 		db := client //getDB(client)
 
+		view, viewErr := util.ParseView(r)
+		if viewErr != nil {
+			util.ErrorException(w, viewErr, http.StatusBadRequest)
+			return
+		}
+
 		id := chi.URLParam(r, "id")
 
 		// The id that be either the team by name or by id, hence if it matches it is an ID else it's a name
@@ -430,7 +457,7 @@ func GetTeam(client *mongo.Database) http.HandlerFunc {
 
 			var team Team
 
-			fil := bson.M{"_id": objID}
+			fil := view.Within(bson.M{"_id": objID})
 			opt := options.FindOne().SetSort(bson.M{"created_at": -1})
 			err = db.Collection("teams").FindOne(r.Context(), fil, opt).Decode(&team)
 
@@ -449,11 +476,11 @@ func GetTeam(client *mongo.Database) http.HandlerFunc {
 
 		// When the id does not match the OBJECT ID type
 		if !doesMatch {
-			fil := bson.M{
+			fil := view.Within(bson.M{
 				"$text": bson.M{
 					"$search": id,
 				},
-			}
+			})
 
 			opt := options.Find().SetLimit(50).SetAllowPartialResults(true).SetSort(bson.M{"name": 1})
 			results, resultsErr := db.Collection("teams").Find(r.Context(), fil, opt)
@@ -495,18 +522,36 @@ func HandleArchiveTeam(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.UpdatedBy = actor
+
 		objId, objErr := util.GetPrimitiveID(body.ID)
 		if objErr != nil {
 			util.ErrorException(w, objErr, http.StatusInternalServerError)
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "teams", body.ID)
+
 		result, err, code := body.ArchiveTeam(r.Context(), objId, db)
 		if err != nil {
 			util.ErrorException(w, err, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamArchived,
+			EntityType: audit.TeamEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+		notify.Publish(r.Context(), notify.ToTeam(result.ID), notify.TeamArchived, result)
+
 		respByt, respErr := util.GetBytesResponse(code, result)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -529,18 +574,36 @@ func HandleUnArchiveTeam(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.UpdatedBy = actor
+
 		objId, objErr := util.GetPrimitiveID(body.ID)
 		if objErr != nil {
 			util.ErrorException(w, objErr, http.StatusInternalServerError)
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "teams", body.ID)
+
 		result, err, code := body.UnArchiveTeam(r.Context(), objId, db)
 		if err != nil {
 			util.ErrorException(w, err, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamUnArchived,
+			EntityType: audit.TeamEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+		notify.Publish(r.Context(), notify.ToTeam(result.ID), notify.TeamUnArchived, result)
+
 		respByt, respErr := util.GetBytesResponse(code, result)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -563,18 +626,38 @@ func HandleAddNewMembers(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.Team.UpdatedBy = actor
+
 		objId, objErr := util.GetPrimitiveID(body.Team.ID)
 		if objErr != nil {
 			util.ErrorException(w, objErr, http.StatusInternalServerError)
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "teams", body.Team.ID)
+
 		result, e, code := body.Team.AddNewTeamMember(body.TeamMembers, objId, db, r.Context())
 		if e != nil {
 			util.ErrorException(w, e, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamMembersAdded,
+			EntityType: audit.TeamEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+		for _, member := range body.TeamMembers {
+			notify.Publish(r.Context(), notify.ToUser(member), notify.TeamMemberAdded, result)
+		}
+
 		respByte, respErr := util.GetBytesResponse(code, result)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -597,18 +680,38 @@ func HandleRemoveNewMembers(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.Team.UpdatedBy = actor
+
 		objId, objErr := util.GetPrimitiveID(body.Team.ID)
 		if objErr != nil {
 			util.ErrorException(w, objErr, http.StatusInternalServerError)
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "teams", body.Team.ID)
+
 		result, e, code := body.Team.RemoveTeamMember(body.TeamMembers, *objId, db, r.Context())
 		if e != nil {
 			util.ErrorException(w, e, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamMembersRemoved,
+			EntityType: audit.TeamEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+		for _, member := range body.TeamMembers {
+			notify.Publish(r.Context(), notify.ToUser(member), notify.TeamMemberRemoved, result)
+		}
+
 		respByte, respErr := util.GetBytesResponse(code, result)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -637,7 +740,7 @@ func HardDeleteTeam(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
-		if deleted := db.Collection("teams").FindOneAndDelete(r.Context(), bson.M{"_id": objID}).Decode(&t); deleted != nil {
+		if deleted := deleteTeam(r.Context(), db, bson.M{"_id": objID}, &t); deleted != nil {
 			if errors.Is(deleted, mongo.ErrNoDocuments) {
 				util.ErrorException(w, errors.New("no team matching the record was found and hence it can't be deleted"), http.StatusOK)
 				return
@@ -647,6 +750,13 @@ func HardDeleteTeam(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamDeleted,
+			EntityType: audit.TeamEntity,
+			EntityId:   t.ID,
+			Before:     t,
+		})
+
 		respBy, respErr := util.GetBytesResponse(http.StatusAccepted, t.ID)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -659,6 +769,12 @@ func HardDeleteTeam(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
+// deleteTeam is the hard delete of the team matching the filter, shared by the delete route and the bin purger. The
+// deleted team is decoded into t.
+func deleteTeam(ctx context.Context, db *mongo.Database, filter bson.M, t *Team) error {
+	return db.Collection("teams").FindOneAndDelete(ctx, filter).Decode(t)
+}
+
 func HandleChangeTeamLead(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
@@ -669,18 +785,36 @@ func HandleChangeTeamLead(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.Team.UpdatedBy = actor
+
 		objId, objErr := util.GetPrimitiveID(body.Team.ID)
 		if objErr != nil {
 			util.ErrorException(w, objErr, http.StatusInternalServerError)
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "teams", body.Team.ID)
+
 		result, e, code := body.Team.ChangeTeamLead(body.NewLead, body.Team.TeamLead, objId, db, r.Context())
 		if e != nil {
 			util.ErrorException(w, e, code)
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamLeadChanged,
+			EntityType: audit.TeamEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+		notify.Publish(r.Context(), notify.ToTeam(result.ID), notify.TeamLeadChanged, result)
+
 		respBy, respErr := util.GetBytesResponse(code, result)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -703,6 +837,13 @@ func PushTeamToBin(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		t.UpdatedBy = actor
+
 		if t.DeletedStatus {
 			util.ErrorException(w, errors.New("team has already sent to the bin"), http.StatusOK)
 			return
@@ -714,13 +855,17 @@ func PushTeamToBin(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "teams", t.ID)
+
 		flt := bson.M{"_id": objId}
 		opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
+		now := time.Now().UTC()
 		update := bson.D{{
 			"$set", bson.M{
-				"updated_at":        time.Now(),
+				"updated_at":        now,
 				"updated_by":        t.UpdatedBy,
 				"is_deleted_status": true,
+				"deleted_at":        now,
 			},
 		}}
 
@@ -734,6 +879,14 @@ func PushTeamToBin(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamBinned,
+			EntityType: audit.TeamEntity,
+			EntityId:   t.ID,
+			Before:     before,
+			After:      t,
+		})
+
 		respBy, respErr := util.GetBytesResponse(http.StatusAccepted, t)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -749,7 +902,19 @@ func PushTeamToBin(db *mongo.Database) http.HandlerFunc {
 func RestoreTeamFromBin(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
+
 		var t Team
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		t.UpdatedBy = actor
 
 		if !t.DeletedStatus {
 			util.ErrorException(w, errors.New("team cannot be restored as it is not in the bin"), http.StatusOK)
@@ -762,15 +927,18 @@ func RestoreTeamFromBin(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "teams", t.ID)
+
 		flt := bson.M{"_id": objId}
 		opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
-		update := bson.D{{
-			"$set", bson.M{
+		update := bson.D{
+			{"$set", bson.M{
 				"updated_at":        time.Now(),
 				"updated_by":        t.UpdatedBy,
 				"is_deleted_status": false,
-			},
-		}}
+			}},
+			{"$unset", bson.M{"deleted_at": ""}},
+		}
 
 		if err := db.Collection("teams").FindOneAndUpdate(r.Context(), flt, update, opt).Decode(&t); err != nil {
 			if errors.Is(err, mongo.ErrNoDocuments) {
@@ -782,6 +950,14 @@ func RestoreTeamFromBin(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TeamRestored,
+			EntityType: audit.TeamEntity,
+			EntityId:   t.ID,
+			Before:     before,
+			After:      t,
+		})
+
 		respBy, respErr := util.GetBytesResponse(http.StatusAccepted, t)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
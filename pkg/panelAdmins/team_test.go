@@ -523,7 +523,7 @@ func TestTeam_HandleCreatTeam(t *testing.T) {
 		req.Header.Set("Content-Type", "application/json")
 		rr := httptest.NewRecorder()
 
-		HandleCreateTeam(db).ServeHTTP(rr, req)
+		HandleCreateTeam(db).ServeHTTP(rr, withCaller(req, "tester"))
 
 		assert.Equal(t, tt.ExpectedStatus, rr.Code)
 	}
@@ -773,7 +773,7 @@ func TestTeam_HandleArchiveTeam(t *testing.T) {
 			rr.WriteHeader(http.StatusAccepted)
 			rr.Write(respBytes)
 
-			HandleArchiveTeam(db).ServeHTTP(rr, req)
+			HandleArchiveTeam(db).ServeHTTP(rr, withCaller(req, "tester"))
 
 			var resp util.Response
 			json.NewDecoder(rr.Body).Decode(&resp)
@@ -796,7 +796,7 @@ func TestTeam_HandleArchiveTeam(t *testing.T) {
 			req := httptest.NewRequest("PATCH", "/api/teams/archive", bytes.NewReader(body))
 			rr := httptest.NewRecorder()
 
-			HandleArchiveTeam(db).ServeHTTP(rr, req)
+			HandleArchiveTeam(db).ServeHTTP(rr, withCaller(req, "tester"))
 
 			var resp map[string]string
 			json.NewDecoder(rr.Body).Decode(&resp)
@@ -836,7 +836,7 @@ func TestTeam_HandleUnArchiveTeam(t *testing.T) {
 			req := httptest.NewRequest("PATCH", "/api/v1/teams/unarchive", bytes.NewReader(body))
 			rr := httptest.NewRecorder()
 
-			HandleUnArchiveTeam(db).ServeHTTP(rr, req)
+			HandleUnArchiveTeam(db).ServeHTTP(rr, withCaller(req, "tester"))
 
 			var response util.Response
 			json.NewDecoder(rr.Body).Decode(&response)
@@ -859,7 +859,7 @@ func TestTeam_HandleUnArchiveTeam(t *testing.T) {
 			req := httptest.NewRequest("PATCH", "/api/v1/teams/unarchive", bytes.NewReader(body))
 			rr := httptest.NewRecorder()
 
-			HandleUnArchiveTeam(db).ServeHTTP(rr, req)
+			HandleUnArchiveTeam(db).ServeHTTP(rr, withCaller(req, "tester"))
 
 			var response map[string]string
 			json.NewDecoder(rr.Body).Decode(&response)
@@ -905,7 +905,7 @@ func TestTeam_HandleAddNewMember(t *testing.T) {
 			req := httptest.NewRequest("PATCH", "/api/v1/teams/add-member", bytes.NewReader(bdy))
 			rr := httptest.NewRecorder()
 
-			HandleAddNewMembers(db).ServeHTTP(rr, req)
+			HandleAddNewMembers(db).ServeHTTP(rr, withCaller(req, "tester"))
 
 			var resp util.Response
 			json.NewDecoder(rr.Body).Decode(&resp)
@@ -941,7 +941,7 @@ func TestTeam_HandleAddNewMember(t *testing.T) {
 
 			rr := httptest.NewRecorder()
 
-			HandleAddNewMembers(db).ServeHTTP(rr, req)
+			HandleAddNewMembers(db).ServeHTTP(rr, withCaller(req, "tester"))
 
 			var resp map[string]string
 			json.NewDecoder(rr.Body).Decode(&resp)
@@ -0,0 +1,168 @@
+package panelAdmins
+
+import (
+	"context"
+	"control-panel-bk/config"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// signOutUser revokes the sessions of the user in the user pool, a seam for the tests
+var signOutUser = func(email string) error {
+	return aws.SignOutUser(config.AwsConfig, email)
+}
+
+// roleHolder is the part of a user document a role change reads, the email is the username in the user pool
+type roleHolder struct {
+	Email             string     `json:"email"`
+	RoleId            string     `json:"role_id"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+	UpdatedBy         string     `json:"updated_by"`
+	SessionsRevokedAt *time.Time `json:"sessions_revoked_at"`
+}
+
+// ChangeUserRole gives the user another role. The role is changed in mongo, then the custom:role and the role group of
+// the user in the user pool are pointed at it, and both sides are put back when the user pool cannot be updated. The sessions of the user are
+// revoked along with the change so the new permissions apply on the next request: sessions_revoked_at refuses the
+// access tokens already issued and the sign-out in the user pool refuses the refresh tokens.
+func ChangeUserRole(userId string, roleId string, actor string, ctx context.Context, db *mongo.Database) (*User, error, int) {
+	objID, err := util.GetPrimitiveID(userId)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user id %q", userId), http.StatusBadRequest
+	}
+
+	var user roleHolder
+	if err := db.Collection("users").FindOne(ctx, bson.M{"_id": objID}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("no user record was found"), http.StatusNotFound
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	if user.RoleId == roleId {
+		return nil, errors.New("the user already holds the role"), http.StatusBadRequest
+	}
+
+	if _, err, code := assignableRole(roleId, ctx, db); err != nil {
+		return nil, err, code
+	}
+
+	revokedAt := time.Now().UTC()
+
+	// The previous role is part of the filter, a role changed by someone else meanwhile is not overwritten
+	filter := bson.M{"_id": objID, "role_id": user.RoleId}
+	update := bson.M{
+		"$set": bson.M{
+			"role_id":             roleId,
+			"sessions_revoked_at": revokedAt,
+			"updated_at":          revokedAt,
+			"updated_by":          actor,
+		},
+	}
+
+	var updated User
+	opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := db.Collection("users").FindOneAndUpdate(ctx, filter, update, opt).Decode(&updated); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, errors.New("the role of the user was changed meanwhile, fetch the user and try again"), http.StatusConflict
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	if err := updateCognitoRole(user.Email, roleId); err != nil {
+		revertUserRole(ctx, db, objID, &user, roleId)
+		return nil, fmt.Errorf("unable to update the role of %s in the user pool, the role was put back: %w", user.Email, err), http.StatusBadGateway
+	}
+
+	// The role is changed on both sides by now and sessions_revoked_at already refuses the access tokens, a failed
+	// sign-out leaves the refresh tokens working but the tokens they issue carry the new role
+	if err := signOutUser(user.Email); err != nil {
+		log.Printf("users: unable to sign %s out of the user pool: %s", user.Email, err.Error())
+	}
+
+	return &updated, nil, http.StatusOK
+}
+
+// revertUserRole is the compensation of a role change the user pool refused. The custom:role may be changed already
+// and the user added to the group of the new role, the user pool is pointed back at the role the user had. In mongo
+// the user gets back the role and the sessions_revoked_at it had.
+func revertUserRole(ctx context.Context, db *mongo.Database, objID *bson.ObjectID, user *roleHolder, roleId string) {
+	if err := updateCognitoRole(user.Email, user.RoleId); err != nil {
+		log.Printf("users: unable to point %s back at the role %s in the user pool, run cognito-sync: %s", user.Email, user.RoleId, err.Error())
+	}
+
+	revert := bson.M{"$set": bson.M{"role_id": user.RoleId, "updated_at": user.UpdatedAt, "updated_by": user.UpdatedBy}}
+	if user.SessionsRevokedAt != nil {
+		revert["$set"].(bson.M)["sessions_revoked_at"] = *user.SessionsRevokedAt
+	} else {
+		revert["$unset"] = bson.M{"sessions_revoked_at": ""}
+	}
+
+	if _, err := db.Collection("users").UpdateOne(ctx, bson.M{"_id": objID, "role_id": roleId}, revert); err != nil {
+		log.Printf("users: unable to put the role %s of %s back: %s", user.RoleId, objID.Hex(), err.Error())
+	}
+}
+
+// HandleChangeUserRole takes the id of the user in the path, /users/{user}/role, and {"role_id": "..."} in the body
+func HandleChangeUserRole(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			RoleId string `json:"role_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+		if body.RoleId == "" {
+			util.ErrorException(w, errors.New("role_id is required"), http.StatusBadRequest)
+			return
+		}
+
+		userId := chi.URLParam(r, "user")
+		before := audit.Snapshot(r.Context(), db, "users", userId)
+
+		user, err, code := ChangeUserRole(userId, body.RoleId, actor, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.UserRoleChanged,
+			EntityType: audit.UserEntity,
+			EntityId:   userId,
+			Before:     before,
+			After:      user,
+		})
+		notify.Disconnect(r.Context(), userId, notify.UserRoleChanged, map[string]string{"role_id": body.RoleId})
+
+		respBytes, respErr := util.GetBytesResponse(http.StatusOK, user)
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
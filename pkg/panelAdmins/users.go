@@ -4,6 +4,8 @@ import (
 	"context"
 	"control-panel-bk/config"
 	"control-panel-bk/internal/aws"
+	"control-panel-bk/internal/notify"
+	"control-panel-bk/pkg/audit"
 	"control-panel-bk/util"
 	"encoding/json"
 	"errors"
@@ -12,9 +14,9 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
 	"net/http"
 	"regexp"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -47,6 +49,24 @@ type User struct {
 
 	// The id of the user from the cognito user pool
 	UpId string `json:"up_id,omitempty"`
+
+	// The tokens issued before this time are refused, it is set when the role of the user changes
+	SessionsRevokedAt *time.Time `json:"sessions_revoked_at,omitempty"`
+}
+
+// userFields are the fields /users can be filtered and sorted by, team_id matches the members and the lead of the team
+var userFields = util.Fields{
+	"first_name":        {Type: util.StringField, Sortable: true},
+	"last_name":         {Type: util.StringField, Sortable: true},
+	"email":             {Type: util.StringField, Sortable: true},
+	"role_id":           {Type: util.StringField},
+	"team_id":           {Type: util.ObjectIDField},
+	"is_active":         {Type: util.BoolField},
+	"archive_status":    {Type: util.BoolField},
+	"is_deleted_status": {Type: util.BoolField},
+	"created_by":        {Type: util.StringField},
+	"created_at":        {Type: util.TimeField, Sortable: true},
+	"updated_at":        {Type: util.TimeField, Sortable: true},
 }
 
 type NewUser struct {
@@ -73,6 +93,13 @@ func DeActiveUser(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		u.UpdatedBy = actor
+
 		if !u.IsActive {
 			util.ErrorException(w, errors.New("user is currently deactivated"), http.StatusBadRequest)
 			return
@@ -89,6 +116,8 @@ func DeActiveUser(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "users", u.ID)
+
 		filter := bson.M{"_id": userID}
 		update := bson.M{
 			"$set": bson.M{
@@ -105,6 +134,15 @@ func DeActiveUser(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.UserDeactivated,
+			EntityType: audit.UserEntity,
+			EntityId:   userID.Hex(),
+			Before:     before,
+			After:      u,
+		})
+		notify.Disconnect(r.Context(), userID.Hex(), notify.UserDeactivated, u)
+
 		respBytes, respErr := util.GetBytesResponse(http.StatusOK, u)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -127,6 +165,13 @@ func ActiveUser(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		u.UpdatedBy = actor
+
 		if u.IsActive {
 			util.ErrorException(w, errors.New("user is currently active"), http.StatusBadRequest)
 			return
@@ -143,6 +188,8 @@ func ActiveUser(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		before := audit.Snapshot(r.Context(), db, "users", u.ID)
+
 		filter := bson.M{"_id": userID}
 		update := bson.M{
 			"$set": bson.M{
@@ -158,6 +205,15 @@ func ActiveUser(db *mongo.Database) http.HandlerFunc {
 			return
 		}
 
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.UserActivated,
+			EntityType: audit.UserEntity,
+			EntityId:   userID.Hex(),
+			Before:     before,
+			After:      u,
+		})
+		notify.Publish(r.Context(), notify.ToUser(userID.Hex()), notify.UserActivated, u)
+
 		respBytes, respErr := util.GetBytesResponse(http.StatusOK, u)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -170,138 +226,199 @@ func ActiveUser(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
-func CreateUser(client *mongo.Client) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		defer r.Body.Close()
+// userDocument is the document a new user is stored as, the personal fields are at the top of it
+func userDocument(newUser NewUser, upId *string) bson.M {
+	return bson.M{
+		"first_name":        newUser.FirstName,
+		"last_name":         newUser.LastName,
+		"full_name":         strings.Join([]string{newUser.FirstName, newUser.LastName}, " "),
+		"email":             newUser.Email,
+		"phone_num":         newUser.Phone,
+		"gender":            newUser.Gender,
+		"dob":               newUser.Dob,
+		"created_at":        time.Now(),
+		"updated_at":        time.Now(),
+		"role_id":           newUser.RoleId,
+		"up_id":             upId,
+		"is_active":         false, // Will be set to true when user changes passwords
+		"archive_status":    false,
+		"is_deleted_status": false,
+		"created_by":        newUser.CreatedBy,
+		"updated_by":        newUser.UpdatedBy,
+	}
+}
 
-		var newUser NewUser
-		if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
-			util.ErrorException(w, err, http.StatusInternalServerError)
-			return
+// dropCreatedRoleGroups removes the groups of the roles createUser created and did not keep
+func dropCreatedRoleGroups(roleIds []string) {
+	for _, roleId := range roleIds {
+		if err := dropRoleGroup(roleId); err != nil {
+			log.Printf("users: unable to remove the group of the role %s of a user not created: %s", roleId, err.Error())
 		}
+	}
+}
 
-		session, err := getSession(client)
+// createUser creates the role of the user when no role id is given, the user in the user pool, their record in mongo
+// and adds them to their team. The mongo writes are one transaction, when a step fails none of them is kept and the
+// user pool user and the groups of the roles created are removed again.
+func createUser(ctx context.Context, client *mongo.Client, newUser NewUser, actor string) (*User, string, error, int) {
+	newUser.CreatedBy = actor
+	newUser.UpdatedBy = actor
+	newUser.Role.CreatedBy = actor
+	newUser.Role.UpdatedBy = actor
 
-		if err != nil {
-			util.ErrorException(w, fmt.Errorf("failed to start session %w", err), http.StatusInternalServerError)
-			return
-		}
+	session, err := getSession(client)
 
-		defer session.EndSession(r.Context())
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start session %w", err), http.StatusInternalServerError
+	}
 
-		db := session.Client().Database("flowCx")
+	defer session.EndSession(ctx)
 
-		col := db.Collection("users")
-		tmCol := db.Collection("teams")
+	db := session.Client().Database("flowCx")
 
-		// Steps in creating a new user (transactional operation in mongo)
-		err = mongo.WithSession(r.Context(), session, func(ctx context.Context) error {
+	col := db.Collection("users")
+	tmCol := db.Collection("teams")
 
-			// STEP 1: CHECK IF THE ROLE ID (if the roleId is not provided then we need to create a new role for the user)
-			if newUser.RoleId == "" {
-				// Create a role and assign it to the newUser.RoleId
-				crl := newUser.Role
-				if rl, err := CreateRole(crl, r.Context(), db); err != nil {
-					return fmt.Errorf("failed to create the user's role")
-				} else {
-					newUser.RoleId = rl.Data.InsertedID.(bson.ObjectID).Hex()
-				}
-			}
+	// The transaction runs again on a transient error, the user pool user is created by the first run only. Each run
+	// creates the role and its group again, only the role of the last run is kept when the transaction commits.
+	var upId *string
+	var createdRoles []string
+	code := http.StatusInternalServerError
+
+	created, err := session.WithTransaction(ctx, func(sessionCtx context.Context) (interface{}, error) {
+		roleId := newUser.RoleId
 
-			// STEP 2: CREATE THE USER IN COGNITO USER POOL
-			userId, outputErr := aws.CreateNewUser(config.AwsConfig, newUser.Email, newUser.RoleId, util.DefaultPassword)
+		// STEP 1: CHECK IF THE ROLE ID (if the roleId is not provided then we need to create a new role for the user)
+		if roleId == "" {
+			rl, err := CreateRole(newUser.Role, sessionCtx, db)
+			if err != nil {
+				code = createRoleError(err)
+				return nil, fmt.Errorf("failed to create the user's role: %w", err)
+			}
+			roleId = rl.Data.InsertedID.(bson.ObjectID).Hex()
+			createdRoles = append(createdRoles, roleId)
+		}
 
+		// STEP 2: CREATE THE USER IN COGNITO USER POOL
+		if upId == nil {
+			userId, outputErr := aws.CreateNewUser(config.AwsConfig, newUser.Email, roleId, util.DefaultPassword)
 			if outputErr != nil {
-				return fmt.Errorf("failed to create a user in the userpool")
+				return nil, fmt.Errorf("failed to create a user in the userpool")
 			}
+			upId = userId
+		} else if err := aws.UpdateUserRole(config.AwsConfig, newUser.Email, roleId); err != nil {
+			return nil, fmt.Errorf("failed to update the role of the user in the userpool %w", err)
+		}
 
-			// STEP 3: CREATE THE USER IN A MONGO "users" COLLECTION WITH THE USER ID FROM THE USER POOL IN THE STUB
-			doc, docErr := col.InsertOne(r.Context(), bson.M{
-				"first_name":        newUser.FirstName,
-				"last_name":         newUser.LastName,
-				"full_name":         strings.Join([]string{newUser.FirstName, newUser.LastName}, " "),
-				"email":             newUser.Email,
-				"phone_num":         newUser.Phone,
-				"gender":            newUser.Gender,
-				"dob":               newUser.Dob,
-				"created_at":        time.Now(),
-				"updated_at":        time.Now(),
-				"role_id":           newUser.RoleId,
-				"up_id":             userId,
-				"is_active":         false, // Will be set to true when user changes passwords
-				"archive_status":    false,
-				"is_deleted_status": false,
-				"created_by":        newUser.CreatedBy,
-				"updated_by":        newUser.UpdatedBy,
-			})
+		// The user joins the group of the role, the role the cognito:groups claim of their tokens carries
+		if err := moveToRoleGroup(newUser.Email, roleId); err != nil {
+			return nil, fmt.Errorf("failed to add the user to the group of the role %w", err)
+		}
 
-			if docErr != nil {
-				return fmt.Errorf("failed to insert the user document in the users collection %w", docErr)
-			}
+		// STEP 3: CREATE THE USER IN A MONGO "users" COLLECTION WITH THE USER ID FROM THE USER POOL IN THE STUB
+		user := newUser
+		user.RoleId = roleId
+		doc, docErr := col.InsertOne(sessionCtx, userDocument(user, upId))
 
-			userID := doc.InsertedID.(bson.ObjectID).Hex() //doc.InsertedID.(string)
+		if docErr != nil {
+			return nil, fmt.Errorf("failed to insert the user document in the users collection %w", docErr)
+		}
 
-			// STEP 4: ADD THE USER ID FROM THE "teams" COLLECTION into the team he was added to if such was provided
-			var team Team
-			isAssignToTeam := len(newUser.teamId) > 0 || false
+		userID := doc.InsertedID.(bson.ObjectID).Hex()
 
-			if isAssignToTeam {
-				teamID, teamErr := util.GetPrimitiveID(newUser.teamId)
+		// STEP 4: ADD THE USER ID FROM THE "teams" COLLECTION into the team he was added to if such was provided
+		var team Team
 
-				if teamErr != nil {
-					return teamErr
-				}
+		if len(newUser.teamId) > 0 {
+			teamID, teamErr := util.GetPrimitiveID(newUser.teamId)
 
-				e := tmCol.FindOne(r.Context(), bson.M{"_id": teamID}).Decode(&team)
+			if teamErr != nil {
+				return nil, teamErr
+			}
+
+			if e := tmCol.FindOne(sessionCtx, bson.M{"_id": teamID}).Decode(&team); e != nil {
+				return nil, e
+			}
 
-				if e != nil {
-					if errors.Is(e, mongo.ErrNoDocuments) {
-						return mongo.ErrNoDocuments
-					}
+			team.UpdatedBy = actor
 
-					return e
+			// Add user to the team and update it
+			if newUser.IsTeamLead {
+				// The changeTeamLead will add the userId as a member of the team if he is not a member
+				if _, tlErr, _ := team.ChangeTeamLead(userID, team.TeamLead, teamID, db, sessionCtx); tlErr != nil {
+					return nil, tlErr
 				}
-
-				// Add user to the team and update it
-				if newUser.IsTeamLead {
-					// The changeTeamLead will add the userId as a member of the team if he is not a member
-					_, tlErr, _ := team.ChangeTeamLead(userID, team.TeamLead, teamID, db, r.Context())
-					if tlErr != nil {
-						return tlErr
-					}
-				} else {
-					mbr := []string{userID}
-					if _, e, _ := team.AddNewTeamMember(mbr, teamID, db, r.Context()); e != nil {
-						return e
-					}
+			} else {
+				if _, e, _ := team.AddNewTeamMember([]string{userID}, teamID, db, sessionCtx); e != nil {
+					return nil, e
 				}
 			}
+		}
 
-			return nil
+		return userID, nil
+	})
 
-		})
+	if err != nil {
+		// The mongo writes were rolled back, the groups of the roles created with them are removed and the user pool
+		// user is deleted
+		dropCreatedRoleGroups(createdRoles)
 
-		if err != nil {
-			// cognito roll back
+		if upId != nil {
 			if _, aErr := aws.DeleteUser(config.AwsConfig, newUser.Email); aErr != nil {
-				util.ErrorException(w, aErr, http.StatusNotImplemented)
-				return
+				return nil, "", aErr, http.StatusNotImplemented
 			}
+		}
+
+		return nil, "", err, code
+	}
+
+	// The roles of the runs rolled back before the last one are gone, their groups are too
+	if len(createdRoles) > 1 {
+		dropCreatedRoleGroups(createdRoles[:len(createdRoles)-1])
+	}
+
+	userID := created.(string)
 
+	// We will need to find the user by email
+	var user User
+
+	findErr := col.FindOne(ctx, bson.M{"email": newUser.Email}).Decode(&user)
+
+	if findErr != nil {
+		return nil, "", findErr, http.StatusNotFound
+	}
+
+	return &user, userID, nil, http.StatusCreated
+}
+
+func CreateUser(client *mongo.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var newUser NewUser
+		if err := json.NewDecoder(r.Body).Decode(&newUser); err != nil {
 			util.ErrorException(w, err, http.StatusInternalServerError)
 			return
 		}
 
-		// We will need to find the user by email
-		var user User
-
-		findErr := col.FindOne(r.Context(), bson.M{"email": newUser.Email}).Decode(user)
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
 
-		if findErr != nil {
-			util.ErrorException(w, findErr, http.StatusNotFound)
+		user, userID, err, code := createUser(r.Context(), client, newUser, actor)
+		if err != nil {
+			util.ErrorException(w, err, code)
 			return
 		}
 
+		audit.Record(r.Context(), client.Database("flowCx"), audit.Event{
+			Action:     audit.UserCreated,
+			EntityType: audit.UserEntity,
+			EntityId:   userID,
+			After:      user,
+		})
+
 		respBy, respErr := util.GetBytesResponse(http.StatusCreated, user)
 		if respErr != nil {
 			util.ErrorException(w, respErr, http.StatusInternalServerError)
@@ -314,59 +431,88 @@ func CreateUser(client *mongo.Client) http.HandlerFunc {
 	}
 }
 
-func GetUsers(db *mongo.Database) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-		var users []User
-		var limit int64
-		var skip int64
+// teamMembership turns a team_id condition into one on the ids of the users in the teams
+func teamMembership(c util.Condition, ctx context.Context, db *mongo.Database) (util.Condition, error) {
+	teamIds, ok := c.Value.(bson.A)
+	if !ok {
+		teamIds = bson.A{c.Value}
+	}
 
-		var page int
+	cursor, err := db.Collection("teams").Find(ctx, bson.M{"_id": bson.M{"$in": teamIds}})
+	if err != nil {
+		return c, err
+	}
 
-		query := request.URL.Query()
+	var teams []Team
+	if err := cursor.All(ctx, &teams); err != nil {
+		return c, err
+	}
 
-		if len(query.Get("page")) > 0 {
-			pg, pgErr := strconv.Atoi(query.Get("page"))
-			if pgErr != nil {
-				util.ErrorException(writer, pgErr, http.StatusInternalServerError)
-				return
+	userIds := bson.A{}
+	for _, team := range teams {
+		for _, member := range append([]string{team.TeamLead}, team.TeamMember...) {
+			if id, err := bson.ObjectIDFromHex(member); err == nil {
+				userIds = append(userIds, id)
 			}
-			page = pg
-		} else {
-			page = 1
 		}
+	}
 
-		if len(query.Get("limit")) > 0 {
-			lmt, lmtErr := strconv.Atoi(query.Get("limit"))
-			if lmtErr != nil {
-				util.ErrorException(writer, lmtErr, http.StatusInternalServerError)
-				return
-			}
-			limit = int64(lmt)
-		} else {
-			limit = int64(MAX_LIMIT)
-		}
+	membership := util.Condition{Field: "_id", Path: "_id", Op: util.In, Value: userIds}
+	if c.Op == util.Ne || c.Op == util.Nin {
+		membership.Op = util.Nin
+	}
+
+	return membership, nil
+}
 
-		if page > 1 {
-			skip = int64(page - 1)
-		} else {
-			skip = int64(0)
+// usersFilter compiles the query of a users list, with the team_id conditions turned into ones on the members
+func usersFilter(query util.ListQuery, ctx context.Context, db *mongo.Database) (bson.M, error) {
+	for _, c := range query.Take("team_id") {
+		membership, err := teamMembership(c, ctx, db)
+		if err != nil {
+			return nil, err
 		}
+		query.Conditions = append(query.Conditions, membership)
+	}
+
+	return query.Filter(), nil
+}
 
-		opts := options.Find().SetLimit(limit).SetSkip(skip).SetSort(bson.M{"created_at": -1})
-		coll, err := db.Collection("users").Find(request.Context(), bson.M{}, opts)
+// FetchUsers lists the users of the view matching the query a page at a time, newest first unless the query is sorted
+func FetchUsers(req util.PageRequest, query util.ListQuery, ctx context.Context, db *mongo.Database) (*util.Page[User], error, int) {
+	req.Sort = query.Sort
 
-		if err != nil {
-			if errors.Is(err, mongo.ErrNoDocuments) {
-				util.ErrorException(writer, mongo.ErrNoDocuments, http.StatusOK)
-				return
-			}
+	filter, err := usersFilter(query, ctx, db)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	page, err := util.Paginate[User](ctx, db.Collection("users"), filter, req)
+	if err != nil {
+		return nil, err, util.PageErrorStatus(err)
+	}
 
-			util.ErrorException(writer, err, http.StatusNotImplemented)
+	return page, nil, http.StatusOK
+}
+
+// GetUsers takes the query params view, cursor, page, limit, filter[field][op] and sort
+func GetUsers(db *mongo.Database) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		req, reqErr := util.ParsePageRequest(request, MAX_LIMIT)
+		if reqErr != nil {
+			util.ErrorException(writer, reqErr, http.StatusBadRequest)
+			return
+		}
+
+		query, queryErr := util.ParseListQuery(request, userFields)
+		if queryErr != nil {
+			util.ErrorException(writer, queryErr, http.StatusBadRequest)
 			return
 		}
 
-		if err := coll.All(context.TODO(), &users); err != nil {
-			util.ErrorException(writer, err, http.StatusNotImplemented)
+		users, err, code := FetchUsers(req, query, request.Context(), db)
+		if err != nil {
+			util.ErrorException(writer, err, code)
 			return
 		}
 
@@ -383,9 +529,17 @@ func GetUsers(db *mongo.Database) http.HandlerFunc {
 	}
 }
 
+// GetUser takes the query param view, the users outside of the view are not found
 func GetUser(db *mongo.Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var users []User
+
+		view, viewErr := util.ParseView(r)
+		if viewErr != nil {
+			util.ErrorException(w, viewErr, http.StatusBadRequest)
+			return
+		}
+
 		user := chi.URLParam(r, "user") // The userId can be a name i.e (firstName, lastName, combination of both, email, or id)
 
 		isObjId, err := regexp.Match("^[a-f0-9]{24}$", []byte(user))                                                                                // Checking id the user params is of mongo ID
@@ -411,7 +565,7 @@ func GetUser(db *mongo.Database) http.HandlerFunc {
 
 			var u User
 
-			fil := bson.M{"_id": objID}
+			fil := view.Within(bson.M{"_id": objID})
 			opt := options.FindOne().SetSort(bson.M{"created_at": -1})
 
 			if err := db.Collection("users").FindOne(r.Context(), fil, opt).Decode(&u); err != nil {
@@ -429,11 +583,11 @@ func GetUser(db *mongo.Database) http.HandlerFunc {
 
 		if isNotObjId {
 
-			filter := bson.M{
+			filter := view.Within(bson.M{
 				"$text": bson.M{
 					"$search": user,
 				},
-			}
+			})
 
 			opt := options.Find().SetLimit(50).SetAllowPartialResults(true)
 
@@ -0,0 +1,550 @@
+package panelAdmins
+
+import (
+	"bufio"
+	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"io"
+	"mime"
+	"net/http"
+	"net/mail"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	// MaxImportRows is the most users an import creates
+	MaxImportRows = 500
+	// MaxImportBytes is the largest file an import reads
+	MaxImportBytes = 5 << 20
+	// ImportBatchSize is the number of users created in parallel, the user pool throttles past a few dozen a second
+	ImportBatchSize = 10
+)
+
+// importColumns are the columns of an import, name is split into the first and last name when they are not given.
+// The role and the team are a name or an id.
+var importColumns = []string{"first_name", "last_name", "name", "email", "phone", "gender", "role", "team", "is_team_lead"}
+
+var importPhone = regexp.MustCompile(`^\+?[0-9 ()-]{7,20}$`)
+
+type ImportStatus string
+
+const (
+	ImportValid   ImportStatus = "valid"
+	ImportInvalid ImportStatus = "invalid"
+	ImportCreated ImportStatus = "created"
+	ImportFailed  ImportStatus = "failed"
+)
+
+// ImportRow is the outcome of a row of the import, Line is the line of the file it was read from
+type ImportRow struct {
+	Line   int          `json:"line"`
+	Email  string       `json:"email,omitempty"`
+	Status ImportStatus `json:"status"`
+	UserId string       `json:"user_id,omitempty"`
+	Errors []string     `json:"errors,omitempty"`
+}
+
+// ImportReport is the outcome of every row of the import. The invalid rows are never created, the valid ones are
+// unless the import is a dry run.
+type ImportReport struct {
+	DryRun  bool        `json:"dry_run"`
+	Total   int         `json:"total"`
+	Valid   int         `json:"valid"`
+	Invalid int         `json:"invalid"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Rows    []ImportRow `json:"rows"`
+}
+
+// importRecord is a row as read from the file, by column
+type importRecord struct {
+	line   int
+	fields map[string]string
+}
+
+func (r importRecord) get(column string) string {
+	return strings.TrimSpace(r.fields[column])
+}
+
+// importRow is a validated row, the user to create along with the team it is batched by
+type importRow struct {
+	ImportRow
+	user NewUser
+}
+
+// importDirectory is what the rows are validated against
+type importDirectory struct {
+	roles    []Role
+	teams    []Team
+	existing map[string]bool
+}
+
+// importFormat reads the format of the upload from the format query param, then the content type and the extension
+func importFormat(format string, contentType string, filename string) (string, error) {
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(contentType)
+		switch {
+		case mediaType == "text/csv" || path.Ext(filename) == ".csv":
+			format = "csv"
+		case slices.Contains([]string{"application/x-ndjson", "application/jsonl", "application/json-lines"}, mediaType),
+			path.Ext(filename) == ".jsonl", path.Ext(filename) == ".ndjson":
+			format = "jsonl"
+		}
+	}
+
+	switch format {
+	case "csv", "jsonl":
+		return format, nil
+	case "":
+		return "", errors.New("the format of the upload is unknown, send a .csv or .jsonl file or pass format=csv or format=jsonl")
+	default:
+		return "", fmt.Errorf("unsupported format %q, csv or jsonl is expected", format)
+	}
+}
+
+// parseImport reads the rows of the upload by column, the fields missing from a short csv row are empty
+func parseImport(r io.Reader, format string) ([]importRecord, error) {
+	var records []importRecord
+
+	switch format {
+	case "csv":
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the header of the csv: %w", err)
+		}
+
+		columns := make([]string, len(header))
+		for i, column := range header {
+			columns[i] = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(column, "\ufeff")))
+		}
+		if err := checkImportColumns(columns); err != nil {
+			return nil, err
+		}
+
+		for {
+			values, err := reader.Read()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to read the csv: %w", err)
+			}
+
+			line, _ := reader.FieldPos(0)
+			record := importRecord{line: line, fields: map[string]string{}}
+			for i, value := range values {
+				if i < len(columns) {
+					record.fields[columns[i]] = value
+				}
+			}
+			records = append(records, record)
+
+			if len(records) > MaxImportRows {
+				return nil, fmt.Errorf("an import takes at most %d rows", MaxImportRows)
+			}
+		}
+
+	case "jsonl":
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), MaxImportBytes)
+
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+
+			// The numbers are kept as written, a phone number is not turned into a float
+			decoder := json.NewDecoder(strings.NewReader(text))
+			decoder.UseNumber()
+
+			var object map[string]interface{}
+			if err := decoder.Decode(&object); err != nil {
+				return nil, fmt.Errorf("line %d is not a json object: %w", line, err)
+			}
+
+			record := importRecord{line: line, fields: map[string]string{}}
+			columns := []string{}
+			for key, value := range object {
+				column := strings.ToLower(key)
+				columns = append(columns, column)
+				if value != nil {
+					record.fields[column] = fmt.Sprint(value)
+				}
+			}
+			if err := checkImportColumns(columns); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			records = append(records, record)
+
+			if len(records) > MaxImportRows {
+				return nil, fmt.Errorf("an import takes at most %d rows", MaxImportRows)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("unable to read the jsonl: %w", err)
+		}
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("the upload has no rows")
+	}
+	return records, nil
+}
+
+// checkImportColumns refuses the columns an import does not know, a misspelt column would otherwise be left out
+func checkImportColumns(columns []string) error {
+	var unknown []string
+	for _, column := range columns {
+		if !slices.Contains(importColumns, column) {
+			unknown = append(unknown, column)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown column(s) %s, the columns are %s", strings.Join(unknown, ", "), strings.Join(importColumns, ", "))
+	}
+	return nil
+}
+
+// validateImport checks every row before any user is created. An email is taken once across the upload and the
+// users, and a team is led by one row at most.
+func validateImport(records []importRecord, dir importDirectory) []*importRow {
+	rows := make([]*importRow, 0, len(records))
+	emails := map[string]int{}
+	leads := map[string]int{}
+
+	for _, record := range records {
+		row := &importRow{ImportRow: ImportRow{Line: record.line}}
+		invalid := func(format string, args ...interface{}) {
+			row.Errors = append(row.Errors, fmt.Sprintf(format, args...))
+		}
+
+		email := strings.ToLower(record.get("email"))
+		row.Email = email
+		switch address, err := mail.ParseAddress(email); {
+		case email == "":
+			invalid("email is required")
+		case err != nil || address.Address != email:
+			invalid("invalid email %q", email)
+		case emails[email] > 0:
+			invalid("the email is on line %d too", emails[email])
+		case dir.existing[email]:
+			invalid("a user with the email already exists")
+		}
+		if email != "" && emails[email] == 0 {
+			emails[email] = record.line
+		}
+
+		firstName, lastName := record.get("first_name"), record.get("last_name")
+		if firstName == "" && record.get("name") != "" {
+			firstName, lastName, _ = strings.Cut(record.get("name"), " ")
+			lastName = strings.TrimSpace(lastName)
+		}
+		if firstName == "" {
+			invalid("first_name or name is required")
+		}
+
+		phone := record.get("phone")
+		if phone != "" && !importPhone.MatchString(phone) {
+			invalid("invalid phone %q", phone)
+		}
+
+		row.user = NewUser{
+			Personal: Personal{FirstName: firstName, LastName: lastName, Email: email, Phone: phone, Gender: record.get("gender")},
+		}
+
+		if role := record.get("role"); role == "" {
+			invalid("role is required")
+		} else if rl := findImportRole(dir.roles, role); rl == nil {
+			invalid("no role is named or has the id %q", role)
+		} else if rl.ArchiveStatus || rl.IsDeletedStatus {
+			invalid("the role %q is archived or in the bin", role)
+		} else {
+			row.user.RoleId = rl.ID
+		}
+
+		if lead := record.get("is_team_lead"); lead != "" {
+			isLead, err := strconv.ParseBool(lead)
+			if err != nil {
+				invalid("invalid is_team_lead %q, true or false is expected", lead)
+			}
+			row.user.IsTeamLead = isLead
+		}
+
+		if team := record.get("team"); team != "" {
+			if tm := findImportTeam(dir.teams, team); tm == nil {
+				invalid("no team is named or has the id %q", team)
+			} else if tm.ArchiveStatus || tm.DeletedStatus {
+				invalid("the team %q is archived or in the bin", team)
+			} else {
+				row.user.teamId = tm.ID
+			}
+		} else if row.user.IsTeamLead {
+			invalid("a team lead needs a team")
+		}
+
+		if row.user.IsTeamLead && row.user.teamId != "" {
+			if line, led := leads[row.user.teamId]; led {
+				invalid("the team is led by the row on line %d already", line)
+			} else {
+				leads[row.user.teamId] = record.line
+			}
+		}
+
+		row.Status = ImportValid
+		if len(row.Errors) > 0 {
+			row.Status = ImportInvalid
+		}
+		rows = append(rows, row)
+	}
+
+	return rows
+}
+
+func findImportRole(roles []Role, key string) *Role {
+	for i := range roles {
+		if roles[i].ID == key || roles[i].Name == strings.ToLower(key) {
+			return &roles[i]
+		}
+	}
+	return nil
+}
+
+func findImportTeam(teams []Team, key string) *Team {
+	for i := range teams {
+		if teams[i].ID == key || strings.EqualFold(teams[i].Name, key) {
+			return &teams[i]
+		}
+	}
+	return nil
+}
+
+// importBatches groups the valid rows in batches created in parallel. The members of a team are written by reading
+// the team then setting them all, two rows of a team are never in a batch so neither overwrites the other.
+func importBatches(rows []*importRow, size int) [][]*importRow {
+	var batches [][]*importRow
+
+	for _, row := range rows {
+		if row.Status != ImportValid {
+			continue
+		}
+
+		placed := false
+		for i, batch := range batches {
+			if len(batch) >= size {
+				continue
+			}
+			if row.user.teamId != "" && slices.ContainsFunc(batch, func(r *importRow) bool { return r.user.teamId == row.user.teamId }) {
+				continue
+			}
+			batches[i] = append(batch, row)
+			placed = true
+			break
+		}
+
+		if !placed {
+			batches = append(batches, []*importRow{row})
+		}
+	}
+
+	return batches
+}
+
+// runImport creates the users a batch at a time, the rows of a batch in parallel
+func runImport(rows []*importRow, size int, create func(NewUser) (string, error)) {
+	for _, batch := range importBatches(rows, size) {
+		var wg sync.WaitGroup
+		for _, row := range batch {
+			wg.Add(1)
+			go func(row *importRow) {
+				defer wg.Done()
+
+				userId, err := create(row.user)
+				if err != nil {
+					row.Status = ImportFailed
+					row.Errors = append(row.Errors, err.Error())
+					return
+				}
+				row.Status, row.UserId = ImportCreated, userId
+			}(row)
+		}
+		wg.Wait()
+	}
+}
+
+// loadImportDirectory reads the roles, the teams and the users already holding an email of the upload
+func loadImportDirectory(ctx context.Context, db *mongo.Database, records []importRecord) (importDirectory, error) {
+	dir := importDirectory{existing: map[string]bool{}}
+
+	cursor, err := db.Collection("roles").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "archive_status": 1, "is_deleted_status": 1}))
+	if err != nil {
+		return dir, err
+	}
+	if err := cursor.All(ctx, &dir.roles); err != nil {
+		return dir, err
+	}
+
+	cursor, err = db.Collection("teams").Find(ctx, bson.M{}, options.Find().SetProjection(bson.M{"name": 1, "archive_status": 1, "is_deleted_status": 1}))
+	if err != nil {
+		return dir, err
+	}
+	if err := cursor.All(ctx, &dir.teams); err != nil {
+		return dir, err
+	}
+
+	// The emails created before the imports kept the case they were typed in
+	emails := bson.A{}
+	for _, record := range records {
+		if email := record.get("email"); email != "" {
+			emails = append(emails, email, strings.ToLower(email))
+		}
+	}
+
+	var existing []struct {
+		Email string `json:"email"`
+	}
+	cursor, err = db.Collection("users").Find(ctx, bson.M{"email": bson.M{"$in": emails}}, options.Find().SetProjection(bson.M{"email": 1}))
+	if err != nil {
+		return dir, err
+	}
+	if err := cursor.All(ctx, &existing); err != nil {
+		return dir, err
+	}
+	for _, user := range existing {
+		dir.existing[strings.ToLower(user.Email)] = true
+	}
+
+	return dir, nil
+}
+
+// importUpload returns the file of the multipart field "file", or the body itself, along with its format
+func importUpload(r *http.Request) (io.Reader, string, error) {
+	r.Body = http.MaxBytesReader(nil, r.Body, MaxImportBytes)
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		format, err := importFormat(r.URL.Query().Get("format"), r.Header.Get("Content-Type"), "")
+		return r.Body, format, err
+	}
+
+	if err := r.ParseMultipartForm(MaxImportBytes); err != nil {
+		return nil, "", fmt.Errorf("unable to read the upload: %w", err)
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", errors.New("the upload is expected in the multipart field file")
+	}
+
+	format, err := importFormat(r.URL.Query().Get("format"), header.Header.Get("Content-Type"), header.Filename)
+	return file, format, err
+}
+
+// HandleImportUsers takes a csv or jsonl upload, in the multipart field file or as the body, and the query params
+// format (csv or jsonl, read from the upload otherwise) and dry_run. Every row is validated before any user is created,
+// the invalid ones are reported and skipped.
+func HandleImportUsers(client *mongo.Client) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		dryRun := false
+		if value := r.URL.Query().Get("dry_run"); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				util.ErrorException(w, fmt.Errorf("invalid dry_run %q, true or false is expected", value), http.StatusBadRequest)
+				return
+			}
+			dryRun = parsed
+		}
+
+		upload, format, err := importUpload(r)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		records, err := parseImport(upload, format)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		db := client.Database("flowCx")
+
+		dir, err := loadImportDirectory(r.Context(), db, records)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
+
+		rows := validateImport(records, dir)
+
+		if !dryRun {
+			runImport(rows, ImportBatchSize, func(newUser NewUser) (string, error) {
+				user, userID, err, _ := createUser(r.Context(), client, newUser, actor)
+				if err != nil {
+					return "", err
+				}
+
+				audit.Record(r.Context(), db, audit.Event{
+					Action:     audit.UserCreated,
+					EntityType: audit.UserEntity,
+					EntityId:   userID,
+					After:      user,
+				})
+				return userID, nil
+			})
+		}
+
+		report := ImportReport{DryRun: dryRun, Total: len(rows), Rows: make([]ImportRow, 0, len(rows))}
+		for _, row := range rows {
+			switch row.Status {
+			case ImportValid:
+				report.Valid++
+			case ImportInvalid:
+				report.Invalid++
+			case ImportCreated:
+				report.Valid++
+				report.Created++
+			case ImportFailed:
+				report.Valid++
+				report.Failed++
+			}
+			report.Rows = append(report.Rows, row.ImportRow)
+		}
+
+		respBytes, respErr := util.GetBytesResponse(http.StatusOK, report)
+		if respErr != nil {
+			util.ErrorException(w, respErr, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write(respBytes)
+	}
+}
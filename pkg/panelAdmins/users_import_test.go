@@ -0,0 +1,176 @@
+package panelAdmins
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportFormat(t *testing.T) {
+	cases := map[string][3]string{
+		"csv":   {"", "text/csv; charset=utf-8", ""},
+		"jsonl": {"", "application/octet-stream", "support.jsonl"},
+	}
+	for want, in := range cases {
+		format, err := importFormat(in[0], in[1], in[2])
+		require.NoError(t, err)
+		assert.Equal(t, want, format)
+	}
+
+	format, err := importFormat("jsonl", "text/csv", "team.csv")
+	require.NoError(t, err)
+	assert.Equal(t, "jsonl", format, "the format query param wins")
+
+	_, err = importFormat("", "application/octet-stream", "team.xlsx")
+	assert.ErrorContains(t, err, "unknown")
+	_, err = importFormat("xml", "", "")
+	assert.ErrorContains(t, err, "unsupported format")
+}
+
+func TestParseImport(t *testing.T) {
+	records, err := parseImport(strings.NewReader("\ufeffName,Email,Role,Team,is_team_lead\nAda Lovelace,ada@flowcx.io,support,tier 1,true\nBob,bob@flowcx.io\n"), "csv")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, 2, records[0].line)
+	assert.Equal(t, "Ada Lovelace", records[0].get("name"))
+	assert.Equal(t, "true", records[0].get("is_team_lead"))
+	assert.Equal(t, "", records[1].get("role"), "the fields missing from a short row are empty")
+
+	records, err = parseImport(strings.NewReader(`{"email": "ada@flowcx.io", "phone": 2348012345678, "is_team_lead": false}`+"\n\n"+`{"email": "bob@flowcx.io"}`), "jsonl")
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, "2348012345678", records[0].get("phone"), "a number is kept as written")
+	assert.Equal(t, "false", records[0].get("is_team_lead"))
+	assert.Equal(t, 3, records[1].line)
+
+	_, err = parseImport(strings.NewReader("email,e-mail\n"), "csv")
+	assert.ErrorContains(t, err, "unknown column(s) e-mail")
+
+	_, err = parseImport(strings.NewReader("email,role\n"), "csv")
+	assert.ErrorContains(t, err, "no rows")
+
+	_, err = parseImport(strings.NewReader("{\"email\": \"ada@flowcx.io\"}\nnot json\n"), "jsonl")
+	assert.ErrorContains(t, err, "line 2")
+}
+
+func TestValidateImport(t *testing.T) {
+	dir := importDirectory{
+		roles: []Role{
+			{ID: "67db3402d08dedc2e44081bb", Name: "support"},
+			{ID: "67db3402d08dedc2e44081bc", Name: "retired", ArchiveStatus: true},
+		},
+		teams: []Team{
+			{ID: "67db3402d08dedc2e44081c1", Name: "Tier 1"},
+			{ID: "67db3402d08dedc2e44081c2", Name: "Night shift", DeletedStatus: true},
+		},
+		existing: map[string]bool{"eve@flowcx.io": true},
+	}
+
+	record := func(line int, fields map[string]string) importRecord {
+		return importRecord{line: line, fields: fields}
+	}
+
+	rows := validateImport([]importRecord{
+		record(2, map[string]string{"name": "Ada King Lovelace", "email": "Ada@FlowCX.io", "phone": "+234 801 234 5678", "role": "Support", "team": "tier 1", "is_team_lead": "true"}),
+		record(3, map[string]string{"first_name": "Bob", "email": "bob@flowcx.io", "role": "67db3402d08dedc2e44081bb", "team": "67db3402d08dedc2e44081c1", "is_team_lead": "yes"}),
+		record(4, map[string]string{"first_name": "Cy", "email": "ada@flowcx.io", "role": "retired", "team": "night shift"}),
+		record(5, map[string]string{"email": "eve@flowcx.io", "role": "ghost", "phone": "call me", "is_team_lead": "true"}),
+		record(6, map[string]string{"first_name": "Dee", "email": "not-an-email", "role": "support", "team": "tier 1", "is_team_lead": "1"}),
+	}, dir)
+
+	require.Len(t, rows, 5)
+
+	ada := rows[0]
+	assert.Equal(t, ImportValid, ada.Status, ada.Errors)
+	assert.Equal(t, "ada@flowcx.io", ada.Email)
+	assert.Equal(t, "Ada", ada.user.FirstName)
+	assert.Equal(t, "King Lovelace", ada.user.LastName)
+	assert.Equal(t, "67db3402d08dedc2e44081bb", ada.user.RoleId)
+	assert.Equal(t, "67db3402d08dedc2e44081c1", ada.user.teamId)
+	assert.True(t, ada.user.IsTeamLead)
+
+	assert.Equal(t, []string{`invalid is_team_lead "yes", true or false is expected`}, rows[1].Errors)
+
+	assert.Equal(t, []string{
+		"the email is on line 2 too",
+		`the role "retired" is archived or in the bin`,
+		`the team "night shift" is archived or in the bin`,
+	}, rows[2].Errors)
+
+	assert.Equal(t, []string{
+		"a user with the email already exists",
+		"first_name or name is required",
+		`invalid phone "call me"`,
+		`no role is named or has the id "ghost"`,
+		"a team lead needs a team",
+	}, rows[3].Errors)
+
+	assert.Equal(t, []string{`invalid email "not-an-email"`, "the team is led by the row on line 2 already"}, rows[4].Errors)
+	for _, row := range rows[1:] {
+		assert.Equal(t, ImportInvalid, row.Status)
+	}
+}
+
+func TestImportBatches(t *testing.T) {
+	row := func(email string, team string, status ImportStatus) *importRow {
+		r := &importRow{ImportRow: ImportRow{Email: email, Status: status}}
+		r.user.teamId = team
+		return r
+	}
+
+	rows := []*importRow{
+		row("a", "t1", ImportValid),
+		row("b", "t1", ImportValid),
+		row("c", "", ImportValid),
+		row("d", "t2", ImportInvalid),
+		row("e", "", ImportValid),
+		row("f", "t1", ImportValid),
+	}
+
+	emails := func(batches [][]*importRow) [][]string {
+		out := [][]string{}
+		for _, batch := range batches {
+			b := []string{}
+			for _, r := range batch {
+				b = append(b, r.Email)
+			}
+			out = append(out, b)
+		}
+		return out
+	}
+
+	assert.Equal(t, [][]string{{"a", "c"}, {"b", "e"}, {"f"}}, emails(importBatches(rows, 2)), "two rows of a team are never in a batch")
+	assert.Equal(t, [][]string{{"a", "c", "e"}, {"b"}, {"f"}}, emails(importBatches(rows, 10)))
+}
+
+func TestRunImport(t *testing.T) {
+	valid := func(email string) *importRow {
+		r := &importRow{ImportRow: ImportRow{Email: email, Status: ImportValid}}
+		r.user.Email = email
+		return r
+	}
+	rows := []*importRow{valid("ada@flowcx.io"), valid("bob@flowcx.io"), {ImportRow: ImportRow{Email: "cy@flowcx.io", Status: ImportInvalid}}}
+
+	var mu sync.Mutex
+	created := []string{}
+	runImport(rows, ImportBatchSize, func(user NewUser) (string, error) {
+		if user.Email == "bob@flowcx.io" {
+			return "", errors.New("failed to create a user in the userpool")
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		created = append(created, user.Email)
+		return "u-" + user.Email, nil
+	})
+
+	assert.Equal(t, []string{"ada@flowcx.io"}, created, "the invalid rows are not created")
+	assert.Equal(t, ImportCreated, rows[0].Status)
+	assert.Equal(t, "u-ada@flowcx.io", rows[0].UserId)
+	assert.Equal(t, ImportFailed, rows[1].Status)
+	assert.Equal(t, []string{"failed to create a user in the userpool"}, rows[1].Errors)
+	assert.Equal(t, ImportInvalid, rows[2].Status)
+}
@@ -1,7 +1,49 @@
 package panelAdmins
 
-import "testing"
+import (
+	"bytes"
+	"control-panel-bk/internal/aws"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// withCaller attaches the verified claims of an authenticated caller to the request
+func withCaller(req *http.Request, actor string) *http.Request {
+	claims := &aws.CognitoClaims{Username: actor, TokenUse: aws.AccessTokenUse}
+	return req.WithContext(aws.ContextWithClaims(req.Context(), claims))
+}
 
 func TestUsers(t *testing.T) {
 
-}
\ No newline at end of file
+}
+
+func TestMutatingHandlers_RequireCaller(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"HandleCreateRole":     HandleCreateRole(nil),
+		"HandleArchiveRole":    HandleArchiveRole(nil),
+		"HandleCreateTeam":     HandleCreateTeam(nil),
+		"HandleArchiveTeam":    HandleArchiveTeam(nil),
+		"PushTeamToBin":        PushTeamToBin(nil),
+		"RestoreTeamFromBin":   RestoreTeamFromBin(nil),
+		"DeActiveUser":         DeActiveUser(nil),
+		"CreateUser":           CreateUser(nil),
+		"HandleChangeUserRole": HandleChangeUserRole(nil),
+		"HandleImportUsers":    HandleImportUsers(nil),
+	}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			body, _ := json.Marshal(map[string]string{"created_by": "mallory", "updated_by": "mallory"})
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusUnauthorized {
+				t.Errorf("expected status %d without an authenticated caller, got %d", http.StatusUnauthorized, rr.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package tenants
+
+import (
+	"context"
+	"control-panel-bk/internal/aws"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+)
+
+func writeResponse(w http.ResponseWriter, code int, data interface{}) {
+	respBytes, err := util.GetBytesResponse(code, data)
+	if err != nil {
+		util.ErrorException(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(respBytes)
+}
+
+func HandleCreateTenant(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var body CTenant
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.CreatedBy = actor
+		body.UpdatedBy = actor
+
+		result, err, code := CreateTenant(body, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		tenantId, _ := result.InsertedID.(bson.ObjectID)
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TenantCreated,
+			EntityType: audit.TenantEntity,
+			EntityId:   tenantId.Hex(),
+			After:      body,
+		})
+
+		writeResponse(w, code, result)
+	}
+}
+
+// HandleFetchTenants takes the query params view, cursor, page, limit and status
+func HandleFetchTenants(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, reqErr := util.ParsePageRequest(r, MAX_LIMIT)
+		if reqErr != nil {
+			util.ErrorException(w, reqErr, http.StatusBadRequest)
+			return
+		}
+
+		view, viewErr := util.ParseView(r)
+		if viewErr != nil {
+			util.ErrorException(w, viewErr, http.StatusBadRequest)
+			return
+		}
+
+		result, err, code := FetchTenants(req, view, Status(r.URL.Query().Get("status")), r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		writeResponse(w, code, result)
+	}
+}
+
+func HandleFetchTenantById(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		result, err, code := FetchTenantById(chi.URLParam(r, "id"), r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		writeResponse(w, code, result)
+	}
+}
+
+func HandleUpdateTenant(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var body Tenant
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		body.UpdatedBy = actor
+
+		before := audit.Snapshot(r.Context(), db, Collection, body.ID)
+
+		result, err, code := body.Update(r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TenantUpdated,
+			EntityType: audit.TenantEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+
+		writeResponse(w, code, result)
+	}
+}
+
+// lifecycleHandler moves the tenant with the _id of the body through its lifecycle. The archive and bin flags are read
+// from the stored tenant rather than the body.
+func lifecycleHandler(db *mongo.Database, action audit.Action, move func(t *Tenant, ctx context.Context, db *mongo.Database) (*Tenant, error, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var body struct {
+			ID string `json:"_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		actor, ok := aws.RequireActor(w, r)
+		if !ok {
+			return
+		}
+
+		tenant, err, code := FetchTenantById(body.ID, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		before := *tenant
+		tenant.UpdatedBy = actor
+
+		result, err, code := move(tenant, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     action,
+			EntityType: audit.TenantEntity,
+			EntityId:   result.ID,
+			Before:     before,
+			After:      result,
+		})
+
+		writeResponse(w, code, result)
+	}
+}
+
+func HandleArchiveTenant(db *mongo.Database) http.HandlerFunc {
+	return lifecycleHandler(db, audit.TenantArchived, (*Tenant).ArchiveTenant)
+}
+
+func HandleUnArchiveTenant(db *mongo.Database) http.HandlerFunc {
+	return lifecycleHandler(db, audit.TenantUnArchived, (*Tenant).UnArchiveTenant)
+}
+
+func HandlePushTenantToBin(db *mongo.Database) http.HandlerFunc {
+	return lifecycleHandler(db, audit.TenantBinned, (*Tenant).PushTenantToBin)
+}
+
+func HandleRestoreTenantFromBin(db *mongo.Database) http.HandlerFunc {
+	return lifecycleHandler(db, audit.TenantRestored, (*Tenant).RestoreTenantFromBin)
+}
+
+func HandleHardDeleteTenant(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var tenant Tenant
+		if err := json.NewDecoder(r.Body).Decode(&tenant); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if _, ok := aws.RequireActor(w, r); !ok {
+			return
+		}
+
+		before := audit.Snapshot(r.Context(), db, Collection, tenant.ID)
+
+		id, err, code := tenant.HardDeleteTenant(r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, code)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TenantDeleted,
+			EntityType: audit.TenantEntity,
+			EntityId:   tenant.ID,
+			Before:     before,
+		})
+
+		writeResponse(w, code, id)
+	}
+}
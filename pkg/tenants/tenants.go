@@ -0,0 +1,477 @@
+package tenants
+
+import (
+	"context"
+	"control-panel-bk/pkg/tiers"
+	"control-panel-bk/util"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"net/http"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
+)
+
+const Collection = "tenants"
+
+const MAX_LIMIT = 50
+
+type Status string
+
+const (
+	StatusTrial     Status = "trial"
+	StatusActive    Status = "active"
+	StatusSuspended Status = "suspended"
+	StatusChurned   Status = "churned"
+)
+
+// nameCollation makes the tenant names unique regardless of their case
+var nameCollation = &options.Collation{Locale: "en", Strength: 2}
+
+func (s Status) Valid() bool {
+	switch s {
+	case StatusTrial, StatusActive, StatusSuspended, StatusChurned:
+		return true
+	default:
+		return false
+	}
+}
+
+// Profile is the organization profile of a tenant
+type Profile struct {
+	LegalName string `json:"legal_name,omitempty"`
+	Industry  string `json:"industry,omitempty"`
+	Website   string `json:"website,omitempty"`
+	Phone     string `json:"phone,omitempty"`
+	Address   string `json:"address,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Size      string `json:"size,omitempty"`
+}
+
+// Tenant is a customer organization
+type Tenant struct {
+	ID              string     `json:"_id"`
+	Name            string     `json:"name"`
+	Profile         Profile    `json:"profile"`
+	ContactEmails   []string   `json:"contact_emails"`
+	PlanCode        string     `json:"plan_code,omitempty"`
+	Status          Status     `json:"status"`
+	AccountManager  string     `json:"account_manager"`
+	AccountTeam     string     `json:"account_team,omitempty"`
+	AdminEmail      string     `json:"admin_email,omitempty"`
+	CreatedBy       string     `json:"created_by"`
+	UpdatedBy       string     `json:"updated_by"`
+	ArchiveStatus   bool       `json:"archive_status"`
+	IsDeletedStatus bool       `json:"is_deleted_status"`
+	DeletedAt       *time.Time `json:"deleted_at,omitempty"`
+	UpdatedAt       time.Time  `json:"updated_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at,omitempty"`
+}
+
+type CTenant struct {
+	Name           string   `json:"name"`
+	Profile        Profile  `json:"profile"`
+	ContactEmails  []string `json:"contact_emails"`
+	PlanCode       string   `json:"plan_code,omitempty"`
+	Status         Status   `json:"status,omitempty"`
+	AccountManager string   `json:"account_manager"`
+	CreatedBy      string   `json:"created_by"`
+	UpdatedBy      string   `json:"updated_by"`
+}
+
+// planExists reports whether the plan code is a plan of the tier mirror
+var planExists = func(ctx context.Context, db *mongo.Database, planCode string) (bool, error) {
+	_, err, code := tiers.GetMirroredTier(planCode, ctx, db)
+	if err != nil {
+		if code == http.StatusNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// accountManagerExists reports whether the account manager is a panel user out of the bin
+var accountManagerExists = func(ctx context.Context, db *mongo.Database, userId string) (bool, error) {
+	objId, err := bson.ObjectIDFromHex(userId)
+	if err != nil {
+		return false, nil
+	}
+
+	count, err := db.Collection("users").CountDocuments(ctx, bson.M{"_id": objId, "is_deleted_status": bson.M{"$ne": true}}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// tenantOnboardings counts the onboardings that refer to the tenant
+var tenantOnboardings = func(ctx context.Context, db *mongo.Database, tenantId string) (int64, error) {
+	return db.Collection("onboardings").CountDocuments(ctx, bson.M{"tenant_id": tenantId})
+}
+
+// normalizeEmails validates the contact emails, they are lowercased and kept once
+func normalizeEmails(emails []string) ([]string, error) {
+	normalized := make([]string, 0, len(emails))
+
+	for _, email := range emails {
+		address, err := mail.ParseAddress(strings.TrimSpace(email))
+		if err != nil {
+			return nil, fmt.Errorf("invalid contact email %q", email)
+		}
+
+		lower := strings.ToLower(address.Address)
+		if !slices.Contains(normalized, lower) {
+			normalized = append(normalized, lower)
+		}
+	}
+
+	return normalized, nil
+}
+
+// validate checks the fields shared by creation and update, a new tenant starts on trial
+func validate(name string, status *Status, emails *[]string, accountManager string) error {
+	if strings.TrimSpace(name) == "" {
+		return errors.New("the tenant's name is required")
+	}
+
+	if *status == "" {
+		*status = StatusTrial
+	}
+
+	if !status.Valid() {
+		return fmt.Errorf("invalid status %q, expected one of trial, active, suspended or churned", *status)
+	}
+
+	if len(*emails) == 0 {
+		return errors.New("at least one contact email is required")
+	}
+
+	normalized, err := normalizeEmails(*emails)
+	if err != nil {
+		return err
+	}
+	*emails = normalized
+
+	if strings.TrimSpace(accountManager) == "" {
+		return errors.New("the tenant's account manager is required")
+	}
+
+	return nil
+}
+
+// Validate checks the tenant to be created, the contact emails are normalized
+func (ct *CTenant) Validate() error {
+	return validate(ct.Name, &ct.Status, &ct.ContactEmails, ct.AccountManager)
+}
+
+// checkPlan verifies the assigned plan code against the tier mirror, a tenant may have no plan yet
+func checkPlan(ctx context.Context, db *mongo.Database, planCode string) (error, int) {
+	if planCode == "" {
+		return nil, http.StatusOK
+	}
+
+	exists, err := planExists(ctx, db, planCode)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	if !exists {
+		return fmt.Errorf("no tier plan with the code %s", planCode), http.StatusBadRequest
+	}
+
+	return nil, http.StatusOK
+}
+
+// checkAccountManager verifies the account manager is a user of the panel
+func checkAccountManager(ctx context.Context, db *mongo.Database, userId string) (error, int) {
+	exists, err := accountManagerExists(ctx, db, userId)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	if !exists {
+		return fmt.Errorf("no user with the id %s can manage the tenant", userId), http.StatusBadRequest
+	}
+
+	return nil, http.StatusOK
+}
+
+// nameTaken reports whether another tenant, other than the one with the id, has the name
+func nameTaken(ctx context.Context, db *mongo.Database, name string, exceptId *bson.ObjectID) (bool, error) {
+	filter := bson.M{"name": strings.TrimSpace(name)}
+	if exceptId != nil {
+		filter["_id"] = bson.M{"$ne": exceptId}
+	}
+
+	count, err := db.Collection(Collection).CountDocuments(ctx, filter, options.Count().SetCollation(nameCollation).SetLimit(1))
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+func CreateTenant(ct CTenant, ctx context.Context, db *mongo.Database) (*mongo.InsertOneResult, error, int) {
+	if err := ct.Validate(); err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+
+	if err, code := checkPlan(ctx, db, ct.PlanCode); err != nil {
+		return nil, err, code
+	}
+
+	if err, code := checkAccountManager(ctx, db, ct.AccountManager); err != nil {
+		return nil, err, code
+	}
+
+	taken, err := nameTaken(ctx, db, ct.Name, nil)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	if taken {
+		return nil, errors.New("a tenant having the same name already exists"), http.StatusConflict
+	}
+
+	doc, err := db.Collection(Collection).InsertOne(ctx, bson.M{
+		"name":              strings.TrimSpace(ct.Name),
+		"profile":           ct.Profile,
+		"contact_emails":    ct.ContactEmails,
+		"plan_code":         ct.PlanCode,
+		"status":            ct.Status,
+		"account_manager":   ct.AccountManager,
+		"created_by":        ct.CreatedBy,
+		"updated_by":        ct.UpdatedBy,
+		"archive_status":    false,
+		"is_deleted_status": false,
+		"created_at":        time.Now().UTC(),
+		"updated_at":        time.Now().UTC(),
+	})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, errors.New("a tenant having the same name already exists"), http.StatusConflict
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	return doc, nil, http.StatusCreated
+}
+
+// FetchTenants lists the tenants of the view, newest first, optionally of a status
+func FetchTenants(req util.PageRequest, view util.View, status Status, ctx context.Context, db *mongo.Database) (*util.Page[Tenant], error, int) {
+	filter := bson.M{}
+	if status != "" {
+		if !status.Valid() {
+			return nil, fmt.Errorf("invalid status %q", status), http.StatusBadRequest
+		}
+		filter["status"] = status
+	}
+
+	page, err := util.Paginate[Tenant](ctx, db.Collection(Collection), view.Within(filter), req)
+	if err != nil {
+		return nil, err, util.PageErrorStatus(err)
+	}
+
+	return page, nil, http.StatusOK
+}
+
+func FetchTenantById(tenantId string, ctx context.Context, db *mongo.Database) (*Tenant, error, int) {
+	objId, err := util.GetPrimitiveID(tenantId)
+	if err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+
+	var tenant Tenant
+	if err := db.Collection(Collection).FindOne(ctx, bson.M{"_id": objId}).Decode(&tenant); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no tenant with the id %s was found", tenantId), http.StatusNotFound
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	return &tenant, nil, http.StatusOK
+}
+
+// Update replaces the profile, contacts, plan, status and account manager of the tenant
+func (t *Tenant) Update(ctx context.Context, db *mongo.Database) (*Tenant, error, int) {
+	if err := validate(t.Name, &t.Status, &t.ContactEmails, t.AccountManager); err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+
+	objId, objErr := util.GetPrimitiveID(t.ID)
+	if objErr != nil {
+		return nil, objErr, http.StatusBadRequest
+	}
+
+	if err, code := checkPlan(ctx, db, t.PlanCode); err != nil {
+		return nil, err, code
+	}
+
+	if err, code := checkAccountManager(ctx, db, t.AccountManager); err != nil {
+		return nil, err, code
+	}
+
+	taken, err := nameTaken(ctx, db, t.Name, objId)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	if taken {
+		return nil, errors.New("a tenant having the same name already exists"), http.StatusConflict
+	}
+
+	return t.set(ctx, db, objId, bson.M{
+		"name":            strings.TrimSpace(t.Name),
+		"profile":         t.Profile,
+		"contact_emails":  t.ContactEmails,
+		"plan_code":       t.PlanCode,
+		"status":          t.Status,
+		"account_manager": t.AccountManager,
+	})
+}
+
+func (t *Tenant) ArchiveTenant(ctx context.Context, db *mongo.Database) (*Tenant, error, int) {
+	if t.ArchiveStatus {
+		return nil, errors.New("tenant is already archived"), http.StatusBadRequest
+	}
+
+	return t.setLifecycle(ctx, db, "archive_status", true)
+}
+
+func (t *Tenant) UnArchiveTenant(ctx context.Context, db *mongo.Database) (*Tenant, error, int) {
+	if !t.ArchiveStatus {
+		return nil, errors.New("tenant is not in the archive catalogue"), http.StatusBadRequest
+	}
+
+	return t.setLifecycle(ctx, db, "archive_status", false)
+}
+
+// PushTenantToBin sends the tenant to the bin, deleted_at is when the purger counts its retention from
+func (t *Tenant) PushTenantToBin(ctx context.Context, db *mongo.Database) (*Tenant, error, int) {
+	if t.IsDeletedStatus {
+		return nil, errors.New("tenant has already been sent to the bin"), http.StatusBadRequest
+	}
+
+	objId, objErr := util.GetPrimitiveID(t.ID)
+	if objErr != nil {
+		return nil, objErr, http.StatusBadRequest
+	}
+
+	return t.set(ctx, db, objId, bson.M{"is_deleted_status": true, "deleted_at": time.Now().UTC()})
+}
+
+func (t *Tenant) RestoreTenantFromBin(ctx context.Context, db *mongo.Database) (*Tenant, error, int) {
+	if !t.IsDeletedStatus {
+		return nil, errors.New("tenant cannot be restored as it is not in the bin"), http.StatusBadRequest
+	}
+
+	objId, objErr := util.GetPrimitiveID(t.ID)
+	if objErr != nil {
+		return nil, objErr, http.StatusBadRequest
+	}
+
+	t.DeletedAt = nil
+	return t.update(ctx, db, objId, bson.M{"$set": t.changed(bson.M{"is_deleted_status": false}), "$unset": bson.M{"deleted_at": ""}})
+}
+
+// HardDeleteTenant deletes a tenant of the bin, it is refused while an onboarding still refers to the tenant
+func (t *Tenant) HardDeleteTenant(ctx context.Context, db *mongo.Database) (*string, error, int) {
+	stored, err, code := FetchTenantById(t.ID, ctx, db)
+	if err != nil {
+		return nil, err, code
+	}
+
+	if !stored.IsDeletedStatus {
+		return nil, errors.New("tenant has to be sent to the bin before it is deleted"), http.StatusBadRequest
+	}
+
+	if err, code := checkTenantUnreferenced(ctx, db, t.ID); err != nil {
+		return nil, err, code
+	}
+
+	objId, objErr := util.GetPrimitiveID(t.ID)
+	if objErr != nil {
+		return nil, objErr, http.StatusBadRequest
+	}
+
+	deleted, err := DeleteTenant(ctx, db, t.ID, bson.M{"_id": objId})
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	if !deleted {
+		return nil, fmt.Errorf("no tenant with the id %s was found", t.ID), http.StatusNotFound
+	}
+
+	return &t.ID, nil, http.StatusOK
+}
+
+// DeleteTenant is the hard delete of the tenant matching the filter, shared by the delete route and the bin purger. A
+// tenant an onboarding refers to is kept, it tells whether the tenant was deleted.
+func DeleteTenant(ctx context.Context, db *mongo.Database, tenantId string, filter bson.M) (bool, error) {
+	if err, _ := checkTenantUnreferenced(ctx, db, tenantId); err != nil {
+		return false, err
+	}
+
+	del, err := db.Collection(Collection).DeleteOne(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+
+	return del.DeletedCount > 0, nil
+}
+
+// checkTenantUnreferenced fails with a 409 while onboardings refer to the tenant
+func checkTenantUnreferenced(ctx context.Context, db *mongo.Database, tenantId string) (error, int) {
+	onboardings, err := tenantOnboardings(ctx, db, tenantId)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	if onboardings > 0 {
+		return fmt.Errorf("the tenant %s is referred to by %d onboarding(s), roll back their company details step first", tenantId, onboardings), http.StatusConflict
+	}
+
+	return nil, http.StatusOK
+}
+
+func (t *Tenant) setLifecycle(ctx context.Context, db *mongo.Database, field string, value bool) (*Tenant, error, int) {
+	objId, objErr := util.GetPrimitiveID(t.ID)
+	if objErr != nil {
+		return nil, objErr, http.StatusBadRequest
+	}
+
+	return t.set(ctx, db, objId, bson.M{field: value})
+}
+
+// set applies the fields to the stored tenant along with who changed it, t is replaced by the updated tenant
+func (t *Tenant) set(ctx context.Context, db *mongo.Database, objId *bson.ObjectID, fields bson.M) (*Tenant, error, int) {
+	return t.update(ctx, db, objId, bson.M{"$set": t.changed(fields)})
+}
+
+// changed adds who changed the tenant and when to the fields
+func (t *Tenant) changed(fields bson.M) bson.M {
+	fields["updated_at"] = time.Now().UTC()
+	fields["updated_by"] = t.UpdatedBy
+	return fields
+}
+
+// update applies the update to the stored tenant, t is replaced by the updated tenant
+func (t *Tenant) update(ctx context.Context, db *mongo.Database, objId *bson.ObjectID, update bson.M) (*Tenant, error, int) {
+	opt := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	if err := db.Collection(Collection).FindOneAndUpdate(ctx, bson.M{"_id": objId}, update, opt).Decode(t); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no tenant with the id %s was found", t.ID), http.StatusNotFound
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	return t, nil, http.StatusOK
+}
@@ -0,0 +1,147 @@
+package tenants
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	status := Status("")
+	emails := []string{" Ops@Acme.com ", "Jane Doe <jane@acme.com>", "ops@acme.com"}
+
+	require.NoError(t, validate("Acme", &status, &emails, "manager-1"))
+	assert.Equal(t, StatusTrial, status, "a tenant starts on trial")
+	assert.Equal(t, []string{"ops@acme.com", "jane@acme.com"}, emails)
+
+	tests := []struct {
+		name    string
+		tenant  CTenant
+		message string
+	}{
+		{"no name", CTenant{Name: " ", ContactEmails: []string{"a@b.co"}, AccountManager: "m"}, "the tenant's name is required"},
+		{"unknown status", CTenant{Name: "Acme", Status: "paused", ContactEmails: []string{"a@b.co"}, AccountManager: "m"}, `invalid status "paused", expected one of trial, active, suspended or churned`},
+		{"no contact", CTenant{Name: "Acme", AccountManager: "m"}, "at least one contact email is required"},
+		{"invalid contact", CTenant{Name: "Acme", ContactEmails: []string{"acme"}, AccountManager: "m"}, `invalid contact email "acme"`},
+		{"no account manager", CTenant{Name: "Acme", ContactEmails: []string{"a@b.co"}}, "the tenant's account manager is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validate(tt.tenant.Name, &tt.tenant.Status, &tt.tenant.ContactEmails, tt.tenant.AccountManager)
+			assert.EqualError(t, err, tt.message)
+		})
+	}
+}
+
+func TestCheckPlan(t *testing.T) {
+	original := planExists
+	t.Cleanup(func() { planExists = original })
+
+	planExists = func(ctx context.Context, db *mongo.Database, planCode string) (bool, error) {
+		switch planCode {
+		case "PLN_known":
+			return true, nil
+		case "PLN_broken":
+			return false, errors.New("mirror unavailable")
+		default:
+			return false, nil
+		}
+	}
+
+	err, _ := checkPlan(context.Background(), nil, "")
+	assert.NoError(t, err, "a tenant may have no plan yet")
+
+	err, _ = checkPlan(context.Background(), nil, "PLN_known")
+	assert.NoError(t, err)
+
+	err, code := checkPlan(context.Background(), nil, "PLN_unknown")
+	assert.EqualError(t, err, "no tier plan with the code PLN_unknown")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	_, code = checkPlan(context.Background(), nil, "PLN_broken")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestCheckAccountManager(t *testing.T) {
+	original := accountManagerExists
+	t.Cleanup(func() { accountManagerExists = original })
+
+	accountManagerExists = func(ctx context.Context, db *mongo.Database, userId string) (bool, error) {
+		if userId == "broken" {
+			return false, errors.New("connection reset")
+		}
+		return userId == "67de0141ee7ad487b8861b73", nil
+	}
+
+	err, _ := checkAccountManager(context.Background(), nil, "67de0141ee7ad487b8861b73")
+	assert.NoError(t, err)
+
+	err, code := checkAccountManager(context.Background(), nil, "joshua")
+	assert.EqualError(t, err, "no user with the id joshua can manage the tenant")
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	_, code = checkAccountManager(context.Background(), nil, "broken")
+	assert.Equal(t, http.StatusInternalServerError, code)
+}
+
+func TestCheckTenantUnreferenced(t *testing.T) {
+	original := tenantOnboardings
+	t.Cleanup(func() { tenantOnboardings = original })
+
+	tenantOnboardings = func(ctx context.Context, db *mongo.Database, tenantId string) (int64, error) {
+		if tenantId == "onboarded" {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	err, _ := checkTenantUnreferenced(context.Background(), nil, "t1")
+	assert.NoError(t, err)
+
+	err, code := checkTenantUnreferenced(context.Background(), nil, "onboarded")
+	assert.EqualError(t, err, "the tenant onboarded is referred to by 1 onboarding(s), roll back their company details step first")
+	assert.Equal(t, http.StatusConflict, code)
+
+	deleted, err := DeleteTenant(context.Background(), nil, "onboarded", nil)
+	assert.Error(t, err, "the purger keeps a tenant an onboarding refers to")
+	assert.False(t, deleted)
+}
+
+func TestMutatingHandlers_RequireCaller(t *testing.T) {
+	handlers := map[string]http.HandlerFunc{
+		"HandleCreateTenant":         HandleCreateTenant(nil),
+		"HandleUpdateTenant":         HandleUpdateTenant(nil),
+		"HandleArchiveTenant":        HandleArchiveTenant(nil),
+		"HandleUnArchiveTenant":      HandleUnArchiveTenant(nil),
+		"HandlePushTenantToBin":      HandlePushTenantToBin(nil),
+		"HandleRestoreTenantFromBin": HandleRestoreTenantFromBin(nil),
+		"HandleHardDeleteTenant":     HandleHardDeleteTenant(nil),
+	}
+
+	for name, handler := range handlers {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPatch, "/", bytes.NewBufferString(`{"_id": "67de0141ee7ad487b8861b73", "created_by": "mallory"}`))
+			rr := httptest.NewRecorder()
+
+			handler.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		})
+	}
+}
+
+func TestHandleFetchTenants_InvalidQuery(t *testing.T) {
+	for _, query := range []string{"?page=one", "?limit=ten", "?view=deleted"} {
+		rr := httptest.NewRecorder()
+		HandleFetchTenants(nil).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/all"+query, nil))
+
+		assert.Equal(t, http.StatusBadRequest, rr.Code, query)
+	}
+}
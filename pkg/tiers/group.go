@@ -0,0 +1,286 @@
+package tiers
+
+import (
+	"context"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"net/http"
+)
+
+// TierRequest is a logical tier, published on Paystack as one plan per price
+type TierRequest struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description,omitempty"`
+	Interval     Interval `json:"interval"`
+	SendInvoices bool     `json:"send_invoices,omitempty"`
+	SendSMS      bool     `json:"send_sms,omitempty"`
+	InvoiceLimit int      `json:"invoice_limit,omitempty"`
+	Prices       []Money  `json:"prices,omitempty"`
+
+	// Amount (in the major unit) and Currency are the single price of the requests that predate the prices
+	Amount   json.Number `json:"amount,omitempty"`
+	Currency Currency    `json:"currency,omitempty"`
+
+	UpdateExistingSubscriptions bool `json:"update_existing_subscriptions,omitempty"`
+}
+
+// PricePoint is the plan of a tier in one currency
+type PricePoint struct {
+	PlanCode    string `json:"plan_code"`
+	Price       Money  `json:"price"`
+	MinorAmount int64  `json:"minor_amount"`
+	Drift       Drift  `json:"drift,omitempty"`
+}
+
+// TierGroup is a logical tier along with all its price points
+type TierGroup struct {
+	GroupId     string       `json:"group_id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Interval    string       `json:"interval"`
+	Prices      []PricePoint `json:"prices"`
+}
+
+type TierGroupResponse struct {
+	Status  bool      `json:"status"`
+	Message string    `json:"message"`
+	Data    TierGroup `json:"data"`
+}
+
+// minorPrice is a price of the request converted to the minor unit of its currency
+type minorPrice struct {
+	Money
+	minor int64
+}
+
+// prices validates the prices of the request and converts them to minor units, a currency is priced once
+func (t TierRequest) prices() ([]minorPrice, error) {
+	prices := t.Prices
+	if len(prices) == 0 && t.Amount != "" {
+		prices = []Money{{Amount: t.Amount, Currency: t.Currency}}
+	}
+
+	seen := map[Currency]bool{}
+	converted := make([]minorPrice, 0, len(prices))
+
+	for _, price := range prices {
+		if seen[price.Currency] {
+			return nil, fmt.Errorf("the tier is priced twice in %s", price.Currency)
+		}
+		seen[price.Currency] = true
+
+		// The currency of a single price may be left out on update, it is then the currency of the tier's only plan
+		if price.Currency == "" {
+			converted = append(converted, minorPrice{Money: price})
+			continue
+		}
+
+		minor, err := price.MinorUnits()
+		if err != nil {
+			return nil, err
+		}
+		converted = append(converted, minorPrice{Money: price, minor: minor})
+	}
+
+	return converted, nil
+}
+
+// groupOf builds the tier out of its mirrored plans
+func groupOf(groupId string, plans []MirroredTier) TierGroup {
+	group := TierGroup{GroupId: groupId, Prices: []PricePoint{}}
+
+	for _, plan := range plans {
+		if group.Name == "" {
+			group.Name, group.Description, group.Interval = plan.Name, plan.Description, plan.Interval
+		}
+
+		group.Prices = append(group.Prices, PricePoint{
+			PlanCode:    plan.ID,
+			Price:       FromMinorUnits(int64(plan.Amount), Currency(plan.Currency)),
+			MinorAmount: int64(plan.Amount),
+			Drift:       plan.Drift,
+		})
+	}
+
+	return group
+}
+
+// FetchTierGroup resolves the id, a group id or the plan code of any of its price points, to the plans of the tier
+func FetchTierGroup(id string, ctx context.Context, db *mongo.Database) (*TierGroup, []MirroredTier, error, int) {
+	col := db.Collection(TiersCollection)
+
+	var match MirroredTier
+	if err := col.FindOne(ctx, bson.M{"$or": bson.A{bson.M{"_id": id}, bson.M{"group_id": id}}}).Decode(&match); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil, fmt.Errorf("no tier with the id %s", id), http.StatusNotFound
+		}
+		return nil, nil, err, http.StatusInternalServerError
+	}
+
+	// The plans mirrored before the groups are a group of their own
+	groupId := match.GroupId
+	if groupId == "" {
+		groupId = match.ID
+	}
+
+	cursor, err := col.Find(ctx, bson.M{"$or": bson.A{bson.M{"group_id": groupId}, bson.M{"_id": groupId}}}, options.Find().SetSort(bson.D{{Key: "currency", Value: 1}}))
+	if err != nil {
+		return nil, nil, err, http.StatusInternalServerError
+	}
+
+	var plans []MirroredTier
+	if err := cursor.All(ctx, &plans); err != nil {
+		return nil, nil, err, http.StatusInternalServerError
+	}
+
+	group := groupOf(groupId, plans)
+	return &group, plans, nil, http.StatusOK
+}
+
+// CreateTierGroup publishes a plan per price of the tier. Should a plan fail the ones already published are kept in
+// the group, updating the tier with the missing price publishes it.
+func CreateTierGroup(tier TierRequest, ctx context.Context, db *mongo.Database) (*TierGroupResponse, error, int) {
+	prices, err := tier.prices()
+	if err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+
+	if len(prices) == 0 {
+		return nil, errors.New("a tier needs at least one price"), http.StatusBadRequest
+	}
+
+	for _, price := range prices {
+		if price.Currency == "" {
+			return nil, errors.New("every price needs a currency"), http.StatusBadRequest
+		}
+	}
+
+	uuid, err := util.GenerateUuid()
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	group := TierGroup{GroupId: uuid.String(), Name: tier.Name, Description: tier.Description, Interval: string(tier.Interval), Prices: []PricePoint{}}
+
+	for _, price := range prices {
+		created, err, statusCode := CreateMirroredTier(CreateTierRequest{
+			Name:         tier.Name,
+			Amount:       price.minor,
+			Interval:     tier.Interval,
+			Description:  tier.Description,
+			SendInvoices: tier.SendInvoices,
+			SendSMS:      tier.SendSMS,
+			Currency:     price.Currency,
+			InvoiceLimit: tier.InvoiceLimit,
+		}, group.GroupId, ctx, db)
+		if err != nil {
+			if len(group.Prices) > 0 {
+				err = fmt.Errorf("published %d of the %d prices of the tier %s, %s failed: %w", len(group.Prices), len(prices), group.GroupId, price.Currency, err)
+			}
+			return nil, err, statusCode
+		}
+
+		group.Prices = append(group.Prices, PricePoint{
+			PlanCode:    created.Data.PlanCode,
+			Price:       FromMinorUnits(int64(created.Data.Amount), price.Currency),
+			MinorAmount: int64(created.Data.Amount),
+		})
+	}
+
+	return &TierGroupResponse{Status: true, Message: "Tier created", Data: group}, nil, http.StatusCreated
+}
+
+// UpdateTierGroup updates every plan of the tier, the prices set their plan's amount and a price in a new currency
+// publishes a new plan in the group
+func UpdateTierGroup(id string, tier TierRequest, ctx context.Context, db *mongo.Database) (*TierGroupResponse, error, int) {
+	prices, err := tier.prices()
+	if err != nil {
+		return nil, err, http.StatusBadRequest
+	}
+
+	group, plans, err, statusCode := FetchTierGroup(id, ctx, db)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	byCurrency := make(map[Currency]minorPrice, len(prices))
+	for _, price := range prices {
+		if price.Currency == "" {
+			if len(plans) != 1 {
+				return nil, errors.New("every price needs a currency, the tier has several"), http.StatusBadRequest
+			}
+
+			price.Currency = Currency(plans[0].Currency)
+			if price.minor, err = price.MinorUnits(); err != nil {
+				return nil, err, http.StatusBadRequest
+			}
+		}
+		byCurrency[price.Currency] = price
+	}
+
+	for _, plan := range plans {
+		update := UpdateTierRequest{
+			CreateTierRequest: CreateTierRequest{
+				Name:         firstOf(tier.Name, plan.Name),
+				Amount:       int64(plan.Amount),
+				Interval:     Interval(firstOf(string(tier.Interval), plan.Interval)),
+				Description:  firstOf(tier.Description, plan.Description),
+				SendInvoices: tier.SendInvoices,
+				SendSMS:      tier.SendSMS,
+				Currency:     Currency(plan.Currency),
+				InvoiceLimit: tier.InvoiceLimit,
+			},
+			UpdateExistingSubscriptions: tier.UpdateExistingSubscriptions,
+		}
+
+		if price, ok := byCurrency[Currency(plan.Currency)]; ok {
+			update.Amount = price.minor
+			delete(byCurrency, Currency(plan.Currency))
+		}
+
+		if _, err, statusCode := UpdateTier(plan.ID, update, ctx); err != nil {
+			return nil, fmt.Errorf("updating the %s plan %s: %w", plan.Currency, plan.ID, err), statusCode
+		}
+
+		if err := mirrorTierByCode(ctx, db, plan.ID); err != nil {
+			// The plan is updated on Paystack, the next reconciliation mirrors it
+			log.Printf("tiers: unable to mirror the plan %s: %s", plan.ID, err.Error())
+		}
+	}
+
+	// The prices left are in currencies the tier had no plan in yet
+	for _, price := range byCurrency {
+		if _, err, statusCode := CreateMirroredTier(CreateTierRequest{
+			Name:         firstOf(tier.Name, group.Name),
+			Amount:       price.minor,
+			Interval:     Interval(firstOf(string(tier.Interval), group.Interval)),
+			Description:  firstOf(tier.Description, group.Description),
+			SendInvoices: tier.SendInvoices,
+			SendSMS:      tier.SendSMS,
+			Currency:     price.Currency,
+			InvoiceLimit: tier.InvoiceLimit,
+		}, group.GroupId, ctx, db); err != nil {
+			return nil, fmt.Errorf("publishing the %s price: %w", price.Currency, err), statusCode
+		}
+	}
+
+	updated, _, err, statusCode := FetchTierGroup(group.GroupId, ctx, db)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	return &TierGroupResponse{Status: true, Message: "Tier updated", Data: *updated}, nil, http.StatusOK
+}
+
+func firstOf(value string, fallback string) string {
+	if value != "" {
+		return value
+	}
+	return fallback
+}
@@ -1,133 +1,236 @@
 package tiers
 
 import (
+	"control-panel-bk/pkg/audit"
 	"control-panel-bk/util"
 	"encoding/json"
 	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 	"net/http"
 )
 
-func HandleTierCreation(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
+// HandleTierCreation publishes the tier as a Paystack plan per price, the prices are decimal amounts in the major unit
+// of their currency
+func HandleTierCreation(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var ctr TierRequest
+
+		err := json.NewDecoder(r.Body).Decode(&ctr)
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		tier, err, statusCode := CreateTierGroup(ctr, r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, statusCode)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TierCreated,
+			EntityType: audit.TierEntity,
+			EntityId:   tier.Data.GroupId,
+			After:      tier.Data,
+		})
+
+		reads, e := json.Marshal(tier)
+		if e != nil {
+			util.ErrorException(w, e, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_, writeErr := w.Write(reads)
+		if writeErr != nil {
+			util.ErrorException(w, writeErr, http.StatusInternalServerError)
+			return
+		}
 
-	var ctr CreateTierRequest
-
-	err := json.NewDecoder(r.Body).Decode(&ctr)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	ctr.Amount = ctr.Amount * 100 // From the documentation whatever price is charge it must be by 100
-
-	tier, err, statusCode := CreateTier(ctr, r.Context())
-	if err != nil {
-		util.ErrorException(w, err, statusCode)
-		return
-	}
-
-	reads, e := json.Marshal(tier)
-	if e != nil {
-		util.ErrorException(w, e, http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Encoding", "application/json")
-	w.WriteHeader(statusCode)
-	_, writeErr := w.Write(reads)
-	if writeErr != nil {
-		util.ErrorException(w, writeErr, http.StatusInternalServerError)
-		return
 	}
-
 }
 
-func HandleFetchTiers(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-
-	var ftr FetchTiersRequest
-
-	err := json.NewDecoder(r.Body).Decode(&ftr)
-	if err != nil {
-		util.ErrorException(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	tiers, err, statusCode := FetchTiers(ftr, r.Context())
-	if err != nil {
-		util.ErrorException(w, err, statusCode)
-		return
-	}
-
-	reads, e := json.Marshal(tiers)
-	if e != nil {
-		util.ErrorException(w, err, statusCode)
-		return
-	}
-
-	w.Header().Set("Content-Encoding", "application/json")
-	w.WriteHeader(statusCode)
-	_, writeErr := w.Write(reads)
-	if writeErr != nil {
-		util.ErrorException(w, writeErr, http.StatusInternalServerError)
-		return
+// HandleFetchTiers serves the plans from the mirror, ?live=true fetches them from Paystack and ?drift=true (or a drift
+// kind) only lists the drifted plans of the mirror
+func HandleFetchTiers(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var ftr FetchTiersRequest
+
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&ftr); err != nil {
+				util.ErrorException(w, err, http.StatusBadRequest)
+				return
+			}
+		}
+
+		var tiers interface{}
+		var err error
+		var statusCode int
+
+		if r.URL.Query().Get("live") == "true" {
+			tiers, err, statusCode = FetchTiers(ftr, r.Context())
+		} else {
+			tiers, err, statusCode = FetchMirroredTiers(ftr, r.URL.Query().Get("drift"), r.Context(), db)
+		}
+
+		if err != nil {
+			util.ErrorException(w, err, statusCode)
+			return
+		}
+
+		reads, e := json.Marshal(tiers)
+		if e != nil {
+			util.ErrorException(w, e, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		_, writeErr := w.Write(reads)
+		if writeErr != nil {
+			util.ErrorException(w, writeErr, http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
-func HandleFetchTier(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	planCode := chi.URLParam(r, "id")
-
-	resp, err, statsCode := GetTier(planCode, r.Context())
-	if err != nil {
-		util.ErrorException(w, err, statsCode)
-		return
-	}
-
-	respBytes, e := json.Marshal(resp)
-	if e != nil {
-		util.ErrorException(w, e, http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Encoding", "application/json")
-	w.WriteHeader(statsCode)
-	_, writeErr := w.Write(respBytes)
-	if writeErr != nil {
-		util.ErrorException(w, writeErr, http.StatusInternalServerError)
-		return
+// HandleFetchTier serves the tier with all its price points from the mirror, the id is the group id or the plan code of
+// any of its price points. ?live=true fetches its plans from Paystack along with their subscriptions.
+func HandleFetchTier(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		id := chi.URLParam(r, "id")
+
+		var resp interface{}
+
+		group, plans, err, statsCode := FetchTierGroup(id, r.Context(), db)
+		if err != nil && statsCode != http.StatusNotFound {
+			util.ErrorException(w, err, statsCode)
+			return
+		}
+
+		switch {
+		case r.URL.Query().Get("live") == "true":
+			// A plan not mirrored yet is still fetched by its code
+			codes := []string{id}
+			if err == nil {
+				codes = codes[:0]
+				for _, plan := range plans {
+					codes = append(codes, plan.ID)
+				}
+			}
+
+			live := make([]*FetchTierResponse, 0, len(codes))
+			for _, code := range codes {
+				tier, err, statusCode := GetTier(code, r.Context())
+				if err != nil {
+					util.ErrorException(w, err, statusCode)
+					return
+				}
+				live = append(live, tier)
+			}
+
+			resp, statsCode = live, http.StatusOK
+
+		case err != nil:
+			util.ErrorException(w, err, statsCode)
+			return
+
+		default:
+			resp = TierGroupResponse{Status: true, Message: "Tier retrieved", Data: *group}
+		}
+
+		respBytes, e := json.Marshal(resp)
+		if e != nil {
+			util.ErrorException(w, e, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statsCode)
+		_, writeErr := w.Write(respBytes)
+		if writeErr != nil {
+			util.ErrorException(w, writeErr, http.StatusInternalServerError)
+			return
+		}
 	}
 }
 
-func HandleUpdateTier(w http.ResponseWriter, r *http.Request) {
-	defer r.Body.Close()
-	planCode := chi.URLParam(r, "id")
-
-	var updateBody UpdateTierRequest
-	if err := json.NewDecoder(r.Body).Decode(&updateBody); err != nil {
-		util.ErrorException(w, err, http.StatusInternalServerError)
-		return
-	}
-
-	updateBody.Amount = updateBody.Amount * 100 // We have to multiply the amount by 100 - default paystack rule
-
-	updated, updateError, updateStatCde := UpdateTier(planCode, updateBody, r.Context())
-	if updateError != nil {
-		util.ErrorException(w, updateError, updateStatCde)
-		return
+// HandleReconcileTiers runs a pass of the reconciler right away and responds with its report
+func HandleReconcileTiers(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		report, err, statusCode := Reconcile(r.Context(), db)
+		if err != nil {
+			util.ErrorException(w, err, statusCode)
+			return
+		}
+
+		respBytes, e := util.GetBytesResponse(statusCode, report)
+		if e != nil {
+			util.ErrorException(w, e, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(statusCode)
+		if _, err := w.Write(respBytes); err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
 	}
+}
 
-	updatedBytes, e := json.Marshal(updated)
-	if e != nil {
-		util.ErrorException(w, e, http.StatusInternalServerError)
-		return
-	}
+// HandleUpdateTier updates every plan of the tier, the id is the group id or the plan code of any of its price points
+func HandleUpdateTier(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		id := chi.URLParam(r, "id")
+
+		var updateBody TierRequest
+		if err := json.NewDecoder(r.Body).Decode(&updateBody); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		// The tier as it was before the update, for the audit log
+		var before interface{}
+		if current, _, err, _ := FetchTierGroup(id, r.Context(), db); err == nil {
+			before = current
+		}
+
+		updated, updateError, updateStatCde := UpdateTierGroup(id, updateBody, r.Context(), db)
+		if updateError != nil {
+			util.ErrorException(w, updateError, updateStatCde)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.TierUpdated,
+			EntityType: audit.TierEntity,
+			EntityId:   updated.Data.GroupId,
+			Before:     before,
+			After:      updated.Data,
+		})
+
+		updatedBytes, e := json.Marshal(updated)
+		if e != nil {
+			util.ErrorException(w, e, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(updateStatCde)
+		if _, err := w.Write(updatedBytes); err != nil {
+			util.ErrorException(w, err, http.StatusInternalServerError)
+			return
+		}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(updateStatCde)
-	if _, err := w.Write(updatedBytes); err != nil {
-		util.ErrorException(w, err, http.StatusInternalServerError)
-		return
 	}
-
 }
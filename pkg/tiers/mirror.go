@@ -0,0 +1,481 @@
+package tiers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"log"
+	"net/http"
+	"time"
+)
+
+// TiersCollection mirrors the plans of Paystack, the reads of the panel are served from it
+const TiersCollection = "tiers"
+
+// reconcilePageSize is the number of plans fetched per page while reconciling the mirror
+const reconcilePageSize = 100
+
+type Drift string
+
+const (
+	NoDrift Drift = ""
+	// DriftModified the plan was edited on the Paystack dashboard rather than through the panel
+	DriftModified Drift = "modified_on_paystack"
+	// DriftCreated the plan was created on the Paystack dashboard rather than through the panel
+	DriftCreated Drift = "created_on_paystack"
+	// DriftMissing the mirrored plan no longer exists on Paystack
+	DriftMissing Drift = "missing_on_paystack"
+)
+
+// MirroredTier is a Paystack plan as it is kept in the tiers collection, the id is the plan code
+type MirroredTier struct {
+	ID           string    `json:"_id"`
+	GroupId      string    `json:"group_id,omitempty"` // the logical tier the plan prices in its currency
+	PlanId       int       `json:"plan_id"`
+	Name         string    `json:"name"`
+	Description  string    `json:"description"`
+	Amount       int       `json:"amount"`
+	Interval     string    `json:"interval"`
+	Currency     string    `json:"currency"`
+	SendInvoices bool      `json:"send_invoices"`
+	SendSms      bool      `json:"send_sms"`
+	HostedPage   bool      `json:"hosted_page"`
+	Integration  int       `json:"integration"`
+	Domain       string    `json:"domain"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"` // as last updated on Paystack
+	SyncedAt     time.Time `json:"synced_at"`
+
+	Drift           Drift      `json:"drift,omitempty"`
+	DriftFields     []string   `json:"drift_fields,omitempty"`
+	DriftDetectedAt *time.Time `json:"drift_detected_at,omitempty"`
+}
+
+// MirroredTiersResponse is the page of mirrored plans, shaped like the Paystack listing
+type MirroredTiersResponse struct {
+	Status  bool           `json:"status"`
+	Message string         `json:"message"`
+	Data    []MirroredTier `json:"data"`
+	Meta    struct {
+		Total     int64 `json:"total"`
+		PerPage   int   `json:"perPage"`
+		Page      int   `json:"page"`
+		PageCount int64 `json:"pageCount"`
+	} `json:"meta"`
+}
+
+type MirroredTierResponse struct {
+	Status  bool         `json:"status"`
+	Message string       `json:"message"`
+	Data    MirroredTier `json:"data"`
+}
+
+// ReconcileReport sums up a pass of the reconciler
+type ReconcileReport struct {
+	Fetched   int       `json:"fetched"`
+	Inserted  int       `json:"inserted"`
+	Modified  []string  `json:"modified"`
+	Created   []string  `json:"created"`
+	Missing   []string  `json:"missing"`
+	StartedAt time.Time `json:"started_at"`
+	EndedAt   time.Time `json:"ended_at"`
+}
+
+// fetchedTier is the plan of FetchTiersResponse and FetchTierResponse, without its subscriptions
+type fetchedTier struct {
+	Integration  int         `json:"integration"`
+	Domain       string      `json:"domain"`
+	Name         string      `json:"name"`
+	PlanCode     string      `json:"plan_code"`
+	Description  interface{} `json:"description"`
+	Amount       int         `json:"amount"`
+	Interval     string      `json:"interval"`
+	SendInvoices bool        `json:"send_invoices"`
+	SendSms      bool        `json:"send_sms"`
+	HostedPage   bool        `json:"hosted_page"`
+	Currency     string      `json:"currency"`
+	Id           int         `json:"id"`
+	CreatedAt    time.Time   `json:"createdAt"`
+	UpdatedAt    time.Time   `json:"updatedAt"`
+}
+
+// mirrorFromPaystack maps a plan as returned by Paystack onto its mirror
+func mirrorFromPaystack(plan interface{}) (MirroredTier, error) {
+	raw, err := json.Marshal(plan)
+	if err != nil {
+		return MirroredTier{}, err
+	}
+
+	var data fetchedTier
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return MirroredTier{}, err
+	}
+
+	// Paystack returns the description of a plan as a string or null
+	desc, _ := data.Description.(string)
+
+	return MirroredTier{
+		ID:           data.PlanCode,
+		PlanId:       data.Id,
+		Name:         data.Name,
+		Description:  desc,
+		Amount:       data.Amount,
+		Interval:     data.Interval,
+		Currency:     data.Currency,
+		SendInvoices: data.SendInvoices,
+		SendSms:      data.SendSms,
+		HostedPage:   data.HostedPage,
+		Integration:  data.Integration,
+		Domain:       data.Domain,
+		CreatedAt:    data.CreatedAt,
+		UpdatedAt:    data.UpdatedAt,
+	}, nil
+}
+
+// tierFields the fields a plan is compared on, the ones editable on the Paystack dashboard
+func tierFields(t MirroredTier) map[string]interface{} {
+	return map[string]interface{}{
+		"name":          t.Name,
+		"description":   t.Description,
+		"amount":        t.Amount,
+		"interval":      t.Interval,
+		"currency":      t.Currency,
+		"send_invoices": t.SendInvoices,
+		"send_sms":      t.SendSms,
+	}
+}
+
+// changedFields lists the fields the remote plan differs from its mirror on
+func changedFields(mirror MirroredTier, remote MirroredTier) []string {
+	before, after := tierFields(mirror), tierFields(remote)
+
+	var changed []string
+	for _, field := range []string{"name", "description", "amount", "interval", "currency", "send_invoices", "send_sms"} {
+		if before[field] != after[field] {
+			changed = append(changed, field)
+		}
+	}
+
+	return changed
+}
+
+// fetchAllTiers pages through the plans of Paystack, an error on any page fails the whole listing since an incomplete
+// listing would flag the plans of the missing pages as deleted
+func fetchAllTiers(ctx context.Context) ([]MirroredTier, error, int) {
+	var all []MirroredTier
+
+	for page := 1; ; page++ {
+		resp, err, statusCode := FetchTiers(FetchTiersRequest{PerPage: reconcilePageSize, Page: page}, ctx)
+		if err != nil {
+			return nil, err, statusCode
+		}
+
+		for _, data := range resp.Data {
+			plan, err := mirrorFromPaystack(data)
+			if err != nil {
+				return nil, err, http.StatusInternalServerError
+			}
+			all = append(all, plan)
+		}
+
+		if len(resp.Data) == 0 || page >= resp.Meta.PageCount {
+			return all, nil, http.StatusOK
+		}
+	}
+}
+
+// SaveMirroredTier writes the plan as created or updated through the panel, which clears any drift of the plan. A plan
+// without a group keeps the group it is mirrored in.
+func SaveMirroredTier(ctx context.Context, db *mongo.Database, tier MirroredTier) error {
+	col := db.Collection(TiersCollection)
+
+	if tier.GroupId == "" {
+		var existing MirroredTier
+		err := col.FindOne(ctx, bson.M{"_id": tier.ID}, options.FindOne().SetProjection(bson.M{"group_id": 1})).Decode(&existing)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		tier.GroupId = existing.GroupId
+	}
+
+	tier.SyncedAt = time.Now().UTC()
+	tier.Drift, tier.DriftFields, tier.DriftDetectedAt = NoDrift, nil, nil
+
+	_, err := col.ReplaceOne(ctx, bson.M{"_id": tier.ID}, tier, options.Replace().SetUpsert(true))
+	return err
+}
+
+// mirrorTierByCode refreshes the mirror of a plan from Paystack, after it was changed through the panel
+func mirrorTierByCode(ctx context.Context, db *mongo.Database, planCode string) error {
+	resp, err, _ := GetTier(planCode, ctx)
+	if err != nil {
+		return err
+	}
+
+	plan, err := mirrorFromPaystack(resp.Data)
+	if err != nil {
+		return err
+	}
+
+	return SaveMirroredTier(ctx, db, plan)
+}
+
+// diffMirror compares the plans of Paystack to their mirror, it returns the plans to insert, the plans to refresh and
+// the mirrored plans no longer on Paystack, each with its drift flag set
+func diffMirror(remote []MirroredTier, mirrored []MirroredTier, now time.Time, report *ReconcileReport) (inserted, refreshed, missing []MirroredTier) {
+	// The very first pass imports the plans
+	initial := len(mirrored) == 0
+
+	local := make(map[string]MirroredTier, len(mirrored))
+	for _, t := range mirrored {
+		local[t.ID] = t
+	}
+
+	for _, plan := range remote {
+		plan.SyncedAt = now
+
+		existing, found := local[plan.ID]
+		delete(local, plan.ID)
+
+		if !found {
+			if !initial {
+				plan.Drift, plan.DriftDetectedAt = DriftCreated, &now
+				report.Created = append(report.Created, plan.ID)
+			}
+
+			report.Inserted++
+			inserted = append(inserted, plan)
+			continue
+		}
+
+		plan.GroupId = existing.GroupId
+
+		if fields := changedFields(existing, plan); len(fields) > 0 {
+			plan.Drift, plan.DriftFields, plan.DriftDetectedAt = DriftModified, fields, &now
+			report.Modified = append(report.Modified, plan.ID)
+		} else if existing.Drift != DriftMissing {
+			// A flag raised by an earlier pass stays until the plan is saved through the panel
+			plan.Drift, plan.DriftFields, plan.DriftDetectedAt = existing.Drift, existing.DriftFields, existing.DriftDetectedAt
+		}
+
+		refreshed = append(refreshed, plan)
+	}
+
+	// What is left of the mirror is no longer on Paystack
+	for _, t := range local {
+		if t.Drift == DriftMissing {
+			continue
+		}
+
+		t.Drift, t.DriftDetectedAt, t.SyncedAt = DriftMissing, &now, now
+		report.Missing = append(report.Missing, t.ID)
+		missing = append(missing, t)
+	}
+
+	return inserted, refreshed, missing
+}
+
+// Reconcile refreshes the mirror from Paystack and flags the plans that drifted from it
+func Reconcile(ctx context.Context, db *mongo.Database) (*ReconcileReport, error, int) {
+	report := &ReconcileReport{StartedAt: time.Now().UTC(), Modified: []string{}, Created: []string{}, Missing: []string{}}
+
+	remote, err, statusCode := fetchAllTiers(ctx)
+	if err != nil {
+		return nil, err, statusCode
+	}
+	report.Fetched = len(remote)
+
+	col := db.Collection(TiersCollection)
+
+	cursor, err := col.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	var mirrored []MirroredTier
+	if err := cursor.All(ctx, &mirrored); err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	inserted, refreshed, missing := diffMirror(remote, mirrored, time.Now().UTC(), report)
+
+	var writes []mongo.WriteModel
+
+	// A plan saved through the panel while the pass was fetching is fresher than the fetched one, hence neither
+	// writes over a plan synced after the pass started
+	for _, plan := range inserted {
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": plan.ID}).SetUpdate(bson.M{"$setOnInsert": plan}).SetUpsert(true))
+	}
+
+	for _, plan := range refreshed {
+		writes = append(writes, mongo.NewReplaceOneModel().SetFilter(bson.M{"_id": plan.ID, "synced_at": bson.M{"$lt": report.StartedAt}}).SetReplacement(plan))
+	}
+
+	for _, plan := range missing {
+		writes = append(writes, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": plan.ID, "synced_at": bson.M{"$lt": report.StartedAt}}).SetUpdate(bson.M{
+			"$set": bson.M{"drift": plan.Drift, "drift_detected_at": plan.DriftDetectedAt, "synced_at": plan.SyncedAt},
+		}))
+	}
+
+	if len(writes) > 0 {
+		if _, err := col.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false)); err != nil {
+			return nil, err, http.StatusInternalServerError
+		}
+	}
+
+	report.EndedAt = time.Now().UTC()
+	return report, nil, http.StatusOK
+}
+
+// StartReconciler reconciles the mirror right away and then on every tick of the interval, until the context is done
+func StartReconciler(ctx context.Context, db *mongo.Database, interval time.Duration) {
+	reconcile := func() {
+		report, err, _ := Reconcile(ctx, db)
+		if err != nil {
+			log.Printf("tiers: reconciliation failed: %s", err.Error())
+			return
+		}
+
+		if len(report.Modified)+len(report.Created)+len(report.Missing) > 0 {
+			log.Printf("tiers: drift detected, modified %v, created %v, missing %v", report.Modified, report.Created, report.Missing)
+		}
+	}
+
+	go func() {
+		reconcile()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				reconcile()
+			}
+		}
+	}()
+}
+
+// mirrorFilter the filters of the Paystack listing, applied to the mirror
+func mirrorFilter(arg FetchTiersRequest, drift string) bson.M {
+	filter := bson.M{}
+
+	if arg.Interval != "" {
+		filter["interval"] = arg.Interval
+	}
+
+	if arg.Amount > 0 {
+		filter["amount"] = arg.Amount
+	}
+
+	switch drift {
+	case "":
+	case "true":
+		filter["drift"] = bson.M{"$exists": true, "$ne": NoDrift}
+	default:
+		filter["drift"] = drift
+	}
+
+	return filter
+}
+
+// FetchMirroredTiers lists the plans of the mirror, newest first
+func FetchMirroredTiers(arg FetchTiersRequest, drift string, ctx context.Context, db *mongo.Database) (*MirroredTiersResponse, error, int) {
+	if arg.Page < 1 {
+		arg.Page = 1
+	}
+
+	if arg.PerPage < 1 || arg.PerPage > reconcilePageSize {
+		arg.PerPage = 50
+	}
+
+	col := db.Collection(TiersCollection)
+	filter := mirrorFilter(arg, drift)
+
+	total, err := col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetSkip(int64((arg.Page - 1) * arg.PerPage)).
+		SetLimit(int64(arg.PerPage))
+
+	cursor, err := col.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	resp := MirroredTiersResponse{Status: true, Message: "Plans retrieved", Data: []MirroredTier{}}
+	if err := cursor.All(ctx, &resp.Data); err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	resp.Meta.Total = total
+	resp.Meta.Page = arg.Page
+	resp.Meta.PerPage = arg.PerPage
+	resp.Meta.PageCount = (total + int64(arg.PerPage) - 1) / int64(arg.PerPage)
+
+	return &resp, nil, http.StatusOK
+}
+
+// GetMirroredTier retrieves a plan of the mirror by its plan code
+func GetMirroredTier(planCode string, ctx context.Context, db *mongo.Database) (*MirroredTierResponse, error, int) {
+	var tier MirroredTier
+	if err := db.Collection(TiersCollection).FindOne(ctx, bson.M{"_id": planCode}).Decode(&tier); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, fmt.Errorf("no plan with the code %s", planCode), http.StatusNotFound
+		}
+		return nil, err, http.StatusInternalServerError
+	}
+
+	return &MirroredTierResponse{Status: true, Message: "Plan retrieved", Data: tier}, nil, http.StatusOK
+}
+
+// CreateMirroredTier creates the plan on Paystack once the mirror holds no plan with the same amount, currency and
+// interval, then mirrors it in the group
+func CreateMirroredTier(tier CreateTierRequest, groupId string, ctx context.Context, db *mongo.Database) (*TierResponse, error, int) {
+	duplicate := bson.M{
+		"amount":   tier.Amount,
+		"interval": tier.Interval,
+		"drift":    bson.M{"$ne": DriftMissing},
+	}
+
+	if tier.Currency != "" {
+		duplicate["currency"] = tier.Currency
+	}
+
+	duplicates, err := db.Collection(TiersCollection).CountDocuments(ctx, duplicate)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	if duplicates > 0 {
+		return nil, errors.New("an active tier with the same data already exists"), http.StatusOK
+	}
+
+	created, err, statusCode := createTier(tier, ctx)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	plan, err := mirrorFromPaystack(created.Data)
+	if err == nil {
+		plan.Description, plan.GroupId = tier.Description, groupId
+		err = SaveMirroredTier(ctx, db, plan)
+	}
+
+	if err != nil {
+		// The plan exists on Paystack, the next reconciliation mirrors it
+		log.Printf("tiers: unable to mirror the plan %s: %s", created.Data.PlanCode, err.Error())
+	}
+
+	return created, nil, statusCode
+}
@@ -0,0 +1,137 @@
+package tiers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestFetchAllTiers(t *testing.T) {
+	var pages []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pages = append(pages, r.URL.Query().Get("page"))
+		assert.Equal(t, strconv.Itoa(reconcilePageSize), r.URL.Query().Get("perPage"))
+
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": true,
+			"data": []map[string]interface{}{
+				{"plan_code": "PLN_" + strconv.Itoa(page), "name": "Plan", "amount": 500000, "interval": "monthly", "description": nil},
+			},
+			"meta": map[string]interface{}{"page": page, "pageCount": 3},
+		})
+	}))
+	defer server.Close()
+
+	url = server.URL
+
+	plans, err, code := fetchAllTiers(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, []string{"1", "2", "3"}, pages)
+	require.Len(t, plans, 3)
+	assert.Equal(t, "PLN_3", plans[2].ID)
+	assert.Equal(t, 500000, plans[2].Amount)
+	assert.Empty(t, plans[2].Description)
+}
+
+func TestFetchAllTiers_FailsOnAnyPage(t *testing.T) {
+	withTransport(t, func(tr *paystackTransport) { tr.maxRetries = 0 })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("page") == "2" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": true,
+			"data":   []map[string]interface{}{{"plan_code": "PLN_1"}},
+			"meta":   map[string]interface{}{"pageCount": 2},
+		})
+	}))
+	defer server.Close()
+
+	url = server.URL
+
+	plans, err, _ := fetchAllTiers(context.Background())
+	assert.Error(t, err)
+	assert.Nil(t, plans, "an incomplete listing would flag the plans of the failed page as missing")
+}
+
+func TestDiffMirror(t *testing.T) {
+	now := time.Now().UTC()
+	basic := MirroredTier{ID: "PLN_basic", Name: "Basic", Amount: 500000, Interval: "monthly", Currency: "NGN"}
+	pro := MirroredTier{ID: "PLN_pro", Name: "Pro", Amount: 1500000, Interval: "monthly", Currency: "NGN"}
+
+	t.Run("first pass imports without flagging", func(t *testing.T) {
+		report := &ReconcileReport{}
+		inserted, refreshed, missing := diffMirror([]MirroredTier{basic, pro}, nil, now, report)
+
+		assert.Len(t, inserted, 2)
+		assert.Empty(t, refreshed)
+		assert.Empty(t, missing)
+		assert.Equal(t, NoDrift, inserted[0].Drift)
+		assert.Empty(t, report.Created)
+	})
+
+	t.Run("flags the plans edited, created and deleted on the dashboard", func(t *testing.T) {
+		edited := basic
+		edited.Amount = 600000
+		edited.Name = "Basic+"
+
+		dashboardOnly := MirroredTier{ID: "PLN_dashboard", Name: "Dashboard", Amount: 100, Interval: "daily"}
+
+		report := &ReconcileReport{}
+		inserted, refreshed, missing := diffMirror([]MirroredTier{edited, dashboardOnly}, []MirroredTier{basic, pro}, now, report)
+
+		require.Len(t, inserted, 1)
+		assert.Equal(t, DriftCreated, inserted[0].Drift)
+
+		require.Len(t, refreshed, 1)
+		assert.Equal(t, DriftModified, refreshed[0].Drift)
+		assert.Equal(t, []string{"name", "amount"}, refreshed[0].DriftFields)
+		assert.Equal(t, 600000, refreshed[0].Amount, "Paystack is the source of truth")
+
+		require.Len(t, missing, 1)
+		assert.Equal(t, "PLN_pro", missing[0].ID)
+		assert.Equal(t, DriftMissing, missing[0].Drift)
+
+		assert.Equal(t, []string{"PLN_basic"}, report.Modified)
+		assert.Equal(t, []string{"PLN_dashboard"}, report.Created)
+		assert.Equal(t, []string{"PLN_pro"}, report.Missing)
+	})
+
+	t.Run("keeps an earlier flag and clears a plan that came back", func(t *testing.T) {
+		earlier := now.Add(-time.Hour)
+
+		flagged := basic
+		flagged.Drift, flagged.DriftFields, flagged.DriftDetectedAt = DriftModified, []string{"amount"}, &earlier
+
+		gone := pro
+		gone.Drift, gone.DriftDetectedAt = DriftMissing, &earlier
+
+		report := &ReconcileReport{}
+		_, refreshed, missing := diffMirror([]MirroredTier{basic, pro}, []MirroredTier{flagged, gone}, now, report)
+
+		require.Len(t, refreshed, 2)
+		assert.Equal(t, DriftModified, refreshed[0].Drift)
+		assert.Equal(t, &earlier, refreshed[0].DriftDetectedAt)
+		assert.Equal(t, NoDrift, refreshed[1].Drift)
+		assert.Empty(t, missing)
+		assert.Empty(t, report.Modified)
+	})
+}
+
+func TestMirrorFilter(t *testing.T) {
+	assert.Equal(t, bson.M{}, mirrorFilter(FetchTiersRequest{Page: 1}, ""))
+	assert.Equal(t, bson.M{"interval": IntervalMonthly, "amount": int64(500000)}, mirrorFilter(FetchTiersRequest{Interval: IntervalMonthly, Amount: 500000}, ""))
+	assert.Equal(t, bson.M{"drift": bson.M{"$exists": true, "$ne": NoDrift}}, mirrorFilter(FetchTiersRequest{}, "true"))
+	assert.Equal(t, bson.M{"drift": "missing_on_paystack"}, mirrorFilter(FetchTiersRequest{}, "missing_on_paystack"))
+}
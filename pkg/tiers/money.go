@@ -0,0 +1,82 @@
+package tiers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// minorUnits is the number of decimals of each currency, Paystack takes the amounts in the minor unit (kobo, cents,
+// pesewas) of the currency
+var minorUnits = map[Currency]int{
+	CurrencyUSD: 2,
+	CurrencyNGN: 2,
+	CurrencyGHS: 2,
+	CurrencyZAR: 2,
+}
+
+// Money is a decimal amount in the major unit of its currency, e.g. {"amount": "49.99", "currency": "USD"}. The amount
+// is kept as its decimal text so no precision is lost on the way to minor units.
+type Money struct {
+	Amount   json.Number `json:"amount"`
+	Currency Currency    `json:"currency"`
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%s %s", m.Currency, m.Amount)
+}
+
+// MinorUnits converts the amount to the minor unit of the currency, 49.99 USD is 4999 cents
+func (m Money) MinorUnits() (int64, error) {
+	exponent, ok := minorUnits[m.Currency]
+	if !ok {
+		return 0, fmt.Errorf("unsupported currency %q", m.Currency)
+	}
+
+	amount := strings.TrimSpace(m.Amount.String())
+	if amount == "" {
+		return 0, fmt.Errorf("an amount is required for %s", m.Currency)
+	}
+
+	whole, fraction, _ := strings.Cut(amount, ".")
+	if len(fraction) > exponent {
+		return 0, fmt.Errorf("%s has at most %d decimals, got %s", m.Currency, exponent, amount)
+	}
+
+	if whole == "" || strings.ContainsAny(whole, "+-eE") || strings.ContainsAny(fraction, "+-eE") {
+		return 0, fmt.Errorf("invalid amount %s, a positive decimal such as 49.99 is expected", amount)
+	}
+
+	// 49.9 is 49 and 90 hundredths
+	fraction += strings.Repeat("0", exponent-len(fraction))
+
+	minor, err := strconv.ParseInt(whole+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %s: %w", amount, err)
+	}
+
+	if minor <= 0 {
+		return 0, fmt.Errorf("the amount of %s must be positive", m.Currency)
+	}
+
+	return minor, nil
+}
+
+// FromMinorUnits converts an amount in the minor unit of the currency back to Money, 4999 cents is 49.99 USD
+func FromMinorUnits(minor int64, currency Currency) Money {
+	exponent, ok := minorUnits[currency]
+	if !ok || exponent == 0 {
+		return Money{Amount: json.Number(strconv.FormatInt(minor, 10)), Currency: currency}
+	}
+
+	sign := ""
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+
+	digits := fmt.Sprintf("%0*d", exponent+1, minor)
+	split := len(digits) - exponent
+
+	return Money{Amount: json.Number(sign + digits[:split] + "." + digits[split:]), Currency: currency}
+}
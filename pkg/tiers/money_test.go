@@ -0,0 +1,97 @@
+package tiers
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestMoney_MinorUnits(t *testing.T) {
+	tests := []struct {
+		money    Money
+		expected int64
+		err      bool
+	}{
+		{Money{"49.99", CurrencyUSD}, 4999, false},
+		{Money{"49.9", CurrencyUSD}, 4990, false},
+		{Money{"5000", CurrencyNGN}, 500000, false},
+		{Money{"0.5", CurrencyGHS}, 50, false},
+		{Money{"129.00", CurrencyZAR}, 12900, false},
+		{Money{"49.999", CurrencyUSD}, 0, true},
+		{Money{"-10", CurrencyNGN}, 0, true},
+		{Money{"0", CurrencyNGN}, 0, true},
+		{Money{"1e3", CurrencyNGN}, 0, true},
+		{Money{"", CurrencyNGN}, 0, true},
+		{Money{"10", "EUR"}, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.money.String(), func(t *testing.T) {
+			minor, err := tt.money.MinorUnits()
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, minor)
+		})
+	}
+}
+
+func TestFromMinorUnits(t *testing.T) {
+	assert.Equal(t, Money{"49.99", CurrencyUSD}, FromMinorUnits(4999, CurrencyUSD))
+	assert.Equal(t, Money{"0.05", CurrencyGHS}, FromMinorUnits(5, CurrencyGHS))
+	assert.Equal(t, Money{"5000.00", CurrencyNGN}, FromMinorUnits(500000, CurrencyNGN))
+
+	// The round trip is lossless
+	minor, err := FromMinorUnits(12345, CurrencyZAR).MinorUnits()
+	require.NoError(t, err)
+	assert.Equal(t, int64(12345), minor)
+}
+
+func TestMoney_JSON(t *testing.T) {
+	var prices []Money
+	require.NoError(t, json.Unmarshal([]byte(`[{"amount": 49.99, "currency": "USD"}, {"amount": "19.5", "currency": "GHS"}]`), &prices))
+
+	minor, err := prices[0].MinorUnits()
+	require.NoError(t, err)
+	assert.Equal(t, int64(4999), minor, "a JSON number is not rounded through a float")
+
+	minor, err = prices[1].MinorUnits()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1950), minor)
+}
+
+func TestTierRequest_Prices(t *testing.T) {
+	// The single amount of the former requests
+	prices, err := TierRequest{Amount: "1000", Currency: CurrencyNGN}.prices()
+	require.NoError(t, err)
+	require.Len(t, prices, 1)
+	assert.Equal(t, int64(100000), prices[0].minor)
+
+	prices, err = TierRequest{Prices: []Money{{"9.99", CurrencyUSD}, {"15000", CurrencyNGN}}}.prices()
+	require.NoError(t, err)
+	assert.Equal(t, int64(999), prices[0].minor)
+	assert.Equal(t, int64(1500000), prices[1].minor)
+
+	_, err = TierRequest{Prices: []Money{{"9.99", CurrencyUSD}, {"10.99", CurrencyUSD}}}.prices()
+	assert.EqualError(t, err, "the tier is priced twice in USD")
+
+	_, err = TierRequest{Prices: []Money{{"9.999", CurrencyUSD}}}.prices()
+	assert.Error(t, err)
+}
+
+func TestGroupOf(t *testing.T) {
+	group := groupOf("group-1", []MirroredTier{
+		{ID: "PLN_ngn", GroupId: "group-1", Name: "Pro", Interval: "monthly", Amount: 1500000, Currency: "NGN"},
+		{ID: "PLN_usd", GroupId: "group-1", Name: "Pro", Interval: "monthly", Amount: 999, Currency: "USD", Drift: DriftModified},
+	})
+
+	assert.Equal(t, "Pro", group.Name)
+	assert.Equal(t, []PricePoint{
+		{PlanCode: "PLN_ngn", Price: Money{"15000.00", CurrencyNGN}, MinorAmount: 1500000},
+		{PlanCode: "PLN_usd", Price: Money{"9.99", CurrencyUSD}, MinorAmount: 999, Drift: DriftModified},
+	}, group.Prices)
+}
@@ -0,0 +1,245 @@
+package tiers
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/go-querystring/query"
+	"net/http"
+	burl "net/url"
+	"time"
+)
+
+type SubscriptionStatus string
+
+const (
+	SubscriptionActive       SubscriptionStatus = "active"
+	SubscriptionNonRenewing  SubscriptionStatus = "non-renewing"
+	SubscriptionAttention    SubscriptionStatus = "attention"
+	SubscriptionCompleted    SubscriptionStatus = "completed"
+	SubscriptionCancelled    SubscriptionStatus = "cancelled"
+	SubscriptionNotCompleted SubscriptionStatus = "not_complete"
+)
+
+type SubscriptionCustomer struct {
+	Id           int    `json:"id"`
+	FirstName    string `json:"first_name"`
+	LastName     string `json:"last_name"`
+	Email        string `json:"email"`
+	CustomerCode string `json:"customer_code"`
+	Phone        string `json:"phone"`
+}
+
+type SubscriptionPlan struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	PlanCode string `json:"plan_code"`
+	Amount   int    `json:"amount"`
+	Interval string `json:"interval"`
+	Currency string `json:"currency"`
+}
+
+type SubscriptionAuthorization struct {
+	AuthorizationCode string `json:"authorization_code"`
+	Bin               string `json:"bin"`
+	Last4             string `json:"last4"`
+	ExpMonth          string `json:"exp_month"`
+	ExpYear           string `json:"exp_year"`
+	Channel           string `json:"channel"`
+	CardType          string `json:"card_type"`
+	Bank              string `json:"bank"`
+	CountryCode       string `json:"country_code"`
+	Brand             string `json:"brand"`
+	Reusable          bool   `json:"reusable"`
+	Signature         string `json:"signature"`
+	AccountName       string `json:"account_name"`
+}
+
+// Subscription is a subscription as fetched or listed, with its customer, plan and card expanded
+type Subscription struct {
+	Id               int                       `json:"id"`
+	Domain           string                    `json:"domain"`
+	Status           SubscriptionStatus        `json:"status"`
+	SubscriptionCode string                    `json:"subscription_code"`
+	EmailToken       string                    `json:"email_token"`
+	Amount           int                       `json:"amount"`
+	CronExpression   string                    `json:"cron_expression"`
+	NextPaymentDate  *time.Time                `json:"next_payment_date"`
+	OpenInvoice      interface{}               `json:"open_invoice"`
+	Integration      int                       `json:"integration"`
+	Customer         SubscriptionCustomer      `json:"customer"`
+	Plan             SubscriptionPlan          `json:"plan"`
+	Authorization    SubscriptionAuthorization `json:"authorization"`
+	CreatedAt        time.Time                 `json:"createdAt"`
+	UpdatedAt        time.Time                 `json:"updatedAt"`
+}
+
+// CreateSubscriptionResponse on creation Paystack only returns the ids of the customer, plan and authorization
+type CreateSubscriptionResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Data    struct {
+		Customer         int                `json:"customer"`
+		Plan             int                `json:"plan"`
+		Integration      int                `json:"integration"`
+		Domain           string             `json:"domain"`
+		Start            int                `json:"start"`
+		Status           SubscriptionStatus `json:"status"`
+		Quantity         int                `json:"quantity"`
+		Amount           int                `json:"amount"`
+		Authorization    int                `json:"authorization"`
+		SubscriptionCode string             `json:"subscription_code"`
+		EmailToken       string             `json:"email_token"`
+		Id               int                `json:"id"`
+		CreatedAt        time.Time          `json:"createdAt"`
+		UpdatedAt        time.Time          `json:"updatedAt"`
+	} `json:"data"`
+}
+
+type FetchSubscriptionResponse struct {
+	Status  bool         `json:"status"`
+	Message string       `json:"message"`
+	Data    Subscription `json:"data"`
+}
+
+type FetchSubscriptionsResponse struct {
+	Status  bool           `json:"status"`
+	Message string         `json:"message"`
+	Data    []Subscription `json:"data"`
+	Meta    struct {
+		Total     int `json:"total"`
+		Skipped   int `json:"skipped"`
+		PerPage   int `json:"perPage"`
+		Page      int `json:"page"`
+		PageCount int `json:"pageCount"`
+	} `json:"meta"`
+}
+
+// SubscriptionStateResponse is the response of enabling or disabling a subscription and of emailing its manage link
+type SubscriptionStateResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+}
+
+type ManageLinkResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Data    struct {
+		Link string `json:"link"`
+	} `json:"data"`
+}
+
+type CreateSubscriptionRequest struct {
+	Customer      string `json:"customer"` // the customer's email or code
+	Plan          string `json:"plan"`     // the plan code
+	Authorization string `json:"authorization,omitempty"`
+	StartDate     string `json:"start_date,omitempty"` // ISO 8601, the first debit date
+}
+
+type FetchSubscriptionsRequest struct {
+	PerPage  int    `url:"perPage,omitempty" json:"perPage"`
+	Page     int    `url:"page,omitempty" json:"page"`
+	Customer int    `url:"customer,omitempty" json:"customer,omitempty"` // the customer's id
+	Plan     string `url:"plan,omitempty" json:"plan,omitempty"`         // the plan's id
+}
+
+// SubscriptionStateRequest enables or disables a subscription, the token is the email token of the subscription
+type SubscriptionStateRequest struct {
+	Code  string `json:"code"`
+	Token string `json:"token"`
+}
+
+var subscriptionUrl = payStackConfig.SubscriptionUrl()
+
+// CreateSubscription subscribes a customer to a plan, the customer is charged with their saved card or the authorization
+func CreateSubscription(sub CreateSubscriptionRequest, ctx context.Context) (*CreateSubscriptionResponse, error, int) {
+	if sub.Customer == "" || sub.Plan == "" {
+		return nil, fmt.Errorf("a customer and a plan are required"), http.StatusBadRequest
+	}
+
+	var created CreateSubscriptionResponse
+	err, statusCode := payStackRequest(ctx, http.MethodPost, subscriptionUrl, sub, &created, http.StatusOK, http.StatusCreated)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	return &created, nil, http.StatusCreated
+}
+
+// GetSubscription retrieves a subscription from the PayStack API by its id or code
+func GetSubscription(idOrCode string, ctx context.Context) (*FetchSubscriptionResponse, error, int) {
+	var sub FetchSubscriptionResponse
+	err, statusCode := payStackRequest(ctx, http.MethodGet, fmt.Sprintf("%s/%s", subscriptionUrl, burl.PathEscape(idOrCode)), nil, &sub, http.StatusOK)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	return &sub, nil, http.StatusOK
+}
+
+// FetchSubscriptions lists the subscriptions, optionally of a customer or a plan
+func FetchSubscriptions(arg FetchSubscriptionsRequest, ctx context.Context) (*FetchSubscriptionsResponse, error, int) {
+	v, err := query.Values(arg)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+
+	baseUrl, err := burl.Parse(subscriptionUrl)
+	if err != nil {
+		return nil, err, http.StatusInternalServerError
+	}
+	baseUrl.RawQuery = v.Encode()
+
+	var subs FetchSubscriptionsResponse
+	err, statusCode := payStackRequest(ctx, http.MethodGet, baseUrl.String(), nil, &subs, http.StatusOK)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	return &subs, nil, http.StatusOK
+}
+
+// EnableSubscription resumes a disabled subscription
+func EnableSubscription(state SubscriptionStateRequest, ctx context.Context) (*SubscriptionStateResponse, error, int) {
+	return changeSubscriptionState("enable", state, ctx)
+}
+
+// DisableSubscription stops the subscription from renewing
+func DisableSubscription(state SubscriptionStateRequest, ctx context.Context) (*SubscriptionStateResponse, error, int) {
+	return changeSubscriptionState("disable", state, ctx)
+}
+
+func changeSubscriptionState(action string, state SubscriptionStateRequest, ctx context.Context) (*SubscriptionStateResponse, error, int) {
+	if state.Code == "" || state.Token == "" {
+		return nil, fmt.Errorf("the subscription code and email token are required"), http.StatusBadRequest
+	}
+
+	var resp SubscriptionStateResponse
+	err, statusCode := payStackRequest(ctx, http.MethodPost, fmt.Sprintf("%s/%s", subscriptionUrl, action), state, &resp, http.StatusOK)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	return &resp, nil, http.StatusOK
+}
+
+// GenerateManageLink generates the link the customer updates the card of the subscription with
+func GenerateManageLink(code string, ctx context.Context) (*ManageLinkResponse, error, int) {
+	var link ManageLinkResponse
+	err, statusCode := payStackRequest(ctx, http.MethodGet, fmt.Sprintf("%s/%s/manage/link", subscriptionUrl, burl.PathEscape(code)), nil, &link, http.StatusOK)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	return &link, nil, http.StatusOK
+}
+
+// SendManageLink emails the manage link to the customer of the subscription
+func SendManageLink(code string, ctx context.Context) (*SubscriptionStateResponse, error, int) {
+	var resp SubscriptionStateResponse
+	err, statusCode := payStackRequest(ctx, http.MethodPost, fmt.Sprintf("%s/%s/manage/email", subscriptionUrl, burl.PathEscape(code)), nil, &resp, http.StatusOK)
+	if err != nil {
+		return nil, err, statusCode
+	}
+
+	return &resp, nil, http.StatusOK
+}
@@ -0,0 +1,176 @@
+package tiers
+
+import (
+	"context"
+	"control-panel-bk/pkg/audit"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"strconv"
+)
+
+// writePayStackResponse relays the response of the PayStack API to the panel
+func writePayStackResponse(w http.ResponseWriter, statusCode int, resp interface{}) {
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		util.ErrorException(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(respBytes); err != nil {
+		util.ErrorException(w, err, http.StatusInternalServerError)
+		return
+	}
+}
+
+func HandleCreateSubscription(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var csr CreateSubscriptionRequest
+		if err := json.NewDecoder(r.Body).Decode(&csr); err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		created, err, statusCode := CreateSubscription(csr, r.Context())
+		if err != nil {
+			util.ErrorException(w, err, statusCode)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     audit.SubscriptionCreated,
+			EntityType: audit.SubscriptionEntity,
+			EntityId:   created.Data.SubscriptionCode,
+			After:      csr,
+		})
+
+		writePayStackResponse(w, statusCode, created)
+	}
+}
+
+// HandleFetchSubscriptions takes the query params page, perPage, customer (the customer's id) and plan (the plan's id)
+func HandleFetchSubscriptions(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	query := r.URL.Query()
+
+	fsr := FetchSubscriptionsRequest{Page: 1, PerPage: 50, Plan: query.Get("plan")}
+
+	for param, dest := range map[string]*int{"page": &fsr.Page, "perPage": &fsr.PerPage, "customer": &fsr.Customer} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			util.ErrorException(w, errors.New("invalid "+param+" query param"), http.StatusBadRequest)
+			return
+		}
+		*dest = n
+	}
+
+	subs, err, statusCode := FetchSubscriptions(fsr, r.Context())
+	if err != nil {
+		util.ErrorException(w, err, statusCode)
+		return
+	}
+
+	writePayStackResponse(w, statusCode, subs)
+}
+
+func HandleFetchSubscription(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	sub, err, statusCode := GetSubscription(chi.URLParam(r, "code"), r.Context())
+	if err != nil {
+		util.ErrorException(w, err, statusCode)
+		return
+	}
+
+	writePayStackResponse(w, statusCode, sub)
+}
+
+func HandleEnableSubscription(db *mongo.Database) http.HandlerFunc {
+	return handleSubscriptionState(db, audit.SubscriptionEnabled, EnableSubscription)
+}
+
+func HandleDisableSubscription(db *mongo.Database) http.HandlerFunc {
+	return handleSubscriptionState(db, audit.SubscriptionDisabled, DisableSubscription)
+}
+
+// handleSubscriptionState enables or disables the subscription of the path. The email token is optional in the body,
+// when it is omitted it is read off the subscription.
+func handleSubscriptionState(db *mongo.Database, action audit.Action, change func(SubscriptionStateRequest, context.Context) (*SubscriptionStateResponse, error, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+		code := chi.URLParam(r, "code")
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				util.ErrorException(w, err, http.StatusBadRequest)
+				return
+			}
+		}
+
+		current, err, statusCode := GetSubscription(code, r.Context())
+		if err != nil {
+			util.ErrorException(w, err, statusCode)
+			return
+		}
+
+		if body.Token == "" {
+			body.Token = current.Data.EmailToken
+		}
+
+		resp, err, statusCode := change(SubscriptionStateRequest{Code: current.Data.SubscriptionCode, Token: body.Token}, r.Context())
+		if err != nil {
+			util.ErrorException(w, err, statusCode)
+			return
+		}
+
+		audit.Record(r.Context(), db, audit.Event{
+			Action:     action,
+			EntityType: audit.SubscriptionEntity,
+			EntityId:   current.Data.SubscriptionCode,
+			Before:     map[string]interface{}{"status": current.Data.Status},
+		})
+
+		writePayStackResponse(w, statusCode, resp)
+	}
+}
+
+// HandleManageLink generates the link the customer updates the card of the subscription with
+func HandleManageLink(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	link, err, statusCode := GenerateManageLink(chi.URLParam(r, "code"), r.Context())
+	if err != nil {
+		util.ErrorException(w, err, statusCode)
+		return
+	}
+
+	writePayStackResponse(w, statusCode, link)
+}
+
+// HandleSendManageLink emails the manage link to the customer of the subscription
+func HandleSendManageLink(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	sent, err, statusCode := SendManageLink(chi.URLParam(r, "code"), r.Context())
+	if err != nil {
+		util.ErrorException(w, err, statusCode)
+		return
+	}
+
+	writePayStackResponse(w, statusCode, sent)
+}
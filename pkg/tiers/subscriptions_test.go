@@ -0,0 +1,169 @@
+package tiers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCreateSubscription(t *testing.T) {
+	tests := []struct {
+		name           string
+		request        CreateSubscriptionRequest
+		mockResponse   interface{}
+		mockStatusCode int
+		expectedCode   int
+		expectedErr    string
+	}{
+		{
+			name:           "Success - Subscription Created",
+			request:        CreateSubscriptionRequest{Customer: "CUS_xnxdt6s1zg1f4nx", Plan: "PLN_gx2wn530m0i3w3m"},
+			mockResponse:   map[string]interface{}{"status": true, "message": "Subscription successfully created", "data": map[string]interface{}{"subscription_code": "SUB_vsyqdmlzble3uii", "email_token": "d7gofp6yppn3qz7"}},
+			mockStatusCode: http.StatusOK,
+			expectedCode:   http.StatusCreated,
+		},
+		{
+			name:           "Error - Customer Has No Authorization",
+			request:        CreateSubscriptionRequest{Customer: "CUS_xnxdt6s1zg1f4nx", Plan: "PLN_gx2wn530m0i3w3m"},
+			mockResponse:   APIError{Status: false, Message: "Customer has no authorization"},
+			mockStatusCode: http.StatusBadRequest,
+			expectedCode:   http.StatusBadRequest,
+			expectedErr:    "Customer has no authorization",
+		},
+		{
+			name:         "Error - Missing Plan",
+			request:      CreateSubscriptionRequest{Customer: "CUS_xnxdt6s1zg1f4nx"},
+			expectedCode: http.StatusBadRequest,
+			expectedErr:  "a customer and a plan are required",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := mockServer(tt.mockStatusCode, tt.mockResponse)
+			defer server.Close()
+
+			// Override the global URL with the mock server URL
+			subscriptionUrl = server.URL
+
+			response, err, code := CreateSubscription(tt.request, context.Background())
+			assert.Equal(t, tt.expectedCode, code)
+
+			if tt.expectedErr != "" {
+				assert.EqualError(t, err, tt.expectedErr)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, "SUB_vsyqdmlzble3uii", response.Data.SubscriptionCode)
+			assert.Equal(t, "d7gofp6yppn3qz7", response.Data.EmailToken)
+		})
+	}
+}
+
+func TestGetSubscription(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		if r.URL.Path != "/SUB_vsyqdmlzble3uii" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(APIError{Message: "Subscription not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":  true,
+			"message": "Subscription retrieved successfully",
+			"data": map[string]interface{}{
+				"subscription_code": "SUB_vsyqdmlzble3uii",
+				"status":            "active",
+				"customer":          map[string]interface{}{"email": "customer@email.com", "customer_code": "CUS_xnxdt6s1zg1f4nx"},
+				"plan":              map[string]interface{}{"plan_code": "PLN_gx2wn530m0i3w3m"},
+			},
+		})
+	}))
+	defer server.Close()
+
+	subscriptionUrl = server.URL
+
+	sub, err, code := GetSubscription("SUB_vsyqdmlzble3uii", context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, SubscriptionActive, sub.Data.Status)
+	assert.Equal(t, "customer@email.com", sub.Data.Customer.Email)
+	assert.Equal(t, "PLN_gx2wn530m0i3w3m", sub.Data.Plan.PlanCode)
+
+	_, err, code = GetSubscription("SUB_unknown", context.Background())
+	assert.EqualError(t, err, "Subscription not found")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, "/SUB_unknown", path)
+}
+
+func TestHandleFetchSubscriptions(t *testing.T) {
+	var rawQuery string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawQuery = r.URL.RawQuery
+		json.NewEncoder(w).Encode(FetchSubscriptionsResponse{Status: true, Message: "Subscriptions retrieved"})
+	}))
+	defer server.Close()
+
+	subscriptionUrl = server.URL
+
+	rec := httptest.NewRecorder()
+	HandleFetchSubscriptions(rec, httptest.NewRequest(http.MethodGet, "/subscriptions?page=2&perPage=10&customer=1173&plan=28", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "customer=1173&page=2&perPage=10&plan=28", rawQuery)
+
+	rec = httptest.NewRecorder()
+	HandleFetchSubscriptions(rec, httptest.NewRequest(http.MethodGet, "/subscriptions?page=first", nil))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestChangeSubscriptionState(t *testing.T) {
+	var got SubscriptionStateRequest
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(SubscriptionStateResponse{Status: true, Message: "Subscription disabled successfully"})
+	}))
+	defer server.Close()
+
+	subscriptionUrl = server.URL
+
+	resp, err, code := DisableSubscription(SubscriptionStateRequest{Code: "SUB_vsyqdmlzble3uii", Token: "d7gofp6yppn3qz7"}, context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Status)
+	assert.Equal(t, "/disable", path)
+	assert.Equal(t, "d7gofp6yppn3qz7", got.Token)
+
+	_, err, code = EnableSubscription(SubscriptionStateRequest{Code: "SUB_vsyqdmlzble3uii"}, context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestGenerateManageLink(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": true,
+			"data":   map[string]interface{}{"link": "https://paystack.com/manage/subscriptions/qlgwhpyq1ts9nsw"},
+		})
+	}))
+	defer server.Close()
+
+	subscriptionUrl = server.URL
+
+	link, err, code := GenerateManageLink("SUB_vsyqdmlzble3uii", context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/SUB_vsyqdmlzble3uii/manage/link", path)
+	assert.Equal(t, "https://paystack.com/manage/subscriptions/qlgwhpyq1ts9nsw", link.Data.Link)
+}
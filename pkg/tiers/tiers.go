@@ -1,14 +1,10 @@
 package tiers
 
 import (
-	"bytes"
 	"context"
 	cfg "control-panel-bk/config"
-	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/google/go-querystring/query"
-	"io"
 	"net/http"
 	burl "net/url"
 	"time"
@@ -199,11 +195,11 @@ type CreateTierRequest struct {
 }
 
 type FetchTiersRequest struct {
-	PerPage  int      `json:"perPage"`
-	Page     int      `json:"page"`
-	Status   string   `json:"status,omitempty"`
-	Interval Interval `json:"interval,omitempty"`
-	Amount   int64    `json:"amount,omitempty"`
+	PerPage  int      `url:"perPage,omitempty" json:"perPage"`
+	Page     int      `url:"page,omitempty" json:"page"`
+	Status   string   `url:"status,omitempty" json:"status,omitempty"`
+	Interval Interval `url:"interval,omitempty" json:"interval,omitempty"`
+	Amount   int64    `url:"amount,omitempty" json:"amount,omitempty"`
 }
 
 type UpdateTierRequest struct {
@@ -219,111 +215,37 @@ type APIError struct {
 	Type    string                 `json:"type"`
 }
 
+// client every call to Paystack goes through, retried and guarded by the circuit breaker of its transport
 var client = &http.Client{
-	Timeout: 10 * time.Second,
-	Transport: &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
-	},
+	Timeout: 30 * time.Second, // every attempt and backoff included
+	Transport: newPaystackTransport(&http.Transport{
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+		ResponseHeaderTimeout: 10 * time.Second,
+	}),
 }
 
 var payStackConfig = cfg.DefaultPayStackConfiguration()
 var url = payStackConfig.PlanUrl()
 
-// CreateTier creates a new tier on the PayStack API
-func CreateTier(tier CreateTierRequest, ctx context.Context) (*TierResponse, error, int) {
-	body, err := json.Marshal(tier)
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
-	// We need to check if the Tiers exist already
-	par := FetchTiersRequest{
-		PerPage:  0,
-		Amount:   tier.Amount,
-		Interval: tier.Interval,
-		Page:     1,
-		Status:   "active",
-	}
-
-	existingTiers, err, statusCode := FetchTiers(par, ctx)
+// createTier creates the tier on the PayStack API, the caller has checked it is not a duplicate
+func createTier(tier CreateTierRequest, ctx context.Context) (*TierResponse, error, int) {
+	var createdTier TierResponse
+	err, statusCode := payStackRequest(ctx, http.MethodPost, url, tier, &createdTier, http.StatusCreated)
 	if err != nil {
 		return nil, err, statusCode
 	}
 
-	if len(existingTiers.Data) > 0 {
-		return nil, errors.New("an active tier with the same data already exists"), http.StatusOK
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-	req.Header.Set("Authorization", cfg.PayStackConfig.Headers.Authorization)
-	req.Header.Set("Content-Type", cfg.PayStackConfig.Headers.ContentType)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
-	defer resp.Body.Close()
-
-	responseBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
-	if resp.StatusCode != http.StatusCreated {
-		var errResponse APIError
-		if err := json.Unmarshal(responseBytes, &errResponse); err != nil {
-			return nil, err, http.StatusInternalServerError
-		}
-
-		return nil, errors.New(errResponse.Message), resp.StatusCode
-	}
-
-	var createdTier TierResponse
-	if err := json.Unmarshal(responseBytes, &createdTier); err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
 	return &createdTier, nil, http.StatusCreated
 }
 
 // GetTier retrieves a tier from the PayStack API by the plan code
 func GetTier(planCode string, ctx context.Context) (*FetchTierResponse, error, int) {
-	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/%s", url, planCode), nil)
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-	req.Header.Set("Authorization", cfg.PayStackConfig.Headers.Authorization)
-	req.Header.Set("Content-Type", cfg.PayStackConfig.Headers.ContentType)
-
-	resp, respErr := client.Do(req)
-	defer resp.Body.Close()
-
-	if respErr != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
-	respBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var apiErr APIError
-		if err := json.Unmarshal(respBytes, &apiErr); err != nil {
-			return nil, errors.New(apiErr.Message), resp.StatusCode
-		}
-		return nil, fmt.Errorf("failed to get tier: %d", resp.StatusCode), resp.StatusCode
-	}
-
 	var tier FetchTierResponse
-	if err := json.Unmarshal(respBytes, &tier); err != nil {
-		return nil, err, http.StatusInternalServerError
+	err, statusCode := payStackRequest(ctx, http.MethodGet, fmt.Sprintf("%s/%s", url, burl.PathEscape(planCode)), nil, &tier, http.StatusOK)
+	if err != nil {
+		return nil, err, statusCode
 	}
 
 	return &tier, nil, http.StatusOK
@@ -342,81 +264,22 @@ func FetchTiers(arg FetchTiersRequest, ctx context.Context) (*FetchTiersResponse
 	}
 
 	baseUrl.RawQuery = v.Encode()
-	baseUrlStr := baseUrl.String()
 
-	req, e := http.NewRequestWithContext(ctx, "GET", baseUrlStr, nil)
-	if e != nil {
-		return nil, e, http.StatusInternalServerError
-	}
-
-	req.Header.Set("Authorization", cfg.PayStackConfig.Headers.Authorization)
-	req.Header.Set("Content-Type", cfg.PayStackConfig.Headers.ContentType)
-
-	resp, err := client.Do(req)
+	var fetchTiers FetchTiersResponse
+	err, statusCode := payStackRequest(ctx, http.MethodGet, baseUrl.String(), nil, &fetchTiers, http.StatusOK)
 	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
-	defer resp.Body.Close()
-
-	respBody, respBodyErr := io.ReadAll(resp.Body)
-	if respBodyErr != nil {
-		return nil, respBodyErr, http.StatusInternalServerError
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var errorResponse APIError
-		if e := json.Unmarshal(respBody, &errorResponse); e != nil {
-			return nil, errors.New(errorResponse.Message), http.StatusInternalServerError
-		}
-		return nil, fmt.Errorf("failed to get tiers: %d", resp.StatusCode), resp.StatusCode
+		return nil, err, statusCode
 	}
 
-	var fetchTiers FetchTiersResponse
-	if err := json.Unmarshal(respBody, &fetchTiers); err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
 	return &fetchTiers, nil, http.StatusOK
 }
 
 // UpdateTier updates a tier via the PayStack API
 func UpdateTier(planCode string, updateOption UpdateTierRequest, ctx context.Context) (*UpdateTierResponse, error, int) {
-	body, err := json.Marshal(updateOption)
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-
-	req, err := http.NewRequestWithContext(ctx, "PUT", fmt.Sprintf("%s/%s", url, planCode), bytes.NewReader(body))
-	if err != nil {
-		return nil, err, http.StatusInternalServerError
-	}
-	req.Header.Set("Authorization", cfg.PayStackConfig.Headers.Authorization)
-	req.Header.Set("Content-Type", cfg.PayStackConfig.Headers.ContentType)
-
-	resp, respErr := client.Do(req)
-	if respErr != nil {
-		return nil, respErr, resp.StatusCode
-	}
-
-	defer resp.Body.Close()
-
-	respBytes, e := io.ReadAll(resp.Body)
-	if e != nil {
-		return nil, e, http.StatusInternalServerError
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		var apiErr APIError
-		if err := json.Unmarshal(respBytes, &apiErr); err != nil {
-			return nil, errors.New(apiErr.Message), resp.StatusCode
-		}
-
-		return nil, fmt.Errorf("failed to update tier"), resp.StatusCode
-	}
-
 	var updatedTier UpdateTierResponse
-	if err := json.Unmarshal(respBytes, &updatedTier); err != nil {
-		return nil, err, http.StatusInternalServerError
+	err, statusCode := payStackRequest(ctx, http.MethodPut, fmt.Sprintf("%s/%s", url, burl.PathEscape(planCode)), updateOption, &updatedTier, http.StatusOK)
+	if err != nil {
+		return nil, err, statusCode
 	}
 
 	return &updatedTier, nil, http.StatusOK
@@ -18,87 +18,6 @@ func mockServer(statusCode int, response interface{}) *httptest.Server {
 	}))
 }
 
-func TestCreateTier(t *testing.T) {
-	tests := []struct {
-		name           string
-		mockResponse   TierResponse
-		mockStatusCode int
-		request        CreateTierRequest
-		expectedError  error
-		expectedCode   int
-	}{
-		{
-			name: "Success - Tier Created",
-			mockResponse: TierResponse{
-				Status:  true,
-				Message: "Tier created successfully",
-				Data: struct {
-					Name         string    `json:"name"`
-					Amount       int       `json:"amount"`
-					Interval     string    `json:"interval"`
-					Integration  int       `json:"integration"`
-					Domain       string    `json:"domain"`
-					PlanCode     string    `json:"plan_code"`
-					SendInvoices bool      `json:"send_invoices"`
-					SendSms      bool      `json:"send_sms"`
-					HostedPage   bool      `json:"hosted_page"`
-					Currency     string    `json:"currency"`
-					Id           int       `json:"id"`
-					CreatedAt    time.Time `json:"createdAt"`
-					UpdatedAt    time.Time `json:"updatedAt"`
-				}{
-					Name:     "Test Tier",
-					Amount:   1000,
-					Interval: string(IntervalMonthly),
-					Currency: string(CurrencyUSD),
-				},
-			},
-			mockStatusCode: http.StatusCreated,
-			request: CreateTierRequest{
-				Name:     "Test Tier",
-				Amount:   1000,
-				Interval: IntervalMonthly,
-				Currency: CurrencyNGN,
-			},
-			expectedError: nil,
-			expectedCode:  http.StatusCreated,
-		},
-		{
-			name:           "Error - Duplicate Tier",
-			mockResponse:   TierResponse{},
-			mockStatusCode: http.StatusConflict,
-			request: CreateTierRequest{
-				Name:     "Test Tier",
-				Amount:   1000,
-				Interval: IntervalMonthly,
-				Currency: CurrencyNGN,
-			},
-			expectedError: errors.New("an active tier with the same data already exists"),
-			expectedCode:  http.StatusOK,
-		},
-	}
-
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			server := mockServer(tt.mockStatusCode, tt.mockResponse)
-			defer server.Close()
-
-			// Override the global URL with the mock server URL
-			url = server.URL
-
-			response, err, code := CreateTier(tt.request, context.Background())
-			if err != nil && response == nil {
-				t.Logf("Except error code %d not equals to 201", code)
-			}
-
-			if response != nil {
-				t.Logf("Except response status %d to be equals to %d", tt.expectedCode, code)
-				t.Logf("Except response data name %s equals to %s", tt.name, response.Data.Name)
-			}
-		})
-	}
-}
-
 func TestGetTier(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -0,0 +1,276 @@
+package tiers
+
+import (
+	"bytes"
+	"context"
+	cfg "control-panel-bk/config"
+	"control-panel-bk/util"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrPaystackUnavailable is returned without calling Paystack while the circuit breaker is open
+var ErrPaystackUnavailable = errors.New("paystack is unavailable, try again later")
+
+// paystackTransport retries the requests to Paystack that failed on the network, were throttled (429) or failed on
+// Paystack's side (5xx). POSTs carry an Idempotency-Key, the same on every attempt, so a retried POST is not applied twice.
+type paystackTransport struct {
+	next       http.RoundTripper
+	maxRetries int
+	baseDelay  time.Duration // first backoff, doubled on every retry
+	maxDelay   time.Duration // cap of the backoff and of Retry-After
+	breaker    *circuitBreaker
+}
+
+func newPaystackTransport(next http.RoundTripper) *paystackTransport {
+	return &paystackTransport{
+		next:       next,
+		maxRetries: 3,
+		baseDelay:  200 * time.Millisecond,
+		maxDelay:   5 * time.Second,
+		breaker:    newCircuitBreaker(5, 30*time.Second),
+	}
+}
+
+// retryable the statuses worth another attempt
+func retryable(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode >= http.StatusInternalServerError
+}
+
+// retryAfter reads the Retry-After header, in seconds or as an HTTP date
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(value); err == nil {
+		if d := at.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+
+	return 0, false
+}
+
+// backoff the delay before the retry, the Retry-After of the response when it has one, else an exponential backoff with jitter
+func (t *paystackTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	delay, ok := retryAfter(resp, time.Now())
+	if !ok {
+		delay = t.baseDelay << attempt
+		delay = delay/2 + rand.N(delay/2+1)
+	}
+
+	return min(delay, t.maxDelay)
+}
+
+func (t *paystackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == http.MethodPost && req.Header.Get("Idempotency-Key") == "" {
+		key, err := util.GenerateUuid()
+		if err != nil {
+			return nil, err
+		}
+
+		// A RoundTripper must not modify the request it is given
+		req = req.Clone(req.Context())
+		req.Header.Set("Idempotency-Key", key.String())
+	}
+
+	for attempt := 0; ; attempt++ {
+		if !t.breaker.allow() {
+			return nil, ErrPaystackUnavailable
+		}
+
+		resp, err := t.next.RoundTrip(req)
+
+		// Given up by the caller, it tells nothing about the health of Paystack
+		if err != nil && req.Context().Err() != nil {
+			t.breaker.release()
+			return nil, err
+		}
+
+		failed := err != nil || resp.StatusCode >= http.StatusInternalServerError
+		t.breaker.record(!failed)
+
+		if (err == nil && !retryable(resp.StatusCode)) || attempt >= t.maxRetries {
+			return resp, err
+		}
+
+		// The body of the request was consumed by the attempt, it is replayed from GetBody
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return resp, err
+			}
+
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return resp, err
+			}
+
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		delay := t.backoff(attempt, resp)
+
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if sleepErr := sleep(req.Context(), delay); sleepErr != nil {
+			return nil, sleepErr
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker opens after a number of consecutive failures and lets a single probe through once the cooldown is
+// over, the probe closes it again or reopens it
+type circuitBreaker struct {
+	mu        sync.Mutex
+	state     breakerState
+	failures  int
+	threshold int
+	cooldown  time.Duration
+	openedAt  time.Time
+	now       func() time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+
+	case breakerHalfOpen:
+		// The probe is in flight
+		return false
+	}
+
+	return true
+}
+
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state, b.failures = breakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == breakerHalfOpen || b.failures >= b.threshold {
+		b.state, b.openedAt = breakerOpen, b.now()
+	}
+}
+
+// release gives the probe back when it ended without an answer from Paystack, the next call probes again
+func (b *circuitBreaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == breakerHalfOpen {
+		b.state = breakerOpen
+	}
+}
+
+// payStackRequest sends the request to the PayStack API and decodes the response into out. A status other than the
+// expected ones is turned into the error of the API along with its status code.
+func payStackRequest(ctx context.Context, method string, endpoint string, payload interface{}, out interface{}, expected ...int) (error, int) {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err, http.StatusInternalServerError
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+	req.Header.Set("Authorization", cfg.PayStackConfig.Headers.Authorization)
+	req.Header.Set("Content-Type", cfg.PayStackConfig.Headers.ContentType)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		if errors.Is(err, ErrPaystackUnavailable) {
+			return ErrPaystackUnavailable, http.StatusServiceUnavailable
+		}
+		return err, http.StatusBadGateway
+	}
+
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	ok := false
+	for _, code := range expected {
+		ok = ok || resp.StatusCode == code
+	}
+
+	if !ok {
+		var apiErr APIError
+		if err := json.Unmarshal(respBytes, &apiErr); err == nil && apiErr.Message != "" {
+			return fmt.Errorf("%s", apiErr.Message), resp.StatusCode
+		}
+		return fmt.Errorf("paystack responded with %d", resp.StatusCode), resp.StatusCode
+	}
+
+	if err := json.Unmarshal(respBytes, out); err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	return nil, resp.StatusCode
+}
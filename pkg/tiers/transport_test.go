@@ -0,0 +1,210 @@
+package tiers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withTransport routes the calls to Paystack through a fresh transport for the test, with no real backoff
+func withTransport(t *testing.T, configure func(tr *paystackTransport)) *paystackTransport {
+	tr := newPaystackTransport(http.DefaultTransport)
+	tr.baseDelay, tr.maxDelay = time.Millisecond, 5*time.Millisecond
+	if configure != nil {
+		configure(tr)
+	}
+
+	original := client
+	client = &http.Client{Transport: tr, Timeout: 5 * time.Second}
+	t.Cleanup(func() { client = original })
+
+	return tr
+}
+
+// faultyServer answers with the faults in order, then with the response
+func faultyServer(t *testing.T, faults []int, response interface{}) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if int(n) <= len(faults) {
+			w.WriteHeader(faults[n-1])
+			json.NewEncoder(w).Encode(APIError{Message: http.StatusText(faults[n-1])})
+			return
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return server, &calls
+}
+
+func TestTransport_RetriesServerErrors(t *testing.T) {
+	withTransport(t, nil)
+
+	server, calls := faultyServer(t, []int{http.StatusBadGateway, http.StatusServiceUnavailable}, UpdateTierResponse{Status: true, Message: "Plan updated"})
+	url = server.URL
+
+	resp, err, code := UpdateTier("PLN_1", UpdateTierRequest{}, context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Plan updated", resp.Message)
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestTransport_DoesNotRetryClientErrors(t *testing.T) {
+	withTransport(t, nil)
+
+	server, calls := faultyServer(t, []int{http.StatusNotFound}, nil)
+	url = server.URL
+
+	_, err, code := GetTier("PLN_unknown", context.Background())
+	assert.EqualError(t, err, "Not Found")
+	assert.Equal(t, http.StatusNotFound, code)
+	assert.Equal(t, int32(1), atomic.LoadInt32(calls))
+}
+
+func TestTransport_GivesUpAfterMaxRetries(t *testing.T) {
+	withTransport(t, func(tr *paystackTransport) { tr.maxRetries = 2 })
+
+	server, calls := faultyServer(t, []int{500, 500, 500, 500}, nil)
+	url = server.URL
+
+	_, err, code := GetTier("PLN_1", context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusInternalServerError, code, "the status of Paystack is propagated")
+	assert.Equal(t, int32(3), atomic.LoadInt32(calls))
+}
+
+func TestTransport_ReplaysPostsWithTheSameIdempotencyKey(t *testing.T) {
+	withTransport(t, nil)
+
+	var keys, bodies []string
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		bodies = append(bodies, string(body))
+
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(TierResponse{Status: true})
+	}))
+	defer server.Close()
+
+	url = server.URL
+
+	_, err, code := createTier(CreateTierRequest{Name: "Basic", Amount: 500000, Interval: IntervalMonthly}, context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusCreated, code)
+
+	require.Len(t, keys, 2)
+	assert.NotEmpty(t, keys[0])
+	assert.Equal(t, keys[0], keys[1])
+	assert.Equal(t, bodies[0], bodies[1])
+	assert.Contains(t, bodies[1], `"name":"Basic"`)
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	withHeader := func(value string) *http.Response {
+		return &http.Response{Header: http.Header{"Retry-After": []string{value}}}
+	}
+
+	d, ok := retryAfter(withHeader("2"), now)
+	assert.True(t, ok)
+	assert.Equal(t, 2*time.Second, d)
+
+	d, ok = retryAfter(withHeader(now.Add(3*time.Second).Format(http.TimeFormat)), now)
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	_, ok = retryAfter(withHeader("soon"), now)
+	assert.False(t, ok)
+
+	_, ok = retryAfter(nil, now)
+	assert.False(t, ok)
+
+	tr := newPaystackTransport(nil)
+	assert.Equal(t, 2*time.Second, tr.backoff(0, withHeader("2")), "Retry-After is honored")
+	assert.Equal(t, tr.maxDelay, tr.backoff(0, withHeader("3600")), "but capped")
+	assert.LessOrEqual(t, tr.backoff(1, nil), 2*tr.baseDelay)
+}
+
+func TestTransport_CircuitBreaker(t *testing.T) {
+	now := time.Now()
+	tr := withTransport(t, func(tr *paystackTransport) {
+		tr.maxRetries = 0
+		tr.breaker = newCircuitBreaker(3, time.Minute)
+		tr.breaker.now = func() time.Time { return now }
+	})
+
+	down := true
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(FetchTierResponse{Status: true, Message: "Plan retrieved"})
+	}))
+	defer server.Close()
+
+	url = server.URL
+
+	for i := 0; i < 3; i++ {
+		GetTier("PLN_1", context.Background())
+	}
+
+	// Open, the calls fail fast without reaching Paystack
+	_, err, code := GetTier("PLN_1", context.Background())
+	assert.Equal(t, ErrPaystackUnavailable, err)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// Once the cooldown is over a failing probe reopens it
+	now = now.Add(time.Minute)
+	_, _, code = GetTier("PLN_1", context.Background())
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	_, err, _ = GetTier("PLN_1", context.Background())
+	assert.Equal(t, ErrPaystackUnavailable, err)
+
+	// And a successful probe closes it
+	down = false
+	now = now.Add(time.Minute)
+
+	resp, err, code := GetTier("PLN_1", context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "Plan retrieved", resp.Message)
+	assert.Equal(t, breakerClosed, tr.breaker.state)
+}
+
+func TestTransport_UnreachablePaystack(t *testing.T) {
+	withTransport(t, func(tr *paystackTransport) { tr.maxRetries = 1 })
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	url = server.URL
+	server.Close()
+
+	// Both used to dereference the nil response of the failed call
+	_, err, code := GetTier("PLN_1", context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadGateway, code)
+
+	_, err, code = UpdateTier("PLN_1", UpdateTierRequest{}, context.Background())
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadGateway, code)
+}
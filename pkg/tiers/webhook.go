@@ -0,0 +1,261 @@
+package tiers
+
+import (
+	"context"
+	"control-panel-bk/util"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+const (
+	PaystackEventsCollection = "paystack_events"
+	SubscriptionsCollection  = "subscriptions"
+
+	// maxWebhookBody bounds the payload read from Paystack before its signature is checked
+	maxWebhookBody = 1 << 20
+)
+
+const (
+	EventSubscriptionCreate   = "subscription.create"
+	EventSubscriptionDisable  = "subscription.disable"
+	EventSubscriptionNotRenew = "subscription.not_renew"
+	EventChargeSuccess        = "charge.success"
+	EventInvoiceCreate        = "invoice.create"
+	EventInvoiceUpdate        = "invoice.update"
+	EventInvoicePaymentFailed = "invoice.payment_failed"
+)
+
+// WebhookEvent is the payload Paystack posts to the webhook url
+type WebhookEvent struct {
+	Event string          `json:"event"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// PaystackEvent is a webhook event as it is persisted, the id makes a redelivered event a no-op
+type PaystackEvent struct {
+	ID          string                 `json:"_id"`
+	Event       string                 `json:"event"`
+	Data        map[string]interface{} `json:"data"`
+	ReceivedAt  time.Time              `json:"received_at"`
+	ProcessedAt *time.Time             `json:"processed_at,omitempty"`
+	Error       string                 `json:"error,omitempty"`
+}
+
+// webhookData holds the fields of the event data the subscriptions are kept in sync with
+type webhookData struct {
+	Id               json.Number `json:"id"`
+	Reference        string      `json:"reference"`
+	Status           string      `json:"status"`
+	SubscriptionCode string      `json:"subscription_code"`
+	InvoiceCode      string      `json:"invoice_code"`
+	Amount           int64       `json:"amount"`
+	NextPaymentDate  string      `json:"next_payment_date"`
+	Paid             bool        `json:"paid"`
+	Customer         struct {
+		Email        string `json:"email"`
+		CustomerCode string `json:"customer_code"`
+	} `json:"customer"`
+	Plan struct {
+		PlanCode string `json:"plan_code"`
+		Name     string `json:"name"`
+	} `json:"plan"`
+	Subscription struct {
+		SubscriptionCode string `json:"subscription_code"`
+		Status           string `json:"status"`
+		NextPaymentDate  string `json:"next_payment_date"`
+	} `json:"subscription"`
+}
+
+// VerifyWebhookSignature checks the x-paystack-signature header, the hex HMAC-SHA512 of the raw body keyed by the secret key
+func VerifyWebhookSignature(body []byte, signature string, secret string) bool {
+	if secret == "" || signature == "" {
+		return false
+	}
+
+	given, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+
+	return hmac.Equal(given, mac.Sum(nil))
+}
+
+// eventKey identifies the event, Paystack redelivers an event with the same data until it gets a 200
+func eventKey(event WebhookEvent, data webhookData, body []byte) string {
+	switch {
+	case data.Id != "":
+		return fmt.Sprintf("%s:%s", event.Event, data.Id)
+	case data.Reference != "":
+		return fmt.Sprintf("%s:%s", event.Event, data.Reference)
+	case data.InvoiceCode != "":
+		return fmt.Sprintf("%s:%s", event.Event, data.InvoiceCode)
+	case data.SubscriptionCode != "":
+		return fmt.Sprintf("%s:%s", event.Event, data.SubscriptionCode)
+	default:
+		sum := sha256.Sum256(body)
+		return fmt.Sprintf("%s:%s", event.Event, hex.EncodeToString(sum[:]))
+	}
+}
+
+// subscriptionUpdate maps the event onto the change of the subscription it concerns, ok is false for the events that
+// do not concern a subscription
+func subscriptionUpdate(event string, data webhookData) (filter bson.M, update bson.M, ok bool) {
+	now := time.Now().UTC()
+
+	switch event {
+	case EventSubscriptionCreate, EventSubscriptionDisable, EventSubscriptionNotRenew:
+		if data.SubscriptionCode == "" {
+			return nil, nil, false
+		}
+
+		set := bson.M{
+			"status":         data.Status,
+			"plan_code":      data.Plan.PlanCode,
+			"customer_email": data.Customer.Email,
+			"customer_code":  data.Customer.CustomerCode,
+			"amount":         data.Amount,
+			"updated_at":     now,
+		}
+
+		if next, err := time.Parse(time.RFC3339, data.NextPaymentDate); err == nil {
+			set["next_payment_date"] = next
+		}
+
+		return bson.M{"subscription_code": data.SubscriptionCode}, bson.M{"$set": set, "$setOnInsert": bson.M{"created_at": now}}, true
+
+	case EventInvoicePaymentFailed, EventInvoiceUpdate:
+		if data.Subscription.SubscriptionCode == "" {
+			return nil, nil, false
+		}
+
+		set := bson.M{
+			"last_invoice_code": data.InvoiceCode,
+			"updated_at":        now,
+		}
+
+		if event == EventInvoicePaymentFailed || !data.Paid {
+			set["last_payment_status"] = "failed"
+		} else {
+			set["last_payment_status"] = "success"
+			set["last_payment_at"] = now
+		}
+
+		if data.Subscription.Status != "" {
+			set["status"] = data.Subscription.Status
+		}
+
+		return bson.M{"subscription_code": data.Subscription.SubscriptionCode}, bson.M{"$set": set}, true
+
+	case EventChargeSuccess:
+		// Only the charges of a plan renew a subscription
+		if data.Plan.PlanCode == "" || data.Customer.CustomerCode == "" {
+			return nil, nil, false
+		}
+
+		return bson.M{"plan_code": data.Plan.PlanCode, "customer_code": data.Customer.CustomerCode}, bson.M{"$set": bson.M{
+			"last_payment_status": "success",
+			"last_payment_at":     now,
+			"last_reference":      data.Reference,
+			"updated_at":          now,
+		}}, true
+	}
+
+	return nil, nil, false
+}
+
+// recordWebhookEvent persists the event once and applies it to the subscriptions, a redelivered event is only
+// applied again when its first delivery failed to be applied
+var recordWebhookEvent = func(ctx context.Context, db *mongo.Database, key string, event WebhookEvent, data webhookData) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(event.Data, &raw); err != nil {
+		return err
+	}
+
+	events := db.Collection(PaystackEventsCollection)
+
+	_, err := events.InsertOne(ctx, PaystackEvent{ID: key, Event: event.Event, Data: raw, ReceivedAt: time.Now().UTC()})
+	if err != nil {
+		if !mongo.IsDuplicateKeyError(err) {
+			return err
+		}
+
+		var existing PaystackEvent
+		if err := events.FindOne(ctx, bson.M{"_id": key}).Decode(&existing); err != nil {
+			return err
+		}
+
+		if existing.ProcessedAt != nil {
+			return nil
+		}
+	}
+
+	if filter, update, ok := subscriptionUpdate(event.Event, data); ok {
+		upsert := event.Event == EventSubscriptionCreate
+		if _, err := db.Collection(SubscriptionsCollection).UpdateOne(ctx, filter, update, options.UpdateOne().SetUpsert(upsert)); err != nil {
+			events.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"error": err.Error()}})
+			return err
+		}
+	}
+
+	_, err = events.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"processed_at": time.Now().UTC()}, "$unset": bson.M{"error": ""}})
+	return err
+}
+
+// Handlers
+
+// HandlePaystackWebhook receives the events of Paystack. It is not behind the panel's auth, the signature of the body is
+// what authenticates the request.
+func HandlePaystackWebhook(db *mongo.Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		if err != nil {
+			util.ErrorException(w, err, http.StatusBadRequest)
+			return
+		}
+
+		if !VerifyWebhookSignature(body, r.Header.Get("x-paystack-signature"), payStackConfig.SecretKey) {
+			util.ErrorException(w, errors.New("invalid paystack signature"), http.StatusUnauthorized)
+			return
+		}
+
+		var event WebhookEvent
+		if err := json.Unmarshal(body, &event); err != nil || event.Event == "" {
+			util.ErrorException(w, errors.New("malformed paystack event"), http.StatusBadRequest)
+			return
+		}
+
+		var data webhookData
+		if err := json.Unmarshal(event.Data, &data); err != nil {
+			util.ErrorException(w, fmt.Errorf("malformed paystack event data: %w", err), http.StatusBadRequest)
+			return
+		}
+
+		key := eventKey(event, data, body)
+
+		// A non 200 response makes Paystack deliver the event again later
+		if err := recordWebhookEvent(r.Context(), db, key, event, data); err != nil {
+			log.Printf("paystack webhook: unable to record %s: %s", key, err.Error())
+			util.ErrorException(w, errors.New("the event could not be recorded"), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
@@ -0,0 +1,150 @@
+package tiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const testWebhookSecret = "sk_test_webhook"
+
+func sign(body []byte, secret string) string {
+	mac := hmac.New(sha512.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhookSignature(t *testing.T) {
+	body := []byte(`{"event":"charge.success","data":{"id":1}}`)
+
+	assert.True(t, VerifyWebhookSignature(body, sign(body, testWebhookSecret), testWebhookSecret))
+	assert.False(t, VerifyWebhookSignature(body, sign(body, "another-secret"), testWebhookSecret))
+	assert.False(t, VerifyWebhookSignature(append(body, ' '), sign(body, testWebhookSecret), testWebhookSecret))
+	assert.False(t, VerifyWebhookSignature(body, "not-hex", testWebhookSecret))
+	assert.False(t, VerifyWebhookSignature(body, "", testWebhookSecret))
+	assert.False(t, VerifyWebhookSignature(body, sign(body, ""), ""), "an unset secret key rejects every event")
+}
+
+func TestHandlePaystackWebhook(t *testing.T) {
+	originalSecret, originalRecord := payStackConfig.SecretKey, recordWebhookEvent
+	defer func() { payStackConfig.SecretKey, recordWebhookEvent = originalSecret, originalRecord }()
+
+	payStackConfig.SecretKey = testWebhookSecret
+
+	var recorded []string
+	recordWebhookEvent = func(ctx context.Context, db *mongo.Database, key string, event WebhookEvent, data webhookData) error {
+		if data.SubscriptionCode == "SUB_broken" {
+			return errors.New("database unavailable")
+		}
+		recorded = append(recorded, key)
+		return nil
+	}
+
+	testCases := []struct {
+		name       string
+		body       string
+		signature  func(body []byte) string
+		wantStatus int
+		wantKey    string
+	}{
+		{
+			name:       "valid subscription event",
+			body:       `{"event":"subscription.create","data":{"id":4192,"subscription_code":"SUB_vsyqdmlzble3uii","status":"active"}}`,
+			signature:  func(b []byte) string { return sign(b, testWebhookSecret) },
+			wantStatus: http.StatusOK,
+			wantKey:    "subscription.create:4192",
+		},
+		{
+			name:       "event keyed by its reference",
+			body:       `{"event":"charge.success","data":{"reference":"ref_123"}}`,
+			signature:  func(b []byte) string { return sign(b, testWebhookSecret) },
+			wantStatus: http.StatusOK,
+			wantKey:    "charge.success:ref_123",
+		},
+		{
+			name:       "forged signature",
+			body:       `{"event":"subscription.disable","data":{"id":1}}`,
+			signature:  func(b []byte) string { return sign(b, "guessed") },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "missing signature",
+			body:       `{"event":"subscription.disable","data":{"id":1}}`,
+			signature:  func(b []byte) string { return "" },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "malformed event",
+			body:       `{"data":{"id":1}}`,
+			signature:  func(b []byte) string { return sign(b, testWebhookSecret) },
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "recording fails so Paystack retries",
+			body:       `{"event":"subscription.disable","data":{"subscription_code":"SUB_broken"}}`,
+			signature:  func(b []byte) string { return sign(b, testWebhookSecret) },
+			wantStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorded = nil
+
+			req := httptest.NewRequest(http.MethodPost, "/webhooks/paystack", bytes.NewReader([]byte(tc.body)))
+			req.Header.Set("x-paystack-signature", tc.signature([]byte(tc.body)))
+			rec := httptest.NewRecorder()
+
+			HandlePaystackWebhook(nil).ServeHTTP(rec, req)
+
+			assert.Equal(t, tc.wantStatus, rec.Code)
+			if tc.wantKey != "" {
+				assert.Equal(t, []string{tc.wantKey}, recorded)
+			}
+		})
+	}
+}
+
+func TestSubscriptionUpdate(t *testing.T) {
+	var disabled webhookData
+	disabled.SubscriptionCode = "SUB_1"
+	disabled.Status = "complete"
+	disabled.Plan.PlanCode = "PLN_1"
+	disabled.Customer.CustomerCode = "CUS_1"
+	disabled.NextPaymentDate = "2025-05-19T07:00:00Z"
+
+	filter, update, ok := subscriptionUpdate(EventSubscriptionDisable, disabled)
+	assert.True(t, ok)
+	assert.Equal(t, bson.M{"subscription_code": "SUB_1"}, filter)
+	assert.Equal(t, "complete", update["$set"].(bson.M)["status"])
+	assert.Contains(t, update["$set"], "next_payment_date")
+
+	var failed webhookData
+	failed.InvoiceCode = "INV_1"
+	failed.Subscription.SubscriptionCode = "SUB_1"
+	failed.Subscription.Status = "attention"
+
+	filter, update, ok = subscriptionUpdate(EventInvoicePaymentFailed, failed)
+	assert.True(t, ok)
+	assert.Equal(t, bson.M{"subscription_code": "SUB_1"}, filter)
+	assert.Equal(t, "failed", update["$set"].(bson.M)["last_payment_status"])
+	assert.Equal(t, "attention", update["$set"].(bson.M)["status"])
+
+	var oneOff webhookData
+	oneOff.Reference = "ref_1"
+
+	_, _, ok = subscriptionUpdate(EventChargeSuccess, oneOff)
+	assert.False(t, ok, "a charge outside of a plan does not concern a subscription")
+
+	_, _, ok = subscriptionUpdate("transfer.success", oneOff)
+	assert.False(t, ok)
+}
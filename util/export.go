@@ -0,0 +1,334 @@
+package util
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFormat is the file format of an export, the query param format
+type ExportFormat string
+
+const (
+	CSVExport   ExportFormat = "csv"
+	XLSXExport  ExportFormat = "xlsx"
+	JSONLExport ExportFormat = "jsonl"
+)
+
+// exportFlushRows is how many rows are written between two flushes of the response
+const exportFlushRows = 500
+
+// maxXLSXCell is the most characters a spreadsheet cell holds, the longer values are cut
+const maxXLSXCell = 32767
+
+// ParseExportFormat reads the query param format, csv when it is not given
+func ParseExportFormat(r *http.Request) (ExportFormat, error) {
+	switch f := ExportFormat(strings.ToLower(r.URL.Query().Get("format"))); f {
+	case "":
+		return CSVExport, nil
+	case CSVExport, XLSXExport, JSONLExport:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unsupported format %q, one of csv, xlsx or jsonl is expected", f)
+	}
+}
+
+func (f ExportFormat) ContentType() string {
+	switch f {
+	case XLSXExport:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case JSONLExport:
+		return "application/x-ndjson"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+// Exporter writes the rows of an export one at a time, the values are in the order of the columns it was made with.
+// Close completes the file, an export is not readable before it.
+type Exporter interface {
+	Write(values []string) error
+	Close() error
+}
+
+// NewExporter writes the columns as the header of the export, or takes them as the keys of a jsonl one. The sheet
+// names the worksheet of a xlsx.
+func NewExporter(w io.Writer, format ExportFormat, sheet string, columns []string) (Exporter, error) {
+	switch format {
+	case XLSXExport:
+		return newXLSXExporter(w, sheet, columns)
+	case JSONLExport:
+		return &jsonlExporter{w: bufio.NewWriter(w), columns: columns}, nil
+	case CSVExport:
+		return newCSVExporter(w, columns)
+	default:
+		return nil, fmt.Errorf("unsupported format %q", format)
+	}
+}
+
+// csvExporter starts the file with a byte order mark, the spreadsheets read the file as UTF-8 with it
+type csvExporter struct {
+	w *csv.Writer
+}
+
+func newCSVExporter(w io.Writer, columns []string) (*csvExporter, error) {
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return nil, err
+	}
+
+	e := &csvExporter{w: csv.NewWriter(w)}
+	return e, e.w.Write(columns)
+}
+
+func (e *csvExporter) Write(values []string) error {
+	cells := make([]string, len(values))
+	for i, v := range values {
+		cells[i] = neutralizeCell(v)
+	}
+	return e.w.Write(cells)
+}
+
+func (e *csvExporter) Close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// neutralizeCell keeps a spreadsheet from running a value as a formula, by quoting the values starting like one. The
+// signed numbers, such as the phone numbers, are left as they are.
+func neutralizeCell(v string) string {
+	if v == "" || !strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return v
+	}
+	if _, err := strconv.ParseFloat(strings.ReplaceAll(v, " ", ""), 64); err == nil && v[0] != '=' && v[0] != '@' {
+		return v
+	}
+	return "'" + v
+}
+
+// jsonlExporter writes a row as an object keyed by the columns, in their order
+type jsonlExporter struct {
+	w       *bufio.Writer
+	columns []string
+}
+
+func (e *jsonlExporter) Write(values []string) error {
+	var line bytes.Buffer
+	line.WriteByte('{')
+	for i, column := range e.columns {
+		if i > 0 {
+			line.WriteByte(',')
+		}
+		key, _ := json.Marshal(column)
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		val, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		line.Write(key)
+		line.WriteByte(':')
+		line.Write(val)
+	}
+	line.WriteString("}\n")
+
+	_, err := e.w.Write(line.Bytes())
+	return err
+}
+
+func (e *jsonlExporter) Close() error {
+	return e.w.Flush()
+}
+
+// xlsxExporter writes a workbook of a single worksheet. The parts of the workbook are written up front and the rows
+// are streamed into the worksheet, the last entry of the zip, as inline strings.
+type xlsxExporter struct {
+	zip   *zip.Writer
+	sheet *bufio.Writer
+	row   int
+}
+
+const xlsxHeader = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n"
+
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", xlsxHeader + `<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types">` +
+		`<Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/>` +
+		`<Default Extension="xml" ContentType="application/xml"/>` +
+		`<Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/>` +
+		`<Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/>` +
+		`<Override PartName="/xl/styles.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.styles+xml"/>` +
+		`</Types>`},
+	{"_rels/.rels", xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/>` +
+		`</Relationships>`},
+	{"xl/_rels/workbook.xml.rels", xlsxHeader + `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+		`<Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/>` +
+		`<Relationship Id="rId2" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/styles" Target="styles.xml"/>` +
+		`</Relationships>`},
+	// The second cell format is the bold header
+	{"xl/styles.xml", xlsxHeader + `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<fonts count="2"><font><sz val="11"/><name val="Calibri"/></font><font><b/><sz val="11"/><name val="Calibri"/></font></fonts>` +
+		`<fills count="2"><fill><patternFill patternType="none"/></fill><fill><patternFill patternType="gray125"/></fill></fills>` +
+		`<borders count="1"><border><left/><right/><top/><bottom/><diagonal/></border></borders>` +
+		`<cellStyleXfs count="1"><xf numFmtId="0" fontId="0" fillId="0" borderId="0"/></cellStyleXfs>` +
+		`<cellXfs count="2"><xf numFmtId="0" fontId="0" fillId="0" borderId="0" xfId="0"/><xf numFmtId="0" fontId="1" fillId="0" borderId="0" xfId="0" applyFont="1"/></cellXfs>` +
+		`</styleSheet>`},
+}
+
+func newXLSXExporter(w io.Writer, sheet string, columns []string) (*xlsxExporter, error) {
+	e := &xlsxExporter{zip: zip.NewWriter(w)}
+
+	var name bytes.Buffer
+	xml.EscapeText(&name, []byte(sheetName(sheet)))
+	parts := append(xlsxParts, struct{ name, content string }{"xl/workbook.xml", xlsxHeader +
+		`<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+		`<sheets><sheet name="` + name.String() + `" sheetId="1" r:id="rId1"/></sheets></workbook>`})
+
+	for _, part := range parts {
+		f, err := e.zip.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	f, err := e.zip.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	e.sheet = bufio.NewWriter(f)
+
+	// The header row stays in view as the rows are scrolled
+	e.sheet.WriteString(xlsxHeader + `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+		`<sheetViews><sheetView workbookViewId="0"><pane ySplit="1" topLeftCell="A2" activePane="bottomLeft" state="frozen"/></sheetView></sheetViews>` +
+		`<sheetData>`)
+
+	return e, e.writeRow(columns, 1)
+}
+
+func (e *xlsxExporter) Write(values []string) error {
+	return e.writeRow(values, 0)
+}
+
+func (e *xlsxExporter) writeRow(values []string, style int) error {
+	e.row++
+	fmt.Fprintf(e.sheet, `<row r="%d">`, e.row)
+	for i, v := range values {
+		if v == "" {
+			continue
+		}
+		if len(v) > maxXLSXCell {
+			v = string([]rune(v)[:min(len([]rune(v)), maxXLSXCell)])
+		}
+
+		fmt.Fprintf(e.sheet, `<c r="%s%d" t="inlineStr"`, columnName(i), e.row)
+		if style > 0 {
+			fmt.Fprintf(e.sheet, ` s="%d"`, style)
+		}
+		e.sheet.WriteString(`><is><t xml:space="preserve">`)
+		if err := xml.EscapeText(e.sheet, []byte(v)); err != nil {
+			return err
+		}
+		e.sheet.WriteString(`</t></is></c>`)
+	}
+	_, err := e.sheet.WriteString(`</row>`)
+	return err
+}
+
+func (e *xlsxExporter) Close() error {
+	e.sheet.WriteString(`</sheetData></worksheet>`)
+	if err := e.sheet.Flush(); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+// columnName is the letters of the column at the index, A to Z then AA and on
+func columnName(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
+
+// sheetName drops the characters a worksheet name cannot have and cuts it to the 31 characters it is allowed
+func sheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`[]:*?/\`, r) {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 31 {
+		name = string(runes[:31])
+	}
+	if name == "" {
+		return "Sheet1"
+	}
+	return name
+}
+
+// ExportTime is the cell of a time, RFC 3339 in UTC and empty for the zero time
+func ExportTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// StreamExport writes the documents of the cursor as the export named name, a row at a time as they are decoded, and
+// closes the cursor. The row of a document has a value per column. The response is committed once the header is written, an error past it cuts the file short and
+// is returned for the caller to log.
+func StreamExport[T any](ctx context.Context, w http.ResponseWriter, cursor *mongo.Cursor, format ExportFormat, name string, columns []string, row func(T) ([]string, error)) error {
+	defer cursor.Close(ctx)
+
+	filename := fmt.Sprintf("%s-%s.%s", name, time.Now().UTC().Format(time.DateOnly), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+
+	exporter, err := NewExporter(w, format, name, columns)
+	if err != nil {
+		return err
+	}
+
+	flush := http.NewResponseController(w).Flush
+	for rows := 1; cursor.Next(ctx); rows++ {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		values, err := row(doc)
+		if err != nil {
+			return err
+		}
+		if err := exporter.Write(values); err != nil {
+			return err
+		}
+		if rows%exportFlushRows == 0 {
+			// A writer that cannot flush keeps buffering, the export still completes
+			_ = flush()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	return exporter.Close()
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestParseExportFormat(t *testing.T) {
+	for query, want := range map[string]ExportFormat{"": CSVExport, "format=XLSX": XLSXExport, "format=jsonl": JSONLExport} {
+		format, err := ParseExportFormat(httptest.NewRequest(http.MethodGet, "/users/export?"+query, nil))
+		require.NoError(t, err)
+		assert.Equal(t, want, format)
+	}
+
+	_, err := ParseExportFormat(httptest.NewRequest(http.MethodGet, "/users/export?format=pdf", nil))
+	assert.ErrorContains(t, err, "unsupported format")
+}
+
+func TestCSVExporter(t *testing.T) {
+	var out bytes.Buffer
+	e, err := NewExporter(&out, CSVExport, "users", []string{"name", "phone", "note"})
+	require.NoError(t, err)
+
+	require.NoError(t, e.Write([]string{"Lovelace, Ada", "+234 801 234 5678", "=HYPERLINK(\"x\")"}))
+	require.NoError(t, e.Write([]string{"Bob", "-12", "@SUM(A1)"}))
+	require.NoError(t, e.Close())
+
+	assert.Equal(t, "\ufeffname,phone,note\n\"Lovelace, Ada\",+234 801 234 5678,\"'=HYPERLINK(\"\"x\"\")\"\nBob,-12,'@SUM(A1)\n", out.String(),
+		"the formulas are quoted and the signed numbers are left")
+}
+
+func TestJSONLExporter(t *testing.T) {
+	var out bytes.Buffer
+	e, err := NewExporter(&out, JSONLExport, "users", []string{"name", "email"})
+	require.NoError(t, err)
+
+	require.NoError(t, e.Write([]string{"Ada \"the first\"", "ada@flowcx.io"}))
+	require.NoError(t, e.Write([]string{"Bob"}))
+	require.NoError(t, e.Close())
+
+	assert.Equal(t, "{\"name\":\"Ada \\\"the first\\\"\",\"email\":\"ada@flowcx.io\"}\n{\"name\":\"Bob\",\"email\":\"\"}\n", out.String())
+}
+
+// xlsxSheet is the part of a worksheet the tests read
+type xlsxSheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			Ref   string `xml:"r,attr"`
+			Style string `xml:"s,attr"`
+			Text  string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readXLSX(t *testing.T, b []byte) (map[string]string, xlsxSheet) {
+	t.Helper()
+
+	r, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	require.NoError(t, err)
+
+	parts := map[string]string{}
+	for _, f := range r.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		content, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		rc.Close()
+		parts[f.Name] = string(content)
+	}
+
+	var sheet xlsxSheet
+	require.NoError(t, xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &sheet))
+	return parts, sheet
+}
+
+func TestXLSXExporter(t *testing.T) {
+	var out bytes.Buffer
+	columns := make([]string, 28)
+	for i := range columns {
+		columns[i] = "c" + columnName(i)
+	}
+
+	e, err := NewExporter(&out, XLSXExport, "users/[all]", columns)
+	require.NoError(t, err)
+	values := make([]string, 28)
+	values[0], values[2], values[27] = "Ada & <Bob>", "  padded  ", "last"
+	require.NoError(t, e.Write(values))
+	require.NoError(t, e.Close())
+
+	parts, sheet := readXLSX(t, out.Bytes())
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		assert.Contains(t, parts, name)
+	}
+	assert.Contains(t, parts["xl/workbook.xml"], `<sheet name="usersall"`, "the characters a sheet name cannot have are dropped")
+
+	require.Len(t, sheet.Rows, 2)
+	header := sheet.Rows[0]
+	assert.Equal(t, 1, header.R)
+	require.Len(t, header.Cells, 28)
+	assert.Equal(t, "A1", header.Cells[0].Ref)
+	assert.Equal(t, "AB1", header.Cells[27].Ref)
+	assert.Equal(t, "cAB", header.Cells[27].Text)
+	assert.Equal(t, "1", header.Cells[0].Style, "the header is bold")
+
+	row := sheet.Rows[1]
+	require.Len(t, row.Cells, 3, "the empty cells are left out")
+	assert.Equal(t, "A2", row.Cells[0].Ref)
+	assert.Equal(t, "Ada & <Bob>", row.Cells[0].Text)
+	assert.Equal(t, "C2", row.Cells[1].Ref)
+	assert.Equal(t, "  padded  ", row.Cells[1].Text)
+	assert.Equal(t, "AB2", row.Cells[2].Ref)
+	assert.Empty(t, row.Cells[1].Style)
+}
+
+func TestColumnName(t *testing.T) {
+	for i, want := range map[int]string{0: "A", 25: "Z", 26: "AA", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"} {
+		assert.Equal(t, want, columnName(i))
+	}
+}
+
+func TestStreamExport(t *testing.T) {
+	type doc struct {
+		Name  string `bson:"name"`
+		Count int    `bson:"count"`
+	}
+
+	cursor, err := mongo.NewCursorFromDocuments([]interface{}{bson.M{"name": "support", "count": 3}, bson.M{"name": "billing", "count": 0}}, nil, nil)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	err = StreamExport(context.Background(), w, cursor, CSVExport, "roles", []string{"name", "count"}, func(d doc) ([]string, error) {
+		return []string{d.Name, strings.Repeat("*", d.Count)}, nil
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Regexp(t, `^attachment; filename="roles-\d{4}-\d{2}-\d{2}\.csv"$`, w.Header().Get("Content-Disposition"))
+	assert.Equal(t, "\ufeffname,count\nsupport,***\nbilling,\n", w.Body.String())
+}
@@ -0,0 +1,266 @@
+package util
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// MaxPageLimit is the most items a list endpoint returns at once, a larger limit is lowered to it
+const MaxPageLimit = 100
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageRequest is the page of a list. With a Page it is read by offset, otherwise by cursor, from the first item when
+// the Cursor is empty. The items are sorted by Sort, newest first when it is empty.
+type PageRequest struct {
+	Cursor string
+	Page   int
+	Limit  int
+	Sort   []SortKey
+}
+
+// Page is the response envelope of the list endpoints
+type Page[T any] struct {
+	Items      []T    `json:"items"`
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	Page       int    `json:"page,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// SortKey is a field of the sort order of a list
+type SortKey struct {
+	Field string
+	Desc  bool
+}
+
+func (k SortKey) String() string {
+	if k.Desc {
+		return "-" + k.Field
+	}
+	return k.Field
+}
+
+var defaultSort = []SortKey{{Field: "created_at", Desc: true}}
+
+// sortKeys is the full order of a page, the _id settles the ties of the sort
+func sortKeys(sort []SortKey) []SortKey {
+	if len(sort) == 0 {
+		sort = defaultSort
+	}
+
+	keys := make([]SortKey, 0, len(sort)+1)
+	for _, key := range sort {
+		keys = append(keys, key)
+		if key.Field == "_id" {
+			return keys
+		}
+	}
+
+	return append(keys, SortKey{Field: "_id", Desc: sort[len(sort)-1].Desc})
+}
+
+// SortOrder is the find sort of a list sorted by sort, with the _id settling the ties as it does on a page
+func SortOrder(sort []SortKey) bson.D {
+	keys := sortKeys(sort)
+	order := make(bson.D, len(keys))
+	for i, key := range keys {
+		order[i] = bson.E{Key: key.Field, Value: 1}
+		if key.Desc {
+			order[i].Value = -1
+		}
+	}
+	return order
+}
+
+func sortSignature(keys []SortKey) string {
+	parts := make([]string, len(keys))
+	for i, key := range keys {
+		parts[i] = key.String()
+	}
+	return strings.Join(parts, ",")
+}
+
+// cursorKey is the position of the last item of a page, the values of its sort keys in order. A cursor is only valid
+// for the sort it was made with.
+type cursorKey struct {
+	Sort   string `bson:"s"`
+	Values bson.A `bson:"v"`
+}
+
+func encodeCursor(key cursorKey) (string, error) {
+	b, err := bson.Marshal(key)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func decodeCursor(cursor string) (*cursorKey, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var key cursorKey
+	if err := bson.Unmarshal(b, &key); err != nil || key.Sort == "" || len(key.Values) == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &key, nil
+}
+
+// after matches the items past the cursor in the order of the keys
+func (key cursorKey) after(keys []SortKey) bson.M {
+	or := make(bson.A, 0, len(keys))
+	for i, k := range keys {
+		clause := bson.M{}
+		for j := 0; j < i; j++ {
+			clause[keys[j].Field] = key.Values[j]
+		}
+
+		op := "$gt"
+		if k.Desc {
+			op = "$lt"
+		}
+		clause[k.Field] = bson.M{op: key.Values[i]}
+
+		or = append(or, clause)
+	}
+
+	return bson.M{"$or": or}
+}
+
+// ParsePageRequest reads the query params cursor, page and limit. The limit defaults to defaultLimit and is capped at
+// MaxPageLimit.
+func ParsePageRequest(r *http.Request, defaultLimit int) (PageRequest, error) {
+	query := r.URL.Query()
+	req := PageRequest{Cursor: query.Get("cursor"), Limit: min(defaultLimit, MaxPageLimit)}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return req, errors.New("limit must be a positive number")
+		}
+		req.Limit = min(limit, MaxPageLimit)
+	}
+
+	if value := query.Get("page"); value != "" {
+		if req.Cursor != "" {
+			return req, errors.New("a page is read either by cursor or by page, not both")
+		}
+
+		page, err := strconv.Atoi(value)
+		if err != nil || page < 1 {
+			return req, errors.New("page must be a positive number")
+		}
+		req.Page = page
+	}
+
+	if req.Cursor != "" {
+		if _, err := decodeCursor(req.Cursor); err != nil {
+			return req, err
+		}
+	}
+
+	return req, nil
+}
+
+// Paginate lists the documents of the collection matching the filter in the order of the request, along with the total
+// of matches. A cursor page expects the sort fields to be set on every document.
+func Paginate[T any](ctx context.Context, col *mongo.Collection, filter bson.M, req PageRequest) (*Page[T], error) {
+	if req.Limit < 1 || req.Limit > MaxPageLimit {
+		req.Limit = MaxPageLimit
+	}
+
+	keys := sortKeys(req.Sort)
+	sort := SortOrder(req.Sort)
+
+	total, err := col.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	page := Page[T]{Items: make([]T, 0, req.Limit), Total: total, Limit: req.Limit}
+	opt := options.Find().SetSort(sort)
+	query := filter
+
+	if req.Page > 0 {
+		skip := int64(req.Page-1) * int64(req.Limit)
+		opt.SetSkip(skip).SetLimit(int64(req.Limit))
+		page.Page = req.Page
+	} else {
+		// One more than the limit tells whether there is a next page
+		opt.SetLimit(int64(req.Limit) + 1)
+
+		if req.Cursor != "" {
+			key, err := decodeCursor(req.Cursor)
+			if err != nil {
+				return nil, err
+			}
+			if key.Sort != sortSignature(keys) || len(key.Values) != len(keys) {
+				return nil, ErrInvalidCursor
+			}
+			query = bson.M{"$and": bson.A{filter, key.after(keys)}}
+		}
+	}
+
+	cursor, err := col.Find(ctx, query, opt)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var last bson.Raw
+	for cursor.Next(ctx) {
+		if len(page.Items) == req.Limit {
+			page.HasMore = true
+			break
+		}
+
+		var item T
+		if err := cursor.Decode(&item); err != nil {
+			return nil, err
+		}
+		page.Items = append(page.Items, item)
+		last = cursor.Current
+	}
+
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+
+	if req.Page > 0 {
+		page.HasMore = int64(req.Page)*int64(req.Limit) < total
+	} else if page.HasMore {
+		key := cursorKey{Sort: sortSignature(keys), Values: make(bson.A, len(keys))}
+		for i, k := range keys {
+			if value, err := last.LookupErr(strings.Split(k.Field, ".")...); err == nil {
+				key.Values[i] = value
+			}
+		}
+
+		if page.NextCursor, err = encodeCursor(key); err != nil {
+			return nil, fmt.Errorf("failed to make the cursor of %s: %w", col.Name(), err)
+		}
+	}
+
+	return &page, nil
+}
+
+// PageErrorStatus is the status code of an error of Paginate, a bad cursor is the caller's fault
+func PageErrorStatus(err error) int {
+	if errors.Is(err, ErrInvalidCursor) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
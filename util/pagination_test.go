@@ -0,0 +1,91 @@
+package util
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestParsePageRequest(t *testing.T) {
+	cursor, err := encodeCursor(cursorKey{Sort: "-created_at,-_id", Values: bson.A{time.Now(), bson.NewObjectID()}})
+	require.NoError(t, err)
+
+	tests := []struct {
+		query    string
+		expected PageRequest
+		err      string
+	}{
+		{"", PageRequest{Limit: 50}, ""},
+		{"?limit=20", PageRequest{Limit: 20}, ""},
+		{"?limit=1000", PageRequest{Limit: MaxPageLimit}, ""},
+		{"?page=3&limit=10", PageRequest{Page: 3, Limit: 10}, ""},
+		{"?cursor=" + cursor, PageRequest{Cursor: cursor, Limit: 50}, ""},
+		{"?limit=0", PageRequest{}, "limit must be a positive number"},
+		{"?page=two", PageRequest{}, "page must be a positive number"},
+		{"?page=0", PageRequest{}, "page must be a positive number"},
+		{"?page=2&cursor=" + cursor, PageRequest{}, "a page is read either by cursor or by page, not both"},
+		{"?cursor=not-a-cursor", PageRequest{}, "invalid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			req, err := ParsePageRequest(httptest.NewRequest(http.MethodGet, "/"+tt.query, nil), 50)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, req)
+		})
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	assert.Equal(t, []SortKey{{Field: "created_at", Desc: true}, {Field: "_id", Desc: true}}, sortKeys(nil), "newest first by default")
+	assert.Equal(t, []SortKey{{Field: "name"}, {Field: "_id"}}, sortKeys([]SortKey{{Field: "name"}}))
+	assert.Equal(t, []SortKey{{Field: "_id", Desc: true}}, sortKeys([]SortKey{{Field: "_id", Desc: true}, {Field: "name"}}), "the keys past the _id are never used")
+	assert.Equal(t, "-updated_at,name,_id", sortSignature([]SortKey{{Field: "updated_at", Desc: true}, {Field: "name"}, {Field: "_id"}}))
+}
+
+func TestCursor(t *testing.T) {
+	createdAt := time.Date(2025, 5, 1, 12, 0, 0, 123000000, time.UTC)
+	id := bson.NewObjectID()
+	keys := sortKeys(nil)
+
+	raw, err := bson.Marshal(bson.D{{Key: "_id", Value: id}, {Key: "created_at", Value: createdAt}})
+	require.NoError(t, err)
+	cursor, err := encodeCursor(cursorKey{Sort: sortSignature(keys), Values: bson.A{
+		bson.Raw(raw).Lookup("created_at"),
+		bson.Raw(raw).Lookup("_id"),
+	}})
+	require.NoError(t, err)
+
+	key, err := decodeCursor(cursor)
+	require.NoError(t, err)
+	assert.Equal(t, &cursorKey{Sort: "-created_at,-_id", Values: bson.A{bson.NewDateTimeFromTime(createdAt), id}}, key, "the cursor round trips")
+
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"created_at": bson.M{"$lt": key.Values[0]}},
+		bson.M{"created_at": key.Values[0], "_id": bson.M{"$lt": id}},
+	}}, key.after(keys), "the items created at the same time are ordered by id")
+
+	assert.Equal(t, bson.M{"$or": bson.A{
+		bson.M{"name": bson.M{"$gt": "ops"}},
+		bson.M{"name": "ops", "_id": bson.M{"$gt": id}},
+	}}, cursorKey{Values: bson.A{"ops", id}}.after(sortKeys([]SortKey{{Field: "name"}})))
+
+	for _, bad := range []string{"not-a-cursor", base64.RawURLEncoding.EncodeToString([]byte(`{"t":"2025-05-01T12:00:00Z"}`))} {
+		_, err = decodeCursor(bad)
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	}
+
+	assert.Equal(t, http.StatusBadRequest, PageErrorStatus(ErrInvalidCursor))
+	assert.Equal(t, http.StatusInternalServerError, PageErrorStatus(assert.AnError))
+}
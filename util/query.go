@@ -0,0 +1,240 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldType is the type of the values a field is filtered by
+type FieldType int
+
+const (
+	StringField FieldType = iota
+	BoolField
+	TimeField
+	ObjectIDField
+)
+
+// Operator is a comparison of a filter, the query string filter[field][op]=value. Without an op it is eq.
+type Operator string
+
+const (
+	Eq  Operator = "eq"
+	Ne  Operator = "ne"
+	In  Operator = "in"
+	Nin Operator = "nin"
+	Gt  Operator = "gt"
+	Gte Operator = "gte"
+	Lt  Operator = "lt"
+	Lte Operator = "lte"
+)
+
+var operators = map[FieldType][]Operator{
+	StringField:   {Eq, Ne, In, Nin},
+	BoolField:     {Eq, Ne},
+	TimeField:     {Eq, Ne, Gt, Gte, Lt, Lte},
+	ObjectIDField: {Eq, Ne, In, Nin},
+}
+
+// Field is a field a list can be filtered or sorted by. Path is the document field when it differs from the name.
+type Field struct {
+	Type     FieldType
+	Path     string
+	Sortable bool
+}
+
+// Fields is the whitelist of the fields of a list, by their name in the query string
+type Fields map[string]Field
+
+func (f Fields) names(match func(Field) bool) string {
+	names := make([]string, 0, len(f))
+	for name, field := range f {
+		if match(field) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Condition is a parsed filter, the value is of the type of the field (a bson.A of them for in and nin)
+type Condition struct {
+	Field string
+	Path  string
+	Op    Operator
+	Value any
+}
+
+// ListQuery is the view, filter and sort of a list request, the zero value lists the active records
+type ListQuery struct {
+	View       View
+	Conditions []Condition
+	Sort       []SortKey
+}
+
+// Filter compiles the conditions to a filter within the view, every condition has to match
+func (q ListQuery) Filter() bson.M {
+	filter := bson.M{}
+	if len(q.Conditions) > 0 {
+		and := make(bson.A, len(q.Conditions))
+		for i, c := range q.Conditions {
+			and[i] = bson.M{c.Path: bson.M{"$" + string(c.Op): c.Value}}
+		}
+		filter["$and"] = and
+	}
+
+	return q.View.Within(filter)
+}
+
+// Take removes the conditions on the field from the query and returns them, for the fields that are not a plain match
+// on the document
+func (q *ListQuery) Take(field string) []Condition {
+	var taken []Condition
+	q.Conditions = slices.DeleteFunc(q.Conditions, func(c Condition) bool {
+		if c.Field == field {
+			taken = append(taken, c)
+			return true
+		}
+		return false
+	})
+	return taken
+}
+
+var filterParam = regexp.MustCompile(`^filter\[([a-z0-9_.]+)\](?:\[([a-z]+)\])?$`)
+
+// ParseListQuery reads the query params view, filter[field][op] and sort against the fields of the list. The sort is a
+// comma separated list of fields, descending with a leading "-", e.g. sort=-updated_at,name.
+func ParseListQuery(r *http.Request, fields Fields) (ListQuery, error) {
+	var q ListQuery
+
+	view, err := ParseView(r)
+	if err != nil {
+		return q, err
+	}
+	q.View = view
+
+	params := r.URL.Query()
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	// The order of the conditions does not change the result, sorting them keeps the filter stable
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "filter") {
+			continue
+		}
+
+		m := filterParam.FindStringSubmatch(key)
+		if m == nil {
+			return q, fmt.Errorf("malformed filter %q, filter[field] or filter[field][op] is expected", key)
+		}
+
+		name, op := m[1], Operator(m[2])
+		if op == "" {
+			op = Eq
+		}
+
+		field, ok := fields[name]
+		if !ok {
+			return q, fmt.Errorf("unknown filter field %q, one of %s is expected", name, fields.names(func(Field) bool { return true }))
+		}
+		if !slices.Contains(operators[field.Type], op) {
+			return q, fmt.Errorf("the filter %q does not support the op %q", name, op)
+		}
+
+		values := params[key]
+		if len(values) > 1 {
+			return q, fmt.Errorf("the filter %s is given more than once", key)
+		}
+
+		value, err := parseFilterValue(field.Type, op, values[0])
+		if err != nil {
+			return q, fmt.Errorf("invalid value for the filter %s: %w", key, err)
+		}
+
+		path := field.Path
+		if path == "" {
+			path = name
+		}
+		q.Conditions = append(q.Conditions, Condition{Field: name, Path: path, Op: op, Value: value})
+	}
+
+	if value := params.Get("sort"); value != "" {
+		seen := map[string]bool{}
+		for _, part := range strings.Split(value, ",") {
+			key := SortKey{Field: strings.TrimSpace(part)}
+			if strings.HasPrefix(key.Field, "-") {
+				key.Field, key.Desc = key.Field[1:], true
+			}
+
+			field, ok := fields[key.Field]
+			if !ok || !field.Sortable {
+				return q, fmt.Errorf("unknown sort field %q, one of %s is expected", key.Field, fields.names(func(f Field) bool { return f.Sortable }))
+			}
+			if seen[key.Field] {
+				return q, fmt.Errorf("the sort field %q is given more than once", key.Field)
+			}
+			seen[key.Field] = true
+
+			if field.Path != "" {
+				key.Field = field.Path
+			}
+			q.Sort = append(q.Sort, key)
+		}
+	}
+
+	return q, nil
+}
+
+func parseFilterValue(t FieldType, op Operator, value string) (any, error) {
+	if op == In || op == Nin {
+		parts := strings.Split(value, ",")
+		values := make(bson.A, len(parts))
+		for i, part := range parts {
+			v, err := parseScalar(t, strings.TrimSpace(part))
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return values, nil
+	}
+
+	return parseScalar(t, value)
+}
+
+func parseScalar(t FieldType, value string) (any, error) {
+	switch t {
+	case BoolField:
+		return strconv.ParseBool(value)
+	case TimeField:
+		if at, err := time.Parse(time.RFC3339, value); err == nil {
+			return at, nil
+		}
+		if at, err := time.Parse(time.DateOnly, value); err == nil {
+			return at, nil
+		}
+		return nil, errors.New("a RFC 3339 time or a YYYY-MM-DD date is expected")
+	case ObjectIDField:
+		id, err := GetPrimitiveID(value)
+		if err != nil {
+			return nil, errors.New("an object id is expected")
+		}
+		return *id, nil
+	default:
+		if value == "" {
+			return nil, errors.New("an empty value")
+		}
+		return value, nil
+	}
+}
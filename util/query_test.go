@@ -0,0 +1,99 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var testFields = Fields{
+	"name":           {Type: StringField, Sortable: true},
+	"archive_status": {Type: BoolField},
+	"created_at":     {Type: TimeField, Sortable: true},
+	"team_id":        {Type: ObjectIDField, Path: "team._id"},
+}
+
+func listRequest(query string) *http.Request {
+	return httptest.NewRequest(http.MethodGet, "/?"+query, nil)
+}
+
+func TestParseListQuery(t *testing.T) {
+	teamId := bson.NewObjectID()
+	from := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	q, err := ParseListQuery(listRequest(url.Values{
+		"filter[archive_status]":   {"false"},
+		"filter[created_at][gte]":  {"2025-03-01"},
+		"filter[name][in]":         {"ops, sales"},
+		"filter[team_id]":          {teamId.Hex()},
+		"sort":                     {"-created_at,name"},
+		"limit":                    {"20"},
+		"some_other_query_param_x": {"ignored"},
+	}.Encode()), testFields)
+	require.NoError(t, err)
+
+	assert.Equal(t, []Condition{
+		{Field: "archive_status", Path: "archive_status", Op: Eq, Value: false},
+		{Field: "created_at", Path: "created_at", Op: Gte, Value: from},
+		{Field: "name", Path: "name", Op: In, Value: bson.A{"ops", "sales"}},
+		{Field: "team_id", Path: "team._id", Op: Eq, Value: teamId},
+	}, q.Conditions)
+	assert.Equal(t, []SortKey{{Field: "created_at", Desc: true}, {Field: "name"}}, q.Sort)
+	assert.Equal(t, ActiveView, q.View)
+
+	conditions := bson.M{"$and": bson.A{
+		bson.M{"archive_status": bson.M{"$eq": false}},
+		bson.M{"created_at": bson.M{"$gte": from}},
+		bson.M{"name": bson.M{"$in": bson.A{"ops", "sales"}}},
+		bson.M{"team._id": bson.M{"$eq": teamId}},
+	}}
+	assert.Equal(t, bson.M{"$and": bson.A{ActiveView.Filter(), conditions}}, q.Filter())
+
+	q.View = AllView
+	assert.Equal(t, conditions, q.Filter())
+
+	taken := q.Take("team_id")
+	assert.Len(t, taken, 1)
+	assert.Len(t, q.Conditions, 3)
+
+	empty, err := ParseListQuery(listRequest("page=2&view=bin"), testFields)
+	require.NoError(t, err)
+	assert.Equal(t, BinView.Filter(), empty.Filter())
+	assert.Empty(t, empty.Sort)
+
+	assert.Equal(t, ActiveView.Filter(), ListQuery{}.Filter(), "the zero query lists the active records")
+}
+
+func TestParseListQuery_Invalid(t *testing.T) {
+	tests := map[string]string{
+		"filter[role]=admin":                      `unknown filter field "role", one of archive_status, created_at, name, team_id is expected`,
+		"filter[name][gt]=a":                      `the filter "name" does not support the op "gt"`,
+		"filter[name][like]=a":                    `the filter "name" does not support the op "like"`,
+		"filter[archive_status]=maybe":            "invalid value for the filter filter[archive_status]",
+		"filter[created_at][lt]=yesterday":        "invalid value for the filter filter[created_at][lt]",
+		"filter[team_id]=team-a":                  "invalid value for the filter filter[team_id]",
+		"filter[name]=":                           "invalid value for the filter filter[name]",
+		"filter[name]=a&filter[name]=b":           "the filter filter[name] is given more than once",
+		"filter=name":                             `malformed filter "filter"`,
+		"filter[name][eq][x]=a":                   `malformed filter "filter[name][eq][x]"`,
+		"sort=archive_status":                     `unknown sort field "archive_status", one of created_at, name is expected`,
+		"sort=-updated_at":                        `unknown sort field "updated_at"`,
+		"sort=name,-name":                         `the sort field "name" is given more than once`,
+		"filter[created_at][gte]=2025-13-01&sort": "invalid value for the filter filter[created_at][gte]",
+		"view=deleted":                            `invalid view "deleted"`,
+	}
+
+	for query, expected := range tests {
+		t.Run(query, func(t *testing.T) {
+			_, err := ParseListQuery(listRequest(query), testFields)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), expected)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"net/http"
+)
+
+// View is the part of the archive and bin lifecycle a read sees, by the archive_status and is_deleted_status flags of
+// the records
+type View string
+
+const (
+	// ActiveView is neither archived nor in the bin, the default of every read
+	ActiveView   View = "active"
+	ArchivedView View = "archived"
+	BinView      View = "bin"
+	AllView      View = "all"
+)
+
+func (v View) Valid() bool {
+	switch v {
+	case ActiveView, ArchivedView, BinView, AllView:
+		return true
+	}
+	return false
+}
+
+// ParseView reads the query param view, active when it is not given
+func ParseView(r *http.Request) (View, error) {
+	value := r.URL.Query().Get("view")
+	if value == "" {
+		return ActiveView, nil
+	}
+
+	if v := View(value); v.Valid() {
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid view %q, one of active, archived, bin or all is expected", value)
+}
+
+// Filter matches the records of the view. The flags are compared with $ne so the records written before the flags
+// existed count as live ones.
+func (v View) Filter() bson.M {
+	switch v {
+	case ArchivedView:
+		return bson.M{"archive_status": true, "is_deleted_status": bson.M{"$ne": true}}
+	case BinView:
+		return bson.M{"is_deleted_status": true}
+	case AllView:
+		return bson.M{}
+	default:
+		return bson.M{"archive_status": bson.M{"$ne": true}, "is_deleted_status": bson.M{"$ne": true}}
+	}
+}
+
+// Includes tells whether a record with the flags is in the view, for the reads of a single record
+func (v View) Includes(archived, deleted bool) bool {
+	switch v {
+	case ArchivedView:
+		return archived && !deleted
+	case BinView:
+		return deleted
+	case AllView:
+		return true
+	default:
+		return !archived && !deleted
+	}
+}
+
+// Within narrows the filter to the view
+func (v View) Within(filter bson.M) bson.M {
+	if v == AllView {
+		return filter
+	}
+	if len(filter) == 0 {
+		return v.Filter()
+	}
+	return bson.M{"$and": bson.A{v.Filter(), filter}}
+}
@@ -0,0 +1,47 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestView(t *testing.T) {
+	tests := []struct {
+		view                           View
+		active, archived, binned, both bool
+	}{
+		{ActiveView, true, false, false, false},
+		{"", true, false, false, false},
+		{ArchivedView, false, true, false, false},
+		{BinView, false, false, true, true},
+		{AllView, true, true, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.view), func(t *testing.T) {
+			assert.Equal(t, tt.active, tt.view.Includes(false, false), "active")
+			assert.Equal(t, tt.archived, tt.view.Includes(true, false), "archived")
+			assert.Equal(t, tt.binned, tt.view.Includes(false, true), "binned")
+			assert.Equal(t, tt.both, tt.view.Includes(true, true), "archived and binned")
+		})
+	}
+
+	filter := bson.M{"name": "ops"}
+	assert.Equal(t, bson.M{"$and": bson.A{BinView.Filter(), filter}}, BinView.Within(filter))
+	assert.Equal(t, filter, AllView.Within(filter))
+	assert.Equal(t, ActiveView.Filter(), ActiveView.Within(bson.M{}))
+	assert.Equal(t, bson.M{"archive_status": bson.M{"$ne": true}, "is_deleted_status": bson.M{"$ne": true}}, View("").Filter())
+}
+
+func TestParseView(t *testing.T) {
+	for query, expected := range map[string]View{"": ActiveView, "view=archived": ArchivedView, "view=bin": BinView, "view=all": AllView} {
+		view, err := ParseView(listRequest(query))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, view)
+	}
+
+	_, err := ParseView(listRequest("view=trash"))
+	assert.EqualError(t, err, `invalid view "trash", one of active, archived, bin or all is expected`)
+}